@@ -0,0 +1,275 @@
+// Package cache provides caching of responses for clients: https://tools.ietf.org/html/rfc7252#section-5.6
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+// DefaultMaxAge is freshness lifetime of the response without Max-Age option: https://tools.ietf.org/html/rfc7252#section-5.10.5
+const DefaultMaxAge = 60 * time.Second
+
+// Client serves GET requests of the underlying client from the cache when the cached response is fresh.
+// Stale responses with ETag are revalidated by the server and 2.03 Valid response refreshes them,
+// also when the caller validates the cached ETag by itself.
+// Other requests and observations are passed to the underlying client.
+type Client struct {
+	mux.Client
+	storage Storage
+	clock   clock.Clock
+}
+
+// NewClient creates client which caches responses in the storage. The storage can be shared by clients
+// of different connections, because the cache key contains the remote address.
+func NewClient(cc mux.Client, storage Storage, opts ...ClientOption) *Client {
+	var cfg clientOptions
+	for _, o := range opts {
+		o.apply(&cfg)
+	}
+	return &Client{
+		Client:  cc,
+		storage: storage,
+		clock:   clock.Get(cfg.clock),
+	}
+}
+
+// Get issues a GET to the specified path.
+func (c *Client) Get(ctx context.Context, path string, opts ...message.Option) (*message.Message, error) {
+	token, err := message.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token: %w", err)
+	}
+	options := make(message.Options, 0, len(opts)+4)
+	for _, o := range opts {
+		options = options.Add(o)
+	}
+	buf := make([]byte, 64)
+	options, n, err := options.SetPath(buf, path)
+	if err == message.ErrTooSmall {
+		buf = append(buf, make([]byte, n)...)
+		options, _, err = options.SetPath(buf, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot set path: %w", err)
+	}
+	return c.Do(&message.Message{
+		Context: ctx,
+		Token:   token,
+		Code:    codes.GET,
+		Options: options,
+	})
+}
+
+func maxAge(opts message.Options) time.Duration {
+	v, err := opts.GetUint32(message.MaxAge)
+	if err != nil {
+		return DefaultMaxAge
+	}
+	return time.Duration(v) * time.Second
+}
+
+func uint32Option(id message.OptionID, v uint32) message.Option {
+	buf := make([]byte, 4)
+	n, _ := message.EncodeUint32(buf, v)
+	return message.Option{ID: id, Value: buf[:n]}
+}
+
+func readBody(m *message.Message) ([]byte, error) {
+	if m.Body == nil {
+		return nil, nil
+	}
+	if _, err := m.Body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.Body = bytes.NewReader(body)
+	return body, nil
+}
+
+// cacheable returns true for the responses which can be cached: https://tools.ietf.org/html/rfc7252#section-5.9
+func cacheable(resp *message.Message) bool {
+	switch resp.Code >> 5 {
+	case 2:
+		return resp.Code == codes.Content
+	case 4, 5:
+		// error responses without validator are useless when they are stale
+		return maxAge(resp.Options) > 0
+	}
+	return false
+}
+
+// response creates response from the entry, Max-Age is set to the remaining freshness lifetime.
+func (e Entry) response(req *message.Message, now time.Time) (*message.Message, error) {
+	opts, err := e.Options.Clone()
+	if err != nil {
+		return nil, err
+	}
+	remaining := e.Expires.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	opts = opts.Set(uint32Option(message.MaxAge, uint32(remaining/time.Second)))
+	resp := &message.Message{
+		Context: req.Context,
+		Token:   req.Token,
+		Code:    e.Code,
+		Options: opts,
+	}
+	if e.Body != nil {
+		resp.Body = bytes.NewReader(e.Body)
+	}
+	return resp, nil
+}
+
+func (c *Client) key(req *message.Message) string {
+	return c.Client.RemoteAddr().String() + "|" + Key(req)
+}
+
+func (c *Client) store(key string, resp *message.Message, now time.Time) error {
+	if !cacheable(resp) {
+		c.storage.Delete(key)
+		return nil
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return fmt.Errorf("cannot read response: %w", err)
+	}
+	opts, err := resp.Options.Clone()
+	if err != nil {
+		return err
+	}
+	c.storage.Store(key, Entry{
+		Code:    resp.Code,
+		Options: opts,
+		Body:    body,
+		Expires: now.Add(maxAge(resp.Options)),
+	})
+	return nil
+}
+
+// refresh updates the entry by options of 2.03 Valid response: https://tools.ietf.org/html/rfc7252#section-5.9.1.3
+func (c *Client) refresh(key string, entry Entry, resp *message.Message, now time.Time) (Entry, error) {
+	opts, err := entry.Options.Clone()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, o := range resp.Options {
+		switch o.ID {
+		case message.ETag, message.MaxAge:
+			opts = opts.Set(o)
+		}
+	}
+	if !resp.Options.HasOption(message.MaxAge) {
+		opts = opts.Remove(message.MaxAge)
+	}
+	entry.Options = opts
+	entry.Expires = now.Add(maxAge(resp.Options))
+	c.storage.Store(key, entry)
+	return entry, nil
+}
+
+// revalidate refreshes the stale entry and serves the request from it.
+func (c *Client) revalidate(key string, req *message.Message, entry Entry, resp *message.Message, now time.Time) (*message.Message, error) {
+	entry, err := c.refresh(key, entry, resp, now)
+	if err != nil {
+		return nil, err
+	}
+	return entry.response(req, now)
+}
+
+// refreshValidated refreshes the cached entry which 2.03 Valid response to the request with the ETags
+// of the caller validates.
+func (c *Client) refreshValidated(key string, req *message.Message, resp *message.Message, now time.Time) error {
+	entry, ok := c.storage.Load(key)
+	if !ok {
+		return nil
+	}
+	cached, err := entry.Options.GetBytes(message.ETag)
+	if err != nil {
+		return nil
+	}
+	etag, err := resp.Options.GetBytes(message.ETag)
+	if err != nil {
+		// without ETag in the response only the single ETag of the request is validated
+		etags := make([][]byte, 1)
+		if n, err := req.Options.GetBytess(message.ETag, etags); err != nil || n != 1 {
+			return nil
+		}
+		etag = etags[0]
+	}
+	if !bytes.Equal(cached, etag) {
+		return nil
+	}
+	_, err = c.refresh(key, entry, resp, now)
+	return err
+}
+
+// Do sends the request or serves it from the cache.
+func (c *Client) Do(req *message.Message) (*message.Message, error) {
+	if req.Code != codes.GET || req.Options.HasOption(message.Observe) {
+		return c.Client.Do(req)
+	}
+	key := c.key(req)
+	now := c.clock.Now()
+	if req.Options.HasOption(message.ETag) {
+		// the caller validates its own responses
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Code == codes.Valid {
+			err = c.refreshValidated(key, req, resp, now)
+		} else {
+			err = c.store(key, resp, now)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	entry, ok := c.storage.Load(key)
+	if ok && now.Before(entry.Expires) {
+		return entry.response(req, now)
+	}
+	r := req
+	var validated bool
+	if ok {
+		if etag, err := entry.Options.GetBytes(message.ETag); err == nil {
+			opts, err := req.Options.Clone()
+			if err != nil {
+				return nil, err
+			}
+			r = &message.Message{
+				Context: req.Context,
+				Token:   req.Token,
+				Code:    req.Code,
+				Options: opts.Add(message.Option{ID: message.ETag, Value: etag}),
+			}
+			validated = true
+		}
+	}
+	resp, err := c.Client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if validated && resp.Code == codes.Valid {
+		return c.revalidate(key, req, entry, resp, now)
+	}
+	if err := c.store(key, resp, now); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,116 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/cache"
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	etag := []byte{0x0a}
+	var requests, validations int32
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddInt32(&requests, 1)
+		maxAge := message.Option{ID: message.MaxAge, Value: []byte{1}}
+		if v, err := r.Options.GetBytes(message.ETag); err == nil && bytes.Equal(v, etag) {
+			atomic.AddInt32(&validations, 1)
+			err := w.SetResponse(codes.Valid, message.TextPlain, nil, message.Option{ID: message.ETag, Value: etag}, maxAge)
+			require.NoError(t, err)
+			return
+		}
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")), message.Option{ID: message.ETag, Value: etag}, maxAge)
+		require.NoError(t, err)
+	}))
+	m.Handle("/nocache", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddInt32(&requests, 1)
+		err := w.SetResponse(codes.NotFound, message.TextPlain, nil, message.Option{ID: message.MaxAge, Value: []byte{}})
+		require.NoError(t, err)
+	}))
+
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithMux(m))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+	storage := cache.NewLRU(16)
+	clk := clock.NewFake(time.Now())
+	c := cache.NewClient(cc.Client(), storage, cache.WithClock(clk))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	get := func(path string, opts ...message.Option) *message.Message {
+		resp, err := c.Get(ctx, path, opts...)
+		require.NoError(t, err)
+		return resp
+	}
+	checkContent := func(resp *message.Message) {
+		require.Equal(t, codes.Content, resp.Code)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(body))
+	}
+
+	checkContent(get("/a"))
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	// fresh response is served from the cache, NoCacheKey options don't change the key
+	resp := get("/a", message.Option{ID: message.Size1, Value: []byte{5}})
+	checkContent(resp)
+	maxAge, err := resp.Options.GetUint32(message.MaxAge)
+	require.NoError(t, err)
+	require.LessOrEqual(t, maxAge, uint32(1))
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	// different key
+	checkContent(get("/a", message.Option{ID: message.URIQuery, Value: []byte("q")}))
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// stale response is revalidated
+	clk.Advance(time.Millisecond * 1100)
+	checkContent(get("/a"))
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	require.Equal(t, int32(1), atomic.LoadInt32(&validations))
+	checkContent(get("/a"))
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// 2.03 Valid to the ETag of the caller refreshes the stale response too
+	clk.Advance(time.Millisecond * 1100)
+	resp = get("/a", message.Option{ID: message.ETag, Value: etag})
+	require.Equal(t, codes.Valid, resp.Code)
+	require.Equal(t, int32(4), atomic.LoadInt32(&requests))
+	require.Equal(t, int32(2), atomic.LoadInt32(&validations))
+	checkContent(get("/a"))
+	require.Equal(t, int32(4), atomic.LoadInt32(&requests))
+
+	// Max-Age 0
+	resp = get("/nocache")
+	require.Equal(t, codes.NotFound, resp.Code)
+	resp = get("/nocache")
+	require.Equal(t, codes.NotFound, resp.Code)
+	require.Equal(t, int32(6), atomic.LoadInt32(&requests))
+	require.Equal(t, 2, storage.Len())
+}
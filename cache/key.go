@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+)
+
+// NoCacheKey returns true when the option is not part of the cache key: https://tools.ietf.org/html/rfc7252#section-5.4.6
+func NoCacheKey(id message.OptionID) bool {
+	return id&0x1e == 0x1c
+}
+
+// Key computes cache key of the request from the method and all options except the NoCacheKey ones:
+// https://tools.ietf.org/html/rfc7252#section-5.6
+//
+// ETag options of the request are also excluded, because they are used for validation of the cached response.
+func Key(req *message.Message) string {
+	opts := make(message.Options, 0, len(req.Options))
+	for _, o := range req.Options {
+		if NoCacheKey(o.ID) || o.ID == message.ETag {
+			continue
+		}
+		opts = append(opts, o)
+	}
+	// options are compared in the order of their numbers, the order of options with the same number is kept
+	sort.SliceStable(opts, func(i, j int) bool {
+		return opts[i].ID < opts[j].ID
+	})
+	buf := make([]byte, 0, 64)
+	buf = append(buf, byte(req.Code))
+	var hdr [6]byte
+	for _, o := range opts {
+		binary.BigEndian.PutUint16(hdr[:2], uint16(o.ID))
+		binary.BigEndian.PutUint32(hdr[2:], uint32(len(o.Value)))
+		buf = append(buf, hdr[:]...)
+		buf = append(buf, o.Value...)
+	}
+	return string(buf)
+}
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"github.com/plgd-dev/go-coap/v2/clock"
+)
+
+// A ClientOption sets options of the Client.
+type ClientOption interface {
+	apply(*clientOptions)
+}
+
+type clientOptions struct {
+	clock clock.Clock
+}
+
+// ClockOpt clock option.
+type ClockOpt struct {
+	clock clock.Clock
+}
+
+func (o ClockOpt) apply(opts *clientOptions) {
+	opts.clock = o.clock
+}
+
+// WithClock sets clock which measures freshness of the cached responses.
+// Eg. clock.NewFake lets tests advance the time manually.
+func WithClock(c clock.Clock) ClockOpt {
+	return ClockOpt{clock: c}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Code    codes.Code
+	Options message.Options
+	Body    []byte
+	// Expires is the time when the response becomes stale.
+	Expires time.Time
+}
+
+// Storage stores cached responses by the cache key. Implementations must be safe for concurrent use.
+type Storage interface {
+	Load(key string) (Entry, bool)
+	Store(key string, e Entry)
+	Delete(key string)
+}
+
+type lruItem struct {
+	key   string
+	entry Entry
+}
+
+// LRU is in-memory Storage which evicts the least recently used entries when it is full.
+type LRU struct {
+	capacity int
+
+	mutex sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+// NewLRU creates in-memory storage of capacity entries.
+func NewLRU(capacity int) *LRU {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRU{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Load returns the entry and marks it as recently used.
+func (c *LRU) Load(key string) (Entry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruItem).entry, true
+}
+
+// Store stores the entry and evicts the least recently used one when the storage is full.
+func (c *LRU) Store(key string, entry Entry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruItem).entry = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruItem{key: key, entry: entry})
+	for c.order.Len() > c.capacity {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*lruItem).key)
+	}
+}
+
+// Delete removes the entry.
+func (c *LRU) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// Len returns number of stored entries.
+func (c *LRU) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
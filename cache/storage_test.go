@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(2)
+	c.Store("a", Entry{Code: codes.Content})
+	c.Store("b", Entry{Code: codes.NotFound})
+	_, ok := c.Load("a")
+	require.True(t, ok)
+	// b is the least recently used
+	c.Store("c", Entry{Code: codes.Content})
+	require.Equal(t, 2, c.Len())
+	_, ok = c.Load("b")
+	require.False(t, ok)
+	_, ok = c.Load("a")
+	require.True(t, ok)
+
+	c.Store("c", Entry{Code: codes.BadRequest})
+	e, ok := c.Load("c")
+	require.True(t, ok)
+	require.Equal(t, codes.BadRequest, e.Code)
+
+	c.Delete("c")
+	_, ok = c.Load("c")
+	require.False(t, ok)
+	require.Equal(t, 1, c.Len())
+}
+
+func TestKey(t *testing.T) {
+	req := func(opts ...message.Option) *message.Message {
+		return &message.Message{Code: codes.GET, Options: opts}
+	}
+	path := message.Option{ID: message.URIPath, Value: []byte("a")}
+	query := message.Option{ID: message.URIQuery, Value: []byte("q")}
+	accept := message.Option{ID: message.Accept, Value: []byte{50}}
+
+	require.True(t, NoCacheKey(message.Size1))
+	require.False(t, NoCacheKey(message.URIPath))
+
+	require.Equal(t, Key(req(path, query)), Key(req(query, path)))
+	require.Equal(t, Key(req(path)), Key(req(path, message.Option{ID: message.Size1, Value: []byte{1}})))
+	require.Equal(t, Key(req(path)), Key(req(path, message.Option{ID: message.ETag, Value: []byte{1}})))
+	require.NotEqual(t, Key(req(path)), Key(req(path, accept)))
+	require.NotEqual(t, Key(req(path, query)), Key(req(path)))
+	require.NotEqual(t, Key(req(path)), Key(&message.Message{Code: codes.POST, Options: message.Options{path}}))
+}
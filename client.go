@@ -0,0 +1,118 @@
+package coap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	piondtls "github.com/pion/dtls/v2"
+	"github.com/plgd-dev/go-coap/v2/dtls"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/plgd-dev/go-coap/v2/ws"
+)
+
+var defaultDialOptions = dialOptions{
+	dialer: &net.Dialer{Timeout: time.Second * 3},
+}
+
+type dialOptions struct {
+	dialer   *net.Dialer
+	tlsCfg   *tls.Config
+	dtlsCfg  *piondtls.Config
+	udpOpts  []udp.DialOption
+	dtlsOpts []dtls.DialOption
+	tcpOpts  []tcp.DialOption
+}
+
+// Dial creates a client connection to the server identified by CoAP URI. The scheme of the URI
+// selects the transport: coap (UDP), coaps (DTLS), coap+tcp (TCP), coaps+tcp (TLS), coap+ws (WebSockets)
+// and coaps+ws (secure WebSockets).
+// Path and query of the URI are not used.
+//
+// The ctx is used only for resolving of the host and for establishing of the connection.
+func Dial(ctx context.Context, uri string, opts ...DialOption) (mux.Client, error) {
+	u, err := message.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	cfg := defaultDialOptions
+	for _, o := range opts {
+		o.applyDial(&cfg)
+	}
+
+	switch u.Scheme {
+	case message.SchemeCoap:
+		c, err := cfg.dialer.DialContext(ctx, "udp", u.Address())
+		if err != nil {
+			return nil, err
+		}
+		conn, ok := c.(*net.UDPConn)
+		if !ok {
+			c.Close()
+			return nil, fmt.Errorf("unsupported connection type: %T", c)
+		}
+		return udp.Client(conn, append(cfg.udpOpts, udp.WithCloseSocket())...).Client(), nil
+	case message.SchemeCoaps:
+		if cfg.dtlsCfg == nil {
+			return nil, fmt.Errorf("dtls configuration is required for scheme '%v'", u.Scheme)
+		}
+		c, err := cfg.dialer.DialContext(ctx, "udp", u.Address())
+		if err != nil {
+			return nil, err
+		}
+		conn, err := piondtls.ClientWithContext(ctx, c, cfg.dtlsCfg)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return dtls.Client(conn, append(cfg.dtlsOpts, dtls.WithCloseSocket())...).Client(), nil
+	case message.SchemeCoapTCP:
+		c, err := cfg.dialer.DialContext(ctx, "tcp", u.Address())
+		if err != nil {
+			return nil, err
+		}
+		return tcp.Client(c, append(cfg.tcpOpts, tcp.WithCloseSocket())...).Client(), nil
+	case message.SchemeCoapsTCP:
+		c, err := cfg.dialer.DialContext(ctx, "tcp", u.Address())
+		if err != nil {
+			return nil, err
+		}
+		conn, err := coapNet.TLSClient(ctx, c, cfg.tlsCfg, u.Host)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return tcp.Client(conn, append(cfg.tcpOpts, tcp.WithCloseSocket())...).Client(), nil
+	case message.SchemeCoapWS, message.SchemeCoapsWS:
+		location, err := ws.Location(u)
+		if err != nil {
+			return nil, err
+		}
+		c, err := cfg.dialer.DialContext(ctx, "tcp", u.Address())
+		if err != nil {
+			return nil, err
+		}
+		conn := c
+		if u.Scheme == message.SchemeCoapsWS {
+			conn, err = coapNet.TLSClient(ctx, c, cfg.tlsCfg, u.Host)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		cc, err := ws.Client(ctx, conn, location, cfg.tcpOpts...)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return cc.Client(), nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme '%v'", u.Scheme)
+	}
+}
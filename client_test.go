@@ -0,0 +1,252 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/plgd-dev/go-coap/v2/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouter(t *testing.T) *mux.Router {
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")))
+		require.NoError(t, err)
+	}))
+	return m
+}
+
+func TestDial(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	udpListener, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer udpListener.Close()
+	udpServer := udp.NewServer(udp.WithMux(newTestRouter(t)))
+	defer udpServer.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := udpServer.Serve(udpListener)
+		require.NoError(t, err)
+	}()
+
+	tcpListener, err := coapNet.NewTCPListener("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer tcpListener.Close()
+	tcpServer := tcp.NewServer(tcp.WithMux(newTestRouter(t)))
+	defer tcpServer.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := tcpServer.Serve(tcpListener)
+		require.NoError(t, err)
+	}()
+
+	wsListener := ws.NewListener()
+	defer wsListener.Close()
+	httpServer := httptest.NewServer(wsListener)
+	defer httpServer.Close()
+	wsServer := tcp.NewServer(tcp.WithMux(newTestRouter(t)))
+	defer wsServer.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := wsServer.Serve(wsListener)
+		require.NoError(t, err)
+	}()
+
+	tests := []struct {
+		name    string
+		uri     string
+		wantErr bool
+	}{
+		{
+			name: "coap",
+			uri:  fmt.Sprintf("coap://%v/a", udpListener.LocalAddr()),
+		},
+		{
+			name: "coap+tcp",
+			uri:  fmt.Sprintf("coap+tcp://%v/a", tcpListener.Addr()),
+		},
+		{
+			name: "coap+ws",
+			uri:  fmt.Sprintf("coap+ws://%v/a", httpServer.Listener.Addr()),
+		},
+		{
+			name:    "coaps without dtls config",
+			uri:     fmt.Sprintf("coaps://%v/a", udpListener.LocalAddr()),
+			wantErr: true,
+		},
+		{
+			name:    "unsupported scheme",
+			uri:     fmt.Sprintf("http://%v/a", tcpListener.Addr()),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			c, err := Dial(ctx, tt.uri)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer c.Close()
+
+			u, err := message.ParseURI(tt.uri)
+			require.NoError(t, err)
+			resp, err := c.Get(ctx, u.PathString())
+			require.NoError(t, err)
+			require.Equal(t, codes.Content, resp.Code)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, []byte("hello"), body)
+		})
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	l, err := coapNet.NewTCPListener("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer l.Close()
+
+	obs := mux.NewObservers(0, func(err error) {})
+	m := mux.NewRouter()
+	m.Use(obs.Middleware)
+	m.Handle("/obs", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("init")))
+		require.NoError(t, err)
+	}))
+	serverConns := make(chan *tcp.ClientConn, 2)
+	s := tcp.NewServer(tcp.WithMux(m), tcp.WithOnNewClientConn(func(cc *tcp.ClientConn, tlscon *tls.Conn) {
+		serverConns <- cc
+	}))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	states := make(chan ConnectionState, 16)
+	c, err := DialReconnecting(ctx, fmt.Sprintf("coap+tcp://%v", l.Addr()),
+		WithBackoff(time.Millisecond*10, time.Millisecond*100),
+		WithOnStateChange(func(state ConnectionState, err error) {
+			states <- state
+		}))
+	require.NoError(t, err)
+	require.Equal(t, Connected, <-states)
+
+	notifications := make(chan string, 8)
+	o, err := c.Observe(ctx, "/obs", func(n *message.Message) {
+		body, err := ioutil.ReadAll(n.Body)
+		require.NoError(t, err)
+		notifications <- string(body)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "init", <-notifications)
+
+	// the server closes the connection so the client reconnects and registers the observation again
+	serverCC := <-serverConns
+	require.NoError(t, serverCC.Close())
+	require.Equal(t, Disconnected, <-states)
+	require.Equal(t, Reconnecting, <-states)
+	require.Equal(t, Connected, <-states)
+	<-serverConns
+	require.Equal(t, "init", <-notifications)
+
+	err = obs.Publish("/obs", message.TextPlain, bytes.NewReader([]byte("changed")))
+	require.NoError(t, err)
+	require.Equal(t, "changed", <-notifications)
+
+	resp, err := c.Get(ctx, "/obs")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+
+	require.NoError(t, o.Cancel(ctx))
+	require.False(t, obs.Observed("/obs"))
+	require.NoError(t, c.Close())
+	require.Equal(t, Closed, <-states)
+	_, err = c.Get(ctx, "/obs")
+	require.ErrorIs(t, err, ErrClientClosed)
+}
+
+func TestReconnectingClient_Release(t *testing.T) {
+	newServer := func(wg *sync.WaitGroup, serverConns chan *tcp.ClientConn) (*tcp.Server, string) {
+		l, err := coapNet.NewTCPListener("tcp", "127.0.0.1:")
+		require.NoError(t, err)
+		s := tcp.NewServer(tcp.WithMux(newTestRouter(t)), tcp.WithOnNewClientConn(func(cc *tcp.ClientConn, tlscon *tls.Conn) {
+			serverConns <- cc
+		}))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer l.Close()
+			err := s.Serve(l)
+			require.NoError(t, err)
+		}()
+		return s, l.Addr().String()
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	releasingConns := make(chan *tcp.ClientConn, 2)
+	releasing, releasingAddr := newServer(&wg, releasingConns)
+	defer releasing.Stop()
+	alternativeConns := make(chan *tcp.ClientConn, 2)
+	alternative, alternativeAddr := newServer(&wg, alternativeConns)
+	defer alternative.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	states := make(chan ConnectionState, 16)
+	c, err := DialReconnecting(ctx, fmt.Sprintf("coap+tcp://%v", releasingAddr),
+		WithBackoff(time.Millisecond*10, time.Millisecond*100),
+		WithOnStateChange(func(state ConnectionState, err error) {
+			states <- state
+		}))
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, Connected, <-states)
+
+	// the server releases the connection so the client reconnects to the alternative address
+	releasingCC := <-releasingConns
+	err = releasingCC.Release(ctx, alternativeAddr, 0)
+	require.NoError(t, err)
+	require.Equal(t, Disconnected, <-states)
+	require.Equal(t, Reconnecting, <-states)
+	require.Equal(t, Connected, <-states)
+	<-alternativeConns
+	require.Equal(t, alternativeAddr, c.RemoteAddr().String())
+	select {
+	case <-releasingCC.Context().Done():
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+
+	resp, err := c.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+}
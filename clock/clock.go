@@ -0,0 +1,66 @@
+// Package clock abstracts time, so timers of connections can be driven by a fake clock in tests.
+package clock
+
+import "time"
+
+// Clock provides time and timers.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Until(t time.Time) time.Duration
+	// NewTimer creates a timer which sends the current time on its channel after at least duration d.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc waits for the duration to elapse and then calls f in its own goroutine.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer represents a single event, see time.Timer.
+type Timer interface {
+	// C returns channel of the timer, it is nil for timers created by AfterFunc.
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// New returns the clock of the system.
+func New() Clock {
+	return realClock{}
+}
+
+// Get returns c or the clock of the system when c is nil.
+func Get(c Clock) Clock {
+	if c == nil {
+		return New()
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (realClock) Until(t time.Time) time.Duration {
+	return time.Until(t)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
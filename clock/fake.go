@@ -0,0 +1,170 @@
+package clock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock which moves only by Advance, so tests of timeouts don't wait for the real time.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+// NewFake creates a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the time of the fake clock.
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// Since returns the time elapsed since t on the fake clock.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Until returns the duration until t on the fake clock.
+func (f *Fake) Until(t time.Time) time.Duration {
+	return t.Sub(f.Now())
+}
+
+// NewTimer creates a timer which fires when the clock is advanced by d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{fake: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc calls fn in its own goroutine when the clock is advanced by d.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{fake: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d and fires timers which expire in the meantime in order of their deadlines.
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	target := f.now.Add(d)
+	f.mutex.Unlock()
+	for {
+		f.mutex.Lock()
+		if len(f.timers) == 0 || f.timers[0].deadline.After(target) {
+			if target.After(f.now) {
+				f.now = target
+			}
+			f.mutex.Unlock()
+			return
+		}
+		t := f.timers[0]
+		f.timers = f.timers[1:]
+		if t.deadline.After(f.now) {
+			f.now = t.deadline
+		}
+		now := f.now
+		f.mutex.Unlock()
+		t.fire(now)
+	}
+}
+
+// PendingTimers returns the number of timers which didn't fire yet and weren't stopped.
+func (f *Fake) PendingTimers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.timers)
+}
+
+// WaitForTimers waits until at least n timers are pending, eg. until a goroutine starts waiting for a timeout.
+func (f *Fake) WaitForTimers(ctx context.Context, n int) error {
+	for {
+		f.mutex.Lock()
+		pending, changed := len(f.timers), f.changed
+		f.mutex.Unlock()
+		if pending >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("cannot wait for %v timers, %v are pending: %w", n, pending, ctx.Err())
+		}
+	}
+}
+
+// removeLocked removes the timer from pending timers and returns true when it was pending.
+func (f *Fake) removeLocked(t *fakeTimer) bool {
+	for i, v := range f.timers {
+		if v == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) addLocked(t *fakeTimer) {
+	idx := sort.Search(len(f.timers), func(i int) bool {
+		return f.timers[i].deadline.After(t.deadline)
+	})
+	f.timers = append(f.timers, nil)
+	copy(f.timers[idx+1:], f.timers[idx:])
+	f.timers[idx] = t
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fakeTimer struct {
+	fake     *Fake
+	deadline time.Time
+	c        chan time.Time
+	fn       func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.fake.mutex.Lock()
+	defer t.fake.mutex.Unlock()
+	return t.fake.removeLocked(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.fake.mutex.Lock()
+	active := t.fake.removeLocked(t)
+	t.deadline = t.fake.now.Add(d)
+	if d > 0 {
+		t.fake.addLocked(t)
+		t.fake.mutex.Unlock()
+		return active
+	}
+	now := t.fake.now
+	t.fake.mutex.Unlock()
+	t.fire(now)
+	return active
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		go t.fn()
+		return
+	}
+	select {
+	case t.c <- now:
+	default:
+	}
+}
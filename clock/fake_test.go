@@ -0,0 +1,60 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake_Timers(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	t1 := c.NewTimer(time.Second)
+	t2 := c.NewTimer(time.Minute)
+	t3 := c.NewTimer(time.Hour)
+	require.Equal(t, 3, c.PendingTimers())
+	require.True(t, t3.Stop())
+	require.False(t, t3.Stop())
+
+	c.Advance(time.Second * 30)
+	require.Equal(t, start.Add(time.Second), <-t1.C())
+	select {
+	case <-t2.C():
+		require.FailNow(t, "timer fired before deadline")
+	default:
+	}
+	require.Equal(t, start.Add(time.Second*30), c.Now())
+	require.Equal(t, time.Second*30, c.Since(start))
+
+	require.False(t, t1.Reset(time.Minute))
+	c.Advance(time.Minute)
+	require.Equal(t, start.Add(time.Minute), <-t2.C())
+	require.Equal(t, start.Add(time.Second*90), <-t1.C())
+	require.Equal(t, 0, c.PendingTimers())
+}
+
+func TestFake_AfterFunc(t *testing.T) {
+	c := NewFake(time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	fired := make(chan struct{})
+	go func() {
+		c.AfterFunc(time.Hour, func() {
+			close(fired)
+		})
+	}()
+	require.NoError(t, c.WaitForTimers(ctx, 1))
+	c.Advance(time.Hour)
+	select {
+	case <-fired:
+	case <-ctx.Done():
+		require.FailNow(t, "function was not called")
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer waitCancel()
+	require.Error(t, c.WaitForTimers(waitCtx, 1))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"gopkg.in/yaml.v3"
+)
+
+// serverConfig describes resources served by the server. JSON is accepted too, because it is a subset of YAML.
+type serverConfig struct {
+	Resources []resourceConfig `yaml:"resources"`
+}
+
+// resourceConfig describes a resource and its response.
+type resourceConfig struct {
+	// Path is the pattern of the resource, eg. /devices/{id}/temperature.
+	Path string `yaml:"path"`
+	// Methods accepted by the resource, the default is GET.
+	Methods []string `yaml:"methods"`
+	// ContentFormat of the payload: an alias (text, json, cbor, link-format, xml, octets), the name or the number.
+	ContentFormat string `yaml:"contentFormat"`
+	// Payload is a static payload of the response.
+	Payload string `yaml:"payload"`
+	// Template is a text/template of the payload, it is used instead of Payload when it is set.
+	Template string `yaml:"template"`
+	// Code of the response, eg. 4.04, NotFound or 132. The default depends on the method.
+	Code string `yaml:"code"`
+	// Delay of the response.
+	Delay time.Duration `yaml:"delay"`
+	// Observable resources accept observe registrations.
+	Observable bool `yaml:"observable"`
+	// Period of notifications of observers, it makes the resource observable.
+	Period time.Duration `yaml:"period"`
+	// Attributes describe the resource in /.well-known/core, eg. rt: temperature.
+	Attributes map[string]string `yaml:"attributes"`
+}
+
+// loadConfig reads the configuration from a YAML or JSON file.
+func loadConfig(path string) (serverConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return serverConfig{}, fmt.Errorf("cannot read config: %w", err)
+	}
+	return parseConfig(data)
+}
+
+// parseConfig parses the configuration from YAML or JSON.
+func parseConfig(data []byte) (serverConfig, error) {
+	var cfg serverConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return serverConfig{}, fmt.Errorf("cannot parse config: %w", err)
+	}
+	return cfg, nil
+}
+
+var mediaTypeAliases = map[string]message.MediaType{
+	"text":        message.TextPlain,
+	"link-format": message.AppLinkFormat,
+	"xml":         message.AppXML,
+	"octets":      message.AppOctets,
+	"exi":         message.AppExi,
+	"json":        message.AppJSON,
+	"cbor":        message.AppCBOR,
+}
+
+func parseMediaType(v string) (message.MediaType, error) {
+	if v == "" {
+		return message.TextPlain, nil
+	}
+	if mt, ok := mediaTypeAliases[strings.ToLower(v)]; ok {
+		return mt, nil
+	}
+	if n, err := strconv.ParseUint(v, 10, 16); err == nil {
+		return message.MediaType(n), nil
+	}
+	if mt, err := message.ToMediaType(v); err == nil {
+		return mt, nil
+	}
+	return 0, fmt.Errorf("unknown content format '%v'", v)
+}
+
+var dottedCode = regexp.MustCompile(`^([0-7])\.([0-9]{2})$`)
+
+// parseCode parses the code in the form class.detail, by the name or by the number.
+func parseCode(v string) (codes.Code, error) {
+	if m := dottedCode.FindStringSubmatch(v); m != nil {
+		class, _ := strconv.Atoi(m[1])
+		detail, _ := strconv.Atoi(m[2])
+		if detail < 32 {
+			return codes.Code(class<<5 | detail), nil
+		}
+	}
+	if n, err := strconv.ParseUint(v, 10, 8); err == nil {
+		return codes.Code(n), nil
+	}
+	if c, err := codes.ToCode(v); err == nil {
+		return c, nil
+	}
+	return 0, fmt.Errorf("unknown code '%v'", v)
+}
+
+func parseMethod(v string) (codes.Code, error) {
+	switch strings.ToUpper(v) {
+	case "GET":
+		return codes.GET, nil
+	case "POST":
+		return codes.POST, nil
+	case "PUT":
+		return codes.PUT, nil
+	case "DELETE":
+		return codes.DELETE, nil
+	case "FETCH":
+		return codes.FETCH, nil
+	case "PATCH":
+		return codes.PATCH, nil
+	case "IPATCH":
+		return codes.IPATCH, nil
+	}
+	return 0, fmt.Errorf("unknown method '%v'", v)
+}
+
+// compiledResource is a validated resource.
+type compiledResource struct {
+	path          string
+	methods       []codes.Code
+	contentFormat message.MediaType
+	payload       []byte
+	template      *template.Template
+	code          codes.Code
+	delay         time.Duration
+	observable    bool
+	period        time.Duration
+	attributes    []message.LinkAttribute
+}
+
+func (r resourceConfig) compile() (compiledResource, error) {
+	if r.Path == "" {
+		return compiledResource{}, fmt.Errorf("path is not set")
+	}
+	c := compiledResource{
+		path:       r.Path,
+		payload:    []byte(r.Payload),
+		delay:      r.Delay,
+		observable: r.Observable || r.Period > 0,
+		period:     r.Period,
+	}
+	methods := r.Methods
+	if len(methods) == 0 {
+		methods = []string{"GET"}
+	}
+	for _, m := range methods {
+		code, err := parseMethod(m)
+		if err != nil {
+			return compiledResource{}, err
+		}
+		c.methods = append(c.methods, code)
+	}
+	var err error
+	if c.contentFormat, err = parseMediaType(r.ContentFormat); err != nil {
+		return compiledResource{}, err
+	}
+	if r.Code != "" {
+		if c.code, err = parseCode(r.Code); err != nil {
+			return compiledResource{}, err
+		}
+	}
+	if r.Template != "" {
+		if c.template, err = template.New(r.Path).Parse(r.Template); err != nil {
+			return compiledResource{}, fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	names := make([]string, 0, len(r.Attributes))
+	for name := range r.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.attributes = append(c.attributes, message.LinkAttribute{Name: name, Value: r.Attributes[name]})
+	}
+	if c.observable {
+		c.attributes = append(c.attributes, message.LinkAttribute{Name: message.LinkAttrObservable})
+	}
+	if r.ContentFormat != "" {
+		c.attributes = append(c.attributes, message.LinkAttribute{Name: message.LinkAttrContentFormat, Value: strconv.Itoa(int(c.contentFormat))})
+	}
+	return c, nil
+}
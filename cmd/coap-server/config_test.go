@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	want := serverConfig{
+		Resources: []resourceConfig{
+			{
+				Path:          "/a",
+				Methods:       []string{"GET", "PUT"},
+				ContentFormat: "json",
+				Payload:       `{"on":true}`,
+				Code:          "4.04",
+				Delay:         time.Second,
+				Period:        100 * time.Millisecond,
+				Attributes:    map[string]string{"rt": "light"},
+			},
+		},
+	}
+	yamlConfig := `
+resources:
+  - path: /a
+    methods: [GET, PUT]
+    contentFormat: json
+    payload: '{"on":true}'
+    code: 4.04
+    delay: 1s
+    period: 100ms
+    attributes:
+      rt: light
+`
+	cfg, err := parseConfig([]byte(yamlConfig))
+	require.NoError(t, err)
+	require.Equal(t, want, cfg)
+
+	jsonConfig := `{"resources": [{"path": "/a", "methods": ["GET", "PUT"], "contentFormat": "json", "payload": "{\"on\":true}",
+		"code": "4.04", "delay": "1s", "period": "100ms", "attributes": {"rt": "light"}}]}`
+	cfg, err = parseConfig([]byte(jsonConfig))
+	require.NoError(t, err)
+	require.Equal(t, want, cfg)
+
+	_, err = parseConfig([]byte("resources:\n  - path: /a\n    unknown: 1\n"))
+	require.Error(t, err)
+}
+
+func TestResourceConfig_Compile(t *testing.T) {
+	c, err := resourceConfig{
+		Path:          "/a",
+		ContentFormat: "cbor",
+		Code:          "NotFound",
+		Period:        time.Second,
+		Attributes:    map[string]string{"rt": "light", "if": "core.a"},
+	}.compile()
+	require.NoError(t, err)
+	require.Equal(t, []codes.Code{codes.GET}, c.methods)
+	require.Equal(t, message.AppCBOR, c.contentFormat)
+	require.Equal(t, codes.NotFound, c.code)
+	require.True(t, c.observable)
+	require.Equal(t, []message.LinkAttribute{
+		{Name: "if", Value: "core.a"},
+		{Name: "rt", Value: "light"},
+		{Name: "obs"},
+		{Name: "ct", Value: "60"},
+	}, c.attributes)
+
+	for _, code := range []string{"5.03", "163", "ServiceUnavailable"} {
+		c, err := resourceConfig{Path: "/a", Code: code}.compile()
+		require.NoError(t, err)
+		require.Equal(t, codes.ServiceUnavailable, c.code)
+	}
+
+	invalid := []resourceConfig{
+		{},
+		{Path: "/a", Methods: []string{"GOT"}},
+		{Path: "/a", ContentFormat: "unknown"},
+		{Path: "/a", Code: "9.99"},
+		{Path: "/a", Template: "{{.Unclosed"},
+	}
+	for _, r := range invalid {
+		_, err := r.compile()
+		require.Error(t, err, r)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+// templateData is passed to templates of payloads.
+type templateData struct {
+	// Method of the request, eg. GET.
+	Method string
+	// Path of the request.
+	Path string
+	// Params are values of parameters of the resource path, eg. id of /devices/{id}.
+	Params map[string]string
+	// Queries of the request.
+	Queries []string
+	// Payload of the request.
+	Payload string
+	// Count is the number of responses of the resource including notifications, starting at 1.
+	Count uint64
+	// Time is the time of the response.
+	Time time.Time
+	// Remote is the address of the client.
+	Remote string
+}
+
+// server serves resources of the config.
+type server struct {
+	// router serves the resources, it is passed to the ListenAndServe helpers
+	router *mux.Router
+
+	logger    *log.Logger
+	observers *mux.Observers
+	resources []*resource
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type resource struct {
+	compiledResource
+	server *server
+	count  uint64
+
+	mutex sync.Mutex
+	// observed are paths of registrations, a pattern with parameters is observed by more paths.
+	observed map[string]struct{}
+}
+
+// newServer creates handlers of resources. Notifications of observable resources with a period are
+// sent until Close is called. Exchanges are logged by logger.
+func newServer(cfg serverConfig, logger *log.Logger) (*server, error) {
+	s := &server{
+		router: mux.NewRouter(),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	s.observers = mux.NewObservers(0, func(err error) {
+		logger.Printf("cannot notify observer: %v", err)
+	})
+	s.router.Use(s.logExchanges)
+	for i, rc := range cfg.Resources {
+		c, err := rc.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource %v (%v): %w", i, rc.Path, err)
+		}
+		r := &resource{
+			compiledResource: c,
+			server:           s,
+			observed:         make(map[string]struct{}),
+		}
+		var h mux.Handler = r
+		if c.observable {
+			h = s.observers.Middleware(h)
+		}
+		err = s.router.HandleRoute(c.path, h, mux.WithMethods(c.methods...), mux.WithAttributes(c.attributes...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource %v (%v): %w", i, rc.Path, err)
+		}
+		s.resources = append(s.resources, r)
+	}
+	for _, r := range s.resources {
+		if r.period > 0 {
+			s.wg.Add(1)
+			go r.notifyPeriodically()
+		}
+	}
+	return s, nil
+}
+
+// Close stops notifications.
+func (s *server) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (r *resource) notifyPeriodically() {
+	defer r.server.wg.Done()
+	t := time.NewTicker(r.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-r.server.done:
+			return
+		}
+		r.mutex.Lock()
+		paths := make([]string, 0, len(r.observed))
+		for path := range r.observed {
+			if !r.server.observers.Observed(path) {
+				delete(r.observed, path)
+				continue
+			}
+			paths = append(paths, path)
+		}
+		r.mutex.Unlock()
+		for _, path := range paths {
+			r.server.logger.Printf("notify /%v", path)
+			r.server.observers.Notify(path)
+		}
+	}
+}
+
+func (r *resource) defaultCode(method codes.Code) codes.Code {
+	if r.code != 0 {
+		return r.code
+	}
+	switch method {
+	case codes.POST:
+		return codes.Created
+	case codes.PUT, codes.PATCH, codes.IPATCH:
+		return codes.Changed
+	case codes.DELETE:
+		return codes.Deleted
+	}
+	return codes.Content
+}
+
+func (r *resource) render(w mux.ResponseWriter, req *mux.Message, path string, count uint64) ([]byte, error) {
+	if r.template == nil {
+		return r.payload, nil
+	}
+	data := templateData{
+		Method: req.Code.String(),
+		Path:   "/" + path,
+		Params: req.RouteParams,
+		Count:  count,
+		Time:   time.Now(),
+	}
+	if w.Client() != nil {
+		data.Remote = w.Client().RemoteAddr().String()
+	}
+	if queries, err := req.Options.Queries(); err == nil {
+		data.Queries = queries
+	}
+	if req.Body != nil {
+		payload, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read payload: %w", err)
+		}
+		data.Payload = string(payload)
+	}
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("cannot execute template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *resource) ServeCOAP(w mux.ResponseWriter, req *mux.Message) {
+	path, err := req.Options.Path()
+	if err != nil {
+		path = ""
+	}
+	if r.delay > 0 {
+		ctx := req.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+	if obs, err := req.Options.Observe(); err == nil && obs == 0 && r.observable {
+		r.mutex.Lock()
+		r.observed[path] = struct{}{}
+		r.mutex.Unlock()
+	}
+	count := atomic.AddUint64(&r.count, 1)
+	body, err := r.render(w, req, path, count)
+	if err != nil {
+		r.server.logger.Printf("/%v: %v", path, err)
+		w.SetResponse(codes.InternalServerError, message.TextPlain, strings.NewReader(err.Error()))
+		return
+	}
+	var payload io.ReadSeeker
+	if len(body) > 0 {
+		payload = bytes.NewReader(body)
+	}
+	if err := w.SetResponse(r.defaultCode(req.Code), r.contentFormat, payload); err != nil {
+		r.server.logger.Printf("/%v: cannot set response: %v", path, err)
+	}
+}
+
+type loggingResponseWriter struct {
+	mux.ResponseWriter
+	code codes.Code
+	size int
+}
+
+func (w *loggingResponseWriter) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	w.code = code
+	if d != nil {
+		if n, err := d.Seek(0, io.SeekEnd); err == nil {
+			w.size = int(n)
+		}
+		if _, err := d.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return w.ResponseWriter.SetResponse(code, contentFormat, d, opts...)
+}
+
+// logExchanges logs requests with their responses.
+func (s *server) logExchanges(next mux.Handler) mux.Handler {
+	return mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeCOAP(lw, r)
+		path, err := r.Options.Path()
+		if err != nil {
+			path = ""
+		}
+		remote := ""
+		if w.Client() != nil {
+			remote = w.Client().RemoteAddr().String()
+		}
+		observe := ""
+		if obs, err := r.Options.Observe(); err == nil {
+			observe = fmt.Sprintf(" observe=%v", obs)
+		}
+		response := "no response"
+		if lw.code != 0 {
+			response = fmt.Sprintf("%v %vB", lw.code, lw.size)
+		}
+		s.logger.Printf("%v %v /%v%v -> %v (%v)", remote, r.Code, path, observe, response, time.Since(start))
+	})
+}
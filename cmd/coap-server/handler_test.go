@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/coaptest"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+const testConfig = `
+resources:
+  - path: /a
+    methods: [GET, PUT]
+    contentFormat: json
+    payload: '{"on":true}'
+    attributes: {rt: light}
+  - path: /devices/{id}
+    methods: [POST]
+    template: '{{.Method}} {{.Params.id}} {{.Payload}} {{index .Queries 0}} {{.Count}}'
+  - path: /error
+    code: 5.03
+  - path: /slow
+    delay: 50ms
+  - path: /obs
+    period: 20ms
+    template: '{{.Count}}'
+`
+
+func newTestServer(t *testing.T) (*server, *syncBuffer) {
+	cfg, err := parseConfig([]byte(testConfig))
+	require.NoError(t, err)
+	var logs syncBuffer
+	s, err := newServer(cfg, log.New(&logs, "", 0))
+	require.NoError(t, err)
+	return s, &logs
+}
+
+func readPayload(t *testing.T, msg *message.Message) string {
+	require.NotNil(t, msg.Body)
+	data, err := ioutil.ReadAll(msg.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestServer_Resources(t *testing.T) {
+	s, logs := newTestServer(t)
+	defer s.Close()
+	srv := coaptest.NewServer(s.router)
+	defer srv.Close()
+	cl := srv.Client()
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := cl.Get(ctx, "/a")
+	require.NoError(t, err)
+	coaptest.AssertMessage(t, resp, codes.Content, []byte(`{"on":true}`))
+	cf, err := resp.Options.ContentFormat()
+	require.NoError(t, err)
+	require.Equal(t, message.AppJSON, cf)
+
+	resp, err = cl.Put(ctx, "/a", message.AppJSON, bytes.NewReader([]byte(`{"on":false}`)))
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code)
+
+	resp, err = cl.Delete(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.MethodNotAllowed, resp.Code)
+
+	query := message.Option{ID: message.URIQuery, Value: []byte("x=1")}
+	for i, want := range []string{"POST 7 hi x=1 1", "POST 7 hi x=1 2"} {
+		resp, err = cl.Post(ctx, "/devices/7", message.TextPlain, bytes.NewReader([]byte("hi")), query)
+		require.NoError(t, err, i)
+		require.Equal(t, codes.Created, resp.Code)
+		require.Equal(t, want, readPayload(t, resp))
+	}
+
+	resp, err = cl.Get(ctx, "/error")
+	require.NoError(t, err)
+	require.Equal(t, codes.ServiceUnavailable, resp.Code)
+
+	start := time.Now()
+	resp, err = cl.Get(ctx, "/slow")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	resp, err = cl.Get(ctx, "/.well-known/core")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+	links := readPayload(t, resp)
+	require.Contains(t, links, `</a>;rt="light";ct=50`)
+	require.Contains(t, links, `</obs>;obs`)
+
+	require.Contains(t, logs.String(), " GET /a -> Content 11B (")
+	require.Contains(t, logs.String(), " DELETE /a -> MethodNotAllowed 0B (")
+}
+
+func TestServer_Observe(t *testing.T) {
+	s, logs := newTestServer(t)
+	defer s.Close()
+	srv := coaptest.NewServer(s.router)
+	defer srv.Close()
+	cl := srv.Client()
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	notifications := make(chan string, 16)
+	obs, err := cl.Observe(ctx, "/obs", func(n *message.Message) {
+		data, err := ioutil.ReadAll(n.Body)
+		require.NoError(t, err)
+		select {
+		case notifications <- string(data):
+		default:
+		}
+	})
+	require.NoError(t, err)
+	var got []string
+	for len(got) < 3 {
+		select {
+		case n := <-notifications:
+			got = append(got, n)
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		}
+	}
+	require.Equal(t, []string{"1", "2", "3"}, got)
+	err = obs.Cancel(ctx)
+	require.NoError(t, err)
+	require.True(t, strings.Contains(logs.String(), "notify /obs"))
+}
@@ -0,0 +1,199 @@
+// Command coap-server serves mock resources defined in a YAML or JSON file over UDP, DTLS, TCP or TLS.
+//
+// Usage:
+//
+//	coap-server [flags] -config resources.yaml
+//
+// Example of the configuration:
+//
+//	resources:
+//	  - path: /light
+//	    methods: [GET, PUT]
+//	    contentFormat: json
+//	    payload: '{"on": true}'
+//	    attributes: {rt: core.light}
+//	  - path: /sensors/{id}/temperature
+//	    contentFormat: json
+//	    template: '{"id": "{{.Params.id}}", "t": {{.Count}}}'
+//	    period: 1s
+//	  - path: /slow
+//	    delay: 3s
+//	    code: 5.03
+//
+// Templates use text/template, see templateData for the available values.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	piondtls "github.com/pion/dtls/v2"
+	coap "github.com/plgd-dev/go-coap/v2"
+)
+
+type flags struct {
+	config  string
+	network string
+	addr    string
+	cert    string
+	key     string
+	ca      string
+	psk     string
+	pskHint string
+}
+
+func newFlagSet(f *flags, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("coap-server", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&f.config, "config", "", "YAML or JSON `file` with resources")
+	fs.StringVar(&f.network, "net", "udp", "transport: udp, dtls, tcp or tls")
+	fs.StringVar(&f.addr, "addr", "", "listen address, the default is :5683 for udp and tcp, :5684 for dtls and tls")
+	fs.StringVar(&f.cert, "cert", "", "PEM encoded certificate of the server for dtls and tls")
+	fs.StringVar(&f.key, "key", "", "PEM encoded private key of the certificate")
+	fs.StringVar(&f.ca, "ca", "", "PEM encoded certificates of authorities, clients must present a certificate signed by them")
+	fs.StringVar(&f.psk, "psk", "", "pre-shared key for dtls, hex encoded keys have 0x prefix")
+	fs.StringVar(&f.pskHint, "psk-hint", "", "identity hint of the pre-shared key")
+	return fs
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	var f flags
+	fs := newFlagSet(&f, stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+	logger := log.New(stderr, "", log.LstdFlags|log.Lmicroseconds)
+	if f.config == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return 1
+	}
+	cfg, err := loadConfig(f.config)
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+	s, err := newServer(cfg, logger)
+	if err != nil {
+		logger.Print(err)
+		return 1
+	}
+	defer s.Close()
+	if err := listenAndServe(f, s, logger); err != nil {
+		logger.Print(err)
+		return 1
+	}
+	return 0
+}
+
+func listenAndServe(f flags, s *server, logger *log.Logger) error {
+	addr := f.addr
+	if addr == "" {
+		addr = ":5683"
+		if f.network == "dtls" || f.network == "tls" {
+			addr = ":5684"
+		}
+	}
+	logger.Printf("serving %v resources over %v on %v", len(s.resources), f.network, addr)
+	switch f.network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+		return coap.ListenAndServe(f.network, addr, s.router)
+	case "dtls":
+		cfg, err := dtlsConfig(f)
+		if err != nil {
+			return err
+		}
+		return coap.ListenAndServeDTLS("udp", addr, cfg, s.router)
+	case "tls":
+		cfg, err := tlsConfig(f)
+		if err != nil {
+			return err
+		}
+		return coap.ListenAndServeTCPTLS("tcp", addr, cfg, s.router)
+	}
+	return fmt.Errorf("invalid network '%v'", f.network)
+}
+
+func loadCertificates(f flags) ([]tls.Certificate, *x509.CertPool, error) {
+	if f.cert == "" || f.key == "" {
+		return nil, nil, fmt.Errorf("certificate and key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(f.cert, f.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load certificate: %w", err)
+	}
+	if f.ca == "" {
+		return []tls.Certificate{cert}, nil, nil
+	}
+	data, err := ioutil.ReadFile(f.ca)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, nil, fmt.Errorf("cannot load ca: no certificate found in %v", f.ca)
+	}
+	return []tls.Certificate{cert}, pool, nil
+}
+
+func dtlsConfig(f flags) (*piondtls.Config, error) {
+	if f.psk != "" {
+		key := []byte(f.psk)
+		if strings.HasPrefix(f.psk, "0x") {
+			var err error
+			if key, err = hex.DecodeString(f.psk[2:]); err != nil {
+				return nil, fmt.Errorf("invalid psk: %w", err)
+			}
+		}
+		return &piondtls.Config{
+			PSK: func([]byte) ([]byte, error) {
+				return key, nil
+			},
+			PSKIdentityHint: []byte(f.pskHint),
+			CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+		}, nil
+	}
+	certs, pool, err := loadCertificates(f)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &piondtls.Config{
+		Certificates:         certs,
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}
+	if pool != nil {
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = piondtls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func tlsConfig(f flags) (*tls.Config, error) {
+	certs, pool, err := loadCertificates(f)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: certs,
+	}
+	if pool != nil {
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
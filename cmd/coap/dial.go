@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	piondtls "github.com/pion/dtls/v2"
+	"github.com/plgd-dev/go-coap/v2/dtls"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/net/blockwise"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+)
+
+// securityConfig holds credentials of coaps and coaps+tcp schemes.
+type securityConfig struct {
+	pskIdentity string
+	psk         string
+	certFile    string
+	keyFile     string
+	caFile      string
+	insecure    bool
+}
+
+// parsePSK decodes the key, hex encoded keys have 0x prefix.
+func parsePSK(v string) ([]byte, error) {
+	if strings.HasPrefix(v, "0x") {
+		key, err := hex.DecodeString(v[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid psk: %w", err)
+		}
+		return key, nil
+	}
+	return []byte(v), nil
+}
+
+func (s securityConfig) certificates() ([]tls.Certificate, *x509.CertPool, error) {
+	var certs []tls.Certificate
+	if s.certFile != "" || s.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot load certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if s.caFile == "" {
+		return certs, nil, nil
+	}
+	data, err := ioutil.ReadFile(s.caFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, nil, fmt.Errorf("cannot load ca: no certificate found in %v", s.caFile)
+	}
+	return certs, pool, nil
+}
+
+func (s securityConfig) dtlsConfig(host string) (*piondtls.Config, error) {
+	if s.psk != "" {
+		key, err := parsePSK(s.psk)
+		if err != nil {
+			return nil, err
+		}
+		return &piondtls.Config{
+			PSK: func([]byte) ([]byte, error) {
+				return key, nil
+			},
+			PSKIdentityHint: []byte(s.pskIdentity),
+			CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+		}, nil
+	}
+	certs, pool, err := s.certificates()
+	if err != nil {
+		return nil, err
+	}
+	return &piondtls.Config{
+		Certificates:         certs,
+		RootCAs:              pool,
+		InsecureSkipVerify:   s.insecure,
+		ServerName:           host,
+		ExtendedMasterSecret: piondtls.RequireExtendedMasterSecret,
+	}, nil
+}
+
+func (s securityConfig) tlsConfig() (*tls.Config, error) {
+	certs, pool, err := s.certificates()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:       certs,
+		RootCAs:            pool,
+		InsecureSkipVerify: s.insecure,
+	}, nil
+}
+
+// counters counts bytes transferred over the connection for the progress of blockwise transfers.
+type counters struct {
+	sent     uint64
+	received uint64
+}
+
+func (c *counters) addSent(n int) {
+	if n > 0 {
+		atomic.AddUint64(&c.sent, uint64(n))
+	}
+}
+
+func (c *counters) addReceived(n int) {
+	if n > 0 {
+		atomic.AddUint64(&c.received, uint64(n))
+	}
+}
+
+func (c *counters) String() string {
+	return fmt.Sprintf("sent %v B, received %v B", atomic.LoadUint64(&c.sent), atomic.LoadUint64(&c.received))
+}
+
+type countingUDPConn struct {
+	*net.UDPConn
+	counters *counters
+}
+
+func (c countingUDPConn) Read(b []byte) (int, error) {
+	n, err := c.UDPConn.Read(b)
+	c.counters.addReceived(n)
+	return n, err
+}
+
+func (c countingUDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.UDPConn.ReadFrom(b)
+	c.counters.addReceived(n)
+	return n, addr, err
+}
+
+func (c countingUDPConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, addr, err := c.UDPConn.ReadFromUDP(b)
+	c.counters.addReceived(n)
+	return n, addr, err
+}
+
+func (c countingUDPConn) Write(b []byte) (int, error) {
+	n, err := c.UDPConn.Write(b)
+	c.counters.addSent(n)
+	return n, err
+}
+
+func (c countingUDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.UDPConn.WriteTo(b, addr)
+	c.counters.addSent(n)
+	return n, err
+}
+
+func (c countingUDPConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	n, err := c.UDPConn.WriteToUDP(b, addr)
+	c.counters.addSent(n)
+	return n, err
+}
+
+type countingConn struct {
+	net.Conn
+	counters *counters
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counters.addReceived(n)
+	return n, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counters.addSent(n)
+	return n, err
+}
+
+type dialConfig struct {
+	security  securityConfig
+	szx       blockwise.SZX
+	timeout   time.Duration
+	counters  *counters
+	errorFunc func(error)
+}
+
+// dial connects to the server of the URI, the transport is selected by the scheme.
+func dial(ctx context.Context, u message.URI, cfg dialConfig) (mux.Client, error) {
+	dialer := &net.Dialer{}
+	switch u.Scheme {
+	case message.SchemeCoap, message.SchemeCoaps:
+		c, err := dialer.DialContext(ctx, "udp", u.Address())
+		if err != nil {
+			return nil, err
+		}
+		udpConn, ok := c.(*net.UDPConn)
+		if !ok {
+			c.Close()
+			return nil, fmt.Errorf("unsupported connection type: %T", c)
+		}
+		if u.Scheme == message.SchemeCoap {
+			return udp.Client(countingUDPConn{UDPConn: udpConn, counters: cfg.counters},
+				udp.WithBlockwise(true, cfg.szx, cfg.timeout),
+				udp.WithErrors(cfg.errorFunc),
+				udp.WithCloseSocket(),
+			).Client(), nil
+		}
+		dtlsCfg, err := cfg.security.dtlsConfig(u.Host)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		conn, err := piondtls.ClientWithContext(ctx, countingConn{Conn: c, counters: cfg.counters}, dtlsCfg)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("dtls handshake failed: %w", err)
+		}
+		return dtls.Client(conn,
+			dtls.WithBlockwise(true, cfg.szx, cfg.timeout),
+			dtls.WithErrors(cfg.errorFunc),
+			dtls.WithCloseSocket(),
+		).Client(), nil
+	case message.SchemeCoapTCP, message.SchemeCoapsTCP:
+		c, err := dialer.DialContext(ctx, "tcp", u.Address())
+		if err != nil {
+			return nil, err
+		}
+		var conn net.Conn = countingConn{Conn: c, counters: cfg.counters}
+		if u.Scheme == message.SchemeCoapsTCP {
+			tlsCfg, err := cfg.security.tlsConfig()
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			conn, err = coapNet.TLSClient(ctx, conn, tlsCfg, u.Host)
+			if err != nil {
+				c.Close()
+				return nil, fmt.Errorf("tls handshake failed: %w", err)
+			}
+		}
+		return tcp.Client(conn,
+			tcp.WithBlockwise(true, cfg.szx, cfg.timeout),
+			tcp.WithErrors(cfg.errorFunc),
+			tcp.WithCloseSocket(),
+		).Client(), nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme '%v'", u.Scheme)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/plgd-dev/go-coap/v2/udp/client"
+	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
+	"github.com/plgd-dev/go-coap/v2/udp/message/pool"
+)
+
+// discover sends GET to the multicast or unicast address of the coap URI and prints responses
+// of all devices until the timeout.
+func (c *command) discover(ctx context.Context, u message.URI, opts message.Options) (int, error) {
+	if u.Scheme != message.SchemeCoap {
+		return exitError, fmt.Errorf("discovery supports only scheme '%v'", message.SchemeCoap)
+	}
+	network := "udp4"
+	if ip := net.ParseIP(u.Host); ip != nil && ip.To4() == nil {
+		network = "udp6"
+	}
+	l, err := coapNet.NewListenUDP(network, "")
+	if err != nil {
+		return exitError, fmt.Errorf("cannot listen: %w", err)
+	}
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithErrors(func(error) {}))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = s.Serve(l)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	req, err := client.NewGetRequest(ctx, "/"+u.PathString(), opts...)
+	if err != nil {
+		return exitError, fmt.Errorf("cannot create discover request: %w", err)
+	}
+	defer pool.ReleaseMessage(req)
+	req.SetMessageID(udpMessage.GetMID())
+	req.SetType(udpMessage.NonConfirmable)
+
+	var mutex sync.Mutex
+	var printErr error
+	err = s.DiscoveryRequest(req, u.Address(), func(cc *client.ClientConn, resp *pool.Message) {
+		msg := message.Message{
+			Code:    resp.Code(),
+			Token:   resp.Token(),
+			Options: resp.Options(),
+			Body:    resp.Body(),
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err := c.printer.print(cc.RemoteAddr().String(), &msg); err != nil && printErr == nil {
+			printErr = err
+		}
+	})
+	if err != nil {
+		return exitError, fmt.Errorf("discovery failed: %w", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if printErr != nil {
+		return exitError, printErr
+	}
+	return exitOK, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/net/blockwise"
+)
+
+// mediaTypeAliases are short names of content formats accepted by -c and -A.
+var mediaTypeAliases = map[string]message.MediaType{
+	"text":        message.TextPlain,
+	"link-format": message.AppLinkFormat,
+	"xml":         message.AppXML,
+	"octets":      message.AppOctets,
+	"exi":         message.AppExi,
+	"json":        message.AppJSON,
+	"cbor":        message.AppCBOR,
+	"senml+json":  message.MediaType(110),
+	"senml+cbor":  message.MediaType(112),
+	"ocf+cbor":    message.AppOcfCbor,
+	"lwm2m+tlv":   message.AppLwm2mTLV,
+	"lwm2m+json":  message.AppLwm2mJSON,
+}
+
+// parseMediaType parses an alias, the full name or the number of the content format.
+func parseMediaType(v string) (message.MediaType, error) {
+	if mt, ok := mediaTypeAliases[strings.ToLower(v)]; ok {
+		return mt, nil
+	}
+	if n, err := strconv.ParseUint(v, 10, 16); err == nil {
+		return message.MediaType(n), nil
+	}
+	if mt, err := message.ToMediaType(v); err == nil {
+		return mt, nil
+	}
+	return 0, fmt.Errorf("unknown content format '%v'", v)
+}
+
+// parseOption parses option in the form name=value, the name can be the number of the option.
+// Opaque values are hex encoded with 0x prefix or they are used as they are.
+func parseOption(v string) (message.Option, error) {
+	idx := strings.IndexByte(v, '=')
+	name, value := v, ""
+	if idx >= 0 {
+		name, value = v[:idx], v[idx+1:]
+	}
+	id, err := message.ToOptionID(name)
+	if err != nil {
+		n, errN := strconv.ParseUint(name, 10, 16)
+		if errN != nil {
+			return message.Option{}, fmt.Errorf("unknown option '%v'", name)
+		}
+		id = message.OptionID(n)
+	}
+	format := message.ValueOpaque
+	if def, ok := message.CoapOptionDefs[id]; ok {
+		format = def.ValueFormat
+	}
+	switch format {
+	case message.ValueEmpty:
+		return message.Option{ID: id}, nil
+	case message.ValueUint:
+		var n uint64
+		if id == message.ContentFormat || id == message.Accept {
+			mt, err := parseMediaType(value)
+			if err != nil {
+				return message.Option{}, err
+			}
+			n = uint64(mt)
+		} else if n, err = strconv.ParseUint(value, 0, 32); err != nil {
+			return message.Option{}, fmt.Errorf("invalid value of option %v: %w", id, err)
+		}
+		buf := make([]byte, 4)
+		l, _ := message.EncodeUint32(buf, uint32(n))
+		return message.Option{ID: id, Value: buf[:l]}, nil
+	case message.ValueString:
+		return message.Option{ID: id, Value: []byte(value)}, nil
+	}
+	if strings.HasPrefix(value, "0x") {
+		data, err := hex.DecodeString(value[2:])
+		if err != nil {
+			return message.Option{}, fmt.Errorf("invalid value of option %v: %w", id, err)
+		}
+		return message.Option{ID: id, Value: data}, nil
+	}
+	return message.Option{ID: id, Value: []byte(value)}, nil
+}
+
+// optionFlags collects repeated -O flags.
+type optionFlags []message.Option
+
+func (o *optionFlags) String() string {
+	return fmt.Sprintf("%v", []message.Option(*o))
+}
+
+func (o *optionFlags) Set(v string) error {
+	opt, err := parseOption(v)
+	if err != nil {
+		return err
+	}
+	*o = append(*o, opt)
+	return nil
+}
+
+func codeString(c codes.Code) string {
+	return fmt.Sprintf("%d.%02d %v", uint8(c)>>5, uint8(c)&0x1f, c)
+}
+
+func formatOptionValue(o message.Option) string {
+	format := message.ValueOpaque
+	if def, ok := message.CoapOptionDefs[o.ID]; ok {
+		format = def.ValueFormat
+	}
+	switch format {
+	case message.ValueEmpty:
+		return ""
+	case message.ValueString:
+		return string(o.Value)
+	case message.ValueUint:
+		v, _, err := message.DecodeUint32(o.Value)
+		if err != nil {
+			return "0x" + hex.EncodeToString(o.Value)
+		}
+		switch o.ID {
+		case message.ContentFormat, message.Accept:
+			return message.MediaType(v).String()
+		case message.Block1, message.Block2:
+			szx, num, more, err := blockwise.DecodeBlockOption(v)
+			if err == nil {
+				return fmt.Sprintf("%d/%v/%d", num, more, szx.Size())
+			}
+		}
+		return strconv.FormatUint(uint64(v), 10)
+	}
+	return "0x" + hex.EncodeToString(o.Value)
+}
+
+func readBody(msg *message.Message) ([]byte, error) {
+	if msg.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read body: %w", err)
+	}
+	return body, nil
+}
+
+func isLinkFormat(msg *message.Message) bool {
+	cf, err := msg.Options.ContentFormat()
+	return err == nil && cf == message.AppLinkFormat
+}
+
+// printer writes responses as text or as JSON, one object per line.
+type printer struct {
+	w    io.Writer
+	json bool
+	// raw disables pretty printing of link-format
+	raw bool
+}
+
+type jsonOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type jsonLink struct {
+	Href       string              `json:"href"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+type jsonMessage struct {
+	Source        string       `json:"source,omitempty"`
+	Code          string       `json:"code"`
+	Options       []jsonOption `json:"options,omitempty"`
+	Payload       *string      `json:"payload,omitempty"`
+	PayloadBase64 string       `json:"payloadBase64,omitempty"`
+	Links         []jsonLink   `json:"links,omitempty"`
+}
+
+// print writes the message, the source is address of the peer for responses of multicast requests.
+func (p printer) print(source string, msg *message.Message) error {
+	body, err := readBody(msg)
+	if err != nil {
+		return err
+	}
+	var links message.Links
+	if !p.raw && isLinkFormat(msg) {
+		if err := links.Unmarshal(body); err != nil {
+			links = nil
+		}
+	}
+	if p.json {
+		return p.printJSON(source, msg, body, links)
+	}
+	return p.printText(source, msg, body, links)
+}
+
+func (p printer) printJSON(source string, msg *message.Message, body []byte, links message.Links) error {
+	m := jsonMessage{
+		Source: source,
+		Code:   codeString(msg.Code),
+	}
+	for _, o := range msg.Options {
+		m.Options = append(m.Options, jsonOption{Name: o.ID.String(), Value: formatOptionValue(o)})
+	}
+	switch {
+	case links != nil:
+		for _, l := range links {
+			jl := jsonLink{Href: l.Target}
+			for _, a := range l.Attributes {
+				if jl.Attributes == nil {
+					jl.Attributes = make(map[string][]string)
+				}
+				jl.Attributes[a.Name] = append(jl.Attributes[a.Name], a.Value)
+			}
+			m.Links = append(m.Links, jl)
+		}
+	case utf8.Valid(body):
+		if len(body) > 0 {
+			payload := string(body)
+			m.Payload = &payload
+		}
+	default:
+		m.PayloadBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", data)
+	return err
+}
+
+func (p printer) printText(source string, msg *message.Message, body []byte, links message.Links) error {
+	var b strings.Builder
+	if source != "" {
+		fmt.Fprintf(&b, "%v: ", source)
+	}
+	fmt.Fprintf(&b, "%v\n", codeString(msg.Code))
+	for _, o := range msg.Options {
+		fmt.Fprintf(&b, "%v: %v\n", o.ID, formatOptionValue(o))
+	}
+	switch {
+	case links != nil:
+		b.WriteString("\n")
+		for _, l := range links {
+			fmt.Fprintf(&b, "<%v>\n", l.Target)
+			for _, a := range l.Attributes {
+				if a.Value == "" {
+					fmt.Fprintf(&b, "    %v\n", a.Name)
+					continue
+				}
+				fmt.Fprintf(&b, "    %v: %v\n", a.Name, a.Value)
+			}
+		}
+	case len(body) == 0:
+	case utf8.Valid(body):
+		fmt.Fprintf(&b, "\n%s\n", body)
+	default:
+		fmt.Fprintf(&b, "\n%s", hex.Dump(body))
+	}
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
@@ -0,0 +1,372 @@
+// Command coap sends requests to CoAP servers over UDP, DTLS, TCP and TLS.
+//
+// Usage:
+//
+//	coap [flags] get|post|put|delete|fetch|observe <uri>
+//	coap [flags] discover [uri]
+//
+// Examples:
+//
+//	coap get coap://[::1]/.well-known/core
+//	coap -c json -d '{"on":true}' put coap+tcp://localhost/light
+//	coap -psk-identity client -psk secret observe coaps://device/temperature
+//	coap -json discover coap://224.0.1.187/.well-known/core?rt=oic.wk.d
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	"github.com/plgd-dev/go-coap/v2/net/blockwise"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	// exitResponse is returned when the server responds by other than 2.xx code.
+	exitResponse = 2
+)
+
+const defaultDiscoveryURI = "coap://224.0.1.187:5683/.well-known/core"
+
+type config struct {
+	options       optionFlags
+	contentFormat string
+	accept        string
+	data          string
+	file          string
+	timeout       time.Duration
+	count         int
+	duration      time.Duration
+	json          bool
+	raw           bool
+	blockSize     int
+	progress      bool
+	security      securityConfig
+}
+
+func newFlagSet(cfg *config, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("coap", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&cfg.options, "O", "option `name=value`, the name can be the number of the option, opaque values can be hex encoded with 0x prefix (repeatable)")
+	fs.StringVar(&cfg.contentFormat, "c", "", "content format of the payload, eg. text, json, cbor, link-format or a number")
+	fs.StringVar(&cfg.accept, "A", "", "accepted content format of the response")
+	fs.StringVar(&cfg.data, "d", "", "payload of the request")
+	fs.StringVar(&cfg.file, "f", "", "read payload of the request from the `file`, - reads stdin")
+	fs.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "timeout of the request, discover waits for responses for the whole timeout")
+	fs.IntVar(&cfg.count, "count", 0, "stop observing after count notifications, 0 means unlimited")
+	fs.DurationVar(&cfg.duration, "duration", 0, "stop observing after the duration, 0 means until interrupted")
+	fs.BoolVar(&cfg.json, "json", false, "print responses as JSON, one object per line")
+	fs.BoolVar(&cfg.raw, "raw", false, "don't pretty print link-format")
+	fs.IntVar(&cfg.blockSize, "blocksize", 1024, "block size of blockwise transfers: 16, 32, 64, 128, 256, 512 or 1024")
+	fs.BoolVar(&cfg.progress, "progress", false, "print progress of the transfer to stderr")
+	fs.StringVar(&cfg.security.pskIdentity, "psk-identity", "", "identity of the pre-shared key for coaps")
+	fs.StringVar(&cfg.security.psk, "psk", "", "pre-shared key for coaps, hex encoded keys have 0x prefix")
+	fs.StringVar(&cfg.security.certFile, "cert", "", "PEM encoded client certificate for coaps and coaps+tcp")
+	fs.StringVar(&cfg.security.keyFile, "key", "", "PEM encoded private key of the client certificate")
+	fs.StringVar(&cfg.security.caFile, "ca", "", "PEM encoded certificates of trusted authorities")
+	fs.BoolVar(&cfg.security.insecure, "insecure", false, "don't verify certificate of the server")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage:\n  coap [flags] get|post|put|delete|fetch|observe <uri>\n  coap [flags] discover [uri]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
+
+// run executes the command, it returns the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var cfg config
+	fs := newFlagSet(&cfg, stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitError
+	}
+	// flags can follow the method and the uri too
+	positional := make([]string, 0, 2)
+	for rest := fs.Args(); len(rest) > 0; rest = fs.Args() {
+		positional = append(positional, rest[0])
+		if err := fs.Parse(rest[1:]); err != nil {
+			return exitError
+		}
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		fs.Usage()
+		return exitError
+	}
+	method := strings.ToLower(positional[0])
+	uri := ""
+	if len(positional) == 2 {
+		uri = positional[1]
+	}
+	if uri == "" && method == "discover" {
+		uri = defaultDiscoveryURI
+	}
+	if uri == "" {
+		fs.Usage()
+		return exitError
+	}
+	c := command{
+		cfg:    cfg,
+		stdin:  stdin,
+		stderr: stderr,
+		printer: printer{
+			w:    stdout,
+			json: cfg.json,
+			raw:  cfg.raw,
+		},
+	}
+	code, err := c.run(ctx, method, uri)
+	if err != nil {
+		fmt.Fprintf(stderr, "coap: %v\n", err)
+		return exitError
+	}
+	return code
+}
+
+type command struct {
+	cfg     config
+	stdin   io.Reader
+	stderr  io.Writer
+	printer printer
+}
+
+func (c *command) run(ctx context.Context, method, uri string) (int, error) {
+	u, err := message.ParseURI(uri)
+	if err != nil {
+		return exitError, err
+	}
+	opts, err := c.requestOptions(u)
+	if err != nil {
+		return exitError, err
+	}
+	szx, err := toSZX(c.cfg.blockSize)
+	if err != nil {
+		return exitError, err
+	}
+	switch method {
+	case "discover":
+		return c.discover(ctx, u, opts)
+	case "get", "delete", "post", "put", "fetch", "observe":
+	default:
+		return exitError, fmt.Errorf("unknown method '%v'", method)
+	}
+	payload, err := c.payload()
+	if err != nil {
+		return exitError, err
+	}
+	contentFormat := message.TextPlain
+	if c.cfg.contentFormat != "" {
+		if contentFormat, err = parseMediaType(c.cfg.contentFormat); err != nil {
+			return exitError, err
+		}
+	}
+
+	var cnt counters
+	dialCtx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	cl, err := dial(dialCtx, u, dialConfig{
+		security: c.cfg.security,
+		szx:      szx,
+		timeout:  c.cfg.timeout,
+		counters: &cnt,
+		errorFunc: func(err error) {
+			if !errors.Is(err, context.Canceled) && c.cfg.progress {
+				fmt.Fprintf(c.stderr, "coap: %v\n", err)
+			}
+		},
+	})
+	if err != nil {
+		return exitError, fmt.Errorf("cannot connect to %v: %w", u.Address(), err)
+	}
+	defer cl.Close()
+	if c.cfg.progress {
+		stop := c.showProgress(&cnt)
+		defer stop()
+	}
+
+	path := "/" + u.PathString()
+	if method == "observe" {
+		return c.observe(ctx, cl, path, opts)
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	var resp *message.Message
+	switch method {
+	case "get":
+		resp, err = cl.Get(reqCtx, path, opts...)
+	case "delete":
+		resp, err = cl.Delete(reqCtx, path, opts...)
+	case "post":
+		resp, err = cl.Post(reqCtx, path, contentFormat, payload, opts...)
+	case "put":
+		resp, err = cl.Put(reqCtx, path, contentFormat, payload, opts...)
+	case "fetch":
+		resp, err = cl.Fetch(reqCtx, path, contentFormat, payload, opts...)
+	}
+	if err != nil {
+		return exitError, fmt.Errorf("%v %v failed: %w", strings.ToUpper(method), uri, err)
+	}
+	if err := c.printer.print("", resp); err != nil {
+		return exitError, err
+	}
+	return responseExitCode(resp.Code), nil
+}
+
+// requestOptions merges queries of the URI with options set by flags.
+func (c *command) requestOptions(u message.URI) (message.Options, error) {
+	opts := make(message.Options, 0, len(u.Queries)+len(c.cfg.options)+1)
+	for _, q := range u.Queries {
+		opts = append(opts, message.Option{ID: message.URIQuery, Value: []byte(q)})
+	}
+	opts = append(opts, c.cfg.options...)
+	if c.cfg.accept != "" {
+		mt, err := parseMediaType(c.cfg.accept)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4)
+		l, _ := message.EncodeUint32(buf, uint32(mt))
+		opts = append(opts, message.Option{ID: message.Accept, Value: buf[:l]})
+	}
+	return opts, nil
+}
+
+func (c *command) payload() (io.ReadSeeker, error) {
+	switch {
+	case c.cfg.file == "-":
+		data, err := ioutil.ReadAll(c.stdin)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read payload: %w", err)
+		}
+		return bytes.NewReader(data), nil
+	case c.cfg.file != "":
+		data, err := ioutil.ReadFile(c.cfg.file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read payload: %w", err)
+		}
+		return bytes.NewReader(data), nil
+	case c.cfg.data != "":
+		return strings.NewReader(c.cfg.data), nil
+	}
+	return nil, nil
+}
+
+func (c *command) observe(ctx context.Context, cl mux.Client, path string, opts message.Options) (int, error) {
+	if c.cfg.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.duration)
+		defer cancel()
+	}
+	var mutex sync.Mutex
+	var received int
+	var printErr error
+	code := exitOK
+	done := make(chan struct{})
+	var doneOnce sync.Once
+	obs, err := cl.Observe(ctx, path, func(n *message.Message) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if received < 0 {
+			return
+		}
+		received++
+		if err := c.printer.print("", n); err != nil {
+			printErr = err
+		}
+		_, errObs := n.Options.Observe()
+		if code = responseExitCode(n.Code); code != exitOK || errObs != nil || printErr != nil ||
+			(c.cfg.count > 0 && received >= c.cfg.count) {
+			// the server doesn't accept the observation or the limit is reached
+			received = -1
+			doneOnce.Do(func() { close(done) })
+		}
+	}, opts...)
+	if err != nil {
+		return exitError, fmt.Errorf("cannot observe %v: %w", path, err)
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	cancelCtx, cancel := context.WithTimeout(context.Background(), c.cfg.timeout)
+	defer cancel()
+	if err := obs.Cancel(cancelCtx); err != nil && c.cfg.progress {
+		fmt.Fprintf(c.stderr, "coap: cannot cancel observation: %v\n", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if printErr != nil {
+		return exitError, printErr
+	}
+	return code, nil
+}
+
+// showProgress periodically prints transferred bytes until the returned function is called.
+func (c *command) showProgress(cnt *counters) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(200 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				fmt.Fprintf(c.stderr, "\r%v", cnt)
+			case <-done:
+				fmt.Fprintf(c.stderr, "\r%v\n", cnt)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func responseExitCode(code codes.Code) int {
+	if code>>5 == 2 {
+		return exitOK
+	}
+	return exitResponse
+}
+
+func toSZX(blockSize int) (blockwise.SZX, error) {
+	for szx := blockwise.SZX16; szx <= blockwise.SZX1024; szx++ {
+		if szx.Size() == int64(blockSize) {
+			return szx, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid block size %v", blockSize)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouter(t *testing.T, observers *mux.Observers) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(observers.Middleware)
+	r.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")))
+		require.NoError(t, err)
+	}))
+	r.Handle("/big", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.AppOctets, bytes.NewReader(make([]byte, 2000)))
+		require.NoError(t, err)
+	}))
+	r.Handle("/echo", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		queries, err := r.Options.Queries()
+		require.NoError(t, err)
+		require.Equal(t, []string{"x=1"}, queries)
+		etag, err := r.Options.GetBytes(message.ETag)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2}, etag)
+		cf, err := r.Options.ContentFormat()
+		require.NoError(t, err)
+		require.Equal(t, message.AppJSON, cf)
+		err = w.SetResponse(codes.Changed, message.AppJSON, bytes.NewReader(body))
+		require.NoError(t, err)
+	}))
+	r.Handle("/.well-known/core", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.AppLinkFormat, bytes.NewReader([]byte(`</a>;rt="sensor";if="core.s",</big>;obs`)))
+		require.NoError(t, err)
+	}))
+	return r
+}
+
+func newTestUDPServer(t *testing.T) (string, *mux.Observers, func()) {
+	l, err := coapNet.NewListenUDP("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	observers := mux.NewObservers(time.Minute, func(err error) {})
+	s := udp.NewServer(udp.WithMux(newTestRouter(t, observers)), udp.WithErrors(func(error) {}))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Serve(l)
+	}()
+	return l.LocalAddr().String(), observers, func() {
+		s.Stop()
+		wg.Wait()
+		l.Close()
+	}
+}
+
+func newTestTCPServer(t *testing.T) (string, func()) {
+	l, err := coapNet.NewTCPListener("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	observers := mux.NewObservers(time.Minute, func(err error) {})
+	s := tcp.NewServer(tcp.WithMux(newTestRouter(t, observers)), tcp.WithErrors(func(error) {}))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Serve(l)
+	}()
+	return l.Addr().String(), func() {
+		s.Stop()
+		wg.Wait()
+		l.Close()
+	}
+}
+
+func runCmd(ctx context.Context, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(ctx, args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Get(t *testing.T) {
+	addr, _, stop := newTestUDPServer(t)
+	defer stop()
+
+	code, stdout, stderr := runCmd(context.Background(), "get", "coap://"+addr+"/a")
+	require.Equal(t, exitOK, code, stderr)
+	require.True(t, strings.HasPrefix(stdout, "2.05 Content\n"), stdout)
+	require.Contains(t, stdout, "\nContentFormat: text/plain;charset=utf-8\n")
+	require.True(t, strings.HasSuffix(stdout, "\n\nhello\n"), stdout)
+
+	code, stdout, _ = runCmd(context.Background(), "get", "coap://"+addr+"/missing")
+	require.Equal(t, exitResponse, code)
+	require.True(t, strings.HasPrefix(stdout, "4.04 NotFound\n"), stdout)
+}
+
+func TestRun_PostJSON(t *testing.T) {
+	addr, stop := newTestTCPServer(t)
+	defer stop()
+
+	code, stdout, stderr := runCmd(context.Background(), "-json", "-c", "json", "-d", `{"a":1}`, "-O", "ETag=0x0102",
+		"post", "coap+tcp://"+addr+"/echo?x=1")
+	require.Equal(t, exitOK, code, stderr)
+	var resp jsonMessage
+	err := json.Unmarshal([]byte(stdout), &resp)
+	require.NoError(t, err)
+	require.Equal(t, "2.04 Changed", resp.Code)
+	require.NotNil(t, resp.Payload)
+	require.Equal(t, `{"a":1}`, *resp.Payload)
+	require.Contains(t, resp.Options, jsonOption{Name: "ContentFormat", Value: "application/json"})
+}
+
+func TestRun_LinkFormat(t *testing.T) {
+	addr, _, stop := newTestUDPServer(t)
+	defer stop()
+
+	code, stdout, stderr := runCmd(context.Background(), "get", "coap://"+addr+"/.well-known/core")
+	require.Equal(t, exitOK, code, stderr)
+	require.Contains(t, stdout, "\n\n</a>\n    rt: sensor\n    if: core.s\n</big>\n    obs\n")
+
+	code, stdout, stderr = runCmd(context.Background(), "get", "coap://"+addr+"/.well-known/core", "-json")
+	require.Equal(t, exitOK, code, stderr)
+	var resp jsonMessage
+	err := json.Unmarshal([]byte(stdout), &resp)
+	require.NoError(t, err)
+	require.Equal(t, []jsonLink{
+		{Href: "/a", Attributes: map[string][]string{"rt": {"sensor"}, "if": {"core.s"}}},
+		{Href: "/big", Attributes: map[string][]string{"obs": {""}}},
+	}, resp.Links)
+}
+
+func TestRun_Blockwise(t *testing.T) {
+	addr, stop := newTestTCPServer(t)
+	defer stop()
+
+	code, stdout, stderr := runCmd(context.Background(), "-blocksize", "64", "-progress", "-json", "get", "coap+tcp://"+addr+"/big")
+	require.Equal(t, exitOK, code, stderr)
+	var resp jsonMessage
+	err := json.Unmarshal([]byte(stdout), &resp)
+	require.NoError(t, err)
+	require.NotNil(t, resp.Payload)
+	require.Len(t, *resp.Payload, 2000)
+	require.Contains(t, stderr, "received")
+
+	code, _, stderr = runCmd(context.Background(), "-blocksize", "100", "get", "coap+tcp://"+addr+"/big")
+	require.Equal(t, exitError, code)
+	require.Contains(t, stderr, "invalid block size")
+}
+
+func TestRun_Observe(t *testing.T) {
+	addr, observers, stop := newTestUDPServer(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			if observers.Observed("/a") {
+				observers.Notify("/a")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	code, stdout, stderr := runCmd(ctx, "-count", "3", "-json", "observe", "coap://"+addr+"/a")
+	require.Equal(t, exitOK, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	require.Len(t, lines, 3)
+	for _, l := range lines {
+		var resp jsonMessage
+		err := json.Unmarshal([]byte(l), &resp)
+		require.NoError(t, err)
+		require.Equal(t, "2.05 Content", resp.Code)
+	}
+}
+
+func TestRun_Discover(t *testing.T) {
+	addr, _, stop := newTestUDPServer(t)
+	defer stop()
+
+	code, stdout, stderr := runCmd(context.Background(), "-timeout", "500ms", "discover", "coap://"+addr+"/a")
+	require.Equal(t, exitOK, code, stderr)
+	require.True(t, strings.HasPrefix(stdout, addr+": 2.05 Content\n"), stdout)
+	require.True(t, strings.HasSuffix(stdout, "\n\nhello\n"), stdout)
+}
+
+func TestParseOption(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    message.Option
+		wantErr bool
+	}{
+		{name: "string", value: "URIHost=example.com", want: message.Option{ID: message.URIHost, Value: []byte("example.com")}},
+		{name: "uint", value: "MaxAge=60", want: message.Option{ID: message.MaxAge, Value: []byte{60}}},
+		{name: "contentFormat", value: "Accept=cbor", want: message.Option{ID: message.Accept, Value: []byte{60}}},
+		{name: "hex", value: "ETag=0xabcd", want: message.Option{ID: message.ETag, Value: []byte{0xab, 0xcd}}},
+		{name: "empty", value: "IfNoneMatch", want: message.Option{ID: message.IfNoneMatch}},
+		{name: "number", value: "65000=0x01", want: message.Option{ID: 65000, Value: []byte{1}}},
+		{name: "unknown", value: "Foo=1", wantErr: true},
+		{name: "invalidUint", value: "MaxAge=x", wantErr: true},
+		{name: "invalidHex", value: "ETag=0xz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOption(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
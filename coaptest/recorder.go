@@ -0,0 +1,129 @@
+package coaptest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+// ResponseRecorder is a mux.ResponseWriter which records the response, so handlers can be tested without network.
+type ResponseRecorder struct {
+	// Code is the code of the response, it is zero until SetResponse is called.
+	Code          codes.Code
+	ContentFormat message.MediaType
+	Options       message.Options
+	// Body is nil when the response has no body.
+	Body []byte
+	// Responded is set by SetResponse.
+	Responded bool
+	// ClientConn is returned by Client, nil by default.
+	ClientConn mux.Client
+}
+
+// NewRecorder returns an initialized ResponseRecorder.
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{}
+}
+
+// SetResponse records the response.
+func (r *ResponseRecorder) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	r.Code = code
+	r.ContentFormat = contentFormat
+	r.Options = cloneOptions(opts)
+	r.Body = nil
+	if d != nil {
+		body, err := ioutil.ReadAll(d)
+		if err != nil {
+			return err
+		}
+		r.Body = body
+	}
+	r.Responded = true
+	return nil
+}
+
+// Client returns ClientConn.
+func (r *ResponseRecorder) Client() mux.Client {
+	return r.ClientConn
+}
+
+// Result returns the recorded response as a message, nil is returned when the handler didn't respond.
+func (r *ResponseRecorder) Result() *message.Message {
+	if !r.Responded {
+		return nil
+	}
+	return newMessage(r.Code, r.ContentFormat, r.Body, r.Options)
+}
+
+// NewRequest returns a request for testing of handlers. The target is a path with an optional
+// query, eg. /sensors/temp?unit=c. The body can be nil.
+func NewRequest(code codes.Code, target string, body io.ReadSeeker, opts ...message.Option) *mux.Message {
+	path, query := target, ""
+	if idx := strings.IndexByte(target, '?'); idx >= 0 {
+		path, query = target[:idx], target[idx+1:]
+	}
+	options := make(message.Options, 0, 16)
+	options, _, err := options.SetPath(make([]byte, len(path)), path)
+	if err != nil {
+		panic("coaptest: invalid path: " + err.Error())
+	}
+	if query != "" {
+		for _, q := range strings.Split(query, "&") {
+			options = options.Add(message.Option{ID: message.URIQuery, Value: []byte(q)})
+		}
+	}
+	for _, o := range opts {
+		options = options.Add(o)
+	}
+	return &mux.Message{
+		Message: &message.Message{
+			Context: context.Background(),
+			Token:   message.Token("coaptest"),
+			Code:    code,
+			Options: options,
+			Body:    body,
+		},
+	}
+}
+
+// AssertMessage reports an error when the message is nil or doesn't have the code. The payload is
+// compared when it isn't nil. The body of the message is rewound after the comparison.
+func AssertMessage(t testing.TB, msg *message.Message, code codes.Code, payload []byte) bool {
+	t.Helper()
+	if msg == nil {
+		t.Errorf("expected message %v, got nil", code)
+		return false
+	}
+	if msg.Code != code {
+		t.Errorf("expected code %v, got %v", code, msg.Code)
+		return false
+	}
+	if payload == nil {
+		return true
+	}
+	var body []byte
+	if msg.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(msg.Body)
+		if err != nil {
+			t.Errorf("cannot read body: %v", err)
+			return false
+		}
+		if _, err := msg.Body.Seek(0, io.SeekStart); err != nil {
+			t.Errorf("cannot rewind body: %v", err)
+			return false
+		}
+	}
+	if !bytes.Equal(body, payload) {
+		t.Errorf("expected payload %q, got %q", payload, body)
+		return false
+	}
+	return true
+}
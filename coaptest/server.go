@@ -0,0 +1,230 @@
+// Package coaptest provides utilities for CoAP testing, in the spirit of net/http/httptest.
+package coaptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+)
+
+// Exchange is a request received by the server and the response set by the handler.
+type Exchange struct {
+	Request *message.Message
+	// Response is nil when the handler didn't set the response.
+	Response *message.Message
+}
+
+// Server is a CoAP server listening on an in-memory network, so tests don't bind sockets.
+// It records exchanged messages, see Exchanges.
+type Server struct {
+	// Network is the in-memory network of the server.
+	Network *coapNet.MemoryNetwork
+	// Addr is the address of the server in the Network.
+	Addr string
+	// Net is the transport of the server, udp or tcp.
+	Net string
+
+	stop func()
+
+	mutex     sync.Mutex
+	exchanges []Exchange
+	changed   chan struct{}
+}
+
+// NewServer starts a CoAP over UDP server serving handler. The caller should call Close when finished.
+func NewServer(handler mux.Handler) *Server {
+	s := newServer("udp")
+	l, err := s.Network.ListenUDP("")
+	if err != nil {
+		panic(fmt.Sprintf("coaptest: cannot listen: %v", err))
+	}
+	s.Addr = l.LocalAddr().String()
+	srv := udp.NewServer(udp.WithMux(s.record(handler)), udp.WithErrors(func(error) {}))
+	s.serve(func() error { return srv.Serve(l) }, srv.Stop, l.Close)
+	return s
+}
+
+// NewTCPServer starts a CoAP over TCP server serving handler. The caller should call Close when finished.
+func NewTCPServer(handler mux.Handler) *Server {
+	s := newServer("tcp")
+	l, err := s.Network.Listen("")
+	if err != nil {
+		panic(fmt.Sprintf("coaptest: cannot listen: %v", err))
+	}
+	s.Addr = l.Addr().String()
+	srv := tcp.NewServer(tcp.WithMux(s.record(handler)), tcp.WithErrors(func(error) {}))
+	s.serve(func() error { return srv.Serve(l) }, srv.Stop, l.Close)
+	return s
+}
+
+func newServer(net string) *Server {
+	return &Server{
+		Network: coapNet.NewMemoryNetwork(),
+		Net:     net,
+		changed: make(chan struct{}),
+	}
+}
+
+func (s *Server) serve(serve func() error, stop func(), closeListener func() error) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve()
+	}()
+	s.stop = func() {
+		stop()
+		wg.Wait()
+		closeListener()
+	}
+}
+
+// Client connects a client to the server over the in-memory network.
+func (s *Server) Client() mux.Client {
+	if s.Net == "tcp" {
+		conn, err := s.Network.Dial(context.Background(), s.Addr)
+		if err != nil {
+			panic(fmt.Sprintf("coaptest: cannot dial: %v", err))
+		}
+		return tcp.Client(conn, tcp.WithCloseSocket(), tcp.WithErrors(func(error) {})).Client()
+	}
+	conn, err := s.Network.DialPacket(s.Addr)
+	if err != nil {
+		panic(fmt.Sprintf("coaptest: cannot dial: %v", err))
+	}
+	return udp.Client(conn, udp.WithCloseSocket(), udp.WithErrors(func(error) {})).Client()
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.stop()
+}
+
+// Exchanges returns exchanges handled by the server in the order of their completion.
+func (s *Server) Exchanges() []Exchange {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Exchange(nil), s.exchanges...)
+}
+
+// WaitForExchanges waits until the server handles at least n exchanges.
+func (s *Server) WaitForExchanges(ctx context.Context, n int) ([]Exchange, error) {
+	for {
+		s.mutex.Lock()
+		exchanges, changed := s.exchanges, s.changed
+		s.mutex.Unlock()
+		if len(exchanges) >= n {
+			return append([]Exchange(nil), exchanges...), nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cannot wait for %v exchanges, %v were handled: %w", n, len(exchanges), ctx.Err())
+		}
+	}
+}
+
+// ResetExchanges forgets the recorded exchanges.
+func (s *Server) ResetExchanges() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.exchanges = nil
+}
+
+func (s *Server) record(next mux.Handler) mux.Handler {
+	return mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		req, err := cloneMessage(r.Message)
+		if err != nil {
+			panic(fmt.Sprintf("coaptest: cannot record request: %v", err))
+		}
+		rw := &recordingResponseWriter{ResponseWriter: w}
+		next.ServeCOAP(rw, r)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.exchanges = append(s.exchanges, Exchange{Request: req, Response: rw.response})
+		close(s.changed)
+		s.changed = make(chan struct{})
+	})
+}
+
+type recordingResponseWriter struct {
+	mux.ResponseWriter
+	response *message.Message
+}
+
+func (w *recordingResponseWriter) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	var body []byte
+	if d != nil {
+		var err error
+		body, err = ioutil.ReadAll(d)
+		if err != nil {
+			return err
+		}
+	}
+	w.response = newMessage(code, contentFormat, body, opts)
+	var r io.ReadSeeker
+	if d != nil {
+		r = bytes.NewReader(body)
+	}
+	return w.ResponseWriter.SetResponse(code, contentFormat, r, opts...)
+}
+
+func newMessage(code codes.Code, contentFormat message.MediaType, body []byte, opts message.Options) *message.Message {
+	msg := &message.Message{
+		Context: context.Background(),
+		Code:    code,
+		Options: cloneOptions(opts),
+	}
+	if body != nil {
+		msg.Options = msg.Options.Set(message.Option{ID: message.ContentFormat, Value: encodeUint32(uint32(contentFormat))})
+		msg.Body = bytes.NewReader(body)
+	}
+	return msg
+}
+
+func encodeUint32(v uint32) []byte {
+	buf := make([]byte, 4)
+	n, _ := message.EncodeUint32(buf, v)
+	return buf[:n]
+}
+
+func cloneOptions(opts message.Options) message.Options {
+	cloned := make(message.Options, 0, len(opts))
+	for _, o := range opts {
+		cloned = append(cloned, message.Option{ID: o.ID, Value: append([]byte(nil), o.Value...)})
+	}
+	return cloned
+}
+
+// cloneMessage copies the message, the body of the original message is rewound.
+func cloneMessage(r *message.Message) (*message.Message, error) {
+	msg := &message.Message{
+		Context: context.Background(),
+		Token:   append(message.Token(nil), r.Token...),
+		Code:    r.Code,
+		Options: cloneOptions(r.Options),
+	}
+	if r.Body == nil {
+		return msg, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	msg.Body = bytes.NewReader(body)
+	return msg, nil
+}
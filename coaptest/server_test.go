@@ -0,0 +1,77 @@
+package coaptest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouter(t *testing.T) *mux.Router {
+	m := mux.NewRouter()
+	err := m.Handle("/devices/{id}", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		q, err := r.Options.Queries()
+		if err != nil {
+			q = nil
+		}
+		payload := "device " + r.RouteParams["id"]
+		if len(q) > 0 {
+			payload += " " + q[0]
+		}
+		err = w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte(payload)))
+		require.NoError(t, err)
+	}))
+	require.NoError(t, err)
+	return m
+}
+
+func TestServer(t *testing.T) {
+	for _, newServer := range []func(mux.Handler) *Server{NewServer, NewTCPServer} {
+		s := newServer(newTestRouter(t))
+		t.Run(s.Net, func(t *testing.T) {
+			defer s.Close()
+			cc := s.Client()
+			defer cc.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := cc.Get(ctx, "/devices/1")
+			require.NoError(t, err)
+			AssertMessage(t, resp, codes.Content, []byte("device 1"))
+			resp, err = cc.Get(ctx, "/unknown")
+			require.NoError(t, err)
+			AssertMessage(t, resp, codes.NotFound, nil)
+
+			exchanges, err := s.WaitForExchanges(ctx, 2)
+			require.NoError(t, err)
+			require.Len(t, exchanges, 2)
+			path, err := exchanges[0].Request.Options.Path()
+			require.NoError(t, err)
+			require.Equal(t, "devices/1", path)
+			AssertMessage(t, exchanges[0].Response, codes.Content, []byte("device 1"))
+			AssertMessage(t, exchanges[1].Response, codes.NotFound, nil)
+
+			s.ResetExchanges()
+			require.Empty(t, s.Exchanges())
+		})
+	}
+}
+
+func TestResponseRecorder(t *testing.T) {
+	w := NewRecorder()
+	newTestRouter(t).ServeCOAP(w, NewRequest(codes.GET, "/devices/7?unit=c", nil))
+	require.True(t, w.Responded)
+	require.Equal(t, codes.Content, w.Code)
+	require.Equal(t, message.TextPlain, w.ContentFormat)
+	require.Equal(t, "device 7 unit=c", string(w.Body))
+	AssertMessage(t, w.Result(), codes.Content, []byte("device 7 unit=c"))
+
+	w = NewRecorder()
+	newTestRouter(t).ServeCOAP(w, NewRequest(codes.GET, "/other", nil))
+	require.Equal(t, codes.NotFound, w.Code)
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/net/blockwise"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
 	"github.com/plgd-dev/go-coap/v2/udp/client"
@@ -110,20 +111,20 @@ type KeepAliveOpt struct {
 }
 
 func (o KeepAliveOpt) apply(opts *serverOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
 		keepalive := inactivity.NewKeepAlive(o.maxRetries, o.onInactive, func(cc inactivity.ClientConn, receivePong func()) (func(), error) {
 			return cc.(*client.ClientConn).AsyncPing(receivePong)
 		})
-		return inactivity.NewInactivityMonitor(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive)
+		return inactivity.NewInactivityMonitorWithClock(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive, clk)
 	}
 }
 
 func (o KeepAliveOpt) applyDial(opts *dialOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
 		keepalive := inactivity.NewKeepAlive(o.maxRetries, o.onInactive, func(cc inactivity.ClientConn, receivePong func()) (func(), error) {
 			return cc.(*client.ClientConn).AsyncPing(receivePong)
 		})
-		return inactivity.NewInactivityMonitor(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive)
+		return inactivity.NewInactivityMonitorWithClock(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive, clk)
 	}
 }
 
@@ -143,14 +144,14 @@ type InactivityMonitorOpt struct {
 }
 
 func (o InactivityMonitorOpt) apply(opts *serverOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
-		return inactivity.NewInactivityMonitor(o.duration, o.onInactive)
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
+		return inactivity.NewInactivityMonitorWithClock(o.duration, o.onInactive, clk)
 	}
 }
 
 func (o InactivityMonitorOpt) applyDial(opts *dialOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
-		return inactivity.NewInactivityMonitor(o.duration, o.onInactive)
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
+		return inactivity.NewInactivityMonitorWithClock(o.duration, o.onInactive, clk)
 	}
 }
 
@@ -162,6 +163,25 @@ func WithInactivityMonitor(duration time.Duration, onInactive inactivity.OnInact
 	}
 }
 
+// ClockOpt clock option.
+type ClockOpt struct {
+	clock clock.Clock
+}
+
+func (o ClockOpt) apply(opts *serverOptions) {
+	opts.clock = o.clock
+}
+
+func (o ClockOpt) applyDial(opts *dialOptions) {
+	opts.clock = o.clock
+}
+
+// WithClock sets clock of retransmissions, inactivity monitors and expiration of blockwise transfers
+// and cached responses. Eg. clock.NewFake lets tests advance the time manually.
+func WithClock(c clock.Clock) ClockOpt {
+	return ClockOpt{clock: c}
+}
+
 // NetOpt network option.
 type NetOpt struct {
 	net string
@@ -222,6 +242,36 @@ func WithBlockwise(enable bool, szx blockwise.SZX, transferTimeout time.Duration
 	}
 }
 
+// QBlockOpt Q-Block option.
+type QBlockOpt struct {
+	enable            bool
+	maxPayloads       int
+	nonReceiveTimeout time.Duration
+}
+
+func (o QBlockOpt) apply(opts *serverOptions) {
+	opts.qBlockEnable = o.enable
+	opts.qBlockMaxPayloads = o.maxPayloads
+	opts.qBlockNonReceiveTimeout = o.nonReceiveTimeout
+}
+
+func (o QBlockOpt) applyDial(opts *dialOptions) {
+	opts.qBlockEnable = o.enable
+	opts.qBlockMaxPayloads = o.maxPayloads
+	opts.qBlockNonReceiveTimeout = o.nonReceiveTimeout
+}
+
+// WithQBlock configure's Q-Block1 and Q-Block2 transfers (RFC 9177) which are used instead of blockwise transfers
+// when the peer supports them. Blocks are sent in bursts of maxPayloads non-confirmable messages and the receiver
+// asks for missing blocks after nonReceiveTimeout. It requires enabled blockwise transfer.
+func WithQBlock(enable bool, maxPayloads int, nonReceiveTimeout time.Duration) QBlockOpt {
+	return QBlockOpt{
+		enable:            enable,
+		maxPayloads:       maxPayloads,
+		nonReceiveTimeout: nonReceiveTimeout,
+	}
+}
+
 // OnNewClientConnOpt network option.
 type OnNewClientConnOpt struct {
 	onNewClientConn OnNewClientConnFunc
@@ -241,6 +291,23 @@ func WithOnNewClientConn(onNewClientConn OnNewClientConnFunc) OnNewClientConnOpt
 	}
 }
 
+// OnShutdownOpt server option.
+type OnShutdownOpt struct {
+	onShutdown OnShutdownFunc
+}
+
+func (o OnShutdownOpt) apply(opts *serverOptions) {
+	opts.onShutdown = o.onShutdown
+}
+
+// WithOnShutdown sets function which is called by Shutdown before the server is drained,
+// eg. mux.Observers.Shutdown to notify observers.
+func WithOnShutdown(onShutdown OnShutdownFunc) OnShutdownOpt {
+	return OnShutdownOpt{
+		onShutdown: onShutdown,
+	}
+}
+
 // TransmissionOpt transmission options.
 type TransmissionOpt struct {
 	transmissionNStart             time.Duration
@@ -271,6 +338,91 @@ func WithTransmission(transmissionNStart time.Duration,
 	}
 }
 
+// NStartOpt NSTART option.
+type NStartOpt struct {
+	nStart uint32
+}
+
+func (o NStartOpt) apply(opts *serverOptions) {
+	opts.nStart = o.nStart
+}
+
+func (o NStartOpt) applyDial(opts *dialOptions) {
+	opts.nStart = o.nStart
+}
+
+// WithNStart limits number of outstanding confirmable messages to the peer (NSTART), further messages
+// wait until an outstanding one is acknowledged or times out. Zero disables the limit, default is 1.
+func WithNStart(nStart uint32) NStartOpt {
+	return NStartOpt{
+		nStart: nStart,
+	}
+}
+
+// PiggybackOpt piggyback option.
+type PiggybackOpt struct {
+	timeout time.Duration
+}
+
+func (o PiggybackOpt) apply(opts *serverOptions) {
+	opts.transmissionPiggybackTimeout = o.timeout
+}
+
+func (o PiggybackOpt) applyDial(opts *dialOptions) {
+	opts.transmissionPiggybackTimeout = o.timeout
+}
+
+// WithPiggyback set's how long to wait for a handler before the confirmable request is acknowledged
+// by an empty message. The response of a handler which ends within the timeout is piggybacked in the acknowledgement,
+// otherwise it is sent as a separate confirmable message. Zero timeout disables piggybacked responses.
+func WithPiggyback(timeout time.Duration) PiggybackOpt {
+	return PiggybackOpt{
+		timeout: timeout,
+	}
+}
+
+// ProbingRateOpt probing rate option.
+type ProbingRateOpt struct {
+	bytesPerSecond uint32
+}
+
+func (o ProbingRateOpt) apply(opts *serverOptions) {
+	opts.transmissionProbingRate = o.bytesPerSecond
+}
+
+func (o ProbingRateOpt) applyDial(opts *dialOptions) {
+	opts.transmissionProbingRate = o.bytesPerSecond
+}
+
+// WithProbingRate limits average data rate of non-confirmable messages sent to the peer which doesn't respond
+// (PROBING_RATE, RFC 7252 recommends 1 byte/second). Zero disables the limit, which is the default.
+func WithProbingRate(bytesPerSecond uint32) ProbingRateOpt {
+	return ProbingRateOpt{
+		bytesPerSecond: bytesPerSecond,
+	}
+}
+
+// CongestionControlOpt congestion control option.
+type CongestionControlOpt struct {
+	congestionControl client.CongestionControl
+}
+
+func (o CongestionControlOpt) apply(opts *serverOptions) {
+	opts.congestionControl = o.congestionControl
+}
+
+func (o CongestionControlOpt) applyDial(opts *dialOptions) {
+	opts.congestionControl = o.congestionControl
+}
+
+// WithCongestionControl selects the algorithm which computes retransmission timeouts of confirmable messages,
+// eg. client.CongestionControlCoCoA. The default algorithm uses fixed timeouts set by WithTransmission.
+func WithCongestionControl(congestionControl client.CongestionControl) CongestionControlOpt {
+	return CongestionControlOpt{
+		congestionControl: congestionControl,
+	}
+}
+
 // CloseSocketOpt close socket option.
 type CloseSocketOpt struct {
 }
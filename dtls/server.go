@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/dtls/v2"
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
@@ -43,6 +45,8 @@ type OnNewClientConnFunc = func(cc *client.ClientConn, dtlsConn *dtls.Conn)
 
 type GetMIDFunc = func() uint16
 
+type OnShutdownFunc = func(ctx context.Context)
+
 func closeClientConn(cc *client.ClientConn) {
 	cc.Close()
 }
@@ -62,7 +66,7 @@ var defaultServerOptions = serverOptions{
 		}()
 		return nil
 	},
-	createInactivityMonitor: func() inactivity.Monitor {
+	createInactivityMonitor: func(clock.Clock) inactivity.Monitor {
 		return inactivity.NewNilMonitor()
 	},
 	blockwiseEnable:                true,
@@ -71,8 +75,10 @@ var defaultServerOptions = serverOptions{
 	onNewClientConn:                func(cc *client.ClientConn, dtlsConn *dtls.Conn) {},
 	heartBeat:                      time.Millisecond * 100,
 	transmissionNStart:             time.Second,
+	nStart:                         1,
 	transmissionAcknowledgeTimeout: time.Second * 2,
 	transmissionMaxRetransmit:      4,
+	transmissionPiggybackTimeout:   time.Millisecond * 200,
 	getMID:                         udpMessage.GetMID,
 }
 
@@ -82,17 +88,26 @@ type serverOptions struct {
 	handler                        HandlerFunc
 	errors                         ErrorFunc
 	goPool                         GoPoolFunc
-	createInactivityMonitor        func() inactivity.Monitor
+	createInactivityMonitor        func(clock.Clock) inactivity.Monitor
 	net                            string
 	blockwiseSZX                   blockwise.SZX
 	blockwiseEnable                bool
 	blockwiseTransferTimeout       time.Duration
+	qBlockEnable                   bool
+	qBlockMaxPayloads              int
+	qBlockNonReceiveTimeout        time.Duration
 	onNewClientConn                OnNewClientConnFunc
 	heartBeat                      time.Duration
 	transmissionNStart             time.Duration
 	transmissionAcknowledgeTimeout time.Duration
 	transmissionMaxRetransmit      int
+	transmissionPiggybackTimeout   time.Duration
+	transmissionProbingRate        uint32
+	nStart                         uint32
+	congestionControl              client.CongestionControl
 	getMID                         GetMIDFunc
+	onShutdown                     OnShutdownFunc
+	clock                          clock.Clock
 }
 
 // Listener defined used by coap
@@ -102,23 +117,42 @@ type Listener interface {
 }
 
 type Server struct {
+	// This field needs to be the first in the struct to ensure proper word alignment on 32-bit platforms.
+	// See: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
+	activeTasks                    int64
+	shuttingDown                   uint32
 	maxMessageSize                 int
 	handler                        HandlerFunc
 	errors                         ErrorFunc
 	goPool                         GoPoolFunc
-	createInactivityMonitor        func() inactivity.Monitor
+	createInactivityMonitor        func(clock.Clock) inactivity.Monitor
 	blockwiseSZX                   blockwise.SZX
 	blockwiseEnable                bool
 	blockwiseTransferTimeout       time.Duration
+	qBlockEnable                   bool
+	qBlockMaxPayloads              int
+	qBlockNonReceiveTimeout        time.Duration
 	onNewClientConn                OnNewClientConnFunc
 	heartBeat                      time.Duration
 	transmissionNStart             time.Duration
 	transmissionAcknowledgeTimeout time.Duration
 	transmissionMaxRetransmit      int
+	transmissionPiggybackTimeout   time.Duration
+	transmissionProbingRate        uint32
+	nStart                         uint32
+	congestionControl              client.CongestionControl
 	getMID                         GetMIDFunc
+	onShutdown                     OnShutdownFunc
+	clock                          clock.Clock
 
 	ctx    context.Context
 	cancel context.CancelFunc
+	// acceptCtx is canceled when the server stops accepting new connections.
+	acceptCtx    context.Context
+	cancelAccept context.CancelFunc
+
+	conns      map[*client.ClientConn]struct{}
+	connsMutex sync.Mutex
 
 	listen      Listener
 	listenMutex sync.Mutex
@@ -131,6 +165,7 @@ func NewServer(opt ...ServerOption) *Server {
 	}
 
 	ctx, cancel := context.WithCancel(opts.ctx)
+	acceptCtx, cancelAccept := context.WithCancel(ctx)
 	if opts.errors == nil {
 		opts.errors = func(error) {}
 	}
@@ -140,14 +175,16 @@ func NewServer(opt ...ServerOption) *Server {
 	}
 
 	if opts.createInactivityMonitor == nil {
-		opts.createInactivityMonitor = func() inactivity.Monitor {
+		opts.createInactivityMonitor = func(clock.Clock) inactivity.Monitor {
 			return inactivity.NewNilMonitor()
 		}
 	}
 
-	return &Server{
+	s := &Server{
 		ctx:            ctx,
 		cancel:         cancel,
+		acceptCtx:      acceptCtx,
+		cancelAccept:   cancelAccept,
 		handler:        opts.handler,
 		maxMessageSize: opts.maxMessageSize,
 		errors: func(err error) {
@@ -157,18 +194,29 @@ func NewServer(opt ...ServerOption) *Server {
 			}
 			opts.errors(fmt.Errorf("dtls: %w", err))
 		},
-		goPool:                         opts.goPool,
 		createInactivityMonitor:        opts.createInactivityMonitor,
 		blockwiseSZX:                   opts.blockwiseSZX,
 		blockwiseEnable:                opts.blockwiseEnable,
 		blockwiseTransferTimeout:       opts.blockwiseTransferTimeout,
+		qBlockEnable:                   opts.qBlockEnable,
+		qBlockMaxPayloads:              opts.qBlockMaxPayloads,
+		qBlockNonReceiveTimeout:        opts.qBlockNonReceiveTimeout,
 		onNewClientConn:                opts.onNewClientConn,
 		heartBeat:                      opts.heartBeat,
 		transmissionNStart:             opts.transmissionNStart,
 		transmissionAcknowledgeTimeout: opts.transmissionAcknowledgeTimeout,
 		transmissionMaxRetransmit:      opts.transmissionMaxRetransmit,
+		transmissionPiggybackTimeout:   opts.transmissionPiggybackTimeout,
+		transmissionProbingRate:        opts.transmissionProbingRate,
+		nStart:                         opts.nStart,
+		congestionControl:              opts.congestionControl,
 		getMID:                         opts.getMID,
+		onShutdown:                     opts.onShutdown,
+		clock:                          clock.Get(opts.clock),
+		conns:                          make(map[*client.ClientConn]struct{}),
 	}
+	s.goPool = s.trackTasks(opts.goPool)
+	return s
 }
 
 func (s *Server) checkAndSetListener(l Listener) error {
@@ -191,7 +239,7 @@ func (s *Server) checkAcceptError(err error) (bool, error) {
 		return false, nil
 	case context.DeadlineExceeded, context.Canceled:
 		select {
-		case <-s.ctx.Done():
+		case <-s.acceptCtx.Done():
 		default:
 			s.errors(fmt.Errorf("cannot accept connection: %w", err))
 			return true, nil
@@ -219,7 +267,7 @@ func (s *Server) Serve(l Listener) error {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 	for {
-		rw, err := l.AcceptWithContext(s.ctx)
+		rw, err := l.AcceptWithContext(s.acceptCtx)
 		ok, err := s.checkAcceptError(err)
 		if err != nil {
 			return err
@@ -230,7 +278,7 @@ func (s *Server) Serve(l Listener) error {
 		if rw != nil {
 			wg.Add(1)
 			var cc *client.ClientConn
-			monitor := s.createInactivityMonitor()
+			monitor := s.createInactivityMonitor(s.clock)
 			opts := []coapNet.ConnOption{
 				coapNet.WithHeartBeat(s.heartBeat),
 				coapNet.WithOnReadTimeout(func() error {
@@ -259,10 +307,77 @@ func (s *Server) Stop() {
 	s.cancel()
 }
 
+// Shutdown gracefully stops the server. New connections aren't accepted and requests which start
+// new exchanges are answered by 5.03 Service Unavailable, while active handlers, retransmissions and
+// blockwise transfers are allowed to finish. The function set by WithOnShutdown is called before
+// the server is drained, eg. to notify observers. Shutdown stops the server when it is drained
+// or when ctx expires, in that case ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connsMutex.Lock()
+	atomic.StoreUint32(&s.shuttingDown, 1)
+	s.connsMutex.Unlock()
+	s.cancelAccept()
+	for _, cc := range s.getClientConns() {
+		cc.Drain()
+	}
+	if s.onShutdown != nil {
+		s.onShutdown(ctx)
+	}
+	err := s.waitForDrain(ctx)
+	s.Stop()
+	return err
+}
+
+// shutdownPollInterval is how often Shutdown checks whether the server is drained.
+const shutdownPollInterval = time.Millisecond * 10
+
+func (s *Server) waitForDrain(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *Server) drained() bool {
+	if atomic.LoadInt64(&s.activeTasks) > 0 {
+		return false
+	}
+	for _, cc := range s.getClientConns() {
+		stats := cc.InteractionStats()
+		if stats.Outstanding > 0 || stats.Queued > 0 || stats.Transfers > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// trackTasks counts tasks of goPool which are in progress.
+func (s *Server) trackTasks(goPool GoPoolFunc) GoPoolFunc {
+	return func(f func()) error {
+		atomic.AddInt64(&s.activeTasks, 1)
+		err := goPool(func() {
+			defer atomic.AddInt64(&s.activeTasks, -1)
+			f()
+		})
+		if err != nil {
+			atomic.AddInt64(&s.activeTasks, -1)
+		}
+		return err
+	}
+}
+
 func (s *Server) createClientConn(connection *coapNet.Conn, monitor inactivity.Monitor) *client.ClientConn {
 	var blockWise *blockwise.BlockWise
 	if s.blockwiseEnable {
-		blockWise = blockwise.NewBlockWise(
+		blockWise = blockwise.NewBlockWiseWithClock(
 			bwAcquireMessage,
 			bwReleaseMessage,
 			s.blockwiseTransferTimeout,
@@ -271,6 +386,7 @@ func (s *Server) createClientConn(connection *coapNet.Conn, monitor inactivity.M
 			func(token message.Token) (blockwise.Message, bool) {
 				return nil, false
 			},
+			s.clock,
 		)
 	}
 	obsHandler := client.NewHandlerContainer()
@@ -280,7 +396,7 @@ func (s *Server) createClientConn(connection *coapNet.Conn, monitor inactivity.M
 		s.maxMessageSize,
 		true,
 	)
-	cc := client.NewClientConn(
+	cc := client.NewClientConnWithClock(
 		session,
 		obsHandler,
 		kitSync.NewMap(),
@@ -294,7 +410,36 @@ func (s *Server) createClientConn(connection *coapNet.Conn, monitor inactivity.M
 		s.errors,
 		s.getMID,
 		monitor,
+		s.clock,
 	)
+	cc.Transmission().SetTransmissionPiggybackTimeout(s.transmissionPiggybackTimeout)
+	cc.Transmission().SetCongestionControl(s.congestionControl)
+	cc.Transmission().SetTransmissionProbingRate(s.transmissionProbingRate)
+	cc.Transmission().SetNStart(s.nStart)
+	if s.qBlockEnable {
+		cc.EnableQBlock(s.qBlockMaxPayloads, s.qBlockNonReceiveTimeout)
+	}
+	s.connsMutex.Lock()
+	s.conns[cc] = struct{}{}
+	if atomic.LoadUint32(&s.shuttingDown) == 1 {
+		cc.Drain()
+	}
+	s.connsMutex.Unlock()
+	cc.AddOnClose(func() {
+		s.connsMutex.Lock()
+		defer s.connsMutex.Unlock()
+		delete(s.conns, cc)
+	})
 
 	return cc
 }
+
+func (s *Server) getClientConns() []*client.ClientConn {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+	conns := make([]*client.ClientConn, 0, len(s.conns))
+	for cc := range s.conns {
+		conns = append(conns, cc)
+	}
+	return conns
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/plgd-dev/go-coap/v2/examples/dtls/pki"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
 	"github.com/plgd-dev/go-coap/v2/udp/client"
@@ -287,3 +288,130 @@ func TestServer_KeepAliveMonitor(t *testing.T) {
 	checkCloseWg.Wait()
 	require.True(t, inactivityDetected)
 }
+
+func TestServer_Shutdown(t *testing.T) {
+	dtlsCfg := &piondtls.Config{
+		PSK: func(hint []byte) ([]byte, error) {
+			return []byte{0xAB, 0xC1, 0x23}, nil
+		},
+		PSKIdentityHint: []byte("Pion DTLS Server"),
+		CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+	}
+	l, err := coapNet.NewDTLSListener("udp", "", dtlsCfg)
+	require.NoError(t, err)
+	defer l.Close()
+
+	observers := mux.NewObservers(0, nil)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	m := mux.NewRouter()
+	m.Use(observers.Middleware)
+	m.Handle("/obs", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("obs")))
+		require.NoError(t, err)
+	}))
+	m.Handle("/slow", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		close(entered)
+		<-release
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("slow")))
+		require.NoError(t, err)
+	}))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := dtls.NewServer(dtls.WithMux(m), dtls.WithOnShutdown(observers.Shutdown))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := dtls.Dial(l.Addr().String(), dtlsCfg)
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	notifications := make(chan codes.Code, 4)
+	_, err = cc.Observe(ctx, "/obs", func(n *pool.Message) {
+		notifications <- n.Code()
+	})
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, <-notifications)
+
+	slowResp := make(chan codes.Code, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := cc.Get(ctx, "/slow")
+		require.NoError(t, err)
+		slowResp <- resp.Code()
+	}()
+	<-entered
+
+	shutdownErr := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shutdownErr <- s.Shutdown(ctx)
+	}()
+
+	// observers are notified by 5.03 and the server rejects new exchanges
+	require.Equal(t, codes.ServiceUnavailable, <-notifications)
+	resp, err := cc.Get(ctx, "/obs")
+	require.NoError(t, err)
+	require.Equal(t, codes.ServiceUnavailable, resp.Code())
+	select {
+	case err := <-shutdownErr:
+		require.FailNow(t, "shutdown finished before active handler", err)
+	default:
+	}
+
+	// active handler finishes
+	close(release)
+	require.Equal(t, codes.Content, <-slowResp)
+	require.NoError(t, <-shutdownErr)
+}
+
+func TestServer_MemoryNetwork(t *testing.T) {
+	dtlsCfg := &piondtls.Config{
+		PSK: func(hint []byte) ([]byte, error) {
+			return []byte{0xAB, 0xC1, 0x23}, nil
+		},
+		PSKIdentityHint: []byte("Pion DTLS Server"),
+		CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+	}
+	network := coapNet.NewMemoryNetwork()
+	l, err := network.ListenDTLS("", dtlsCfg)
+	require.NoError(t, err)
+	defer l.Close()
+
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")))
+		require.NoError(t, err)
+	}))
+	s := dtls.NewServer(dtls.WithMux(m))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := network.DialDTLS(ctx, l.Addr().String(), dtlsCfg)
+	require.NoError(t, err)
+	cc := dtls.Client(conn, dtls.WithCloseSocket())
+	defer cc.Close()
+	resp, err := cc.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+}
@@ -2,13 +2,13 @@ module github.com/plgd-dev/go-coap/v2
 
 require (
 	github.com/dsnet/golib/memfile v0.0.0-20200723050859-c110804dfa93
-	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pion/dtls/v2 v2.0.10-0.20210502094952-3dc563b9aede
 	github.com/plgd-dev/kit v0.0.0-20200819113605-d5fcf3e94f63
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/atomic v1.6.0
 	golang.org/x/net v0.0.0-20210502030024-e5908800b52b
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
 
 go 1.13
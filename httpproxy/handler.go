@@ -0,0 +1,343 @@
+// Package httpproxy provides HTTP-to-CoAP cross-proxy (RFC 8075) which exposes CoAP resources as http.Handler.
+package httpproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/plgd-dev/go-coap/v2"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// DefaultMaxAge is Max-Age of the response without Max-Age option: https://tools.ietf.org/html/rfc7252#section-5.10.5
+const DefaultMaxAge = 60
+
+var defaultHandlerOptions = handlerOptions{
+	maxBodySize: 1024 * 1024,
+	errors:      func(error) {},
+}
+
+type handlerOptions struct {
+	target           *message.URI
+	allowedHosts     []string
+	serverSentEvents bool
+	maxBodySize      int64
+	errors           func(error)
+}
+
+// Handler forwards HTTP requests to CoAP servers and translates the responses: https://tools.ietf.org/html/rfc8075
+type Handler struct {
+	pool *coap.Pool
+	opts handlerOptions
+}
+
+// NewHandler creates HTTP-to-CoAP proxy which forwards requests through connections of the pool.
+func NewHandler(pool *coap.Pool, opts ...HandlerOption) *Handler {
+	cfg := defaultHandlerOptions
+	for _, o := range opts {
+		o.apply(&cfg)
+	}
+	if cfg.errors == nil {
+		cfg.errors = func(error) {}
+	}
+	return &Handler{
+		pool: pool,
+		opts: cfg,
+	}
+}
+
+func splitEscaped(s, sep string) ([]string, error) {
+	var r []string
+	for _, v := range strings.Split(s, sep) {
+		if v == "" {
+			continue
+		}
+		u, err := url.PathUnescape(v)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, u)
+	}
+	return r, nil
+}
+
+func (h *Handler) isAllowed(u message.URI) bool {
+	hostPort := u.Host
+	if u.Port != 0 {
+		hostPort = net.JoinHostPort(u.Host, strconv.Itoa(int(u.Port)))
+	}
+	for _, host := range h.opts.allowedHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, hostPort) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) targetURI(r *http.Request) (message.URI, error) {
+	if h.opts.target == nil {
+		target := r.URL.Query().Get("target_uri")
+		if target == "" {
+			target = strings.TrimPrefix(r.URL.EscapedPath(), "/")
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+		}
+		u, err := message.ParseURI(target)
+		if err != nil {
+			return message.URI{}, newHTTPError(http.StatusBadRequest, "invalid target uri: %w", err)
+		}
+		if !h.isAllowed(u) {
+			return message.URI{}, newHTTPError(http.StatusForbidden, "target host %v is not allowed", u.Host)
+		}
+		return u, nil
+	}
+	u := *h.opts.target
+	path, err := splitEscaped(r.URL.EscapedPath(), "/")
+	if err != nil {
+		return message.URI{}, newHTTPError(http.StatusBadRequest, "invalid target uri: invalid path: %w", err)
+	}
+	queries, err := splitEscaped(r.URL.RawQuery, "&")
+	if err != nil {
+		return message.URI{}, newHTTPError(http.StatusBadRequest, "invalid target uri: invalid query: %w", err)
+	}
+	u.Path = append(append([]string{}, u.Path...), path...)
+	u.Queries = append(append([]string{}, u.Queries...), queries...)
+	return u, nil
+}
+
+func headerValues(h http.Header, key string) string {
+	return strings.Join(h[http.CanonicalHeaderKey(key)], ",")
+}
+
+func addUint32Option(opts message.Options, id message.OptionID, v uint32) (message.Options, error) {
+	buf := make([]byte, 4)
+	n, err := message.EncodeUint32(buf, v)
+	if err != nil {
+		return opts, err
+	}
+	return opts.Add(message.Option{ID: id, Value: buf[:n]}), nil
+}
+
+// httpError carries HTTP status of the request which cannot be translated.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e httpError) Error() string {
+	return e.err.Error()
+}
+
+func newHTTPError(status int, format string, a ...interface{}) error {
+	return httpError{status: status, err: fmt.Errorf(format, a...)}
+}
+
+// newRequest translates the HTTP request to the CoAP request: https://tools.ietf.org/html/rfc8075#section-6
+func (h *Handler) newRequest(r *http.Request, code codes.Code, u message.URI) (*message.Message, error) {
+	opts, err := u.Options()
+	if err != nil {
+		return nil, newHTTPError(http.StatusBadRequest, "invalid target uri: %w", err)
+	}
+	token, err := message.GetToken()
+	if err != nil {
+		return nil, newHTTPError(http.StatusInternalServerError, "cannot create token: %w", err)
+	}
+	req := &message.Message{
+		Context: r.Context(),
+		Token:   token,
+		Code:    code,
+	}
+
+	if code == codes.POST || code == codes.PUT || code == codes.PATCH {
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, h.opts.maxBodySize+1))
+		if err != nil {
+			return nil, newHTTPError(http.StatusBadRequest, "cannot read body: %w", err)
+		}
+		if int64(len(data)) > h.opts.maxBodySize {
+			return nil, newHTTPError(http.StatusRequestEntityTooLarge, "body exceeds %v bytes", h.opts.maxBodySize)
+		}
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = mediaTypeToContentType[message.AppOctets]
+		}
+		mt, err := MediaType(contentType)
+		if err != nil {
+			return nil, newHTTPError(http.StatusUnsupportedMediaType, "%w", err)
+		}
+		if opts, err = addUint32Option(opts, message.ContentFormat, uint32(mt)); err != nil {
+			return nil, newHTTPError(http.StatusInternalServerError, "%w", err)
+		}
+		req.Body = bytes.NewReader(data)
+	}
+
+	if mt, ok := acceptMediaType(r.Header.Get("Accept")); ok {
+		if opts, err = addUint32Option(opts, message.Accept, uint32(mt)); err != nil {
+			return nil, newHTTPError(http.StatusInternalServerError, "%w", err)
+		}
+	}
+
+	if v := headerValues(r.Header, "If-Match"); v != "" {
+		if strings.TrimSpace(v) == "*" {
+			opts = opts.Add(message.Option{ID: message.IfMatch, Value: []byte{}})
+		} else {
+			etags, err := parseETags(v)
+			if err != nil {
+				return nil, newHTTPError(http.StatusPreconditionFailed, "%w", err)
+			}
+			for _, etag := range etags {
+				opts = opts.Add(message.Option{ID: message.IfMatch, Value: etag})
+			}
+		}
+	}
+	if v := headerValues(r.Header, "If-None-Match"); v != "" {
+		if strings.TrimSpace(v) == "*" {
+			opts = opts.Add(message.Option{ID: message.IfNoneMatch, Value: []byte{}})
+		} else if code == codes.GET {
+			// validation of the cached representations: https://tools.ietf.org/html/rfc7252#section-5.10.6
+			// entity tags which weren't created by the proxy cannot match
+			etags, _ := parseETags(v)
+			for _, etag := range etags {
+				opts = opts.Add(message.Option{ID: message.ETag, Value: etag})
+			}
+		}
+	}
+	req.Options = opts
+	return req, nil
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	var httpErr httpError
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.status
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+	h.opts.errors(fmt.Errorf("httpproxy: %w", err))
+	http.Error(w, err.Error(), status)
+}
+
+func readBody(m *message.Message) ([]byte, error) {
+	if m.Body == nil {
+		return nil, nil
+	}
+	if _, err := m.Body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(m.Body)
+}
+
+// location returns Location header of Location-Path and Location-Query options.
+func location(opts message.Options) string {
+	var path, queries []string
+	for _, o := range opts {
+		switch o.ID {
+		case message.LocationPath:
+			path = append(path, url.PathEscape(string(o.Value)))
+		case message.LocationQuery:
+			queries = append(queries, url.PathEscape(string(o.Value)))
+		}
+	}
+	if len(path) == 0 && len(queries) == 0 {
+		return ""
+	}
+	l := "/" + strings.Join(path, "/")
+	if len(queries) > 0 {
+		l += "?" + strings.Join(queries, "&")
+	}
+	return l
+}
+
+// writeHeader translates options of the CoAP response to the HTTP headers: https://tools.ietf.org/html/rfc8075#section-7
+func writeHeader(hdr http.Header, resp *message.Message, hasPayload bool) {
+	if cf, err := resp.Options.ContentFormat(); err == nil {
+		hdr.Set("Content-Type", ContentType(cf))
+	} else if hasPayload {
+		hdr.Set("Content-Type", mediaTypeToContentType[message.AppOctets])
+	}
+	if etag, err := resp.Options.GetBytes(message.ETag); err == nil {
+		hdr.Set("ETag", formatETag(etag))
+	}
+	if maxAge, err := resp.Options.GetUint32(message.MaxAge); err == nil {
+		hdr.Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(maxAge), 10))
+	} else if resp.Code == codes.Content || resp.Code == codes.Valid {
+		hdr.Set("Cache-Control", "max-age="+strconv.Itoa(DefaultMaxAge))
+	}
+	if l := location(resp.Options); l != "" {
+		hdr.Set("Location", l)
+	}
+}
+
+func (h *Handler) writeResponse(w http.ResponseWriter, resp *message.Message) {
+	body, err := readBody(resp)
+	if err != nil {
+		h.writeError(w, fmt.Errorf("cannot read response body: %w", err))
+		return
+	}
+	writeHeader(w.Header(), resp, len(body) > 0)
+	status := StatusCode(resp.Code, len(body) > 0)
+	w.WriteHeader(status)
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		h.opts.errors(fmt.Errorf("httpproxy: cannot write response: %w", err))
+	}
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	for _, v := range strings.Split(headerValues(r.Header, "Accept"), ",") {
+		if strings.HasPrefix(strings.TrimSpace(v), contentTypeEventStream) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP forwards the request to the CoAP server.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code, ok := Method(r.Method)
+	if !ok {
+		http.Error(w, fmt.Sprintf("method %v is not supported", r.Method), http.StatusNotImplemented)
+		return
+	}
+	u, err := h.targetURI(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	req, err := h.newRequest(r, code, u)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	client, err := h.pool.Get(r.Context(), u)
+	if err != nil {
+		h.writeError(w, fmt.Errorf("cannot connect to %v: %w", u.Address(), err))
+		return
+	}
+	if h.opts.serverSentEvents && r.Method == http.MethodGet && acceptsEventStream(r) {
+		if _, ok := w.(http.Flusher); ok {
+			h.serveEvents(w, r, client, u, req.Options)
+			return
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		h.writeError(w, fmt.Errorf("cannot forward request to %v: %w", u.Address(), err))
+		return
+	}
+	h.writeResponse(w, resp)
+}
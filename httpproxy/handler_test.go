@@ -0,0 +1,272 @@
+package httpproxy_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2"
+	"github.com/plgd-dev/go-coap/v2/httpproxy"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/require"
+)
+
+var resourceETag = []byte{0x01, 0x02}
+
+func newRouter(t *testing.T, observers *mux.Observers) *mux.Router {
+	m := mux.NewRouter()
+	m.Use(observers.Middleware)
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		switch r.Code {
+		case codes.GET:
+			if etag, err := r.Options.GetBytes(message.ETag); err == nil && bytes.Equal(etag, resourceETag) {
+				err := w.SetResponse(codes.Valid, message.TextPlain, nil, message.Option{ID: message.ETag, Value: resourceETag})
+				require.NoError(t, err)
+				return
+			}
+			err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")),
+				message.Option{ID: message.ETag, Value: resourceETag}, message.Option{ID: message.MaxAge, Value: []byte{30}})
+			require.NoError(t, err)
+		case codes.POST:
+			err := w.SetResponse(codes.Created, message.TextPlain, nil, message.Option{ID: message.LocationPath, Value: []byte("b")})
+			require.NoError(t, err)
+		default:
+			err := w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+			require.NoError(t, err)
+		}
+	}))
+	m.Handle("/echo", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		cf, err := r.Options.ContentFormat()
+		require.NoError(t, err)
+		accept, err := r.Options.Accept()
+		require.NoError(t, err)
+		queries, err := r.Options.Queries()
+		require.NoError(t, err)
+		ifMatch, err := r.Options.GetBytes(message.IfMatch)
+		require.NoError(t, err)
+		resp := fmt.Sprintf("%v|%v|%v|%x|%s", cf, accept, queries, ifMatch, body)
+		err = w.SetResponse(codes.Changed, message.TextPlain, bytes.NewReader([]byte(resp)))
+		require.NoError(t, err)
+	}))
+	m.Handle("/obs", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("first")))
+		require.NoError(t, err)
+	}))
+	return m
+}
+
+func newServer(t *testing.T, observers *mux.Observers) (addr string, stop func()) {
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	s := udp.NewServer(udp.WithMux(newRouter(t, observers)))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+	return l.LocalAddr().String(), func() {
+		s.Stop()
+		l.Close()
+		wg.Wait()
+	}
+}
+
+func TestHandler(t *testing.T) {
+	addr, stop := newServer(t, mux.NewObservers(0, nil))
+	defer stop()
+	target, err := message.ParseURI("coap://" + addr)
+	require.NoError(t, err)
+	pool := coap.NewPool()
+	defer pool.Close()
+	proxy := httptest.NewServer(httpproxy.NewHandler(pool, httpproxy.WithTarget(target)))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/a")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "hello", string(body))
+	require.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Equal(t, "max-age=30", resp.Header.Get("Cache-Control"))
+	require.Equal(t, `"0102"`, resp.Header.Get("ETag"))
+
+	req, err := http.NewRequest(http.MethodGet, proxy.URL+"/a", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", `"0102"`)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, err = http.Post(proxy.URL+"/a", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "/b", resp.Header.Get("Location"))
+
+	req, err = http.NewRequest(http.MethodPut, proxy.URL+"/echo?x=1&y=2", strings.NewReader(`{"a":1}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/cbor")
+	req.Header.Set("If-Match", `"abcd"`)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `application/json|application/cbor (RFC 7049)|[x=1 y=2]|abcd|{"a":1}`, string(body))
+
+	req, err = http.NewRequest(http.MethodDelete, proxy.URL+"/a", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(proxy.URL+"/echo", "text/html", strings.NewReader("<p>"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodPatch, proxy.URL+"/echo?z=3", strings.NewReader(`{"a":2}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("If-Match", `"ef"`)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `application/json|application/json|[z=3]|ef|{"a":2}`, string(body))
+
+	req, err = http.NewRequest(http.MethodTrace, proxy.URL+"/a", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	resp, err = http.Get(proxy.URL + "/unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandler_DefaultMapping(t *testing.T) {
+	addr, stop := newServer(t, mux.NewObservers(0, nil))
+	defer stop()
+	pool := coap.NewPool()
+	defer pool.Close()
+	proxy := httptest.NewServer(http.StripPrefix("/hc", httpproxy.NewHandler(pool, httpproxy.WithAllowedHosts(addr))))
+	defer proxy.Close()
+
+	for _, uri := range []string{
+		proxy.URL + "/hc/coap://" + addr + "/a",
+		proxy.URL + "/hc/?target_uri=coap://" + addr + "/a",
+	} {
+		resp, err := http.Get(uri)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "hello", string(body))
+	}
+
+	resp, err := http.Get(proxy.URL + "/hc/http://" + addr + "/a")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHandler_RejectsNotAllowedHost(t *testing.T) {
+	addr, stop := newServer(t, mux.NewObservers(0, nil))
+	defer stop()
+	pool := coap.NewPool()
+	defer pool.Close()
+
+	for _, handler := range []*httpproxy.Handler{
+		httpproxy.NewHandler(pool),
+		httpproxy.NewHandler(pool, httpproxy.WithAllowedHosts("example.com")),
+	} {
+		proxy := httptest.NewServer(handler)
+		for _, uri := range []string{
+			proxy.URL + "/coap://" + addr + "/a",
+			proxy.URL + "/?target_uri=coap://" + addr + "/a",
+		} {
+			resp, err := http.Get(uri)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusForbidden, resp.StatusCode)
+		}
+		proxy.Close()
+	}
+}
+
+func TestHandler_ServerSentEvents(t *testing.T) {
+	observers := mux.NewObservers(0, nil)
+	addr, stop := newServer(t, observers)
+	defer stop()
+	target, err := message.ParseURI("coap://" + addr)
+	require.NoError(t, err)
+	pool := coap.NewPool()
+	defer pool.Close()
+	proxy := httptest.NewServer(httpproxy.NewHandler(pool, httpproxy.WithTarget(target), httpproxy.WithServerSentEvents()))
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodGet, proxy.URL+"/obs", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan string, 8)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				events <- data
+			}
+		}
+	}()
+	waitEvent := func() string {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second * 3):
+			require.FailNow(t, "timeout")
+		}
+		return ""
+	}
+	require.Equal(t, "first", waitEvent())
+	err = observers.Publish("/obs", message.TextPlain, bytes.NewReader([]byte("second")))
+	require.NoError(t, err)
+	require.Equal(t, "second", waitEvent())
+	err = observers.Publish("/obs", message.AppOctets, bytes.NewReader([]byte{0xff, 0xfe}))
+	require.NoError(t, err)
+	require.Equal(t, "//4=", waitEvent())
+}
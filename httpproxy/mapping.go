@@ -0,0 +1,234 @@
+package httpproxy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// ContentTypeCoapPayload is the media type of payloads with Content-Format unknown to the proxy:
+// https://tools.ietf.org/html/rfc8075#section-6.3
+const ContentTypeCoapPayload = "application/coap-payload"
+
+var mediaTypeToContentType = map[message.MediaType]string{
+	message.TextPlain:         "text/plain; charset=utf-8",
+	message.AppCoseEncrypt0:   `application/cose; cose-type="cose-encrypt0"`,
+	message.AppCoseMac0:       `application/cose; cose-type="cose-mac0"`,
+	message.AppCoseSign1:      `application/cose; cose-type="cose-sign1"`,
+	message.AppLinkFormat:     "application/link-format",
+	message.AppXML:            "application/xml",
+	message.AppOctets:         "application/octet-stream",
+	message.AppExi:            "application/exi",
+	message.AppJSON:           "application/json",
+	message.AppJSONPatch:      "application/json-patch+json",
+	message.AppJSONMergePatch: "application/merge-patch+json",
+	message.AppCBOR:           "application/cbor",
+	message.AppCWT:            "application/cwt",
+	message.AppCoseEncrypt:    `application/cose; cose-type="cose-encrypt"`,
+	message.AppCoseMac:        `application/cose; cose-type="cose-mac"`,
+	message.AppCoseSign:       `application/cose; cose-type="cose-sign"`,
+	message.AppCoseKey:        "application/cose-key",
+	message.AppCoseKeySet:     "application/cose-key-set",
+	message.AppCoapGroup:      "application/coap-group+json",
+	message.AppMissingBlocks:  "application/missing-blocks+cbor-seq",
+	message.AppOcfCbor:        "application/vnd.ocf+cbor",
+	message.AppLwm2mTLV:       "application/vnd.oma.lwm2m+tlv",
+	message.AppLwm2mJSON:      "application/vnd.oma.lwm2m+json",
+}
+
+var contentTypeToMediaType = func() map[string]message.MediaType {
+	m := make(map[string]message.MediaType, len(mediaTypeToContentType))
+	for mt, ct := range mediaTypeToContentType {
+		v, params, err := mime.ParseMediaType(ct)
+		if err != nil {
+			panic(err)
+		}
+		if t, ok := params["cose-type"]; ok {
+			v += ";" + t
+		}
+		m[v] = mt
+	}
+	return m
+}()
+
+// ContentType returns HTTP media type of the Content-Format: https://tools.ietf.org/html/rfc8075#section-6.2
+func ContentType(mt message.MediaType) string {
+	if ct, ok := mediaTypeToContentType[mt]; ok {
+		return ct
+	}
+	return ContentTypeCoapPayload + "; cf=" + strconv.Itoa(int(mt))
+}
+
+// MediaType returns Content-Format of the HTTP media type.
+func MediaType(contentType string) (message.MediaType, error) {
+	v, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0, fmt.Errorf("invalid media type '%v': %w", contentType, err)
+	}
+	switch v {
+	case ContentTypeCoapPayload:
+		cf, err := strconv.ParseUint(params["cf"], 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid content format of media type '%v': %w", contentType, err)
+		}
+		return message.MediaType(cf), nil
+	case "text/plain":
+		if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+			return 0, fmt.Errorf("unsupported charset '%v'", charset)
+		}
+	}
+	if t, ok := params["cose-type"]; ok {
+		v += ";" + t
+	}
+	mt, ok := contentTypeToMediaType[v]
+	if !ok {
+		return 0, fmt.Errorf("unsupported media type '%v'", contentType)
+	}
+	return mt, nil
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// acceptMediaType returns Content-Format of the most preferred media type of Accept header which has
+// the equivalent Content-Format. It returns false when any media type is acceptable or none of them is known.
+func acceptMediaType(accept string) (message.MediaType, bool) {
+	var ranges []acceptRange
+	for _, r := range strings.Split(accept, ",") {
+		v, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+			delete(params, "q")
+		}
+		if q <= 0 {
+			continue
+		}
+		ranges = append(ranges, acceptRange{mediaType: mime.FormatMediaType(v, params), q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	for _, r := range ranges {
+		if strings.HasSuffix(r.mediaType, "/*") {
+			return 0, false
+		}
+		if mt, err := MediaType(r.mediaType); err == nil {
+			return mt, true
+		}
+	}
+	return 0, false
+}
+
+// StatusCode returns HTTP status code of the CoAP response code: https://tools.ietf.org/html/rfc8075#section-7
+func StatusCode(code codes.Code, hasPayload bool) int {
+	switch code {
+	case codes.Created:
+		return http.StatusCreated
+	case codes.Deleted, codes.Changed:
+		if hasPayload {
+			return http.StatusOK
+		}
+		return http.StatusNoContent
+	case codes.Valid:
+		return http.StatusNotModified
+	case codes.Content:
+		return http.StatusOK
+	case codes.BadRequest, codes.BadOption, codes.RequestEntityIncomplete:
+		return http.StatusBadRequest
+	case codes.Unauthorized, codes.Forbidden:
+		// 401 requires WWW-Authenticate header
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.MethodNotAllowed:
+		// 405 requires Allow header
+		return http.StatusBadRequest
+	case codes.NotAcceptable:
+		return http.StatusNotAcceptable
+	case codes.Conflict:
+		return http.StatusConflict
+	case codes.PreconditionFailed:
+		return http.StatusPreconditionFailed
+	case codes.RequestEntityTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case codes.UnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case codes.UnprocessableEntity:
+		return http.StatusUnprocessableEntity
+	case codes.InternalServerError:
+		return http.StatusInternalServerError
+	case codes.NotImplemented:
+		return http.StatusNotImplemented
+	case codes.BadGateway, codes.ProxyingNotSupported:
+		return http.StatusBadGateway
+	case codes.ServiceUnavailable:
+		return http.StatusServiceUnavailable
+	case codes.GatewayTimeout:
+		return http.StatusGatewayTimeout
+	}
+	switch code >> 5 {
+	case 2:
+		return http.StatusOK
+	case 4:
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// Method returns CoAP method code of the HTTP method.
+func Method(method string) (codes.Code, bool) {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return codes.GET, true
+	case http.MethodPost:
+		return codes.POST, true
+	case http.MethodPut:
+		return codes.PUT, true
+	case http.MethodDelete:
+		return codes.DELETE, true
+	case http.MethodPatch:
+		return codes.PATCH, true
+	}
+	return 0, false
+}
+
+// formatETag formats CoAP ETag as HTTP entity tag.
+func formatETag(etag []byte) string {
+	return `"` + hex.EncodeToString(etag) + `"`
+}
+
+// parseETags parses list of HTTP entity tags formatted by formatETag, the weak tags are ignored.
+func parseETags(v string) ([][]byte, error) {
+	var etags [][]byte
+	for _, t := range strings.Split(v, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" || strings.HasPrefix(t, "W/") {
+			continue
+		}
+		if len(t) < 2 || t[0] != '"' || t[len(t)-1] != '"' {
+			return nil, fmt.Errorf("invalid entity tag %v", t)
+		}
+		etag, err := hex.DecodeString(t[1 : len(t)-1])
+		if err != nil || len(etag) == 0 || len(etag) > message.CoapOptionDefs[message.ETag].MaxLen {
+			return nil, fmt.Errorf("entity tag %v wasn't created by the proxy", t)
+		}
+		etags = append(etags, etag)
+	}
+	return etags, nil
+}
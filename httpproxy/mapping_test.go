@@ -0,0 +1,95 @@
+package httpproxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaType(t *testing.T) {
+	for mt, ct := range mediaTypeToContentType {
+		v, err := MediaType(ct)
+		require.NoError(t, err)
+		require.Equal(t, mt, v)
+		require.Equal(t, ct, ContentType(mt))
+	}
+	v, err := MediaType("text/plain")
+	require.NoError(t, err)
+	require.Equal(t, message.TextPlain, v)
+	v, err = MediaType("application/coap-payload; cf=12345")
+	require.NoError(t, err)
+	require.Equal(t, message.MediaType(12345), v)
+	require.Equal(t, "application/coap-payload; cf=12345", ContentType(12345))
+
+	_, err = MediaType("text/plain; charset=iso-8859-1")
+	require.Error(t, err)
+	_, err = MediaType("text/html")
+	require.Error(t, err)
+}
+
+func TestAcceptMediaType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   message.MediaType
+		wantOk bool
+	}{
+		{accept: "", wantOk: false},
+		{accept: "application/json", want: message.AppJSON, wantOk: true},
+		{accept: "text/html, application/cbor;q=0.5, application/json;q=0.9", want: message.AppJSON, wantOk: true},
+		{accept: "*/*, application/json", wantOk: false},
+		{accept: "application/json;q=0", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got, ok := acceptMediaType(tt.accept)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	require.Equal(t, http.StatusCreated, StatusCode(codes.Created, false))
+	require.Equal(t, http.StatusNoContent, StatusCode(codes.Changed, false))
+	require.Equal(t, http.StatusOK, StatusCode(codes.Changed, true))
+	require.Equal(t, http.StatusNotModified, StatusCode(codes.Valid, false))
+	require.Equal(t, http.StatusForbidden, StatusCode(codes.Unauthorized, true))
+	require.Equal(t, http.StatusBadRequest, StatusCode(codes.MethodNotAllowed, true))
+	require.Equal(t, http.StatusBadGateway, StatusCode(codes.ProxyingNotSupported, true))
+	require.Equal(t, http.StatusConflict, StatusCode(codes.Conflict, true))
+	require.Equal(t, http.StatusUnprocessableEntity, StatusCode(codes.UnprocessableEntity, true))
+	require.Equal(t, http.StatusBadRequest, StatusCode(codes.Code(0x9d), true))
+}
+
+func TestMethod(t *testing.T) {
+	tests := map[string]codes.Code{
+		http.MethodGet:    codes.GET,
+		http.MethodHead:   codes.GET,
+		http.MethodPost:   codes.POST,
+		http.MethodPut:    codes.PUT,
+		http.MethodDelete: codes.DELETE,
+		http.MethodPatch:  codes.PATCH,
+	}
+	for method, want := range tests {
+		code, ok := Method(method)
+		require.True(t, ok, method)
+		require.Equal(t, want, code, method)
+	}
+	_, ok := Method(http.MethodTrace)
+	require.False(t, ok)
+}
+
+func TestETags(t *testing.T) {
+	v := formatETag([]byte{0x01, 0xab})
+	require.Equal(t, `"01ab"`, v)
+	etags, err := parseETags(v + `, W/"ff", "02"`)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0x01, 0xab}, {0x02}}, etags)
+	_, err = parseETags(`"xyz"`)
+	require.Error(t, err)
+	_, err = parseETags(`01`)
+	require.Error(t, err)
+}
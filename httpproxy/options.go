@@ -0,0 +1,93 @@
+package httpproxy
+
+import (
+	"github.com/plgd-dev/go-coap/v2/message"
+)
+
+// A HandlerOption sets options of the Handler.
+type HandlerOption interface {
+	apply(*handlerOptions)
+}
+
+// TargetOpt target option.
+type TargetOpt struct {
+	target message.URI
+}
+
+func (o TargetOpt) apply(opts *handlerOptions) {
+	t := o.target
+	opts.target = &t
+}
+
+// WithTarget forwards all requests to the CoAP endpoint. The path and the query of the HTTP request
+// are appended to the path and the query of the target.
+//
+// Without the target the HTTP request path contains the target URI, eg. /coap://host/path (RFC 8075 section 5.3),
+// or the target URI is in the target_uri query parameter, and the host must be allowed by WithAllowedHosts.
+func WithTarget(target message.URI) TargetOpt {
+	return TargetOpt{
+		target: target,
+	}
+}
+
+// AllowedHostsOpt allowed hosts option.
+type AllowedHostsOpt struct {
+	hosts []string
+}
+
+func (o AllowedHostsOpt) apply(opts *handlerOptions) {
+	opts.allowedHosts = append(opts.allowedHosts, o.hosts...)
+}
+
+// WithAllowedHosts allows targets of the host, eg. "example.com", or of the host and the port, eg. "example.com:5683",
+// when the handler runs without WithTarget. Requests to other hosts are rejected by 403.
+func WithAllowedHosts(hosts ...string) AllowedHostsOpt {
+	return AllowedHostsOpt{
+		hosts: hosts,
+	}
+}
+
+// ServerSentEventsOpt server-sent events option.
+type ServerSentEventsOpt struct {
+}
+
+func (o ServerSentEventsOpt) apply(opts *handlerOptions) {
+	opts.serverSentEvents = true
+}
+
+// WithServerSentEvents exposes CoAP observe as Server-Sent Events for GET requests which accept text/event-stream.
+func WithServerSentEvents() ServerSentEventsOpt {
+	return ServerSentEventsOpt{}
+}
+
+// MaxBodySizeOpt max body size option.
+type MaxBodySizeOpt struct {
+	maxBodySize int64
+}
+
+func (o MaxBodySizeOpt) apply(opts *handlerOptions) {
+	opts.maxBodySize = o.maxBodySize
+}
+
+// WithMaxBodySize limits size of the request body, larger requests are rejected by 413.
+func WithMaxBodySize(maxBodySize int64) MaxBodySizeOpt {
+	return MaxBodySizeOpt{
+		maxBodySize: maxBodySize,
+	}
+}
+
+// ErrorsOpt errors option.
+type ErrorsOpt struct {
+	errors func(error)
+}
+
+func (o ErrorsOpt) apply(opts *handlerOptions) {
+	opts.errors = o.errors
+}
+
+// WithErrors set function for logging error.
+func WithErrors(errors func(error)) ErrorsOpt {
+	return ErrorsOpt{
+		errors: errors,
+	}
+}
@@ -0,0 +1,118 @@
+package httpproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+const contentTypeEventStream = "text/event-stream"
+
+// Event types of the Server-Sent Events stream.
+const (
+	// EventNotification carries UTF-8 payload of the notification.
+	EventNotification = "notification"
+	// EventNotificationBase64 carries base64 encoded binary payload of the notification.
+	EventNotificationBase64 = "notification-base64"
+	// EventError carries HTTP status code of the notification which ended the observation.
+	EventError = "error"
+)
+
+func writeEvent(w http.ResponseWriter, n *message.Message) (bool, error) {
+	var b strings.Builder
+	if seq, err := n.Options.Observe(); err == nil {
+		b.WriteString("id: " + strconv.FormatUint(uint64(seq), 10) + "\n")
+	}
+	ok := n.Code == codes.Content
+	if !ok {
+		b.WriteString("event: " + EventError + "\n")
+		b.WriteString("data: " + strconv.Itoa(StatusCode(n.Code, false)) + "\n\n")
+	} else {
+		body, err := readBody(n)
+		if err != nil {
+			return false, fmt.Errorf("cannot read notification: %w", err)
+		}
+		if utf8.Valid(body) {
+			b.WriteString("event: " + EventNotification + "\n")
+			for _, l := range strings.Split(string(body), "\n") {
+				b.WriteString("data: " + strings.TrimSuffix(l, "\r") + "\n")
+			}
+		} else {
+			b.WriteString("event: " + EventNotificationBase64 + "\n")
+			b.WriteString("data: " + base64.StdEncoding.EncodeToString(body) + "\n")
+		}
+		b.WriteString("\n")
+	}
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		return false, err
+	}
+	w.(http.Flusher).Flush()
+	return ok, nil
+}
+
+// serveEvents observes the resource and streams the notifications as Server-Sent Events.
+// The error response of the observe request is translated as the regular response.
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request, client mux.Client, u message.URI, opts message.Options) {
+	ctx := r.Context()
+	obsOpts := make(message.Options, 0, len(opts))
+	for _, o := range opts {
+		if o.ID != message.URIPath {
+			obsOpts = append(obsOpts, o)
+		}
+	}
+	notifications := make(chan *message.Message, 8)
+	obs, err := client.Observe(ctx, u.PathString(), func(n *message.Message) {
+		select {
+		case notifications <- n:
+		case <-ctx.Done():
+		}
+	}, obsOpts...)
+	if err != nil {
+		select {
+		case n := <-notifications:
+			h.writeResponse(w, n)
+		default:
+			h.writeError(w, fmt.Errorf("cannot observe %v: %w", u, err))
+		}
+		return
+	}
+	defer func() {
+		cancelCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := obs.Cancel(cancelCtx); err != nil {
+			h.opts.errors(fmt.Errorf("httpproxy: cannot cancel observation of %v: %w", u, err))
+		}
+	}()
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", contentTypeEventStream)
+	hdr.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case n := <-notifications:
+			ok, err := writeEvent(w, n)
+			if err != nil {
+				h.opts.errors(fmt.Errorf("httpproxy: cannot write event: %w", err))
+				return
+			}
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		case <-client.Context().Done():
+			return
+		}
+	}
+}
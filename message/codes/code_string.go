@@ -11,6 +11,9 @@ var codeToString = map[Code]string{
 	POST:                  "POST",
 	PUT:                   "PUT",
 	DELETE:                "DELETE",
+	FETCH:                 "FETCH",
+	PATCH:                 "PATCH",
+	IPATCH:                "iPATCH",
 	Created:               "Created",
 	Deleted:               "Deleted",
 	Valid:                 "Valid",
@@ -23,9 +26,11 @@ var codeToString = map[Code]string{
 	NotFound:              "NotFound",
 	MethodNotAllowed:      "MethodNotAllowed",
 	NotAcceptable:         "NotAcceptable",
+	Conflict:              "Conflict",
 	PreconditionFailed:    "PreconditionFailed",
 	RequestEntityTooLarge: "RequestEntityTooLarge",
 	UnsupportedMediaType:  "UnsupportedMediaType",
+	UnprocessableEntity:   "UnprocessableEntity",
 	InternalServerError:   "InternalServerError",
 	NotImplemented:        "NotImplemented",
 	BadGateway:            "BadGateway",
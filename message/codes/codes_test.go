@@ -9,8 +9,8 @@ import (
 
 func TestJSONUnmarshal(t *testing.T) {
 	var got []Code
-	want := []Code{GET, NotFound, InternalServerError, Abort}
-	in := `["GET", "NotFound", "InternalServerError", "Abort"]`
+	want := []Code{GET, NotFound, InternalServerError, Abort, FETCH, PATCH, IPATCH, Conflict, UnprocessableEntity}
+	in := `["GET", "NotFound", "InternalServerError", "Abort", "FETCH", "PATCH", "iPATCH", "Conflict", "UnprocessableEntity"]`
 	err := json.Unmarshal([]byte(in), &got)
 	require.NoError(t, err)
 	require.Equal(t, want, got)
@@ -0,0 +1,265 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Link attributes of CoRE Link Format: https://tools.ietf.org/html/rfc6690#section-3
+const (
+	LinkAttrResourceType  = "rt"
+	LinkAttrInterface     = "if"
+	LinkAttrContentFormat = "ct"
+	LinkAttrSize          = "sz"
+	LinkAttrObservable    = "obs"
+	LinkAttrTitle         = "title"
+)
+
+// LinkAttribute is target attribute of the link. Attributes without value, eg. obs, have empty Value.
+type LinkAttribute struct {
+	Name  string
+	Value string
+}
+
+// Link is one link of CoRE Link Format (RFC 6690).
+type Link struct {
+	Target     string
+	Attributes []LinkAttribute
+}
+
+// Get returns value of the first attribute with the name.
+func (l Link) Get(name string) (string, bool) {
+	for _, a := range l.Attributes {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns values of all attributes with the name. Values of rt, if and ct are split by space.
+func (l Link) Values(name string) []string {
+	var values []string
+	for _, a := range l.Attributes {
+		if a.Name != name {
+			continue
+		}
+		switch name {
+		case LinkAttrResourceType, LinkAttrInterface, LinkAttrContentFormat:
+			values = append(values, strings.Fields(a.Value)...)
+		default:
+			values = append(values, a.Value)
+		}
+	}
+	return values
+}
+
+// Links is a collection of links in CoRE Link Format.
+type Links []Link
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Marshal serializes links to application/link-format.
+func (l Links) Marshal() []byte {
+	var b strings.Builder
+	for i, link := range l {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('<')
+		b.WriteString(link.Target)
+		b.WriteByte('>')
+		for _, a := range link.Attributes {
+			b.WriteByte(';')
+			b.WriteString(a.Name)
+			if a.Value == "" && a.Name == LinkAttrObservable {
+				continue
+			}
+			b.WriteByte('=')
+			if isDigits(a.Value) {
+				b.WriteString(a.Value)
+				continue
+			}
+			b.WriteString(quote(a.Value))
+		}
+	}
+	return []byte(b.String())
+}
+
+type linkParser struct {
+	data string
+	pos  int
+}
+
+func (p *linkParser) skipSpaces() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t' || p.data[p.pos] == '\r' || p.data[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *linkParser) peek() byte {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+func (p *linkParser) parseTarget() (string, error) {
+	if p.peek() != '<' {
+		return "", fmt.Errorf("expected '<' at position %v", p.pos)
+	}
+	end := strings.IndexByte(p.data[p.pos:], '>')
+	if end < 0 {
+		return "", fmt.Errorf("missing '>' of link starting at position %v", p.pos)
+	}
+	target := p.data[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	return target, nil
+}
+
+func (p *linkParser) parseQuoted() (string, error) {
+	var b strings.Builder
+	p.pos++
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch c {
+		case '\\':
+			if p.pos+1 >= len(p.data) {
+				return "", fmt.Errorf("unterminated escape at position %v", p.pos)
+			}
+			b.WriteByte(p.data[p.pos+1])
+			p.pos += 2
+		case '"':
+			p.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted string")
+}
+
+func (p *linkParser) parseAttribute() (LinkAttribute, error) {
+	start := p.pos
+	for p.pos < len(p.data) && !strings.ContainsRune("=;, \t", rune(p.data[p.pos])) {
+		p.pos++
+	}
+	a := LinkAttribute{Name: p.data[start:p.pos]}
+	if a.Name == "" {
+		return a, fmt.Errorf("empty attribute name at position %v", start)
+	}
+	p.skipSpaces()
+	if p.peek() != '=' {
+		return a, nil
+	}
+	p.pos++
+	p.skipSpaces()
+	if p.peek() == '"' {
+		v, err := p.parseQuoted()
+		if err != nil {
+			return a, err
+		}
+		a.Value = v
+		return a, nil
+	}
+	start = p.pos
+	for p.pos < len(p.data) && !strings.ContainsRune(";, \t", rune(p.data[p.pos])) {
+		p.pos++
+	}
+	a.Value = p.data[start:p.pos]
+	return a, nil
+}
+
+// Unmarshal parses links from application/link-format.
+func (l *Links) Unmarshal(data []byte) error {
+	p := linkParser{data: string(data)}
+	links := make(Links, 0, 8)
+	p.skipSpaces()
+	for p.pos < len(p.data) {
+		target, err := p.parseTarget()
+		if err != nil {
+			return err
+		}
+		link := Link{Target: target}
+		for {
+			p.skipSpaces()
+			if p.peek() != ';' {
+				break
+			}
+			p.pos++
+			p.skipSpaces()
+			a, err := p.parseAttribute()
+			if err != nil {
+				return err
+			}
+			link.Attributes = append(link.Attributes, a)
+		}
+		links = append(links, link)
+		switch p.peek() {
+		case ',':
+			p.pos++
+			p.skipSpaces()
+		case 0:
+		default:
+			return fmt.Errorf("unexpected character '%c' at position %v", p.peek(), p.pos)
+		}
+	}
+	*l = links
+	return nil
+}
+
+// Match returns true when the link matches the query filter, eg. rt=temperature or href=/sensors*:
+// https://tools.ietf.org/html/rfc6690#section-4.1
+//
+// A value with the trailing '*' matches the prefix.
+func (l Link) Match(query string) bool {
+	name := query
+	var value string
+	if idx := strings.IndexByte(query, '='); idx >= 0 {
+		name = query[:idx]
+		value = query[idx+1:]
+	}
+	prefix := strings.HasSuffix(value, "*")
+	if prefix {
+		value = strings.TrimSuffix(value, "*")
+	}
+	matchValue := func(v string) bool {
+		if prefix {
+			return strings.HasPrefix(v, value)
+		}
+		return v == value
+	}
+	if name == "href" {
+		return matchValue(l.Target)
+	}
+	for _, v := range l.Values(name) {
+		if matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinks_Unmarshal(t *testing.T) {
+	data := `</sensors/temp>;rt="temperature-c";if="sensor";ct=0;obs,
+	</sensors/light>;rt="light-lux core.s";title="Light, \"lux\"";sz=1024,</t>;anchor="/sensors/temp";rel=describedby`
+	var links Links
+	err := links.Unmarshal([]byte(data))
+	require.NoError(t, err)
+	require.Equal(t, Links{
+		{
+			Target: "/sensors/temp",
+			Attributes: []LinkAttribute{
+				{Name: "rt", Value: "temperature-c"},
+				{Name: "if", Value: "sensor"},
+				{Name: "ct", Value: "0"},
+				{Name: "obs"},
+			},
+		},
+		{
+			Target: "/sensors/light",
+			Attributes: []LinkAttribute{
+				{Name: "rt", Value: "light-lux core.s"},
+				{Name: "title", Value: `Light, "lux"`},
+				{Name: "sz", Value: "1024"},
+			},
+		},
+		{
+			Target: "/t",
+			Attributes: []LinkAttribute{
+				{Name: "anchor", Value: "/sensors/temp"},
+				{Name: "rel", Value: "describedby"},
+			},
+		},
+	}, links)
+	require.Equal(t, []string{"light-lux", "core.s"}, links[1].Values(LinkAttrResourceType))
+
+	var parsed Links
+	err = parsed.Unmarshal(links.Marshal())
+	require.NoError(t, err)
+	require.Equal(t, links, parsed)
+}
+
+func TestLinks_UnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{
+		"/a>",
+		"</a",
+		`</a>;title="a`,
+		"</a>;",
+		"</a> x",
+	} {
+		var links Links
+		require.Error(t, links.Unmarshal([]byte(data)), data)
+	}
+}
+
+func TestLink_Match(t *testing.T) {
+	l := Link{
+		Target: "/sensors/temp",
+		Attributes: []LinkAttribute{
+			{Name: "rt", Value: "temperature-c core.s"},
+			{Name: "ct", Value: "0 41"},
+			{Name: "obs"},
+		},
+	}
+	require.True(t, l.Match("rt=temperature-c"))
+	require.True(t, l.Match("rt=core.s"))
+	require.True(t, l.Match("rt=temp*"))
+	require.False(t, l.Match("rt=temperature"))
+	require.True(t, l.Match("ct=41"))
+	require.True(t, l.Match("href=/sensors*"))
+	require.False(t, l.Match("href=/sensors"))
+	require.True(t, l.Match("obs"))
+	require.False(t, l.Match("if=sensor"))
+}
@@ -35,18 +35,24 @@ type OptionID uint16
    |   7 | x  | x | - |   | Uri-Port       | uint   | 0-2    | (see    |
    |     |    |   |   |   |                |        |        | below)  |
    |   8 |    |   |   | x | Location-Path  | string | 0-255  | (none)  |
+   |   9 | x  |   |   |   | OSCORE         | opaque | 0-255  | (none)  |
    |  11 | x  | x | - | x | Uri-Path       | string | 0-255  | (none)  |
    |  12 |    |   |   |   | Content-Format | uint   | 0-2    | (none)  |
    |  14 |    | x | - |   | Max-Age        | uint   | 0-4    | 60      |
    |  15 | x  | x | - | x | Uri-Query      | string | 0-255  | (none)  |
    |  17 | x  |   |   |   | Accept         | uint   | 0-2    | (none)  |
+   |  19 | x  | x | - | - | Q-Block1       | uint   | 0-3    | (none)  |
    |  20 |    |   |   | x | Location-Query | string | 0-255  | (none)  |
    |  23 | x  | x | - | - | Block2         | uint   | 0-3    | (none)  |
    |  27 | x  | x | - | - | Block1         | uint   | 0-3    | (none)  |
    |  28 |    |   | x |   | Size2          | uint   | 0-4    | (none)  |
+   |  31 | x  | x | - | - | Q-Block2       | uint   | 0-3    | (none)  |
    |  35 | x  | x | - |   | Proxy-Uri      | string | 1-1034 | (none)  |
    |  39 | x  | x | - |   | Proxy-Scheme   | string | 1-255  | (none)  |
    |  60 |    |   | x |   | Size1          | uint   | 0-4    | (none)  |
+   | 252 |    |   | x |   | Echo           | opaque | 1-40   | (none)  |
+   | 258 |    |   | x |   | No-Response    | uint   | 0-1    | 0       |
+   | 292 |    |   |   | x | Request-Tag    | opaque | 0-8    | (none)  |
    +-----+----+---+---+---+----------------+--------+--------+---------+
    C=Critical, U=Unsafe, N=NoCacheKey, R=Repeatable
 */
@@ -60,19 +66,24 @@ const (
 	Observe       OptionID = 6
 	URIPort       OptionID = 7
 	LocationPath  OptionID = 8
+	OSCORE        OptionID = 9
 	URIPath       OptionID = 11
 	ContentFormat OptionID = 12
 	MaxAge        OptionID = 14
 	URIQuery      OptionID = 15
 	Accept        OptionID = 17
+	QBlock1       OptionID = 19
 	LocationQuery OptionID = 20
 	Block2        OptionID = 23
 	Block1        OptionID = 27
 	Size2         OptionID = 28
+	QBlock2       OptionID = 31
 	ProxyURI      OptionID = 35
 	ProxyScheme   OptionID = 39
 	Size1         OptionID = 60
+	Echo          OptionID = 252
 	NoResponse    OptionID = 258
+	RequestTag    OptionID = 292
 )
 
 var optionIDToString = map[OptionID]string{
@@ -83,19 +94,24 @@ var optionIDToString = map[OptionID]string{
 	Observe:       "Observe",
 	URIPort:       "URIPort",
 	LocationPath:  "LocationPath",
+	OSCORE:        "OSCORE",
 	URIPath:       "URIPath",
 	ContentFormat: "ContentFormat",
 	MaxAge:        "MaxAge",
 	URIQuery:      "URIQuery",
 	Accept:        "Accept",
+	QBlock1:       "QBlock1",
 	LocationQuery: "LocationQuery",
 	Block2:        "Block2",
 	Block1:        "Block1",
 	Size2:         "Size2",
+	QBlock2:       "QBlock2",
 	ProxyURI:      "ProxyURI",
 	ProxyScheme:   "ProxyScheme",
 	Size1:         "Size1",
+	Echo:          "Echo",
 	NoResponse:    "NoResponse",
+	RequestTag:    "RequestTag",
 }
 
 func (o OptionID) String() string {
@@ -140,19 +156,24 @@ var CoapOptionDefs = map[OptionID]OptionDef{
 	Observe:       {ValueFormat: ValueUint, MinLen: 0, MaxLen: 3},
 	URIPort:       {ValueFormat: ValueUint, MinLen: 0, MaxLen: 2},
 	LocationPath:  {ValueFormat: ValueString, MinLen: 0, MaxLen: 255},
+	OSCORE:        {ValueFormat: ValueOpaque, MinLen: 0, MaxLen: 255},
 	URIPath:       {ValueFormat: ValueString, MinLen: 0, MaxLen: 255},
 	ContentFormat: {ValueFormat: ValueUint, MinLen: 0, MaxLen: 2},
 	MaxAge:        {ValueFormat: ValueUint, MinLen: 0, MaxLen: 4},
 	URIQuery:      {ValueFormat: ValueString, MinLen: 0, MaxLen: 255},
 	Accept:        {ValueFormat: ValueUint, MinLen: 0, MaxLen: 2},
+	QBlock1:       {ValueFormat: ValueUint, MinLen: 0, MaxLen: 3},
 	LocationQuery: {ValueFormat: ValueString, MinLen: 0, MaxLen: 255},
 	Block2:        {ValueFormat: ValueUint, MinLen: 0, MaxLen: 3},
 	Block1:        {ValueFormat: ValueUint, MinLen: 0, MaxLen: 3},
 	Size2:         {ValueFormat: ValueUint, MinLen: 0, MaxLen: 4},
+	QBlock2:       {ValueFormat: ValueUint, MinLen: 0, MaxLen: 3},
 	ProxyURI:      {ValueFormat: ValueString, MinLen: 1, MaxLen: 1034},
 	ProxyScheme:   {ValueFormat: ValueString, MinLen: 1, MaxLen: 255},
 	Size1:         {ValueFormat: ValueUint, MinLen: 0, MaxLen: 4},
+	Echo:          {ValueFormat: ValueOpaque, MinLen: 1, MaxLen: 40},
 	NoResponse:    {ValueFormat: ValueUint, MinLen: 0, MaxLen: 1},
+	RequestTag:    {ValueFormat: ValueOpaque, MinLen: 0, MaxLen: 8},
 }
 
 // MediaType specifies the content format of a message.
@@ -179,6 +200,7 @@ var (
 	AppCoseKey        MediaType = 101   //application/cose-key (RFC 8152)
 	AppCoseKeySet     MediaType = 102   //application/cose-key-set (RFC 8152)
 	AppCoapGroup      MediaType = 256   //coap-group+json (RFC 7390)
+	AppMissingBlocks  MediaType = 272   //application/missing-blocks+cbor-seq (RFC 9177)
 	AppOcfCbor        MediaType = 10000 //application/vnd.ocf+cbor
 	AppLwm2mTLV       MediaType = 11542 //application/vnd.oma.lwm2m+tlv
 	AppLwm2mJSON      MediaType = 11543 //application/vnd.oma.lwm2m+json
@@ -204,6 +226,7 @@ var mediaTypeToString = map[MediaType]string{
 	AppCoseKey:        "application/cose-key (RFC 8152)",
 	AppCoseKeySet:     "application/cose-key-set (RFC 8152)",
 	AppCoapGroup:      "coap-group+json (RFC 7390)",
+	AppMissingBlocks:  "application/missing-blocks+cbor-seq (RFC 9177)",
 	AppOcfCbor:        "application/vnd.ocf+cbor",
 	AppLwm2mTLV:       "application/vnd.oma.lwm2m+tlv",
 	AppLwm2mJSON:      "application/vnd.oma.lwm2m+json",
@@ -43,6 +43,7 @@ func (r *Message) Reset() {
 	r.valueBuffer = r.origValueBuffer
 	r.payload = nil
 	r.isModified = false
+	atomic.StoreUint32(&r.hijacked, 0)
 }
 
 func (r *Message) Path() (string, error) {
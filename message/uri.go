@@ -0,0 +1,252 @@
+package message
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// URI schemes of CoAP.
+const (
+	SchemeCoap     = "coap"      // CoAP over UDP (RFC 7252)
+	SchemeCoaps    = "coaps"     // CoAP over DTLS (RFC 7252)
+	SchemeCoapTCP  = "coap+tcp"  // CoAP over TCP (RFC 8323)
+	SchemeCoapsTCP = "coaps+tcp" // CoAP over TLS (RFC 8323)
+	SchemeCoapWS   = "coap+ws"   // CoAP over WebSockets (RFC 8323)
+	SchemeCoapsWS  = "coaps+ws"  // CoAP over secure WebSockets (RFC 8323)
+)
+
+var schemeDefaultPort = map[string]uint16{
+	SchemeCoap:     5683,
+	SchemeCoaps:    5684,
+	SchemeCoapTCP:  5683,
+	SchemeCoapsTCP: 5684,
+	SchemeCoapWS:   80,
+	SchemeCoapsWS:  443,
+}
+
+// DefaultPort returns default port of the scheme.
+func DefaultPort(scheme string) (uint16, error) {
+	port, ok := schemeDefaultPort[strings.ToLower(scheme)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported scheme '%v'", scheme)
+	}
+	return port, nil
+}
+
+// URI represents CoAP URI: https://tools.ietf.org/html/rfc7252#section-6
+type URI struct {
+	Scheme string
+	// Host is the host name or the IP address without brackets.
+	Host string
+	// Port is the port of the URI, zero means the default port of the scheme.
+	Port uint16
+	// Path contains decoded path segments.
+	Path []string
+	// Queries contains decoded query arguments.
+	Queries []string
+}
+
+// ParseURI parses CoAP URI, eg. coaps+tcp://host:5684/path?query.
+func ParseURI(uri string) (URI, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return URI{}, fmt.Errorf("cannot parse uri: %w", err)
+	}
+	if !u.IsAbs() || u.Opaque != "" {
+		return URI{}, fmt.Errorf("uri '%v' is not absolute", uri)
+	}
+	if u.Fragment != "" {
+		return URI{}, fmt.Errorf("uri '%v' must not contain fragment", uri)
+	}
+	if u.User != nil {
+		return URI{}, fmt.Errorf("uri '%v' must not contain user info", uri)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if _, err := DefaultPort(scheme); err != nil {
+		return URI{}, err
+	}
+	host := u.Hostname()
+	if host == "" {
+		return URI{}, fmt.Errorf("uri '%v' doesn't contain host", uri)
+	}
+	var port uint16
+	if p := u.Port(); p != "" {
+		v, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return URI{}, fmt.Errorf("invalid port '%v': %w", p, err)
+		}
+		port = uint16(v)
+	}
+	r := URI{
+		Scheme: scheme,
+		Host:   host,
+		Port:   port,
+	}
+	path := strings.TrimPrefix(u.EscapedPath(), "/")
+	if path != "" {
+		for _, s := range strings.Split(path, "/") {
+			v, err := url.PathUnescape(s)
+			if err != nil {
+				return URI{}, fmt.Errorf("invalid path segment '%v': %w", s, err)
+			}
+			r.Path = append(r.Path, v)
+		}
+	}
+	if u.RawQuery != "" {
+		for _, q := range strings.Split(u.RawQuery, "&") {
+			v, err := url.PathUnescape(q)
+			if err != nil {
+				return URI{}, fmt.Errorf("invalid query '%v': %w", q, err)
+			}
+			r.Queries = append(r.Queries, v)
+		}
+	}
+	return r, nil
+}
+
+// PortOrDefault returns port of the URI or default port of the scheme.
+func (u URI) PortOrDefault() uint16 {
+	if u.Port != 0 {
+		return u.Port
+	}
+	return schemeDefaultPort[u.Scheme]
+}
+
+// Address returns host:port of the URI which can be used for dialing.
+func (u URI) Address() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(int(u.PortOrDefault())))
+}
+
+// PathString returns path of the URI joined by '/' in the form used by Options.Path.
+func (u URI) PathString() string {
+	return strings.Join(u.Path, "/")
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// escape escapes all characters except unreserved and the allowed ones.
+func escape(s string, allowed string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUnreserved(c) || strings.IndexByte(allowed, c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// String formats the URI: https://tools.ietf.org/html/rfc7252#section-6.5
+func (u URI) String() string {
+	var b strings.Builder
+	b.WriteString(u.Scheme)
+	b.WriteString("://")
+	host := u.Host
+	if strings.IndexByte(host, ':') >= 0 {
+		host = "[" + host + "]"
+	}
+	b.WriteString(host)
+	if u.Port != 0 && u.Port != schemeDefaultPort[u.Scheme] {
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(int(u.Port)))
+	}
+	if len(u.Path) == 0 {
+		b.WriteByte('/')
+	}
+	for _, p := range u.Path {
+		b.WriteByte('/')
+		b.WriteString(escape(p, "!$&'()*+,;=:@"))
+	}
+	for i, q := range u.Queries {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(escape(q, "!$'()*+,;=:@/?"))
+	}
+	return b.String()
+}
+
+// Options converts the URI to Uri-Host, Uri-Port, Uri-Path and Uri-Query options: https://tools.ietf.org/html/rfc7252#section-6.4
+//
+// Uri-Host is omitted for IP literals and Uri-Port is omitted for the default port of the scheme.
+func (u URI) Options() (Options, error) {
+	opts := make(Options, 0, len(u.Path)+len(u.Queries)+2)
+	if net.ParseIP(u.Host) == nil {
+		host := strings.ToLower(u.Host)
+		if len(host) > CoapOptionDefs[URIHost].MaxLen {
+			return nil, fmt.Errorf("host '%v' is too long", u.Host)
+		}
+		opts = opts.Add(Option{ID: URIHost, Value: []byte(host)})
+	}
+	if u.Port != 0 && u.Port != schemeDefaultPort[u.Scheme] {
+		buf := make([]byte, 2)
+		n, err := EncodeUint32(buf, uint32(u.Port))
+		if err != nil {
+			return nil, err
+		}
+		opts = opts.Add(Option{ID: URIPort, Value: buf[:n]})
+	}
+	for _, p := range u.Path {
+		if len(p) > CoapOptionDefs[URIPath].MaxLen {
+			return nil, fmt.Errorf("path segment '%v' is too long", p)
+		}
+		opts = opts.Add(Option{ID: URIPath, Value: []byte(p)})
+	}
+	for _, q := range u.Queries {
+		if len(q) > CoapOptionDefs[URIQuery].MaxLen {
+			return nil, fmt.Errorf("query '%v' is too long", q)
+		}
+		opts = opts.Add(Option{ID: URIQuery, Value: []byte(q)})
+	}
+	return opts, nil
+}
+
+// URIFromOptions creates URI from the options of the request: https://tools.ietf.org/html/rfc7252#section-6.5
+//
+// host and port identify the destination of the request, they are used when Uri-Host and Uri-Port options are missing.
+func URIFromOptions(scheme, host string, port uint16, opts Options) (URI, error) {
+	scheme = strings.ToLower(scheme)
+	if _, err := DefaultPort(scheme); err != nil {
+		return URI{}, err
+	}
+	u := URI{
+		Scheme: scheme,
+		Host:   host,
+		Port:   port,
+	}
+	if opts.HasOption(URIHost) {
+		v, err := opts.GetString(URIHost)
+		if err != nil {
+			return URI{}, fmt.Errorf("invalid Uri-Host: %w", err)
+		}
+		u.Host = v
+	}
+	if opts.HasOption(URIPort) {
+		v, err := opts.GetUint32(URIPort)
+		if err != nil {
+			return URI{}, fmt.Errorf("invalid Uri-Port: %w", err)
+		}
+		u.Port = uint16(v)
+	}
+	if u.Port == schemeDefaultPort[scheme] {
+		u.Port = 0
+	}
+	for _, o := range opts {
+		switch o.ID {
+		case URIPath:
+			u.Path = append(u.Path, string(o.Value))
+		case URIQuery:
+			u.Queries = append(u.Queries, string(o.Value))
+		}
+	}
+	return u, nil
+}
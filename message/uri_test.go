@@ -0,0 +1,105 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		want    URI
+		wantErr bool
+	}{
+		{
+			name: "udp",
+			uri:  "coap://example.com/sensors/temp?unit=c&x",
+			want: URI{Scheme: SchemeCoap, Host: "example.com", Path: []string{"sensors", "temp"}, Queries: []string{"unit=c", "x"}},
+		},
+		{
+			name: "tls with port",
+			uri:  "COAPS+TCP://example.com:5685/path",
+			want: URI{Scheme: SchemeCoapsTCP, Host: "example.com", Port: 5685, Path: []string{"path"}},
+		},
+		{
+			name: "ipv6 with escaped path",
+			uri:  "coap://[2001:db8::1]:61616/a%2Fb/%C3%A4?q%26a",
+			want: URI{Scheme: SchemeCoap, Host: "2001:db8::1", Port: 61616, Path: []string{"a/b", "ä"}, Queries: []string{"q&a"}},
+		},
+		{
+			name: "root path",
+			uri:  "coap+tcp://127.0.0.1",
+			want: URI{Scheme: SchemeCoapTCP, Host: "127.0.0.1"},
+		},
+		{
+			name:    "unsupported scheme",
+			uri:     "http://example.com",
+			wantErr: true,
+		},
+		{
+			name:    "fragment",
+			uri:     "coap://example.com/a#b",
+			wantErr: true,
+		},
+		{
+			name:    "relative",
+			uri:     "/a/b",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseURI(tt.uri)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+
+			formatted, err := ParseURI(got.String())
+			require.NoError(t, err)
+			require.Equal(t, got, formatted)
+		})
+	}
+}
+
+func TestURI_String(t *testing.T) {
+	u := URI{Scheme: SchemeCoaps, Host: "2001:db8::1", Port: 5684, Path: []string{"a b", "c/d"}, Queries: []string{"x=1&2", "y"}}
+	require.Equal(t, "coaps://[2001:db8::1]/a%20b/c%2Fd?x=1%262&y", u.String())
+}
+
+func TestURI_Options(t *testing.T) {
+	u, err := ParseURI("coap://Example.com:5690/a/b?c=1")
+	require.NoError(t, err)
+	opts, err := u.Options()
+	require.NoError(t, err)
+	host, err := opts.GetString(URIHost)
+	require.NoError(t, err)
+	require.Equal(t, "example.com", host)
+	port, err := opts.GetUint32(URIPort)
+	require.NoError(t, err)
+	require.Equal(t, uint32(5690), port)
+	path, err := opts.Path()
+	require.NoError(t, err)
+	require.Equal(t, "a/b", path)
+	queries, err := opts.Queries()
+	require.NoError(t, err)
+	require.Equal(t, []string{"c=1"}, queries)
+
+	back, err := URIFromOptions(SchemeCoap, "192.0.2.1", 0, opts)
+	require.NoError(t, err)
+	require.Equal(t, "coap://example.com:5690/a/b?c=1", back.String())
+
+	u, err = ParseURI("coap://192.0.2.1:5683/a")
+	require.NoError(t, err)
+	opts, err = u.Options()
+	require.NoError(t, err)
+	require.False(t, opts.HasOption(URIHost))
+	require.False(t, opts.HasOption(URIPort))
+	back, err = URIFromOptions(SchemeCoap, "192.0.2.1", 5683, opts)
+	require.NoError(t, err)
+	require.Equal(t, "coap://192.0.2.1/a", back.String())
+}
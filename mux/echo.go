@@ -0,0 +1,206 @@
+package mux
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/net/cache"
+)
+
+const (
+	// amplificationFactor limits size of a response to an unverified address (RFC 9175 section 2.4).
+	amplificationFactor = 3
+	echoTimestampLen    = 8
+	echoMACLen          = 8
+	// messageHeaderLen is size of the UDP header, payload marker and the options which are added by the response writer.
+	messageHeaderLen = 16
+	// block2OptionLen is the maximal size of the Block2 option which is added to the block.
+	block2OptionLen = 4
+)
+
+// EchoVerifier uses the Echo option (RFC 9175) to verify that clients are able to receive responses
+// at their source address and that requests are fresh.
+//
+// Echo values are stateless - they contain the time of the challenge and MAC which binds them
+// to the remote address, so only addresses which have been verified are stored.
+type EchoVerifier struct {
+	key      []byte
+	validity time.Duration
+	verified *cache.Cache
+	clock    clock.Clock
+}
+
+// NewEchoVerifier creates verifier which accepts Echo values and verified addresses for validity.
+func NewEchoVerifier(validity time.Duration) (*EchoVerifier, error) {
+	return NewEchoVerifierWithClock(validity, nil)
+}
+
+// NewEchoVerifierWithClock creates verifier whose Echo values and verified addresses expire by the clock, nil means the clock of the system.
+func NewEchoVerifierWithClock(validity time.Duration, clk clock.Clock) (*EchoVerifier, error) {
+	clk = clock.Get(clk)
+	key := make([]byte, sha256.Size)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate key: %w", err)
+	}
+	return &EchoVerifier{
+		key:      key,
+		validity: validity,
+		verified: cache.New(validity, validity, clk),
+		clock:    clk,
+	}, nil
+}
+
+func (v *EchoVerifier) mac(timestamp []byte, addr string) []byte {
+	h := hmac.New(sha256.New, v.key)
+	h.Write(timestamp)
+	h.Write([]byte(addr))
+	return h.Sum(nil)[:echoMACLen]
+}
+
+func (v *EchoVerifier) newEcho(addr string) []byte {
+	echo := make([]byte, echoTimestampLen, echoTimestampLen+echoMACLen)
+	binary.BigEndian.PutUint64(echo, uint64(v.clock.Now().UnixNano()))
+	return append(echo, v.mac(echo, addr)...)
+}
+
+// verify checks that the echo was issued for the address within validity.
+func (v *EchoVerifier) verify(echo []byte, addr string) bool {
+	if len(echo) != echoTimestampLen+echoMACLen {
+		return false
+	}
+	if !hmac.Equal(echo[echoTimestampLen:], v.mac(echo[:echoTimestampLen], addr)) {
+		return false
+	}
+	age := v.clock.Since(time.Unix(0, int64(binary.BigEndian.Uint64(echo))))
+	return age >= 0 && age <= v.validity
+}
+
+func (v *EchoVerifier) challenge(w ResponseWriter, addr string) error {
+	return w.SetResponse(codes.Unauthorized, message.TextPlain, nil, message.Option{
+		ID:    message.Echo,
+		Value: v.newEcho(addr),
+	})
+}
+
+func remoteAddr(w ResponseWriter) string {
+	if addr := w.Client().RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// datagramConn is implemented by connections of the datagram transports (udp, dtls),
+// which send large bodies in blocks of BlockSize.
+type datagramConn interface {
+	BlockSize() int64
+}
+
+// IsVerified returns true when the address answered a challenge within validity.
+func (v *EchoVerifier) IsVerified(addr string) bool {
+	_, ok := v.verified.Get(addr)
+	return ok
+}
+
+// Middleware protects the server against amplification attacks. A response whose first datagram is more
+// than three times bigger than the request of an unverified address is replaced by 4.01 Unauthorized
+// with the Echo option. The address is verified when a client repeats the request with the Echo value.
+// Connections of stream transports (tcp, tls) are served without the verification.
+//
+// Requests of unsafe methods from an unverified address are challenged before they are served, so
+// they are not served twice when the client repeats them. Handlers which require fresh requests
+// from verified addresses too should be wrapped by RequireFresh.
+func (v *EchoVerifier) Middleware(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Message) {
+		cc, ok := w.Client().ClientConn().(datagramConn)
+		if !ok {
+			next.ServeCOAP(w, r)
+			return
+		}
+		addr := remoteAddr(w)
+		if echo, err := r.Options.GetBytes(message.Echo); err == nil && v.verify(echo, addr) {
+			v.verified.SetDefault(addr, struct{}{})
+		}
+		if v.IsVerified(addr) {
+			next.ServeCOAP(w, r)
+			return
+		}
+		if isUnsafe(r.Code) {
+			v.challenge(w, addr)
+			return
+		}
+		next.ServeCOAP(&echoResponseWriter{
+			ResponseWriter: w,
+			verifier:       v,
+			addr:           addr,
+			blockSize:      cc.BlockSize(),
+			limit:          amplificationFactor * messageSize(r.Message.Token, r.Options, r.Body, 0),
+		}, r)
+	})
+}
+
+// RequireFresh serves only requests which contain an Echo value issued within validity, other requests
+// are answered by 4.01 Unauthorized with a new Echo value. It protects actuators against delayed and replayed requests.
+func (v *EchoVerifier) RequireFresh(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Message) {
+		addr := remoteAddr(w)
+		echo, err := r.Options.GetBytes(message.Echo)
+		if err != nil || !v.verify(echo, addr) {
+			v.challenge(w, addr)
+			return
+		}
+		v.verified.SetDefault(addr, struct{}{})
+		next.ServeCOAP(w, r)
+	})
+}
+
+// isUnsafe returns true for methods which change state of the resource.
+func isUnsafe(code codes.Code) bool {
+	switch code {
+	case codes.POST, codes.PUT, codes.DELETE, codes.PATCH, codes.IPATCH:
+		return true
+	}
+	return false
+}
+
+type echoResponseWriter struct {
+	ResponseWriter
+	verifier  *EchoVerifier
+	addr      string
+	blockSize int64
+	limit     int64
+}
+
+func (w *echoResponseWriter) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	if messageSize(nil, opts, d, w.blockSize) > w.limit {
+		return w.verifier.challenge(w.ResponseWriter, w.addr)
+	}
+	return w.ResponseWriter.SetResponse(code, contentFormat, d, opts...)
+}
+
+// messageSize estimates size of the first datagram of the message on the wire. The body which is bigger
+// than the non-zero blockSize is sent by the blockwise transfer, so only the first block is counted.
+func messageSize(token message.Token, opts message.Options, body io.ReadSeeker, blockSize int64) int64 {
+	size := int64(messageHeaderLen + len(token))
+	n, _ := opts.Marshal(nil)
+	size += int64(n)
+	if body != nil {
+		bodySize, err := body.Seek(0, io.SeekEnd)
+		if err == nil {
+			if blockSize > 0 && bodySize > blockSize {
+				bodySize = blockSize + block2OptionLen
+			}
+			size += bodySize
+		}
+		body.Seek(0, io.SeekStart)
+	}
+	return size
+}
@@ -0,0 +1,243 @@
+package mux_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/net/blockwise"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEchoVerifier(t *testing.T) {
+	v, err := mux.NewEchoVerifier(time.Second * 10)
+	require.NoError(t, err)
+
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var large, small, fresh uint32
+	echoes := make(chan bool, 8)
+	m := mux.NewRouter()
+	m.Use(v.Middleware)
+	m.Handle("/large", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&large, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(make([]byte, 512)))
+		require.NoError(t, err)
+	}))
+	m.Handle("/small", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&small, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, err)
+	}))
+	m.Handle("/fresh", v.RequireFresh(mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&fresh, 1)
+		echoes <- r.Options.HasOption(message.Echo)
+		err := w.SetResponse(codes.Changed, message.TextPlain, nil)
+		require.NoError(t, err)
+	})))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithMux(m))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// small response is sent to the unverified address
+	resp, err := cc.Get(ctx, "/small")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	require.Equal(t, uint32(1), atomic.LoadUint32(&small))
+
+	// large response is replaced by the challenge and the client repeats the request
+	resp, err = cc.Get(ctx, "/large")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err := ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Len(t, body, 512)
+	require.Equal(t, uint32(2), atomic.LoadUint32(&large))
+
+	// verified address isn't challenged
+	resp, err = cc.Get(ctx, "/large")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	require.Equal(t, uint32(3), atomic.LoadUint32(&large))
+
+	// fresh request is served only with Echo
+	resp, err = cc.Post(ctx, "/fresh", message.TextPlain, bytes.NewReader([]byte("on")))
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code())
+	require.Equal(t, uint32(1), atomic.LoadUint32(&fresh))
+	require.True(t, <-echoes)
+}
+
+func TestEchoVerifier_UnsafeAndExpiration(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	v, err := mux.NewEchoVerifierWithClock(time.Second*10, clk)
+	require.NoError(t, err)
+
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var large, unsafe uint32
+	m := mux.NewRouter()
+	m.Use(v.Middleware)
+	m.Handle("/large", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&large, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(make([]byte, 512)))
+		require.NoError(t, err)
+	}))
+	m.Handle("/unsafe", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&unsafe, 1)
+		err := w.SetResponse(codes.Changed, message.TextPlain, nil)
+		require.NoError(t, err)
+	}))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithMux(m))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// unsafe request of the unverified address is challenged before it is served
+	resp, err := cc.Post(ctx, "/unsafe", message.TextPlain, bytes.NewReader([]byte("on")))
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code())
+	require.Equal(t, uint32(1), atomic.LoadUint32(&unsafe))
+
+	// the address was verified by the repeated request
+	resp, err = cc.Get(ctx, "/large")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	require.Equal(t, uint32(1), atomic.LoadUint32(&large))
+
+	// the verified address expires
+	clk.Advance(time.Second * 11)
+	resp, err = cc.Get(ctx, "/large")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	require.Equal(t, uint32(3), atomic.LoadUint32(&large))
+}
+
+func TestEchoVerifier_FirstBlock(t *testing.T) {
+	v, err := mux.NewEchoVerifier(time.Second * 10)
+	require.NoError(t, err)
+
+	var large uint32
+	m := mux.NewRouter()
+	m.Use(v.Middleware)
+	m.Handle("/large", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&large, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(make([]byte, 512)))
+		require.NoError(t, err)
+	}))
+
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithMux(m), udp.WithBlockwise(true, blockwise.SZX16, time.Second*3))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String(), udp.WithBlockwise(true, blockwise.SZX16, time.Second*3))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// the response is sent in blocks which are small enough for the unverified address
+	resp, err := cc.Get(ctx, "/large")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err := ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Len(t, body, 512)
+	require.Equal(t, uint32(1), atomic.LoadUint32(&large))
+}
+
+func TestEchoVerifier_TCP(t *testing.T) {
+	v, err := mux.NewEchoVerifier(time.Second * 10)
+	require.NoError(t, err)
+
+	var large uint32
+	m := mux.NewRouter()
+	m.Use(v.Middleware)
+	m.Handle("/large", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&large, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(make([]byte, 512)))
+		require.NoError(t, err)
+	}))
+
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := tcp.NewServer(tcp.WithMux(m))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := tcp.Dial(l.Addr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// stream transports are served without the challenge
+	resp, err := cc.Get(ctx, "/large")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	require.Equal(t, uint32(1), atomic.LoadUint32(&large))
+}
@@ -10,6 +10,9 @@ type Message struct {
 	// IsConfirmable indicates that a UDP message is confirmable. For TCP the value has no semantic.
 	// When a handler blocks a confirmable message, the client might decide to issue a re-transmission.
 	// Long running handlers can be handled in a go routine and send the response via w.Client().
-	// The ACK is sent as soon as the handler returns.
+	// The response is piggybacked in the ACK when the handler returns within the piggyback timeout,
+	// otherwise an empty ACK is sent and the response follows as a separate message.
 	IsConfirmable bool
+	// RouteParams contains values of the pattern parameters and the query parameters of the matched route.
+	RouteParams map[string]string
 }
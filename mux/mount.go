@@ -0,0 +1,145 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	// Pattern is the full pattern of the route including prefixes of the mounted routers, eg. /api/devices/{id}.
+	Pattern    string
+	Methods    []codes.Code
+	Host       string
+	Queries    []string
+	Attributes []message.LinkAttribute
+}
+
+// Mount attaches the sub-router to the prefix. Requests for the prefix and the paths under it are served
+// by the sub-router with the prefix stripped from the Uri-Path, eg. /api/sensors/temp is served by the pattern
+// /sensors/temp and /api by the pattern / of the sub-router. Middlewares of the router are executed before
+// middlewares of the sub-router and the default handler of the sub-router serves requests which it doesn't match.
+// The prefix can contain parameters, their values are available in Message.RouteParams.
+func (r *Router) Mount(prefix string, sub *Router) error {
+	if sub == nil {
+		return errors.New("nil router")
+	}
+	if sub == r {
+		return errors.New("cannot mount router to itself")
+	}
+	pattern := normalizePattern(strings.TrimSuffix(prefix, "/"))
+	if pattern == "/" {
+		return errors.New("invalid prefix")
+	}
+	segments, _, err := splitPattern(pattern)
+	if err != nil {
+		return err
+	}
+	h := &mountHandler{sub: sub, n: len(segments)}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if n := r.node(segments, false); n != nil && (n.exact != nil || n.prefix != nil) {
+		return fmt.Errorf("prefix %v is already registered", pattern)
+	}
+	for _, isPrefix := range []bool{false, true} {
+		p := pattern
+		if isPrefix {
+			p += "/"
+		}
+		e, err := r.entry(p, segments, isPrefix)
+		if err != nil {
+			return err
+		}
+		e.mount = sub
+		e.routes = []*route{{h: h}}
+	}
+	return nil
+}
+
+type mountHandler struct {
+	sub *Router
+	n   int
+}
+
+func (h *mountHandler) ServeCOAP(w ResponseWriter, req *Message) {
+	msg := *req.Message
+	msg.Options = stripPath(msg.Options, h.n)
+	stripped := *req
+	stripped.Message = &msg
+	h.sub.ServeCOAP(w, &stripped)
+}
+
+// stripPath removes first n segments of the Uri-Path. An empty segment is kept for the root path.
+func stripPath(opts message.Options, n int) message.Options {
+	stripped := make(message.Options, 0, len(opts))
+	for _, o := range opts {
+		if o.ID == message.URIPath && n > 0 {
+			n--
+			continue
+		}
+		stripped = append(stripped, o)
+	}
+	if !stripped.HasOption(message.URIPath) {
+		stripped = stripped.Add(message.Option{ID: message.URIPath, Value: []byte{}})
+	}
+	return stripped
+}
+
+func joinPattern(prefix, pattern string) string {
+	if pattern == "/" {
+		return prefix
+	}
+	return prefix + pattern
+}
+
+// Routes returns routes of the router and of the mounted routers sorted by pattern.
+func (r *Router) Routes() []RouteInfo {
+	r.m.RLock()
+	entries := make([]*muxEntry, 0, len(r.z))
+	for _, e := range r.z {
+		entries = append(entries, e)
+	}
+	r.m.RUnlock()
+
+	routes := make([]RouteInfo, 0, len(entries))
+	for _, e := range entries {
+		pattern := "/"
+		if e.pattern != "/" {
+			pattern += e.pattern
+		}
+		if e.mount != nil {
+			if e.isPrefix {
+				for _, rt := range e.mount.Routes() {
+					rt.Pattern = joinPattern(pattern[:len(pattern)-1], rt.Pattern)
+					routes = append(routes, rt)
+				}
+			}
+			continue
+		}
+		r.m.RLock()
+		for _, rt := range e.routes {
+			queries := make([]string, 0, len(rt.queries))
+			for _, q := range rt.queries {
+				queries = append(queries, q.String())
+			}
+			routes = append(routes, RouteInfo{
+				Pattern:    pattern,
+				Methods:    append([]codes.Code(nil), rt.methods...),
+				Host:       rt.host,
+				Queries:    queries,
+				Attributes: append([]message.LinkAttribute(nil), rt.attrs...),
+			})
+		}
+		r.m.RUnlock()
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Pattern < routes[j].Pattern
+	})
+	return routes
+}
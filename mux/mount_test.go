@@ -0,0 +1,103 @@
+package mux
+
+import (
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Mount(t *testing.T) {
+	var calls []string
+	trace := func(name string) MiddlewareFunc {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, r *Message) {
+				calls = append(calls, name)
+				next.ServeCOAP(w, r)
+			})
+		}
+	}
+	var got string
+	var params map[string]string
+	handler := func(name string) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Message) {
+			path, err := r.Options.Path()
+			require.NoError(t, err)
+			got = name + ":" + path
+			params = r.RouteParams
+			w.SetResponse(codes.Content, message.TextPlain, nil)
+		})
+	}
+
+	sensors := NewRouter()
+	sensors.Use(trace("sensors"))
+	require.NoError(t, sensors.Handle("/", handler("sensors"), Title("Sensors")))
+	require.NoError(t, sensors.Handle("/{name}", handler("sensor")))
+	require.NoError(t, sensors.HandleRoute("/temp", handler("temp"), WithMethods(codes.GET), WithAttributes(ResourceType("temperature-c"))))
+	sensors.DefaultHandleFunc(func(w ResponseWriter, r *Message) {
+		got = "sensors-default"
+		w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+	})
+
+	api := NewRouter()
+	api.Use(trace("api"))
+	require.NoError(t, api.Handle("/status", handler("status")))
+	require.NoError(t, api.Mount("/devices/{id}/sensors", sensors))
+
+	r := NewRouter()
+	r.Use(trace("root"))
+	require.NoError(t, r.Handle("/config", handler("config")))
+	require.NoError(t, r.Mount("/api/", api))
+	require.Error(t, r.Mount("/api", NewRouter()))
+	require.Error(t, r.Mount("/", NewRouter()))
+	require.Error(t, r.Handle("/api/", handler("api")))
+
+	tests := []struct {
+		name       string
+		code       codes.Code
+		path       string
+		want       string
+		wantCode   codes.Code
+		wantCalls  []string
+		wantParams map[string]string
+	}{
+		{name: "config", code: codes.GET, path: "config", want: "config:config", wantCode: codes.Content, wantCalls: []string{"root"}},
+		{name: "status", code: codes.GET, path: "api/status", want: "status:status", wantCode: codes.Content, wantCalls: []string{"root", "api"}},
+		{name: "sensors", code: codes.GET, path: "api/devices/7/sensors", want: "sensors:", wantCode: codes.Content, wantCalls: []string{"root", "api", "sensors"}, wantParams: map[string]string{"id": "7"}},
+		{name: "sensor", code: codes.GET, path: "api/devices/7/sensors/light", want: "sensor:light", wantCode: codes.Content, wantCalls: []string{"root", "api", "sensors"}, wantParams: map[string]string{"id": "7", "name": "light"}},
+		{name: "temp", code: codes.GET, path: "api/devices/7/sensors/temp", want: "temp:temp", wantCode: codes.Content, wantCalls: []string{"root", "api", "sensors"}, wantParams: map[string]string{"id": "7"}},
+		{name: "method-not-allowed", code: codes.PUT, path: "api/devices/7/sensors/temp", wantCode: codes.MethodNotAllowed, wantCalls: []string{"root", "api", "sensors"}},
+		{name: "sub-default", code: codes.GET, path: "api/devices/7/sensors/temp/raw", want: "sensors-default", wantCode: codes.BadRequest, wantCalls: []string{"root", "api", "sensors"}},
+		{name: "api-default", code: codes.GET, path: "api/unknown", wantCode: codes.NotFound, wantCalls: []string{"root", "api"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, params, calls = "", nil, nil
+			w := &testResponseWriter{}
+			r.ServeCOAP(w, newTestRequest(t, tt.code, tt.path))
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantCode, w.code)
+			require.Equal(t, tt.wantCalls, calls)
+			require.Equal(t, tt.wantParams, params)
+		})
+	}
+
+	routes := r.Routes()
+	patterns := make([]string, 0, len(routes))
+	for _, rt := range routes {
+		patterns = append(patterns, rt.Pattern)
+	}
+	require.Equal(t, []string{"/api/devices/{id}/sensors", "/api/devices/{id}/sensors/temp", "/api/devices/{id}/sensors/{name}", "/api/status", "/config"}, patterns)
+	require.Equal(t, []codes.Code{codes.GET}, routes[1].Methods)
+
+	require.Equal(t, `</api/status>,</config>`, string(r.Links().Marshal()))
+	require.Equal(t, `</>;title="Sensors",</temp>;rt="temperature-c"`, string(sensors.Links().Marshal()))
+
+	require.NoError(t, r.HandleRemove("/api"))
+	got = ""
+	w := &testResponseWriter{}
+	r.ServeCOAP(w, newTestRequest(t, codes.GET, "api/status"))
+	require.Equal(t, codes.NotFound, w.code)
+	require.Len(t, r.z, 1)
+}
@@ -0,0 +1,477 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// maxObserveSequence observe option is 3 bytes long: https://tools.ietf.org/html/rfc7641#section-4.4
+const maxObserveSequence = 0xffffff
+
+// Observers keeps registrations of clients observing resources (RFC 7641) and
+// sends them notifications when the resources change.
+//
+// Registrations are handled by the Middleware which must be used by the Router.
+// A GET or FETCH request with Observe=0 registers the client when the handler sets a 2.xx response,
+// Observe=1 deregisters it. Registrations are also removed when the client rejects
+// a notification by reset or when the connection is closed.
+type Observers struct {
+	maxAge time.Duration
+	errors func(error)
+
+	mutex     sync.Mutex
+	resources map[string]*observedResource
+}
+
+type observedResource struct {
+	sequence  uint32
+	observers map[observerKey]*observer
+}
+
+type observerKey struct {
+	cc    interface{}
+	token string
+}
+
+type observer struct {
+	key     observerKey
+	path    string
+	client  Client
+	handler Handler
+	request message.Message
+	body    []byte
+
+	// queue contains notifications which are sent one by one in order of their sequence numbers
+	queueMutex sync.Mutex
+	queue      []func()
+	sending    bool
+	done       chan struct{}
+}
+
+// enqueue schedules the job after the previous jobs of the observer.
+func (ob *observer) enqueue(job func()) {
+	ob.queueMutex.Lock()
+	defer ob.queueMutex.Unlock()
+	ob.queue = append(ob.queue, job)
+	if ob.sending {
+		return
+	}
+	ob.sending = true
+	go ob.run()
+}
+
+// run processes the queue until it is empty.
+func (ob *observer) run() {
+	for {
+		ob.queueMutex.Lock()
+		if len(ob.queue) == 0 {
+			ob.sending = false
+			ob.queueMutex.Unlock()
+			return
+		}
+		job := ob.queue[0]
+		ob.queue[0] = nil
+		ob.queue = ob.queue[1:]
+		ob.queueMutex.Unlock()
+		job()
+	}
+}
+
+// NewObservers creates manager of observers.
+//
+// maxAge is set to notifications which don't contain Max-Age option, zero means that the option is omitted.
+// errors is called when a notification cannot be delivered.
+func NewObservers(maxAge time.Duration, errors func(error)) *Observers {
+	if errors == nil {
+		errors = func(error) {}
+	}
+	return &Observers{
+		maxAge:    maxAge,
+		errors:    errors,
+		resources: make(map[string]*observedResource),
+	}
+}
+
+func normalizePath(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+// Middleware registers and deregisters observers of resources served by next handler.
+func (o *Observers) Middleware(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Message) {
+		obs, err := r.Options.Observe()
+		if err != nil || (r.Code != codes.GET && r.Code != codes.FETCH) {
+			next.ServeCOAP(w, r)
+			return
+		}
+		path, err := r.Options.Path()
+		if err != nil {
+			path = ""
+		}
+		key := observerKey{
+			cc:    w.Client().ClientConn(),
+			token: r.Token.String(),
+		}
+		switch obs {
+		case 0:
+			next.ServeCOAP(&observeResponseWriter{
+				ResponseWriter: w,
+				observers:      o,
+				key:            key,
+				path:           path,
+				handler:        next,
+				request:        r,
+			}, r)
+		case 1:
+			o.remove(path, key)
+			next.ServeCOAP(w, r)
+		default:
+			next.ServeCOAP(w, r)
+		}
+	})
+}
+
+// confirmableWriter is implemented by clients of UDP and DTLS which can send confirmable messages.
+type confirmableWriter interface {
+	WriteConfirmableMessage(req *message.Message) error
+}
+
+type observeResponseWriter struct {
+	ResponseWriter
+	observers *Observers
+	key       observerKey
+	path      string
+	handler   Handler
+	request   *Message
+}
+
+func isSuccess(code codes.Code) bool {
+	return code>>5 == 2
+}
+
+func (w *observeResponseWriter) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	if !isSuccess(code) {
+		w.observers.remove(w.path, w.key)
+		return w.ResponseWriter.SetResponse(code, contentFormat, d, opts...)
+	}
+	seq, err := w.observers.add(w.path, w.key, w.ResponseWriter.Client(), w.handler, w.request)
+	if err != nil {
+		return fmt.Errorf("cannot register observer: %w", err)
+	}
+	opts = append(opts, uint32Option(message.Observe, seq))
+	return w.ResponseWriter.SetResponse(code, contentFormat, d, opts...)
+}
+
+func uint32Option(id message.OptionID, value uint32) message.Option {
+	buf := make([]byte, 4)
+	n, _ := message.EncodeUint32(buf, value)
+	return message.Option{
+		ID:    id,
+		Value: buf[:n],
+	}
+}
+
+func (r *observedResource) nextSequence() uint32 {
+	r.sequence = (r.sequence + 1) & maxObserveSequence
+	return r.sequence
+}
+
+func (o *Observers) add(path string, key observerKey, client Client, handler Handler, req *Message) (uint32, error) {
+	opts, err := req.Options.Clone()
+	if err != nil {
+		return 0, err
+	}
+	var body []byte
+	if req.Body != nil {
+		if _, err := req.Body.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return 0, err
+		}
+	}
+	ob := &observer{
+		key:     key,
+		path:    path,
+		client:  client,
+		handler: handler,
+		request: message.Message{
+			Token:   append(message.Token(nil), req.Token...),
+			Code:    req.Code,
+			Options: opts,
+		},
+		body: body,
+		done: make(chan struct{}),
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	r, ok := o.resources[path]
+	if !ok {
+		r = &observedResource{
+			sequence:  1,
+			observers: make(map[observerKey]*observer),
+		}
+		o.resources[path] = r
+	}
+	if old, ok := r.observers[key]; ok {
+		close(old.done)
+	}
+	r.observers[key] = ob
+	go func() {
+		select {
+		case <-client.Context().Done():
+			o.remove(path, key)
+		case <-ob.done:
+		}
+	}()
+	return r.nextSequence(), nil
+}
+
+func (o *Observers) remove(path string, key observerKey) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	r, ok := o.resources[path]
+	if !ok {
+		return
+	}
+	ob, ok := r.observers[key]
+	if !ok {
+		return
+	}
+	close(ob.done)
+	delete(r.observers, key)
+	if len(r.observers) == 0 {
+		delete(o.resources, path)
+	}
+}
+
+func (o *Observers) removeObserver(ob *observer) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	r, ok := o.resources[ob.path]
+	if !ok || r.observers[ob.key] != ob {
+		return
+	}
+	close(ob.done)
+	delete(r.observers, ob.key)
+	if len(r.observers) == 0 {
+		delete(o.resources, ob.path)
+	}
+}
+
+type notification struct {
+	observer *observer
+	sequence uint32
+}
+
+// enqueueNotifications queues notifications of the resource for its observers. The sequence number is
+// acquired under the same lock, so each observer receives the notifications in order.
+func (o *Observers) enqueueNotifications(path string, send func(n notification)) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	r, ok := o.resources[normalizePath(path)]
+	if !ok {
+		return
+	}
+	seq := r.nextSequence()
+	for _, ob := range r.observers {
+		n := notification{
+			observer: ob,
+			sequence: seq,
+		}
+		ob.enqueue(func() {
+			send(n)
+		})
+	}
+}
+
+// Observed returns true when the resource has at least one observer.
+func (o *Observers) Observed(path string) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	_, ok := o.resources[normalizePath(path)]
+	return ok
+}
+
+// Notify sends the current representation of the resource to all its observers.
+// The representation is created by the handler which served the registration.
+func (o *Observers) Notify(path string) {
+	o.enqueueNotifications(path, func(n notification) {
+		w := &notificationResponseWriter{
+			client: n.observer.client,
+		}
+		var body io.ReadSeeker
+		if n.observer.body != nil {
+			body = bytes.NewReader(n.observer.body)
+		}
+		n.observer.handler.ServeCOAP(w, &Message{
+			Message: &message.Message{
+				Context: n.observer.client.Context(),
+				Token:   n.observer.request.Token,
+				Code:    n.observer.request.Code,
+				Options: n.observer.request.Options,
+				Body:    body,
+			},
+			SequenceNumber: n.observer.client.Sequence(),
+		})
+		if !w.modified {
+			return
+		}
+		o.send(n, w.code, w.contentFormat, w.body, w.opts)
+	})
+}
+
+// Publish sends the payload as new representation of the resource to all its observers.
+func (o *Observers) Publish(path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) error {
+	var body []byte
+	if payload != nil {
+		if _, err := payload.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("cannot seek to start of payload: %w", err)
+		}
+		var err error
+		body, err = ioutil.ReadAll(payload)
+		if err != nil {
+			return fmt.Errorf("cannot read payload: %w", err)
+		}
+	}
+	o.enqueueNotifications(path, func(n notification) {
+		o.send(n, codes.Content, contentFormat, body, opts)
+	})
+	return nil
+}
+
+func (o *Observers) send(n notification, code codes.Code, contentFormat message.MediaType, body []byte, opts message.Options) {
+	ob := n.observer
+	select {
+	case <-ob.done:
+		return
+	default:
+	}
+	if !isSuccess(code) {
+		// https://tools.ietf.org/html/rfc7641#section-3.2 - non 2.xx response removes the observer
+		o.removeObserver(ob)
+	}
+	notificationOpts := make(message.Options, 0, len(opts)+4)
+	for _, opt := range opts {
+		notificationOpts = notificationOpts.Add(opt)
+	}
+	if isSuccess(code) {
+		notificationOpts = notificationOpts.Set(uint32Option(message.Observe, n.sequence))
+	}
+	if o.maxAge > 0 && !notificationOpts.HasOption(message.MaxAge) {
+		notificationOpts = notificationOpts.Set(uint32Option(message.MaxAge, uint32(o.maxAge/time.Second)))
+	}
+	var payload io.ReadSeeker
+	if body != nil {
+		payload = bytes.NewReader(body)
+		notificationOpts = notificationOpts.Set(uint32Option(message.ContentFormat, uint32(contentFormat)))
+		if !notificationOpts.HasOption(message.ETag) {
+			etag, err := message.GetETag(payload)
+			if err != nil {
+				o.errors(fmt.Errorf("cannot calculate ETag of notification: %w", err))
+				return
+			}
+			notificationOpts = notificationOpts.Set(message.Option{ID: message.ETag, Value: etag})
+		}
+	}
+	notification := &message.Message{
+		Context: ob.client.Context(),
+		Token:   ob.request.Token,
+		Code:    code,
+		Options: notificationOpts,
+		Body:    payload,
+	}
+	var err error
+	if c, ok := ob.client.(confirmableWriter); ok {
+		// confirmable notifications let the server detect observers which are gone: https://tools.ietf.org/html/rfc7641#section-4.5
+		err = c.WriteConfirmableMessage(notification)
+	} else {
+		err = ob.client.WriteMessage(notification)
+	}
+	if err != nil {
+		o.removeObserver(ob)
+		o.errors(fmt.Errorf("cannot send notification to %v: %w", ob.client.RemoteAddr(), err))
+	}
+}
+
+// Shutdown sends 5.03 Service Unavailable to all observers, which removes them (RFC 7641 section 3.2).
+// It returns when the notifications are sent or when ctx expires. It is intended for WithOnShutdown
+// options of the servers.
+func (o *Observers) Shutdown(ctx context.Context) {
+	o.mutex.Lock()
+	notifications := make([]notification, 0, len(o.resources))
+	for _, r := range o.resources {
+		for _, ob := range r.observers {
+			notifications = append(notifications, notification{observer: ob})
+		}
+	}
+	o.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, n := range notifications {
+		n := n
+		wg.Add(1)
+		// the observer is removed after the notifications which are already queued
+		n.observer.enqueue(func() {
+			defer wg.Done()
+			o.send(n, codes.ServiceUnavailable, message.TextPlain, nil, nil)
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// notificationResponseWriter records response of handler for notification.
+type notificationResponseWriter struct {
+	client        Client
+	modified      bool
+	code          codes.Code
+	contentFormat message.MediaType
+	body          []byte
+	opts          message.Options
+}
+
+func (w *notificationResponseWriter) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	var body []byte
+	if d != nil {
+		if _, err := d.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var err error
+		body, err = ioutil.ReadAll(d)
+		if err != nil {
+			return err
+		}
+		if body == nil {
+			body = []byte{}
+		}
+	}
+	w.modified = true
+	w.code = code
+	w.contentFormat = contentFormat
+	w.body = body
+	w.opts = opts
+	return nil
+}
+
+func (w *notificationResponseWriter) Client() Client {
+	return w.client
+}
@@ -0,0 +1,318 @@
+package mux_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
+	"github.com/plgd-dev/go-coap/v2/udp/message/pool"
+	"github.com/stretchr/testify/require"
+)
+
+func newObservedServer(t *testing.T, obs *mux.Observers, counter *uint32) (*coapNet.UDPConn, func()) {
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+
+	m := mux.NewRouter()
+	m.Use(obs.Middleware)
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		v := atomic.AddUint32(counter, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte{byte(v)}))
+		require.NoError(t, err)
+	}))
+
+	s := udp.NewServer(udp.WithMux(m))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+	return l, func() {
+		s.Stop()
+		wg.Wait()
+		l.Close()
+	}
+}
+
+func TestObservers_NotifyAndPublish(t *testing.T) {
+	obs := mux.NewObservers(time.Second*30, func(err error) {
+		require.NoError(t, err)
+	})
+	var counter uint32
+	l, shutdown := newObservedServer(t, obs, &counter)
+	defer shutdown()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	notifications := make(chan *message.Message, 8)
+	o, err := cc.Client().Observe(ctx, "/a", func(n *message.Message) {
+		notifications <- n
+	})
+	require.NoError(t, err)
+	require.True(t, obs.Observed("/a"))
+
+	readNotification := func() ([]byte, uint32, message.Options) {
+		select {
+		case n := <-notifications:
+			seq, err := n.Options.Observe()
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(n.Body)
+			require.NoError(t, err)
+			return body, seq, n.Options
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		}
+		return nil, 0, nil
+	}
+
+	body, regSeq, _ := readNotification()
+	require.Equal(t, []byte{1}, body)
+
+	obs.Notify("/a")
+	body, seq, opts := readNotification()
+	require.Equal(t, []byte{2}, body)
+	require.Greater(t, seq, regSeq)
+	maxAge, err := opts.GetUint32(message.MaxAge)
+	require.NoError(t, err)
+	require.Equal(t, uint32(30), maxAge)
+	require.True(t, opts.HasOption(message.ETag))
+
+	err = obs.Publish("/a", message.TextPlain, bytes.NewReader([]byte("published")))
+	require.NoError(t, err)
+	body, seq2, _ := readNotification()
+	require.Equal(t, []byte("published"), body)
+	require.Greater(t, seq2, seq)
+
+	err = o.Cancel(ctx)
+	require.NoError(t, err)
+	require.False(t, obs.Observed("/a"))
+}
+
+func TestObservers_ResetRemovesObserver(t *testing.T) {
+	errs := make(chan error, 1)
+	obs := mux.NewObservers(0, func(err error) {
+		errs <- err
+	})
+	var counter uint32
+	l, shutdown := newObservedServer(t, obs, &counter)
+	defer shutdown()
+
+	c, err := net.Dial("udp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	writeMessage := func(m udpMessage.Message) {
+		data, err := m.Marshal()
+		require.NoError(t, err)
+		_, err = c.Write(data)
+		require.NoError(t, err)
+	}
+	readMessage := func() udpMessage.Message {
+		err := c.SetReadDeadline(time.Now().Add(time.Second * 2))
+		require.NoError(t, err)
+		b := make([]byte, 1024)
+		n, err := c.Read(b)
+		require.NoError(t, err)
+		resp := udpMessage.Message{
+			Options: make(message.Options, 0, 16),
+		}
+		_, err = resp.Unmarshal(b[:n])
+		require.NoError(t, err)
+		return resp
+	}
+
+	opts := make(message.Options, 0, 4)
+	buf := make([]byte, 32)
+	opts, _, err = opts.SetPath(buf, "/a")
+	require.NoError(t, err)
+	opts = opts.Add(message.Option{ID: message.Observe})
+	writeMessage(udpMessage.Message{
+		Code:      codes.GET,
+		Token:     []byte{1, 2, 3},
+		MessageID: 1,
+		Type:      udpMessage.Confirmable,
+		Options:   opts,
+	})
+	resp := readMessage()
+	require.Equal(t, codes.Content, resp.Code)
+	require.True(t, resp.Options.HasOption(message.Observe))
+	require.True(t, obs.Observed("/a"))
+
+	obs.Notify("/a")
+	resp = readMessage()
+	require.Equal(t, udpMessage.Confirmable, resp.Type)
+	require.Equal(t, []byte{1, 2, 3}, []byte(resp.Token))
+	writeMessage(udpMessage.Message{
+		Code:      codes.Empty,
+		MessageID: resp.MessageID,
+		Type:      udpMessage.Reset,
+	})
+
+	select {
+	case err := <-errs:
+		require.Error(t, err)
+	case <-time.After(time.Second * 2):
+		require.FailNow(t, "observer was not removed")
+	}
+	require.False(t, obs.Observed("/a"))
+}
+
+func TestObservers_Fetch(t *testing.T) {
+	obs := mux.NewObservers(0, func(err error) {
+		require.NoError(t, err)
+	})
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var counter uint32
+	m := mux.NewRouter()
+	m.Use(obs.Middleware)
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		require.Equal(t, codes.FETCH, r.Code)
+		query, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		v := atomic.AddUint32(&counter, 1)
+		err = w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(append(query, byte(v))))
+		require.NoError(t, err)
+	}))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithMux(m))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	notifications := make(chan []byte, 8)
+	o, err := cc.ObserveFetch(ctx, "/a", message.TextPlain, bytes.NewReader([]byte("q")), func(n *pool.Message) {
+		body, err := ioutil.ReadAll(n.Body())
+		require.NoError(t, err)
+		notifications <- body
+	})
+	require.NoError(t, err)
+	require.True(t, obs.Observed("/a"))
+
+	readNotification := func() []byte {
+		select {
+		case n := <-notifications:
+			return n
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		}
+		return nil
+	}
+	require.Equal(t, []byte{'q', 1}, readNotification())
+
+	// the handler is called with the payload of the registration
+	obs.Notify("/a")
+	require.Equal(t, []byte{'q', 2}, readNotification())
+
+	err = o.Cancel(ctx)
+	require.NoError(t, err)
+	require.False(t, obs.Observed("/a"))
+}
+
+func TestObservers_NotificationsInOrder(t *testing.T) {
+	// the last notification can be still in flight when the server stops
+	obs := mux.NewObservers(0, nil)
+	var counter uint32
+	l, shutdown := newObservedServer(t, obs, &counter)
+	defer shutdown()
+
+	c, err := net.Dial("udp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	writeMessage := func(m udpMessage.Message) {
+		data, err := m.Marshal()
+		require.NoError(t, err)
+		_, err = c.Write(data)
+		require.NoError(t, err)
+	}
+	readMessage := func() udpMessage.Message {
+		err := c.SetReadDeadline(time.Now().Add(time.Second * 2))
+		require.NoError(t, err)
+		b := make([]byte, 1024)
+		n, err := c.Read(b)
+		require.NoError(t, err)
+		resp := udpMessage.Message{
+			Options: make(message.Options, 0, 16),
+		}
+		_, err = resp.Unmarshal(b[:n])
+		require.NoError(t, err)
+		return resp
+	}
+
+	opts := make(message.Options, 0, 4)
+	buf := make([]byte, 32)
+	opts, _, err = opts.SetPath(buf, "/a")
+	require.NoError(t, err)
+	opts = opts.Add(message.Option{ID: message.Observe})
+	writeMessage(udpMessage.Message{
+		Code:      codes.GET,
+		Token:     []byte{1, 2, 3},
+		MessageID: 1,
+		Type:      udpMessage.Confirmable,
+		Options:   opts,
+	})
+	resp := readMessage()
+	require.Equal(t, codes.Content, resp.Code)
+	lastSeq, err := resp.Options.Observe()
+	require.NoError(t, err)
+
+	const published = 20
+	for i := 1; i <= published; i++ {
+		err := obs.Publish("/a", message.TextPlain, bytes.NewReader([]byte{byte(i)}))
+		require.NoError(t, err)
+	}
+	received := make(map[uint16]bool)
+	for next := 1; next <= published; {
+		resp := readMessage()
+		if resp.Type == udpMessage.Confirmable {
+			writeMessage(udpMessage.Message{
+				Code:      codes.Empty,
+				MessageID: resp.MessageID,
+				Type:      udpMessage.Acknowledgement,
+			})
+		}
+		if received[resp.MessageID] {
+			// retransmission of the notification
+			continue
+		}
+		received[resp.MessageID] = true
+		seq, err := resp.Options.Observe()
+		require.NoError(t, err)
+		require.Greater(t, seq, lastSeq)
+		lastSeq = seq
+		require.Equal(t, []byte{byte(next)}, resp.Payload)
+		next++
+	}
+}
@@ -0,0 +1,311 @@
+package mux
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+// RouteOption restricts requests which are served by the route.
+type RouteOption interface {
+	applyRoute(*route)
+}
+
+// MethodsOpt restricts the route to methods.
+type MethodsOpt struct {
+	methods []codes.Code
+}
+
+func (o MethodsOpt) applyRoute(r *route) {
+	r.methods = append(r.methods, o.methods...)
+}
+
+// WithMethods restricts the route to methods. Requests with other methods are answered
+// by 4.05 Method Not Allowed when no other route of the pattern accepts them.
+func WithMethods(methods ...codes.Code) MethodsOpt {
+	return MethodsOpt{methods: methods}
+}
+
+// HostOpt restricts the route to a virtual host.
+type HostOpt struct {
+	host string
+}
+
+func (o HostOpt) applyRoute(r *route) {
+	r.host = o.host
+}
+
+// WithHost restricts the route to requests whose Uri-Host is host (case insensitive).
+func WithHost(host string) HostOpt {
+	return HostOpt{host: host}
+}
+
+// QueryOpt restricts the route to requests with the query.
+type QueryOpt struct {
+	query string
+}
+
+func (o QueryOpt) applyRoute(r *route) {
+	r.queries = append(r.queries, parseQueryMatcher(o.query))
+}
+
+// WithQuery restricts the route to requests which contain the query. The query is a key (eg. "on"),
+// a key and value (eg. "if=sensor") or a key and parameter (eg. "page={page}") whose value is
+// available in Message.RouteParams.
+func WithQuery(query string) QueryOpt {
+	return QueryOpt{query: query}
+}
+
+// AttributesOpt sets attributes of the resource.
+type AttributesOpt struct {
+	attrs []message.LinkAttribute
+}
+
+func (o AttributesOpt) applyRoute(r *route) {
+	r.attrs = append(r.attrs, o.attrs...)
+}
+
+// WithAttributes sets attributes which describe the resource in /.well-known/core.
+func WithAttributes(attrs ...message.LinkAttribute) AttributesOpt {
+	return AttributesOpt{attrs: attrs}
+}
+
+type queryMatcher struct {
+	key   string
+	value string
+	param string
+}
+
+func parseQueryMatcher(query string) queryMatcher {
+	idx := strings.IndexByte(query, '=')
+	if idx < 0 {
+		return queryMatcher{key: query}
+	}
+	q := queryMatcher{key: query[:idx], value: query[idx+1:]}
+	if name, ok := paramName(q.value); ok {
+		q.param = name
+		q.value = ""
+	}
+	return q
+}
+
+func (q queryMatcher) String() string {
+	switch {
+	case q.param != "":
+		return q.key + "={" + q.param + "}"
+	case q.value != "":
+		return q.key + "=" + q.value
+	}
+	return q.key
+}
+
+// match returns value of the query when it is matched.
+func (q queryMatcher) match(queries []string) (string, bool) {
+	for _, v := range queries {
+		key, value := v, ""
+		if idx := strings.IndexByte(v, '='); idx >= 0 {
+			key, value = v[:idx], v[idx+1:]
+		}
+		if key != q.key {
+			continue
+		}
+		if q.param == "" && q.value != "" && q.value != value {
+			continue
+		}
+		return value, true
+	}
+	return "", false
+}
+
+type route struct {
+	h       Handler
+	methods []codes.Code
+	host    string
+	queries []queryMatcher
+	attrs   []message.LinkAttribute
+}
+
+// key identifies routes with the same matchers.
+func (r *route) key() string {
+	methods := make([]string, 0, len(r.methods))
+	for _, m := range r.methods {
+		methods = append(methods, m.String())
+	}
+	sort.Strings(methods)
+	queries := make([]string, 0, len(r.queries))
+	for _, q := range r.queries {
+		queries = append(queries, q.String())
+	}
+	sort.Strings(queries)
+	return strings.Join(methods, ",") + "|" + strings.ToLower(r.host) + "|" + strings.Join(queries, "&")
+}
+
+func (r *route) allowMethod(code codes.Code) bool {
+	if len(r.methods) == 0 {
+		return true
+	}
+	for _, m := range r.methods {
+		if m == code {
+			return true
+		}
+	}
+	return false
+}
+
+// accept checks host and queries of the request and returns the score of the route and query parameters.
+func (r *route) accept(host string, queries []string) (int, map[string]string, bool) {
+	score := 0
+	if r.host != "" {
+		if !strings.EqualFold(r.host, host) {
+			return 0, nil, false
+		}
+		score += 100
+	}
+	var params map[string]string
+	for _, q := range r.queries {
+		value, ok := q.match(queries)
+		if !ok {
+			return 0, nil, false
+		}
+		if q.param != "" {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[q.param] = value
+		}
+		score += 10
+	}
+	if len(r.methods) > 0 {
+		score++
+	}
+	return score, params, true
+}
+
+type muxEntry struct {
+	pattern  string
+	segments []string
+	isPrefix bool
+	routes   []*route
+	// mount is the router mounted to the pattern.
+	mount *Router
+}
+
+// match selects the best route for the request. When a route accepts the request
+// but doesn't allow the method, methodNotAllowed is set.
+func (e *muxEntry) match(code codes.Code, host string, queries []string) (r *route, params map[string]string, methodNotAllowed bool) {
+	best := -1
+	for _, rt := range e.routes {
+		score, p, ok := rt.accept(host, queries)
+		if !ok {
+			continue
+		}
+		if !rt.allowMethod(code) {
+			methodNotAllowed = true
+			continue
+		}
+		if score > best {
+			best = score
+			r = rt
+			params = p
+		}
+	}
+	return r, params, methodNotAllowed
+}
+
+// pathParams returns values of the pattern parameters.
+func (e *muxEntry) pathParams(segments []string, params map[string]string) map[string]string {
+	for i, s := range e.segments {
+		name, ok := paramName(s)
+		if !ok || i >= len(segments) {
+			continue
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = segments[i]
+	}
+	return params
+}
+
+// routeNode is a node of the tree of path segments.
+type routeNode struct {
+	children map[string]*routeNode
+	param    *routeNode
+	// exact is the entry of the pattern which ends at the node.
+	exact *muxEntry
+	// prefix is the entry of the pattern with trailing slash which ends at the node.
+	prefix *muxEntry
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{children: make(map[string]*routeNode)}
+}
+
+// walk visits entries which match the segments, the most specific first, until visit returns true.
+func (n *routeNode) walk(segments []string, visit func(e *muxEntry) bool) bool {
+	if len(segments) == 0 {
+		return n.exact != nil && visit(n.exact)
+	}
+	if c, ok := n.children[segments[0]]; ok && c.walk(segments[1:], visit) {
+		return true
+	}
+	if n.param != nil && segments[0] != "" && n.param.walk(segments[1:], visit) {
+		return true
+	}
+	return n.prefix != nil && visit(n.prefix)
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) < 3 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+		return "", false
+	}
+	return segment[1 : len(segment)-1], true
+}
+
+// normalizePattern strips leading slash of the pattern.
+func normalizePattern(pattern string) string {
+	switch pattern {
+	case "", "/":
+		return "/"
+	}
+	if pattern[0] == '/' {
+		return pattern[1:]
+	}
+	return pattern
+}
+
+// splitPattern returns segments of the normalized pattern and whether it matches a prefix.
+func splitPattern(pattern string) ([]string, bool, error) {
+	if pattern == "/" {
+		return nil, false, nil
+	}
+	isPrefix := strings.HasSuffix(pattern, "/")
+	segments := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	names := make(map[string]bool)
+	for _, s := range segments {
+		if !strings.ContainsAny(s, "{}") {
+			continue
+		}
+		name, ok := paramName(s)
+		if !ok || strings.ContainsAny(name, "{}") {
+			return nil, false, fmt.Errorf("invalid parameter %v in pattern %v", s, pattern)
+		}
+		if names[name] {
+			return nil, false, fmt.Errorf("duplicate parameter %v in pattern %v", name, pattern)
+		}
+		names[name] = true
+	}
+	return segments, isPrefix, nil
+}
+
+// splitPath returns segments of the request path.
+func splitPath(path string) []string {
+	switch path {
+	case "", "/":
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
@@ -2,6 +2,7 @@ package mux
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 
@@ -33,23 +34,25 @@ func (f HandlerFunc) ServeCOAP(w ResponseWriter, r *Message) {
 // path name of each incoming request against a list of
 // registered patterns add calls the handler for the pattern
 // with same name.
+//
+// A pattern segment in braces, eg. /devices/{id}, matches any segment and its value
+// is available in Message.RouteParams. A pattern with trailing slash matches
+// all paths with the prefix. Routes of the same pattern can be restricted
+// to methods, virtual hosts and queries by RouteOption.
 // Router is also safe for concurrent access from multiple goroutines.
 type Router struct {
-	z              map[string]muxEntry
+	z              map[string]*muxEntry
+	root           *routeNode
 	m              *sync.RWMutex
 	defaultHandler Handler
 	middlewares    []MiddlewareFunc
 }
 
-type muxEntry struct {
-	h       Handler
-	pattern string
-}
-
 // NewRouter allocates and returns a new Router.
 func NewRouter() *Router {
 	return &Router{
-		z:           make(map[string]muxEntry),
+		z:           make(map[string]*muxEntry),
+		root:        newRouteNode(),
 		m:           new(sync.RWMutex),
 		middlewares: make([]MiddlewareFunc, 0, 2),
 		defaultHandler: HandlerFunc(func(w ResponseWriter, r *Message) {
@@ -58,64 +61,137 @@ func NewRouter() *Router {
 	}
 }
 
-// Does path match pattern?
-func pathMatch(pattern, path string) bool {
-	switch pattern {
-	case "", "/":
-		switch path {
-		case "", "/":
+var methodNotAllowedHandler = HandlerFunc(func(w ResponseWriter, r *Message) {
+	w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+})
+
+// Find a handler for the request. Literal segments take precedence over parameters
+// and the most-specific (longest) pattern wins.
+func (r *Router) match(path string, req *Message) (h Handler, params map[string]string) {
+	segments := splitPath(path)
+	host, _ := req.Options.GetString(message.URIHost)
+	queries, _ := req.Options.Queries()
+	r.m.RLock()
+	defer r.m.RUnlock()
+	r.root.walk(segments, func(e *muxEntry) bool {
+		rt, p, methodNotAllowed := e.match(req.Code, host, queries)
+		if rt != nil {
+			h = rt.h
+			params = e.pathParams(segments, p)
 			return true
 		}
-		return false
-	default:
-		n := len(pattern)
-		if pattern[n-1] != '/' {
-			return pattern == path
+		if methodNotAllowed {
+			h = methodNotAllowedHandler
+			return true
 		}
-		return len(path) >= n && path[0:n] == pattern
-	}
+		return false
+	})
+	return
 }
 
-// Find a handler on a handler map given a path string
-// Most-specific (longest) pattern wins
-func (r *Router) match(path string) (h Handler, pattern string) {
-	r.m.RLock()
-	defer r.m.RUnlock()
-	var n = 0
-	for k, v := range r.z {
-		if !pathMatch(k, path) {
-			continue
+// node returns the node of segments, it creates missing nodes when create is set.
+func (r *Router) node(segments []string, create bool) *routeNode {
+	n := r.root
+	for _, s := range segments {
+		var next *routeNode
+		if _, ok := paramName(s); ok {
+			next = n.param
+			if next == nil && create {
+				next = newRouteNode()
+				n.param = next
+			}
+		} else {
+			next = n.children[s]
+			if next == nil && create {
+				next = newRouteNode()
+				n.children[s] = next
+			}
 		}
-		if h == nil || len(k) > n {
-			n = len(k)
-			h = v.h
-			pattern = v.pattern
+		if next == nil {
+			return nil
 		}
+		n = next
 	}
-	return
+	return n
 }
 
 // Handle adds a handler to the Router for pattern.
-func (r *Router) Handle(pattern string, handler Handler) error {
-	switch pattern {
-	case "", "/":
-		pattern = "/"
-	default:
-		if pattern[0] == '/' {
-			pattern = pattern[1:]
-		}
-	}
+// The attributes describe the resource in /.well-known/core.
+func (r *Router) Handle(pattern string, handler Handler, attrs ...message.LinkAttribute) error {
+	return r.HandleRoute(pattern, handler, WithAttributes(attrs...))
+}
 
+// HandleRoute adds a handler to the Router for pattern restricted by opts. A route with the same
+// pattern and restrictions is replaced.
+func (r *Router) HandleRoute(pattern string, handler Handler, opts ...RouteOption) error {
 	if handler == nil {
 		return errors.New("nil handler")
 	}
+	pattern = normalizePattern(pattern)
+	segments, isPrefix, err := splitPattern(pattern)
+	if err != nil {
+		return err
+	}
+	rt := &route{h: handler}
+	for _, o := range opts {
+		o.applyRoute(rt)
+	}
 
 	r.m.Lock()
-	r.z[pattern] = muxEntry{h: handler, pattern: pattern}
-	r.m.Unlock()
+	defer r.m.Unlock()
+	e, err := r.entry(pattern, segments, isPrefix)
+	if err != nil {
+		return err
+	}
+	if e.mount != nil {
+		return fmt.Errorf("pattern %v is mounted", pattern)
+	}
+	key := rt.key()
+	replaced := false
+	for i, v := range e.routes {
+		if v.key() == key {
+			e.routes[i] = rt
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		e.routes = append(e.routes, rt)
+	}
 	return nil
 }
 
+// entry returns the entry of pattern, a missing entry is registered. The caller must hold the lock.
+func (r *Router) entry(pattern string, segments []string, isPrefix bool) (*muxEntry, error) {
+	n := r.node(segments, true)
+	slot := &n.exact
+	if isPrefix {
+		slot = &n.prefix
+	}
+	e := *slot
+	if e == nil {
+		e = &muxEntry{pattern: pattern, segments: segments, isPrefix: isPrefix}
+		*slot = e
+		r.z[pattern] = e
+	} else if e.pattern != pattern {
+		return nil, fmt.Errorf("pattern %v conflicts with %v", pattern, e.pattern)
+	}
+	return e, nil
+}
+
+// removeEntry deregistrars the entry. The caller must hold the lock.
+func (r *Router) removeEntry(e *muxEntry) {
+	delete(r.z, e.pattern)
+	if n := r.node(e.segments, false); n != nil {
+		if n.exact == e {
+			n.exact = nil
+		}
+		if n.prefix == e {
+			n.prefix = nil
+		}
+	}
+}
+
 // DefaultHandle set default handler to the Router
 func (r *Router) DefaultHandle(handler Handler) {
 	r.m.Lock()
@@ -124,8 +200,8 @@ func (r *Router) DefaultHandle(handler Handler) {
 }
 
 // HandleFunc adds a handler function to the Router for pattern.
-func (r *Router) HandleFunc(pattern string, handler func(w ResponseWriter, r *Message)) {
-	r.Handle(pattern, HandlerFunc(handler))
+func (r *Router) HandleFunc(pattern string, handler func(w ResponseWriter, r *Message), attrs ...message.LinkAttribute) {
+	r.Handle(pattern, HandlerFunc(handler), attrs...)
 }
 
 // DefaultHandleFunc set a default handler function to the Router.
@@ -133,19 +209,25 @@ func (r *Router) DefaultHandleFunc(handler func(w ResponseWriter, r *Message)) {
 	r.DefaultHandle(HandlerFunc(handler))
 }
 
-// HandleRemove deregistrars the handler specific for pattern from the Router.
+// HandleRemove deregistrars all handlers specific for pattern from the Router.
 func (r *Router) HandleRemove(pattern string) error {
-	switch pattern {
-	case "", "/":
-		pattern = "/"
-	}
+	pattern = normalizePattern(pattern)
 	r.m.Lock()
 	defer r.m.Unlock()
-	if _, ok := r.z[pattern]; ok {
-		delete(r.z, pattern)
-		return nil
+	e, ok := r.z[pattern]
+	if !ok {
+		return errors.New("pattern is not registered in")
+	}
+	r.removeEntry(e)
+	if e.mount != nil {
+		// mounted router is registered by the prefix and by the path of the prefix
+		for _, m := range r.z {
+			if m.mount == e.mount {
+				r.removeEntry(m)
+			}
+		}
 	}
-	return errors.New("pattern is not registered in")
+	return nil
 }
 
 // ServeCOAP dispatches the request to the handler whose
@@ -159,13 +241,26 @@ func (r *Router) ServeCOAP(w ResponseWriter, req *Message) {
 		r.defaultHandler.ServeCOAP(w, req)
 		return
 	}
-	h, _ := r.match(path)
+	h, params := r.match(path, req)
+	if h == nil && path == wellKnownCorePath {
+		h = HandlerFunc(r.serveWellKnownCore)
+	}
 	if h == nil {
 		h = r.defaultHandler
 	}
 	if h == nil {
 		return
 	}
+	if len(params) > 0 {
+		for k, v := range req.RouteParams {
+			if _, ok := params[k]; !ok {
+				params[k] = v
+			}
+		}
+		routed := *req
+		routed.RouteParams = params
+		req = &routed
+	}
 	for i := len(r.middlewares) - 1; i >= 0; i-- {
 		h = r.middlewares[i].Middleware(h)
 	}
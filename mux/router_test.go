@@ -0,0 +1,119 @@
+package mux
+
+import (
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHandler(name string, got *string, params *map[string]string) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Message) {
+		*got = name
+		*params = r.RouteParams
+		w.SetResponse(codes.Content, message.TextPlain, nil)
+	})
+}
+
+func TestRouter_Match(t *testing.T) {
+	var got string
+	var params map[string]string
+	r := NewRouter()
+	require.NoError(t, r.Handle("/", newTestHandler("root", &got, &params)))
+	require.NoError(t, r.Handle("/a", newTestHandler("a", &got, &params)))
+	require.NoError(t, r.Handle("/a/", newTestHandler("a/", &got, &params)))
+	require.NoError(t, r.Handle("/a/b", newTestHandler("a/b", &got, &params)))
+	require.NoError(t, r.Handle("/a/b/", newTestHandler("a/b/", &got, &params)))
+	require.NoError(t, r.Handle("/devices/{id}", newTestHandler("device", &got, &params)))
+	require.NoError(t, r.Handle("/devices/{id}/sensors/{name}", newTestHandler("sensor", &got, &params)))
+	require.NoError(t, r.Handle("/devices/main/sensors/{name}", newTestHandler("main", &got, &params)))
+	require.Error(t, r.Handle("/devices/{device}", newTestHandler("device", &got, &params)))
+	require.Error(t, r.Handle("/x/{a}/{a}", newTestHandler("x", &got, &params)))
+	require.Error(t, r.Handle("/x/{a", newTestHandler("x", &got, &params)))
+
+	tests := []struct {
+		path       string
+		want       string
+		wantParams map[string]string
+	}{
+		{path: "a", want: "a"},
+		{path: "a/c", want: "a/"},
+		{path: "a/b", want: "a/b"},
+		{path: "a/b/c/d", want: "a/b/"},
+		{path: "devices/7", want: "device", wantParams: map[string]string{"id": "7"}},
+		{path: "devices/7/sensors/temp", want: "sensor", wantParams: map[string]string{"id": "7", "name": "temp"}},
+		{path: "devices/main/sensors/temp", want: "main", wantParams: map[string]string{"name": "temp"}},
+		{path: "devices/7/sensors", want: ""},
+		{path: "b", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, params = "", nil
+			w := &testResponseWriter{}
+			r.ServeCOAP(w, newTestRequest(t, codes.GET, tt.path))
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantParams, params)
+			if tt.want == "" {
+				require.Equal(t, codes.NotFound, w.code)
+			}
+		})
+	}
+
+	require.NoError(t, r.HandleRemove("/devices/{id}"))
+	got = ""
+	r.ServeCOAP(&testResponseWriter{}, newTestRequest(t, codes.GET, "devices/7"))
+	require.Equal(t, "", got)
+}
+
+func TestRouter_HandleRoute(t *testing.T) {
+	var got string
+	var params map[string]string
+	r := NewRouter()
+	require.NoError(t, r.HandleRoute("/light", newTestHandler("get", &got, &params), WithMethods(codes.GET)))
+	require.NoError(t, r.HandleRoute("/light", newTestHandler("put", &got, &params), WithMethods(codes.PUT, codes.POST)))
+	require.NoError(t, r.HandleRoute("/light", newTestHandler("host", &got, &params), WithMethods(codes.GET), WithHost("kitchen.example")))
+	require.NoError(t, r.HandleRoute("/light", newTestHandler("on", &got, &params), WithMethods(codes.POST), WithQuery("state=on")))
+	require.NoError(t, r.HandleRoute("/items", newTestHandler("page", &got, &params), WithQuery("page={page}")))
+	require.NoError(t, r.HandleRoute("/items", newTestHandler("items", &got, &params)))
+
+	tests := []struct {
+		name       string
+		code       codes.Code
+		path       string
+		host       string
+		queries    []string
+		want       string
+		wantCode   codes.Code
+		wantParams map[string]string
+	}{
+		{name: "get", code: codes.GET, path: "light", want: "get", wantCode: codes.Content},
+		{name: "put", code: codes.PUT, path: "light", want: "put", wantCode: codes.Content},
+		{name: "delete", code: codes.DELETE, path: "light", wantCode: codes.MethodNotAllowed},
+		{name: "host", code: codes.GET, path: "light", host: "Kitchen.example", want: "host", wantCode: codes.Content},
+		{name: "other-host", code: codes.GET, path: "light", host: "garage.example", want: "get", wantCode: codes.Content},
+		{name: "query", code: codes.POST, path: "light", queries: []string{"state=on"}, want: "on", wantCode: codes.Content},
+		{name: "other-query", code: codes.POST, path: "light", queries: []string{"state=off"}, want: "put", wantCode: codes.Content},
+		{name: "query-param", code: codes.GET, path: "items", queries: []string{"page=2"}, want: "page", wantCode: codes.Content, wantParams: map[string]string{"page": "2"}},
+		{name: "no-query", code: codes.GET, path: "items", want: "items", wantCode: codes.Content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, params = "", nil
+			req := newTestRequest(t, tt.code, tt.path, tt.queries...)
+			if tt.host != "" {
+				req.Options = req.Options.Add(message.Option{ID: message.URIHost, Value: []byte(tt.host)})
+			}
+			w := &testResponseWriter{}
+			r.ServeCOAP(w, req)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantCode, w.code)
+			require.Equal(t, tt.wantParams, params)
+		})
+	}
+
+	// route with the same restrictions is replaced
+	require.NoError(t, r.HandleRoute("/light", newTestHandler("get2", &got, &params), WithMethods(codes.GET)))
+	r.ServeCOAP(&testResponseWriter{}, newTestRequest(t, codes.GET, "light"))
+	require.Equal(t, "get2", got)
+}
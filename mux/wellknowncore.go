@@ -0,0 +1,97 @@
+package mux
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+const wellKnownCorePath = ".well-known/core"
+
+// ResourceType creates rt attribute of the resource.
+func ResourceType(rt ...string) message.LinkAttribute {
+	return message.LinkAttribute{Name: message.LinkAttrResourceType, Value: strings.Join(rt, " ")}
+}
+
+// Interface creates if attribute of the resource.
+func Interface(ifs ...string) message.LinkAttribute {
+	return message.LinkAttribute{Name: message.LinkAttrInterface, Value: strings.Join(ifs, " ")}
+}
+
+// ContentFormat creates ct attribute of the resource.
+func ContentFormat(cf ...message.MediaType) message.LinkAttribute {
+	values := make([]string, 0, len(cf))
+	for _, c := range cf {
+		values = append(values, strconv.Itoa(int(c)))
+	}
+	return message.LinkAttribute{Name: message.LinkAttrContentFormat, Value: strings.Join(values, " ")}
+}
+
+// Size creates sz attribute of the resource.
+func Size(size uint32) message.LinkAttribute {
+	return message.LinkAttribute{Name: message.LinkAttrSize, Value: strconv.FormatUint(uint64(size), 10)}
+}
+
+// Observable creates obs attribute of the resource.
+func Observable() message.LinkAttribute {
+	return message.LinkAttribute{Name: message.LinkAttrObservable}
+}
+
+// Title creates title attribute of the resource.
+func Title(title string) message.LinkAttribute {
+	return message.LinkAttribute{Name: message.LinkAttrTitle, Value: title}
+}
+
+// Links returns links of the registered resources and of the mounted routers sorted by path. The links are filtered
+// by the queries, eg. rt=temperature or href=/sensors*.
+func (r *Router) Links(queries ...string) message.Links {
+	routes := r.Routes()
+	links := make(message.Links, 0, len(routes))
+	for _, rt := range routes {
+		if rt.Pattern == "/"+wellKnownCorePath || strings.Contains(rt.Pattern, "{") {
+			continue
+		}
+		if n := len(links); n > 0 && links[n-1].Target == rt.Pattern {
+			if len(rt.Attributes) > 0 {
+				links[n-1].Attributes = rt.Attributes
+			}
+			continue
+		}
+		links = append(links, message.Link{
+			Target:     rt.Pattern,
+			Attributes: rt.Attributes,
+		})
+	}
+
+	filtered := links[:0]
+	for _, l := range links {
+		match := true
+		for _, q := range queries {
+			if !l.Match(q) {
+				match = false
+				break
+			}
+		}
+		if match {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered
+}
+
+// serveWellKnownCore serves resource discovery: https://tools.ietf.org/html/rfc6690#section-4
+func (r *Router) serveWellKnownCore(w ResponseWriter, req *Message) {
+	if req.Code != codes.GET {
+		w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+		return
+	}
+	queries, err := req.Options.Queries()
+	if err != nil && err != message.ErrOptionNotFound {
+		w.SetResponse(codes.BadOption, message.TextPlain, nil)
+		return
+	}
+	w.SetResponse(codes.Content, message.AppLinkFormat, bytes.NewReader(r.Links(queries...).Marshal()))
+}
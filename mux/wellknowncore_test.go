@@ -0,0 +1,101 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+type testResponseWriter struct {
+	code          codes.Code
+	contentFormat message.MediaType
+	body          []byte
+}
+
+func (w *testResponseWriter) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	w.code = code
+	w.contentFormat = contentFormat
+	if d != nil {
+		body, err := ioutil.ReadAll(d)
+		if err != nil {
+			return err
+		}
+		w.body = body
+	}
+	return nil
+}
+
+func (w *testResponseWriter) Client() Client {
+	return nil
+}
+
+func newTestRequest(t *testing.T, code codes.Code, path string, queries ...string) *Message {
+	opts := make(message.Options, 0, 8)
+	opts, _, err := opts.SetPath(make([]byte, 64), path)
+	require.NoError(t, err)
+	for _, q := range queries {
+		opts = opts.Add(message.Option{ID: message.URIQuery, Value: []byte(q)})
+	}
+	return &Message{
+		Message: &message.Message{
+			Context: context.Background(),
+			Code:    code,
+			Options: opts,
+		},
+	}
+}
+
+func TestRouter_WellKnownCore(t *testing.T) {
+	r := NewRouter()
+	h := HandlerFunc(func(w ResponseWriter, r *Message) {})
+	err := r.Handle("/sensors/temp", h, ResourceType("temperature-c"), Interface("sensor"), ContentFormat(message.TextPlain, message.AppJSON), Observable())
+	require.NoError(t, err)
+	err = r.Handle("/sensors/light", h, ResourceType("light-lux"), Title("Light"), Size(100))
+	require.NoError(t, err)
+	err = r.Handle("/config", h)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		queries []string
+		want    string
+	}{
+		{
+			name: "all",
+			want: `</config>,</sensors/light>;rt="light-lux";title="Light";sz=100,</sensors/temp>;rt="temperature-c";if="sensor";ct="0 50";obs`,
+		},
+		{
+			name:    "rt",
+			queries: []string{"rt=temperature-c"},
+			want:    `</sensors/temp>;rt="temperature-c";if="sensor";ct="0 50";obs`,
+		},
+		{
+			name:    "href",
+			queries: []string{"href=/sensors*"},
+			want:    `</sensors/light>;rt="light-lux";title="Light";sz=100,</sensors/temp>;rt="temperature-c";if="sensor";ct="0 50";obs`,
+		},
+		{
+			name:    "no match",
+			queries: []string{"rt=unknown"},
+			want:    ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &testResponseWriter{}
+			r.ServeCOAP(w, newTestRequest(t, codes.GET, "/.well-known/core", tt.queries...))
+			require.Equal(t, codes.Content, w.code)
+			require.Equal(t, message.AppLinkFormat, w.contentFormat)
+			require.Equal(t, tt.want, string(w.body))
+		})
+	}
+
+	w := &testResponseWriter{}
+	r.ServeCOAP(w, newTestRequest(t, codes.POST, "/.well-known/core"))
+	require.Equal(t, codes.MethodNotAllowed, w.code)
+}
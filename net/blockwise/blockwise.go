@@ -3,6 +3,7 @@ package blockwise
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -11,9 +12,10 @@ import (
 	"golang.org/x/sync/semaphore"
 
 	"github.com/dsnet/golib/memfile"
-	"github.com/patrickmn/go-cache"
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/net/cache"
 	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
 )
 
@@ -177,6 +179,12 @@ type BlockWise struct {
 	getSendedRequestFromOutside func(token message.Token) (Message, bool)
 
 	bwSendedRequest *senderRequestMap
+
+	qBlock          *qBlockConfig
+	qBlockPeer      uint32
+	qBlockReceivers *cache.Cache
+
+	clock clock.Clock
 }
 
 type messageGuard struct {
@@ -201,7 +209,21 @@ func NewBlockWise(
 	autoCleanUpResponseCache bool,
 	getSendedRequestFromOutside func(token message.Token) (Message, bool),
 ) *BlockWise {
-	receivingMessagesCache := cache.New(expiration, expiration)
+	return NewBlockWiseWithClock(acquireMessage, releaseMessage, expiration, errors, autoCleanUpResponseCache, getSendedRequestFromOutside, nil)
+}
+
+// NewBlockWiseWithClock provides blockwise whose expiration of transfers is driven by the clock, nil means the clock of the system.
+func NewBlockWiseWithClock(
+	acquireMessage func(ctx context.Context) Message,
+	releaseMessage func(Message),
+	expiration time.Duration,
+	errors func(error),
+	autoCleanUpResponseCache bool,
+	getSendedRequestFromOutside func(token message.Token) (Message, bool),
+	clk clock.Clock,
+) *BlockWise {
+	clk = clock.Get(clk)
+	receivingMessagesCache := cache.New(expiration, expiration, clk)
 	bwSendedRequest := newSenderRequestMap()
 	receivingMessagesCache.OnEvicted(func(tokenstr string, v interface{}) {
 		if v == nil {
@@ -216,11 +238,13 @@ func NewBlockWise(
 		acquireMessage:              acquireMessage,
 		releaseMessage:              releaseMessage,
 		receivingMessagesCache:      receivingMessagesCache,
-		sendingMessagesCache:        cache.New(expiration, expiration),
+		sendingMessagesCache:        cache.New(expiration, expiration, clk),
 		errors:                      errors,
 		autoCleanUpResponseCache:    autoCleanUpResponseCache,
 		getSendedRequestFromOutside: getSendedRequestFromOutside,
 		bwSendedRequest:             bwSendedRequest,
+		qBlockReceivers:             cache.New(expiration, expiration, clk),
+		clock:                       clk,
 	}
 }
 
@@ -248,6 +272,9 @@ func (b *BlockWise) Do(r Message, maxSzx SZX, maxMessageSize int, do func(req Me
 	}
 	defer b.bwSendedRequest.deleteByToken(req.Token().String())
 	if r.Body() == nil {
+		if r.Code() == codes.GET && !r.Options().HasOption(message.Observe) && b.useQBlock(maxSzx) {
+			return b.doQBlock2(r, maxSzx, do)
+		}
 		return do(r)
 	}
 	payloadSize, err := r.BodySize()
@@ -259,11 +286,25 @@ func (b *BlockWise) Do(r Message, maxSzx SZX, maxMessageSize int, do func(req Me
 	}
 
 	switch r.Code() {
-	case codes.POST, codes.PUT:
+	case codes.POST, codes.PUT, codes.FETCH, codes.PATCH, codes.IPATCH:
 		break
 	default:
 		return nil, fmt.Errorf("unsupported command(%v)", r.Code())
 	}
+	if b.useQBlock(maxSzx) {
+		resp, err := b.doQBlock1(r, payloadSize, maxSzx, do)
+		if !errors.Is(err, errQBlockUnsupported) {
+			return resp, err
+		}
+	}
+	if !req.Options().HasOption(message.RequestTag) {
+		// Request-Tag distinguishes the blocks from blocks of other bodies sent to the same resource (RFC 9175)
+		tag, err := message.GetToken()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get request tag: %w", err)
+		}
+		req.SetOptionBytes(message.RequestTag, tag)
+	}
 	req.SetOptionUint32(message.Size1, uint32(payloadSize))
 
 	num := int64(0)
@@ -347,6 +388,7 @@ func NewWriteRequestResponse(remoteAddr net.Addr, request Message, acquireMessag
 	req.SetToken(request.Token())
 	req.ResetOptionsTo(request.Options())
 	req.SetBody(request.Body())
+	setTypeFrom(req, request)
 	return &writeMessageResponse{
 		request:        req,
 		releaseMessage: releaseMessage,
@@ -404,7 +446,7 @@ func (b *BlockWise) handleSendingMessage(w ResponseWriter, sendingMessage Messag
 	blockType := message.Block2
 	sizeType := message.Size2
 	switch sendingMessage.Code() {
-	case codes.POST, codes.PUT:
+	case codes.POST, codes.PUT, codes.FETCH, codes.PATCH, codes.IPATCH:
 		blockType = message.Block1
 		sizeType = message.Size1
 	}
@@ -421,6 +463,8 @@ func (b *BlockWise) handleSendingMessage(w ResponseWriter, sendingMessage Messag
 	sendMessage.SetCode(sendingMessage.Code())
 	sendMessage.ResetOptionsTo(sendingMessage.Options())
 	sendMessage.SetToken(token)
+	// blocks are sent with the type of the whole message, eg. confirmable notification
+	setTypeFrom(sendMessage, sendingMessage)
 	payloadSize, err := sendingMessage.BodySize()
 	if err != nil {
 		return false, fmt.Errorf("cannot get size of payload: %w", err)
@@ -463,6 +507,11 @@ func (b *BlockWise) handleSendingMessage(w ResponseWriter, sendingMessage Messag
 	return more, nil
 }
 
+// Transfers returns number of blockwise transfers which are in progress or kept in the cache.
+func (b *BlockWise) Transfers() int {
+	return b.sendingMessagesCache.ItemCount() + b.receivingMessagesCache.ItemCount()
+}
+
 // RemoveFromResponseCache removes response from cache. It need's tu be used for udp coap.
 func (b *BlockWise) RemoveFromResponseCache(token message.Token) {
 	if len(token) == 0 {
@@ -485,6 +534,13 @@ func (b *BlockWise) Handle(w ResponseWriter, r Message, maxSZX SZX, maxMessageSi
 	}
 	token := r.Token()
 
+	if ok, err := b.handleQBlock(w, r, maxSZX, next); ok {
+		if err != nil {
+			b.errors(fmt.Errorf("handleQBlock(%v): %w", r, err))
+		}
+		return
+	}
+
 	if len(token) == 0 {
 		err := b.handleReceivedMessage(w, r, maxSZX, maxMessageSize, next)
 		if err != nil {
@@ -528,6 +584,13 @@ func (b *BlockWise) handleReceivedMessage(w ResponseWriter, r Message, maxSZX SZ
 		next(w, r)
 		return nil
 	case codes.GET, codes.DELETE:
+		if r.Options().HasOption(message.QBlock2) && b.qBlock != nil && !r.Options().HasOption(message.Observe) {
+			maxSZX = fitSZX(r, message.QBlock2, maxSZX)
+			r.Remove(message.QBlock2)
+			next(w, r)
+			return b.startSendingQBlock2(w, maxSZX)
+		}
+		r.Remove(message.QBlock2)
 		maxSZX = fitSZX(r, message.Block2, maxSZX)
 		block, err := r.GetOptionUint32(message.Block2)
 		if err == nil {
@@ -537,7 +600,7 @@ func (b *BlockWise) handleReceivedMessage(w ResponseWriter, r Message, maxSZX SZ
 		if w.Message().Code() == codes.Content && err == nil {
 			startSendingMessageBlock = block
 		}
-	case codes.POST, codes.PUT:
+	case codes.POST, codes.PUT, codes.FETCH, codes.PATCH, codes.IPATCH:
 		maxSZX = fitSZX(r, message.Block1, maxSZX)
 		err := b.processReceivedMessage(w, r, maxSZX, next, message.Block1, message.Size1)
 		if err != nil {
@@ -563,7 +626,7 @@ func (b *BlockWise) continueSendingMessage(w ResponseWriter, r Message, maxSZX S
 	resp := messageGuard.Message
 	blockType := message.Block2
 	switch resp.Code() {
-	case codes.POST, codes.PUT:
+	case codes.POST, codes.PUT, codes.FETCH, codes.PATCH, codes.IPATCH:
 		blockType = message.Block1
 	}
 
@@ -620,6 +683,7 @@ func (b *BlockWise) startSendingMessage(w ResponseWriter, maxSZX SZX, maxMessage
 	sendingMessage.SetBody(w.Message().Body())
 	sendingMessage.SetCode(w.Message().Code())
 	sendingMessage.SetToken(w.Message().Token())
+	setTypeFrom(sendingMessage, w.Message())
 
 	_, err = b.handleSendingMessage(w, sendingMessage, maxSZX, maxMessageSize, sendingMessage.Token(), block)
 	if err != nil {
@@ -632,7 +696,7 @@ func (b *BlockWise) startSendingMessage(w ResponseWriter, maxSZX SZX, maxMessage
 	expire := cache.DefaultExpiration
 	deadline, ok := sendingMessage.Context().Deadline()
 	if ok {
-		expire = time.Until(deadline)
+		expire = b.clock.Until(deadline)
 	}
 
 	err = b.sendingMessagesCache.Add(sendingMessage.Token().String(), newRequestGuard(sendingMessage), expire)
@@ -685,7 +749,7 @@ func (b *BlockWise) processReceivedMessage(w ResponseWriter, r Message, maxSzx S
 		defer b.releaseMessage(sendedRequest)
 		deadline, ok := sendedRequest.Context().Deadline()
 		if ok {
-			expire = time.Until(deadline)
+			expire = b.clock.Until(deadline)
 		}
 	}
 	if blockType == message.Block2 && sendedRequest == nil {
@@ -696,6 +760,11 @@ func (b *BlockWise) processReceivedMessage(w ResponseWriter, r Message, maxSzx S
 		if sendedRequest == nil {
 			return fmt.Errorf("observation is not registered")
 		}
+		if !more {
+			// the whole notification was received in one block (eg. BERT)
+			next(w, r)
+			return nil
+		}
 		token, err = message.GetToken()
 		if err != nil {
 			return fmt.Errorf("cannot get token for create GET request: %w", err)
@@ -710,6 +779,12 @@ func (b *BlockWise) processReceivedMessage(w ResponseWriter, r Message, maxSzx S
 	}
 
 	tokenStr := token.String()
+	if blockType == message.Block1 {
+		if tag, err := r.GetOptionBytes(message.RequestTag); err == nil {
+			// blocks of different bodies with the same token are not mixed up
+			tokenStr = fmt.Sprintf("%v:%x", tokenStr, tag)
+		}
+	}
 	cachedReceivedMessageGuard, ok := b.receivingMessagesCache.Get(tokenStr)
 	var msgGuard *messageGuard
 	if !ok || cachedReceivedMessageGuard == nil {
@@ -815,6 +890,7 @@ func (b *BlockWise) processReceivedMessage(w ResponseWriter, r Message, maxSzx S
 			b.receivingMessagesCache.Delete(tokenStr)
 			cachedReceivedMessage.Remove(blockType)
 			cachedReceivedMessage.Remove(sizeType)
+			cachedReceivedMessage.Remove(message.RequestTag)
 			cachedReceivedMessage.SetCode(r.Code())
 			setTypeFrom(cachedReceivedMessage, r)
 			if !bytes.Equal(cachedReceivedMessage.Token(), token) {
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"sync"
 	"testing"
@@ -47,6 +48,7 @@ type testmessage struct {
 	options  message.Options
 	payload  io.ReadSeeker
 	sequence uint64
+	typ      udpMessage.Type
 }
 
 func (r *testmessage) Queries() ([]string, error) {
@@ -182,10 +184,11 @@ func (r *testmessage) BodySize() (int64, error) {
 }
 
 func (r *testmessage) Type() udpMessage.Type {
-	return udpMessage.Confirmable
+	return r.typ
 }
 
 func (r *testmessage) SetType(t udpMessage.Type) {
+	r.typ = t
 }
 
 func acquireMessage(ctx context.Context) Message {
@@ -740,3 +743,68 @@ func TestBlockWise_Writetestmessage(t *testing.T) {
 		})
 	}
 }
+
+func TestBlockWise_WriteMessageKeepsType(t *testing.T) {
+	sender := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	addr, err := net.ResolveTCPAddr("tcp", "localhost:1")
+	require.NoError(t, err)
+	for _, typ := range []udpMessage.Type{udpMessage.Confirmable, udpMessage.NonConfirmable} {
+		req := &testmessage{
+			ctx:     context.Background(),
+			token:   []byte{byte(typ)},
+			options: message.Options{message.Option{ID: message.URIPath, Value: []byte("abc")}},
+			code:    codes.Content,
+			payload: bytes.NewReader(make([]byte, 64)),
+			typ:     typ,
+		}
+		err = sender.WriteMessage(addr, req, SZX16, int(SZX16.Size()), func(block Message) error {
+			require.True(t, block.Options().HasOption(message.Block2))
+			require.Equal(t, typ, block.(*testmessage).Type())
+			return nil
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestBlockWise_RequestTag(t *testing.T) {
+	receiver := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	bodies := map[string][]byte{
+		"a": bytes.Repeat([]byte{'a'}, 32),
+		"b": bytes.Repeat([]byte{'b'}, 32),
+	}
+	received := make(map[string][]byte)
+	handleBlock := func(tag string, num int64, more bool) codes.Code {
+		block, err := EncodeBlockOption(SZX16, num, more)
+		require.NoError(t, err)
+		req := &testmessage{
+			ctx:   context.Background(),
+			token: []byte{1},
+			code:  codes.POST,
+			options: message.Options{
+				message.Option{ID: message.URIPath, Value: []byte("abc")},
+				message.Option{ID: message.RequestTag, Value: []byte(tag)},
+			},
+			payload: bytes.NewReader(bodies[tag][num*16 : (num+1)*16]),
+		}
+		req.SetOptionUint32(message.Block1, block)
+		w := newResponseWriter(acquireMessage(req.Context()))
+		receiver.Handle(w, req, SZX16, int(SZX16.Size()), func(w ResponseWriter, r Message) {
+			require.False(t, r.Options().HasOption(message.RequestTag))
+			body, err := ioutil.ReadAll(r.Body())
+			require.NoError(t, err)
+			received[tag] = body
+			w.SetMessage(&testmessage{
+				ctx:   context.Background(),
+				token: r.Token(),
+				code:  codes.Changed,
+			})
+		})
+		return w.Message().Code()
+	}
+	// blocks of both bodies are interleaved
+	require.Equal(t, codes.Continue, handleBlock("a", 0, true))
+	require.Equal(t, codes.Continue, handleBlock("b", 0, true))
+	require.Equal(t, codes.Changed, handleBlock("a", 1, false))
+	require.Equal(t, codes.Changed, handleBlock("b", 1, false))
+	require.Equal(t, bodies, received)
+}
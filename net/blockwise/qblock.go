@@ -0,0 +1,787 @@
+package blockwise
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/net/cache"
+	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
+)
+
+// Parameters of Q-Block transfers: https://tools.ietf.org/html/rfc9177#section-7.2
+const (
+	// DefaultMaxPayloads is number of blocks sent in a burst without waiting for the peer (MAX_PAYLOADS).
+	DefaultMaxPayloads = 10
+	// DefaultNonReceiveTimeout is how long the receiver waits for missing blocks before it asks for them (NON_RECEIVE_TIMEOUT).
+	DefaultNonReceiveTimeout = 4 * time.Second
+	// qBlockMaxRetransmit is how many times the receiver asks for missing blocks (NON_MAX_RETRANSMIT).
+	qBlockMaxRetransmit = 4
+)
+
+// state of the peer support of Q-Block options
+const (
+	qBlockPeerUnknown uint32 = iota
+	qBlockPeerSupported
+	qBlockPeerUnsupported
+)
+
+var errQBlockUnsupported = errors.New("peer doesn't support Q-Block")
+
+type qBlockConfig struct {
+	maxPayloads       int
+	nonReceiveTimeout time.Duration
+	writeMessage      func(Message) error
+}
+
+// EnableQBlock enables Q-Block1 and Q-Block2 transfers (RFC 9177) which send bursts of non-confirmable blocks
+// without waiting for acknowledgement of each block. The receiver asks for missing blocks, so the transfer
+// survives lossy networks. When the peer doesn't support Q-Block, transfers fall back to Block1 and Block2.
+//
+// writeMessage sends non-confirmable message without waiting for the response.
+func (b *BlockWise) EnableQBlock(maxPayloads int, nonReceiveTimeout time.Duration, writeMessage func(Message) error) {
+	if maxPayloads <= 0 {
+		maxPayloads = DefaultMaxPayloads
+	}
+	if nonReceiveTimeout <= 0 {
+		nonReceiveTimeout = DefaultNonReceiveTimeout
+	}
+	b.qBlock = &qBlockConfig{
+		maxPayloads:       maxPayloads,
+		nonReceiveTimeout: nonReceiveTimeout,
+		writeMessage:      writeMessage,
+	}
+}
+
+func (b *BlockWise) useQBlock(maxSzx SZX) bool {
+	return b.qBlock != nil && maxSzx < SZXBERT && atomic.LoadUint32(&b.qBlockPeer) != qBlockPeerUnsupported
+}
+
+func isRequest(code codes.Code) bool {
+	return code >= codes.GET && code < codes.Created
+}
+
+// encodeMissingBlocks encodes block numbers to application/missing-blocks+cbor-seq: https://tools.ietf.org/html/rfc9177#section-5
+func encodeMissingBlocks(nums []int64) []byte {
+	buf := make([]byte, 0, len(nums)*3)
+	for _, n := range nums {
+		v := uint32(n)
+		switch {
+		case v < 24:
+			buf = append(buf, byte(v))
+		case v <= 0xff:
+			buf = append(buf, 0x18, byte(v))
+		case v <= 0xffff:
+			buf = append(buf, 0x19, byte(v>>8), byte(v))
+		default:
+			buf = append(buf, 0x1a, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	return buf
+}
+
+func decodeMissingBlocks(data []byte) ([]int64, error) {
+	var nums []int64
+	for len(data) > 0 {
+		if data[0]>>5 != 0 {
+			return nil, fmt.Errorf("unexpected CBOR major type %v", data[0]>>5)
+		}
+		info := data[0] & 0x1f
+		var size int
+		switch {
+		case info < 24:
+			nums = append(nums, int64(info))
+			data = data[1:]
+			continue
+		case info == 0x18:
+			size = 1
+		case info == 0x19:
+			size = 2
+		case info == 0x1a:
+			size = 4
+		default:
+			return nil, fmt.Errorf("invalid CBOR unsigned integer")
+		}
+		if len(data) < 1+size {
+			return nil, fmt.Errorf("truncated CBOR unsigned integer")
+		}
+		var v int64
+		for _, c := range data[1 : 1+size] {
+			v = v<<8 | int64(c)
+		}
+		nums = append(nums, v)
+		data = data[1+size:]
+	}
+	return nums, nil
+}
+
+// qBlockRequest is the value of Q-Block2 option in a request. It asks for the block or for the set of blocks
+// starting with the block when more is set.
+type qBlockRequest struct {
+	num  int64
+	more bool
+}
+
+func getQBlockRequests(r Message, szx SZX) ([]qBlockRequest, SZX, error) {
+	var reqs []qBlockRequest
+	for _, o := range r.Options() {
+		if o.ID != message.QBlock2 {
+			continue
+		}
+		v, _, err := message.DecodeUint32(o.Value)
+		if err != nil {
+			return nil, szx, fmt.Errorf("cannot decode %v option: %w", o.ID, err)
+		}
+		blockSzx, num, more, err := DecodeBlockOption(v)
+		if err != nil {
+			return nil, szx, fmt.Errorf("cannot decode %v option: %w", o.ID, err)
+		}
+		if blockSzx < szx {
+			szx = blockSzx
+		}
+		reqs = append(reqs, qBlockRequest{num: num, more: more})
+	}
+	return reqs, szx, nil
+}
+
+// newBlockMessage creates message with the block num of the body of src.
+func (b *BlockWise) newBlockMessage(src Message, token message.Token, blockType, sizeType message.OptionID, szx SZX, num int64) (Message, bool, error) {
+	payloadSize, err := src.BodySize()
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot get size of payload: %w", err)
+	}
+	off := num * szx.Size()
+	if off > 0 && off >= payloadSize {
+		return nil, false, fmt.Errorf("block %v is out of payload", num)
+	}
+	_, err = src.Body().Seek(off, io.SeekStart)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot seek in payload: %w", err)
+	}
+	buf := make([]byte, szx.Size())
+	readed, err := io.ReadFull(src.Body(), buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, false, fmt.Errorf("cannot read payload: %w", err)
+	}
+	more := off+int64(readed) < payloadSize
+	block, err := EncodeBlockOption(szx, num, more)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot encode block option(%v,%v,%v): %w", szx, num, more, err)
+	}
+	msg := b.acquireMessage(src.Context())
+	msg.SetCode(src.Code())
+	msg.SetToken(token)
+	msg.ResetOptionsTo(src.Options())
+	setTypeFrom(msg, src)
+	msg.SetOptionUint32(blockType, block)
+	msg.SetOptionUint32(sizeType, uint32(payloadSize))
+	msg.SetBody(bytes.NewReader(buf[:readed]))
+	return msg, more, nil
+}
+
+// doQBlock1 sends body of the request by Q-Block1 option: https://tools.ietf.org/html/rfc9177#section-4.4
+//
+// Blocks of a set are sent as non-confirmable messages except the last one which is sent by do and the server
+// responds to it by 2.31 Continue or by 4.08 Request Entity Incomplete with the missing blocks. Sets end with
+// the block whose number+1 is a multiple of maxPayloads, so the server answers the same blocks as the client waits for.
+func (b *BlockWise) doQBlock1(r Message, payloadSize int64, szx SZX, do func(req Message) (Message, error)) (Message, error) {
+	blocks := (payloadSize + szx.Size() - 1) / szx.Size()
+	probe := atomic.LoadUint32(&b.qBlockPeer) == qBlockPeerUnknown
+	var pending []int64
+	next := int64(0)
+	for {
+		if len(pending) == 0 {
+			end := (next/int64(b.qBlock.maxPayloads) + 1) * int64(b.qBlock.maxPayloads)
+			if probe {
+				// the first block checks whether the peer supports Q-Block1
+				end = next + 1
+			}
+			if end > blocks {
+				end = blocks
+			}
+			for n := next; n < end; n++ {
+				pending = append(pending, n)
+			}
+			next = end
+		}
+		for _, num := range pending[:len(pending)-1] {
+			msg, _, err := b.newBlockMessage(r, r.Token(), message.QBlock1, message.Size1, szx, num)
+			if err != nil {
+				return nil, err
+			}
+			err = b.qBlock.writeMessage(msg)
+			b.releaseMessage(msg)
+			if err != nil {
+				return nil, fmt.Errorf("cannot write block %v: %w", num, err)
+			}
+		}
+		last := pending[len(pending)-1]
+		msg, _, err := b.newBlockMessage(r, r.Token(), message.QBlock1, message.Size1, szx, last)
+		if err != nil {
+			return nil, err
+		}
+		pending = nil
+		resp, err := do(msg)
+		b.releaseMessage(msg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot do bw request: %w", err)
+		}
+		switch {
+		case probe && resp.Code() == codes.BadOption:
+			atomic.StoreUint32(&b.qBlockPeer, qBlockPeerUnsupported)
+			b.releaseMessage(resp)
+			return nil, errQBlockUnsupported
+		case resp.Code() == codes.Continue:
+			probe = false
+			atomic.StoreUint32(&b.qBlockPeer, qBlockPeerSupported)
+			num, ok := continuedBlock(resp)
+			b.releaseMessage(resp)
+			if ok && num != last {
+				// the response belongs to a block which was already passed, the block is sent again to get its response
+				pending = []int64{last}
+				continue
+			}
+			if next >= blocks {
+				return nil, fmt.Errorf("unexpected %v after the last block", codes.Continue)
+			}
+		case resp.Code() == codes.RequestEntityIncomplete && resp.Body() != nil:
+			data, err := ioutil.ReadAll(resp.Body())
+			b.releaseMessage(resp)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read missing blocks: %w", err)
+			}
+			missing, err := decodeMissingBlocks(data)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode missing blocks: %w", err)
+			}
+			for _, num := range missing {
+				if num < next {
+					pending = append(pending, num)
+				}
+			}
+			if len(pending) == 0 {
+				return nil, fmt.Errorf("invalid missing blocks %v", missing)
+			}
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// continuedBlock returns number of the block which is confirmed by 2.31 Continue.
+func continuedBlock(resp Message) (int64, bool) {
+	v, err := resp.GetOptionUint32(message.QBlock1)
+	if err != nil {
+		return 0, false
+	}
+	_, num, _, err := DecodeBlockOption(v)
+	if err != nil {
+		return 0, false
+	}
+	return num, true
+}
+
+// doQBlock2 asks the server to send the response body by Q-Block2 option: https://tools.ietf.org/html/rfc9177#section-4.4
+func (b *BlockWise) doQBlock2(r Message, szx SZX, do func(req Message) (Message, error)) (Message, error) {
+	block, err := EncodeBlockOption(szx, 0, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode block option(%v,%v,%v): %w", szx, 0, false, err)
+	}
+	req := b.acquireMessage(r.Context())
+	defer b.releaseMessage(req)
+	req.SetCode(r.Code())
+	req.SetToken(r.Token())
+	req.ResetOptionsTo(r.Options())
+	setTypeFrom(req, r)
+	req.SetOptionUint32(message.QBlock2, block)
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Code() != codes.BadOption || atomic.LoadUint32(&b.qBlockPeer) == qBlockPeerSupported {
+		return resp, nil
+	}
+	// the server doesn't understand critical Q-Block2 option - send the request without it
+	atomic.StoreUint32(&b.qBlockPeer, qBlockPeerUnsupported)
+	b.releaseMessage(resp)
+	retry := b.acquireMessage(r.Context())
+	defer b.releaseMessage(retry)
+	retry.SetCode(r.Code())
+	retry.SetToken(r.Token())
+	retry.ResetOptionsTo(r.Options())
+	setTypeFrom(retry, r)
+	return do(retry)
+}
+
+// qBlockReceiver collects blocks of a body which can be received out of order.
+type qBlockReceiver struct {
+	mutex  sync.Mutex
+	szx    SZX
+	blocks map[int64][]byte
+	// last is number of the last block, it is -1 when it is unknown
+	last int64
+	etag []byte
+	done bool
+
+	// fields of Q-Block2 response
+	request   Message
+	requested int64
+	timer     clock.Timer
+	retries   int
+}
+
+func newQBlockReceiver(szx SZX) *qBlockReceiver {
+	return &qBlockReceiver{
+		szx:    szx,
+		blocks: make(map[int64][]byte),
+		last:   -1,
+	}
+}
+
+func (q *qBlockReceiver) store(r Message, blockType message.OptionID) (int64, bool, error) {
+	block, err := r.GetOptionUint32(blockType)
+	if err != nil {
+		return 0, false, fmt.Errorf("cannot get %v option: %w", blockType, err)
+	}
+	szx, num, more, err := DecodeBlockOption(block)
+	if err != nil {
+		return 0, false, fmt.Errorf("cannot decode %v option: %w", blockType, err)
+	}
+	if szx != q.szx {
+		return 0, false, fmt.Errorf("unexpected block size %v, expected %v", szx.Size(), q.szx.Size())
+	}
+	etag, _ := r.GetOptionBytes(message.ETag)
+	if !bytes.Equal(etag, q.etag) {
+		// representation was changed - drop data
+		q.etag = append([]byte(nil), etag...)
+		q.blocks = make(map[int64][]byte)
+		q.last = -1
+	}
+	var data []byte
+	if r.Body() != nil {
+		_, err = r.Body().Seek(0, io.SeekStart)
+		if err != nil {
+			return 0, false, fmt.Errorf("cannot seek to start of block: %w", err)
+		}
+		data, err = ioutil.ReadAll(r.Body())
+		if err != nil {
+			return 0, false, fmt.Errorf("cannot read block: %w", err)
+		}
+	}
+	q.blocks[num] = data
+	if !more {
+		q.last = num
+	}
+	return num, more, nil
+}
+
+// missing returns numbers of missing blocks up to the block num.
+func (q *qBlockReceiver) missing(upTo int64, limit int) []int64 {
+	var nums []int64
+	for n := int64(0); n <= upTo && len(nums) < limit; n++ {
+		if _, ok := q.blocks[n]; !ok {
+			nums = append(nums, n)
+		}
+	}
+	return nums
+}
+
+func (q *qBlockReceiver) highest() int64 {
+	highest := int64(-1)
+	for n := range q.blocks {
+		if n > highest {
+			highest = n
+		}
+	}
+	return highest
+}
+
+func (q *qBlockReceiver) complete() bool {
+	return q.last >= 0 && int64(len(q.blocks)) == q.last+1 && len(q.missing(q.last, 1)) == 0
+}
+
+func (q *qBlockReceiver) payload() []byte {
+	nums := make([]int64, 0, len(q.blocks))
+	size := 0
+	for n, data := range q.blocks {
+		nums = append(nums, n)
+		size += len(data)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	buf := make([]byte, 0, size)
+	for _, n := range nums {
+		buf = append(buf, q.blocks[n]...)
+	}
+	return buf
+}
+
+// newAssembledMessage creates message with the whole body from the last received block r.
+func (b *BlockWise) newAssembledMessage(r Message, q *qBlockReceiver, blockType, sizeType message.OptionID) Message {
+	msg := b.acquireMessage(r.Context())
+	msg.SetCode(r.Code())
+	msg.SetToken(r.Token())
+	msg.ResetOptionsTo(r.Options())
+	msg.Remove(blockType)
+	msg.Remove(sizeType)
+	msg.Remove(message.RequestTag)
+	msg.SetSequence(r.Sequence())
+	setTypeFrom(msg, r)
+	msg.SetBody(bytes.NewReader(q.payload()))
+	return msg
+}
+
+func (b *BlockWise) loadOrStoreQBlockReceiver(key string, szx SZX) *qBlockReceiver {
+	v, ok := b.qBlockReceivers.Get(key)
+	if ok {
+		return v.(*qBlockReceiver)
+	}
+	q := newQBlockReceiver(szx)
+	err := b.qBlockReceivers.Add(key, q, cache.DefaultExpiration)
+	if err != nil {
+		// receiver was stored concurrently
+		if v, ok := b.qBlockReceivers.Get(key); ok {
+			return v.(*qBlockReceiver)
+		}
+	}
+	return q
+}
+
+func (b *BlockWise) setQBlockResponse(w ResponseWriter, r Message, code codes.Code, opts ...message.Option) {
+	resp := b.acquireMessage(r.Context())
+	resp.SetCode(code)
+	resp.SetToken(r.Token())
+	for _, o := range opts {
+		resp.SetOptionBytes(o.ID, o.Value)
+	}
+	w.SetMessage(resp)
+}
+
+// handleQBlock1Request collects blocks of the request body and passes the whole request to next.
+//
+// Server responds to the confirmable block, to the last block of a set and to the last block of the body.
+// The last block of a set is the block whose number+1 is a multiple of maxPayloads.
+func (b *BlockWise) handleQBlock1Request(w ResponseWriter, r Message, next func(w ResponseWriter, r Message)) error {
+	block, err := r.GetOptionUint32(message.QBlock1)
+	if err != nil {
+		return fmt.Errorf("cannot get %v option: %w", message.QBlock1, err)
+	}
+	szx, _, _, err := DecodeBlockOption(block)
+	if err != nil {
+		return fmt.Errorf("cannot decode %v option: %w", message.QBlock1, err)
+	}
+	key := "1:" + r.Token().String()
+	q := b.loadOrStoreQBlockReceiver(key, szx)
+	q.mutex.Lock()
+	if q.done {
+		q.mutex.Unlock()
+		return nil
+	}
+	num, more, err := q.store(r, message.QBlock1)
+	if err != nil {
+		q.mutex.Unlock()
+		b.qBlockReceivers.Delete(key)
+		return err
+	}
+	confirmable := false
+	if m, ok := r.(hasType); ok {
+		confirmable = m.Type() == udpMessage.Confirmable
+	}
+	if more && !confirmable && (num+1)%int64(b.qBlock.maxPayloads) != 0 {
+		q.mutex.Unlock()
+		return nil
+	}
+	upTo := q.last
+	if upTo < 0 {
+		upTo = q.highest()
+	}
+	missing := q.missing(upTo, b.qBlock.maxPayloads)
+	if len(missing) > 0 {
+		q.mutex.Unlock()
+		buf := make([]byte, 2)
+		n, _ := message.EncodeUint32(buf, uint32(message.AppMissingBlocks))
+		b.setQBlockResponse(w, r, codes.RequestEntityIncomplete, message.Option{ID: message.ContentFormat, Value: buf[:n]})
+		w.Message().SetBody(bytes.NewReader(encodeMissingBlocks(missing)))
+		return nil
+	}
+	if !q.complete() {
+		q.mutex.Unlock()
+		b.setQBlockResponse(w, r, codes.Continue)
+		w.Message().SetOptionUint32(message.QBlock1, block)
+		return nil
+	}
+	q.done = true
+	msg := b.newAssembledMessage(r, q, message.QBlock1, message.Size1)
+	q.mutex.Unlock()
+	b.qBlockReceivers.Delete(key)
+	next(w, msg)
+	return nil
+}
+
+// startSendingQBlock2 sends the first set of blocks of the response body by Q-Block2 option.
+func (b *BlockWise) startSendingQBlock2(w ResponseWriter, szx SZX) error {
+	payloadSize, err := w.Message().BodySize()
+	if err != nil {
+		return fmt.Errorf("cannot get size of payload: %w", err)
+	}
+	if payloadSize <= szx.Size() {
+		return nil
+	}
+	sendingMessage := b.acquireMessage(w.Message().Context())
+	sendingMessage.ResetOptionsTo(w.Message().Options())
+	sendingMessage.SetBody(w.Message().Body())
+	sendingMessage.SetCode(w.Message().Code())
+	sendingMessage.SetToken(w.Message().Token())
+	guard := newRequestGuard(sendingMessage)
+	expire := cache.DefaultExpiration
+	deadline, ok := sendingMessage.Context().Deadline()
+	if ok {
+		expire = b.clock.Until(deadline)
+	}
+	err = b.sendingMessagesCache.Add(sendingMessage.Token().String(), guard, expire)
+	if err != nil {
+		return fmt.Errorf("cannot add to response cache: %w", err)
+	}
+	return b.sendQBlock2(w, guard, sendingMessage.Token(), szx, []qBlockRequest{{num: 0, more: true}})
+}
+
+// sendQBlock2 sends requested blocks. The first one is the response, others are sent as non-confirmable messages.
+func (b *BlockWise) sendQBlock2(w ResponseWriter, guard *messageGuard, token message.Token, szx SZX, reqs []qBlockRequest) error {
+	err := guard.Acquire(guard.Context(), 1)
+	if err != nil {
+		return fmt.Errorf("cannot lock message: %v", err)
+	}
+	defer guard.Release(1)
+	payloadSize, err := guard.BodySize()
+	if err != nil {
+		return fmt.Errorf("cannot get size of payload: %w", err)
+	}
+	blocks := (payloadSize + szx.Size() - 1) / szx.Size()
+	var nums []int64
+	seen := make(map[int64]bool)
+	add := func(n int64) {
+		if n < blocks && !seen[n] {
+			seen[n] = true
+			nums = append(nums, n)
+		}
+	}
+	for _, req := range reqs {
+		if !req.more {
+			add(req.num)
+			continue
+		}
+		for n := req.num; n < req.num+int64(b.qBlock.maxPayloads); n++ {
+			add(n)
+		}
+	}
+	if len(nums) == 0 {
+		return fmt.Errorf("requested blocks are out of payload")
+	}
+	for i, num := range nums {
+		msg, _, err := b.newBlockMessage(guard.Message, token, message.QBlock2, message.Size2, szx, num)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			w.SetMessage(msg)
+			continue
+		}
+		err = b.qBlock.writeMessage(msg)
+		b.releaseMessage(msg)
+		if err != nil {
+			return fmt.Errorf("cannot write block %v: %w", num, err)
+		}
+	}
+	return nil
+}
+
+// continueSendingQBlock2 sends blocks requested by the client.
+func (b *BlockWise) continueSendingQBlock2(w ResponseWriter, r Message, maxSZX SZX, guard *messageGuard) error {
+	reqs, szx, err := getQBlockRequests(r, maxSZX)
+	if err != nil {
+		return err
+	}
+	return b.sendQBlock2(w, guard, r.Token(), szx, reqs)
+}
+
+// writeQBlock2Request asks the server for the blocks of the response.
+func (b *BlockWise) writeQBlock2Request(q *qBlockReceiver, token message.Token, reqs []qBlockRequest) error {
+	opts := make(message.Options, 0, len(q.request.Options())+len(reqs))
+	for _, o := range q.request.Options() {
+		switch o.ID {
+		case message.QBlock2, message.Block2, message.Observe:
+			continue
+		}
+		opts = opts.Add(o)
+	}
+	for _, req := range reqs {
+		block, err := EncodeBlockOption(q.szx, req.num, req.more)
+		if err != nil {
+			return fmt.Errorf("cannot encode block option(%v,%v,%v): %w", q.szx, req.num, req.more, err)
+		}
+		buf := make([]byte, 4)
+		opts, _, err = opts.AddUint32(buf, message.QBlock2, block)
+		if err != nil {
+			return fmt.Errorf("cannot add %v option: %w", message.QBlock2, err)
+		}
+	}
+	msg := b.acquireMessage(q.request.Context())
+	defer b.releaseMessage(msg)
+	msg.SetCode(q.request.Code())
+	msg.SetToken(token)
+	msg.ResetOptionsTo(opts)
+	return b.qBlock.writeMessage(msg)
+}
+
+func (q *qBlockReceiver) nextSetExists() bool {
+	return q.last < 0 || q.requested <= q.last
+}
+
+func (b *BlockWise) releaseQBlockReceiver(key string, q *qBlockReceiver) {
+	q.done = true
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	if q.request != nil {
+		b.releaseMessage(q.request)
+		q.request = nil
+	}
+	b.qBlockReceivers.Delete(key)
+}
+
+// onNonReceiveTimeout asks for the missing blocks or for the next set when no block was received within NON_RECEIVE_TIMEOUT.
+func (b *BlockWise) onNonReceiveTimeout(key string, q *qBlockReceiver, token message.Token) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.done {
+		return
+	}
+	q.retries++
+	if q.retries > qBlockMaxRetransmit || q.request.Context().Err() != nil {
+		b.releaseQBlockReceiver(key, q)
+		b.errors(fmt.Errorf("cannot receive blocks of response %v: retransmission(%v) was exhausted", token, qBlockMaxRetransmit))
+		return
+	}
+	upTo := q.requested - 1
+	if q.last >= 0 && q.last < upTo {
+		upTo = q.last
+	}
+	missing := q.missing(upTo, b.qBlock.maxPayloads)
+	var reqs []qBlockRequest
+	for _, num := range missing {
+		reqs = append(reqs, qBlockRequest{num: num})
+	}
+	if len(reqs) == 0 && q.nextSetExists() {
+		reqs = append(reqs, qBlockRequest{num: q.requested, more: true})
+		q.requested += int64(b.qBlock.maxPayloads)
+	}
+	if len(reqs) > 0 {
+		err := b.writeQBlock2Request(q, token, reqs)
+		if err != nil {
+			b.errors(fmt.Errorf("cannot request blocks of response %v: %w", token, err))
+		}
+	}
+	q.timer.Reset(b.qBlock.nonReceiveTimeout)
+}
+
+// handleQBlock2Response collects blocks of the response body and passes the whole response to next.
+func (b *BlockWise) handleQBlock2Response(w ResponseWriter, r Message, next func(w ResponseWriter, r Message)) error {
+	block, err := r.GetOptionUint32(message.QBlock2)
+	if err != nil {
+		return fmt.Errorf("cannot get %v option: %w", message.QBlock2, err)
+	}
+	szx, _, _, err := DecodeBlockOption(block)
+	if err != nil {
+		return fmt.Errorf("cannot decode %v option: %w", message.QBlock2, err)
+	}
+	token := r.Token()
+	key := "2:" + token.String()
+	q := b.loadOrStoreQBlockReceiver(key, szx)
+	q.mutex.Lock()
+	if q.done {
+		q.mutex.Unlock()
+		return nil
+	}
+	if q.request == nil {
+		q.request = b.getSendedRequest(token)
+		if q.request == nil {
+			q.mutex.Unlock()
+			b.qBlockReceivers.Delete(key)
+			return fmt.Errorf("cannot request body without paired request")
+		}
+		q.requested = int64(b.qBlock.maxPayloads)
+		q.timer = b.clock.AfterFunc(b.qBlock.nonReceiveTimeout, func() {
+			b.onNonReceiveTimeout(key, q, token)
+		})
+	}
+	_, _, err = q.store(r, message.QBlock2)
+	if err != nil {
+		b.releaseQBlockReceiver(key, q)
+		q.mutex.Unlock()
+		return err
+	}
+	if size, err := r.GetOptionUint32(message.Size2); err == nil && size > 0 && q.last < 0 {
+		q.last = (int64(size) - 1) / szx.Size()
+	}
+	if q.complete() {
+		msg := b.newAssembledMessage(r, q, message.QBlock2, message.Size2)
+		b.releaseQBlockReceiver(key, q)
+		q.mutex.Unlock()
+		next(w, msg)
+		return nil
+	}
+	q.retries = 0
+	q.timer.Reset(b.qBlock.nonReceiveTimeout)
+	if len(q.missing(q.requested-1, 1)) == 0 && q.nextSetExists() {
+		// all blocks of the set were received - ask for the next set
+		reqs := []qBlockRequest{{num: q.requested, more: true}}
+		q.requested += int64(b.qBlock.maxPayloads)
+		err = b.writeQBlock2Request(q, token, reqs)
+	}
+	q.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot request blocks of response: %w", err)
+	}
+	return nil
+}
+
+// handleQBlock handles messages with Q-Block options. It returns false when the message should be processed
+// by Block1 and Block2 transfer.
+func (b *BlockWise) handleQBlock(w ResponseWriter, r Message, maxSZX SZX, next func(w ResponseWriter, r Message)) (bool, error) {
+	hasQBlock1 := r.Options().HasOption(message.QBlock1)
+	hasQBlock2 := r.Options().HasOption(message.QBlock2)
+	if !hasQBlock1 && !hasQBlock2 {
+		return false, nil
+	}
+	request := isRequest(r.Code())
+	if b.qBlock == nil {
+		if request {
+			// Q-Block options are critical: https://tools.ietf.org/html/rfc7252#section-5.4.1
+			b.setQBlockResponse(w, r, codes.BadOption)
+			return true, nil
+		}
+		return false, nil
+	}
+	switch {
+	case request && hasQBlock1:
+		return true, b.handleQBlock1Request(w, r, next)
+	case request:
+		v, ok := b.sendingMessagesCache.Get(r.Token().String())
+		if !ok || len(r.Token()) == 0 {
+			// the initial request is handled by handleReceivedMessage
+			return false, nil
+		}
+		return true, b.continueSendingQBlock2(w, r, maxSZX, v.(*messageGuard))
+	case hasQBlock2:
+		return true, b.handleQBlock2Response(w, r, next)
+	}
+	return false, nil
+}
@@ -0,0 +1,299 @@
+package blockwise
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingBlocks(t *testing.T) {
+	nums := []int64{0, 23, 24, 255, 256, 65535, 65536, maxBlockNumber}
+	data := encodeMissingBlocks(nums)
+	require.Equal(t, []byte{0x00, 0x17, 0x18, 0x18, 0x18, 0xff, 0x19, 0x01, 0x00, 0x19, 0xff, 0xff, 0x1a, 0x00, 0x01, 0x00, 0x00, 0x1a, 0x00, 0x0f, 0xff, 0xf7}, data)
+	decoded, err := decodeMissingBlocks(data)
+	require.NoError(t, err)
+	require.Equal(t, nums, decoded)
+
+	_, err = decodeMissingBlocks([]byte{0x19, 0x01})
+	require.Error(t, err)
+	_, err = decodeMissingBlocks([]byte{0x40})
+	require.Error(t, err)
+}
+
+func cloneMessage(t *testing.T, m Message) *testmessage {
+	c := acquireMessage(m.Context())
+	c.SetCode(m.Code())
+	c.SetToken(m.Token())
+	c.ResetOptionsTo(m.Options())
+	if m.Body() != nil {
+		_, err := m.Body().Seek(0, 0)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(m.Body())
+		require.NoError(t, err)
+		c.SetBody(bytes.NewReader(data))
+	}
+	return c.(*testmessage)
+}
+
+func qBlockNumber(t *testing.T, m Message, id message.OptionID) int64 {
+	v, err := m.GetOptionUint32(id)
+	require.NoError(t, err)
+	_, num, _, err := DecodeBlockOption(v)
+	require.NoError(t, err)
+	return num
+}
+
+func TestBlockWise_QBlock1(t *testing.T) {
+	payload := make([]byte, 16*5)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	sender := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	receiver := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	receiver.EnableQBlock(3, time.Second, nil)
+
+	next := func(w ResponseWriter, r Message) {
+		body, err := ioutil.ReadAll(r.Body())
+		require.NoError(t, err)
+		require.Equal(t, payload, body)
+		require.False(t, r.Options().HasOption(message.QBlock1))
+		w.SetMessage(&testmessage{
+			ctx:   context.Background(),
+			token: r.Token(),
+			code:  codes.Changed,
+		})
+	}
+	deliver := func(req Message) Message {
+		w := newResponseWriter(acquireMessage(req.Context()))
+		receiver.Handle(w, req, SZX1024, int(SZX1024.Size()), next)
+		return w.Message()
+	}
+	dropped := false
+	sender.EnableQBlock(3, time.Second, func(req Message) error {
+		if qBlockNumber(t, req, message.QBlock1) == 1 && !dropped {
+			// the block is lost
+			dropped = true
+			return nil
+		}
+		// testmessage is always confirmable so the receiver answers each block, the answer is lost
+		deliver(cloneMessage(t, req))
+		return nil
+	})
+	var codesOfResponses []codes.Code
+	resp, err := sender.Do(&testmessage{
+		ctx:     context.Background(),
+		token:   []byte{1},
+		code:    codes.POST,
+		payload: bytes.NewReader(payload),
+	}, SZX16, int(SZX16.Size()), func(req Message) (Message, error) {
+		resp := deliver(cloneMessage(t, req))
+		codesOfResponses = append(codesOfResponses, resp.Code())
+		return resp, nil
+	})
+	require.NoError(t, err)
+	require.True(t, dropped)
+	require.Equal(t, codes.Changed, resp.Code())
+	// probe, the set with missing block 1, recovery of block 1, the last set
+	require.Equal(t, []codes.Code{codes.Continue, codes.RequestEntityIncomplete, codes.Continue, codes.Changed}, codesOfResponses)
+}
+
+// typedmessage has the type of UDP message, so the receiver answers only confirmable blocks and the last blocks of sets.
+type typedmessage struct {
+	*testmessage
+	typ udpMessage.Type
+}
+
+func (m *typedmessage) Type() udpMessage.Type {
+	return m.typ
+}
+
+func (m *typedmessage) SetType(t udpMessage.Type) {
+	m.typ = t
+}
+
+func TestBlockWise_QBlock1_Sets(t *testing.T) {
+	payload := make([]byte, 16*11)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	sender := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	receiver := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	receiver.EnableQBlock(3, time.Second, nil)
+
+	next := func(w ResponseWriter, r Message) {
+		body, err := ioutil.ReadAll(r.Body())
+		require.NoError(t, err)
+		require.Equal(t, payload, body)
+		w.SetMessage(&testmessage{
+			ctx:   context.Background(),
+			token: r.Token(),
+			code:  codes.Changed,
+		})
+	}
+	deliver := func(req Message, typ udpMessage.Type) Message {
+		w := newResponseWriter(acquireMessage(req.Context()))
+		receiver.Handle(w, &typedmessage{testmessage: cloneMessage(t, req), typ: typ}, SZX1024, int(SZX1024.Size()), next)
+		return w.Message()
+	}
+	// responses to non-confirmable blocks are matched by the token, so the next request picks them up
+	var unmatched []Message
+	sender.EnableQBlock(3, time.Second, func(req Message) error {
+		resp := deliver(req, udpMessage.NonConfirmable)
+		if resp.Code() != 0 {
+			unmatched = append(unmatched, resp)
+		}
+		return nil
+	})
+	var sent []int64
+	var codesOfResponses []codes.Code
+	resp, err := sender.Do(&testmessage{
+		ctx:     context.Background(),
+		token:   []byte{1},
+		code:    codes.POST,
+		payload: bytes.NewReader(payload),
+	}, SZX16, int(SZX16.Size()), func(req Message) (Message, error) {
+		sent = append(sent, qBlockNumber(t, req, message.QBlock1))
+		resp := deliver(req, udpMessage.Confirmable)
+		if len(sent) == 2 {
+			// the response to the probe was duplicated
+			block, err := EncodeBlockOption(SZX16, 0, true)
+			require.NoError(t, err)
+			stale := acquireMessage(req.Context())
+			stale.SetCode(codes.Continue)
+			stale.SetToken(req.Token())
+			stale.SetOptionUint32(message.QBlock1, block)
+			unmatched = append(unmatched, stale)
+		}
+		if len(unmatched) > 0 {
+			// the response of the request comes later and it is dropped
+			resp, unmatched = unmatched[0], unmatched[1:]
+		}
+		codesOfResponses = append(codesOfResponses, resp.Code())
+		return resp, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code())
+	// probe, the stale response to the probe makes the sender repeat the last block of the first set,
+	// then the sets end with blocks 5, 8 and the last block 10
+	require.Equal(t, []int64{0, 2, 2, 5, 8, 10}, sent)
+	require.Equal(t, []codes.Code{codes.Continue, codes.Continue, codes.Continue, codes.Continue, codes.Continue, codes.Changed}, codesOfResponses)
+}
+
+func TestBlockWise_QBlock1_Unsupported(t *testing.T) {
+	sender := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	receiver := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	sender.EnableQBlock(3, time.Second, func(req Message) error {
+		require.FailNow(t, "unexpected burst")
+		return nil
+	})
+	resp, err := sender.Do(&testmessage{
+		ctx:     context.Background(),
+		token:   []byte{1},
+		code:    codes.POST,
+		payload: bytes.NewReader(make([]byte, 16*5)),
+	}, SZX16, int(SZX16.Size()), makeDo(t, sender, receiver, SZX16, int(SZX16.Size()), SZX16, int(SZX16.Size()), func(w ResponseWriter, r Message) {
+		body, err := ioutil.ReadAll(r.Body())
+		require.NoError(t, err)
+		require.Len(t, body, 16*5)
+		w.SetMessage(&testmessage{
+			ctx:   context.Background(),
+			token: r.Token(),
+			code:  codes.Changed,
+		})
+	}))
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code())
+	require.Equal(t, qBlockPeerUnsupported, sender.qBlockPeer)
+}
+
+func TestBlockWise_QBlock2(t *testing.T) {
+	payload := make([]byte, 16*7+5)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	client := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, true, nil)
+	server := NewBlockWise(acquireMessage, releaseMessage, time.Second*3600, func(err error) { t.Log(err) }, false, nil)
+
+	respChan := make(chan Message, 1)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	toClient := func(resp Message) {
+		client.Handle(newResponseWriter(acquireMessage(resp.Context())), resp, SZX16, int(SZX16.Size()), func(w ResponseWriter, r Message) {
+			respChan <- r
+		})
+	}
+	var dropMutex sync.Mutex
+	dropped := map[int64]bool{}
+	server.EnableQBlock(3, time.Second, func(resp Message) error {
+		num := qBlockNumber(t, resp, message.QBlock2)
+		dropMutex.Lock()
+		drop := (num == 2 || num == 4) && !dropped[num]
+		dropped[num] = true
+		dropMutex.Unlock()
+		if drop {
+			return nil
+		}
+		c := cloneMessage(t, resp)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			toClient(c)
+		}()
+		return nil
+	})
+	toServer := func(req Message) Message {
+		w := newResponseWriter(acquireMessage(req.Context()))
+		server.Handle(w, req, SZX16, int(SZX16.Size()), func(w ResponseWriter, r Message) {
+			require.False(t, r.Options().HasOption(message.QBlock2))
+			w.SetMessage(&testmessage{
+				ctx:     context.Background(),
+				token:   r.Token(),
+				code:    codes.Content,
+				payload: bytes.NewReader(payload),
+			})
+		})
+		return w.Message()
+	}
+	client.EnableQBlock(3, time.Millisecond*50, func(req Message) error {
+		c := cloneMessage(t, req)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := toServer(c)
+			toClient(resp)
+		}()
+		return nil
+	})
+
+	resp, err := client.Do(&testmessage{
+		ctx:   context.Background(),
+		token: []byte{1},
+		code:  codes.GET,
+	}, SZX16, int(SZX16.Size()), func(req Message) (Message, error) {
+		require.True(t, req.Options().HasOption(message.QBlock2))
+		resp := toServer(cloneMessage(t, req))
+		require.Equal(t, int64(0), qBlockNumber(t, resp, message.QBlock2))
+		toClient(resp)
+		select {
+		case r := <-respChan:
+			return r, nil
+		case <-time.After(time.Second * 5):
+			return nil, context.DeadlineExceeded
+		}
+	})
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err := ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Equal(t, payload, body)
+	require.True(t, dropped[2])
+	require.True(t, dropped[4])
+}
@@ -0,0 +1,196 @@
+// Package cache provides an in-memory key:value store with expiration of items driven by clock.Clock.
+// The API follows github.com/patrickmn/go-cache.
+package cache
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+)
+
+const (
+	// NoExpiration is used for items which never expire.
+	NoExpiration time.Duration = -1
+	// DefaultExpiration is used for items which expire after the default expiration of the cache.
+	DefaultExpiration time.Duration = 0
+)
+
+type item struct {
+	value interface{}
+	// expiration is zero for items which never expire
+	expiration time.Time
+}
+
+// Cache is a thread-safe map of items with expiration.
+type Cache struct {
+	*cache
+}
+
+type cache struct {
+	clock             clock.Clock
+	defaultExpiration time.Duration
+	cleanupInterval   time.Duration
+
+	mutex     sync.Mutex
+	items     map[string]item
+	onEvicted func(string, interface{})
+	janitor   clock.Timer
+	stopped   bool
+}
+
+// New creates a cache. Items expire after defaultExpiration, a value lower than 1 means the items
+// never expire. Expired items are deleted every cleanupInterval, a value lower than 1 means they are
+// deleted only by DeleteExpired. The clock can be nil.
+func New(defaultExpiration, cleanupInterval time.Duration, clk clock.Clock) *Cache {
+	if defaultExpiration == 0 {
+		defaultExpiration = NoExpiration
+	}
+	c := &cache{
+		clock:             clock.Get(clk),
+		defaultExpiration: defaultExpiration,
+		cleanupInterval:   cleanupInterval,
+		items:             make(map[string]item),
+	}
+	// the janitor holds only the inner cache, so the finalizer can stop it when the cache is not used anymore
+	wrapper := &Cache{c}
+	if cleanupInterval > 0 {
+		// the janitor is set under the lock, so runJanitor cannot read it before it is set
+		c.mutex.Lock()
+		c.janitor = c.clock.AfterFunc(cleanupInterval, c.runJanitor)
+		c.mutex.Unlock()
+		runtime.SetFinalizer(wrapper, stopJanitor)
+	}
+	return wrapper
+}
+
+func stopJanitor(c *Cache) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stopped = true
+	c.janitor.Stop()
+}
+
+func (c *cache) runJanitor() {
+	c.DeleteExpired()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.stopped {
+		c.janitor.Reset(c.cleanupInterval)
+	}
+}
+
+func (c *cache) expirationLocked(d time.Duration) time.Time {
+	if d == DefaultExpiration {
+		d = c.defaultExpiration
+	}
+	if d <= 0 {
+		return time.Time{}
+	}
+	return c.clock.Now().Add(d)
+}
+
+func (c *cache) getLocked(k string) (interface{}, bool) {
+	it, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	if !it.expiration.IsZero() && c.clock.Now().After(it.expiration) {
+		return nil, false
+	}
+	return it.value, true
+}
+
+// Set adds the item to the cache, replacing any existing item.
+func (c *cache) Set(k string, v interface{}, d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items[k] = item{value: v, expiration: c.expirationLocked(d)}
+}
+
+// SetDefault adds the item to the cache with the default expiration, replacing any existing item.
+func (c *cache) SetDefault(k string, v interface{}) {
+	c.Set(k, v, DefaultExpiration)
+}
+
+// Add adds the item to the cache only when the item doesn't exist or it is expired.
+func (c *cache) Add(k string, v interface{}, d time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.getLocked(k); ok {
+		return fmt.Errorf("item %s already exists", k)
+	}
+	c.items[k] = item{value: v, expiration: c.expirationLocked(d)}
+	return nil
+}
+
+// Replace sets a new value for the key only when the item exists and it isn't expired.
+func (c *cache) Replace(k string, v interface{}, d time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.getLocked(k); !ok {
+		return fmt.Errorf("item %s doesn't exist", k)
+	}
+	c.items[k] = item{value: v, expiration: c.expirationLocked(d)}
+	return nil
+}
+
+// Get returns the item, expired items are not returned.
+func (c *cache) Get(k string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.getLocked(k)
+}
+
+// Delete removes the item and calls the function set by OnEvicted.
+func (c *cache) Delete(k string) {
+	c.mutex.Lock()
+	it, ok := c.items[k]
+	delete(c.items, k)
+	onEvicted := c.onEvicted
+	c.mutex.Unlock()
+	if ok && onEvicted != nil {
+		onEvicted(k, it.value)
+	}
+}
+
+// DeleteExpired removes all expired items and calls the function set by OnEvicted for them.
+func (c *cache) DeleteExpired() {
+	type evicted struct {
+		key   string
+		value interface{}
+	}
+	var evictedItems []evicted
+	c.mutex.Lock()
+	now := c.clock.Now()
+	for k, it := range c.items {
+		if !it.expiration.IsZero() && now.After(it.expiration) {
+			delete(c.items, k)
+			evictedItems = append(evictedItems, evicted{key: k, value: it.value})
+		}
+	}
+	onEvicted := c.onEvicted
+	c.mutex.Unlock()
+	if onEvicted == nil {
+		return
+	}
+	for _, e := range evictedItems {
+		onEvicted(e.key, e.value)
+	}
+}
+
+// OnEvicted sets function which is called when an item is deleted or expired items are removed.
+func (c *cache) OnEvicted(f func(string, interface{})) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onEvicted = f
+}
+
+// ItemCount returns the number of items in the cache including expired items which weren't removed yet.
+func (c *cache) ItemCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.items)
+}
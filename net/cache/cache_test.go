@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_Expiration(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c := New(time.Minute, 0, clk)
+	require.NoError(t, c.Add("a", 1, DefaultExpiration))
+	require.Error(t, c.Add("a", 2, DefaultExpiration))
+	c.Set("b", 2, time.Hour)
+	c.Set("c", 3, NoExpiration)
+
+	clk.Advance(time.Minute + time.Second)
+	_, ok := c.Get("a")
+	require.False(t, ok)
+	require.Error(t, c.Replace("a", 1, DefaultExpiration))
+	require.NoError(t, c.Add("a", 4, DefaultExpiration))
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 4, v)
+
+	clk.Advance(time.Hour)
+	_, ok = c.Get("b")
+	require.False(t, ok)
+	v, ok = c.Get("c")
+	require.True(t, ok)
+	require.Equal(t, 3, v)
+	require.Equal(t, 3, c.ItemCount())
+}
+
+func TestCache_Janitor(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c := New(time.Minute, time.Minute, clk)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	evicted := make(map[string]interface{})
+	var mutex sync.Mutex
+	c.OnEvicted(func(k string, v interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		evicted[k] = v
+		wg.Done()
+	})
+	c.SetDefault("a", 1)
+	c.Set("b", 2, time.Second*90)
+	c.Delete("a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	clk.Advance(time.Minute)
+	// the janitor runs in its own goroutine and schedules the next run
+	require.NoError(t, clk.WaitForTimers(ctx, 1))
+	clk.Advance(time.Minute)
+	wg.Wait()
+	require.Equal(t, map[string]interface{}{"a": 1, "b": 2}, evicted)
+	require.Equal(t, 0, c.ItemCount())
+}
@@ -17,7 +17,7 @@ import (
 // Multiple goroutines may invoke methods on a UDPConn simultaneously.
 type UDPConn struct {
 	heartBeat      time.Duration
-	connection     *net.UDPConn
+	connection     UDPPacketConn
 	packetConn     packetConn
 	errors         func(err error)
 	network        string
@@ -27,6 +27,15 @@ type UDPConn struct {
 	lock sync.Mutex
 }
 
+// UDPPacketConn is a packet connection wrapped by UDPConn, eg. *net.UDPConn or *MemoryPacketConn.
+type UDPPacketConn interface {
+	net.PacketConn
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	Write(b []byte) (int, error)
+	RemoteAddr() net.Addr
+}
+
 type ControlMessage struct {
 	Src     net.IP // source address, specifying only
 	IfIndex int    // interface index, must be 1 <= value when specifying
@@ -166,8 +175,8 @@ func NewListenUDP(network, addr string, opts ...UDPOption) (*UDPConn, error) {
 	return NewUDPConn(network, conn, opts...), nil
 }
 
-// NewUDPConn creates connection over net.UDPConn.
-func NewUDPConn(network string, c *net.UDPConn, opts ...UDPOption) *UDPConn {
+// NewUDPConn creates connection over net.UDPConn or other UDPPacketConn.
+func NewUDPConn(network string, c UDPPacketConn, opts ...UDPOption) *UDPConn {
 	cfg := defaultUDPConnOptions
 	for _, o := range opts {
 		o.applyUDP(&cfg)
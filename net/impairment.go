@@ -0,0 +1,387 @@
+package net
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// DelayDistribution returns delay of a packet, rnd is the seeded source of the impairment.
+type DelayDistribution func(rnd *rand.Rand) time.Duration
+
+// ConstantDelay delays each packet by d.
+func ConstantDelay(d time.Duration) DelayDistribution {
+	return func(*rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformDelay delays packets uniformly between min and max, eg. latency with jitter.
+func UniformDelay(min, max time.Duration) DelayDistribution {
+	return func(rnd *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rnd.Int63n(int64(max-min)+1))
+	}
+}
+
+// NormalDelay delays packets by normally distributed values with mean and standard deviation stddev.
+// Negative values are truncated to zero.
+func NormalDelay(mean, stddev time.Duration) DelayDistribution {
+	return func(rnd *rand.Rand) time.Duration {
+		d := mean + time.Duration(rnd.NormFloat64()*float64(stddev))
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// ImpairmentStats counts packets written through an impaired connection.
+type ImpairmentStats struct {
+	// Written is the number of packets written by the caller.
+	Written uint64
+	// Dropped is the number of packets which were not delivered.
+	Dropped uint64
+	// Duplicated is the number of packets which were delivered twice.
+	Duplicated uint64
+	// Reordered is the number of packets which were delivered after a later packet.
+	Reordered uint64
+}
+
+type impairmentOptions struct {
+	dropProbability      float64
+	duplicateProbability float64
+	reorderWindow        int
+	reorderHold          time.Duration
+	delay                DelayDistribution
+	seed                 int64
+}
+
+var defaultImpairmentOptions = impairmentOptions{
+	seed: 1,
+}
+
+// A ImpairmentOption sets impairments of NewImpairedPacketConn and NewImpairedConn.
+type ImpairmentOption interface {
+	applyImpairment(*impairmentOptions)
+}
+
+type DropProbabilityOpt struct {
+	p float64
+}
+
+func (o DropProbabilityOpt) applyImpairment(opts *impairmentOptions) {
+	opts.dropProbability = o.p
+}
+
+// WithDropProbability drops packets with probability p from interval [0, 1].
+func WithDropProbability(p float64) DropProbabilityOpt {
+	return DropProbabilityOpt{p: p}
+}
+
+type DuplicateProbabilityOpt struct {
+	p float64
+}
+
+func (o DuplicateProbabilityOpt) applyImpairment(opts *impairmentOptions) {
+	opts.duplicateProbability = o.p
+}
+
+// WithDuplicateProbability delivers packets twice with probability p from interval [0, 1].
+func WithDuplicateProbability(p float64) DuplicateProbabilityOpt {
+	return DuplicateProbabilityOpt{p: p}
+}
+
+type ReorderOpt struct {
+	window int
+	hold   time.Duration
+}
+
+func (o ReorderOpt) applyImpairment(opts *impairmentOptions) {
+	opts.reorderWindow = o.window
+	opts.reorderHold = o.hold
+}
+
+// WithReorder collects up to window packets and delivers them in random order. Collected packets
+// are delivered after hold at the latest, so a lone packet isn't held forever.
+func WithReorder(window int, hold time.Duration) ReorderOpt {
+	return ReorderOpt{window: window, hold: hold}
+}
+
+type DelayOpt struct {
+	delay DelayDistribution
+}
+
+func (o DelayOpt) applyImpairment(opts *impairmentOptions) {
+	opts.delay = o.delay
+}
+
+// WithDelay delays packets by the distribution, eg. WithDelay(UniformDelay(20*time.Millisecond, 30*time.Millisecond)).
+// Delayed packets can overtake each other.
+func WithDelay(d DelayDistribution) DelayOpt {
+	return DelayOpt{delay: d}
+}
+
+type SeedOpt struct {
+	seed int64
+}
+
+func (o SeedOpt) applyImpairment(opts *impairmentOptions) {
+	opts.seed = o.seed
+}
+
+// WithSeed sets seed of the random generator. The same seed and the same sequence of writes
+// produce the same impairments. Default: 1.
+func WithSeed(seed int64) SeedOpt {
+	return SeedOpt{seed: seed}
+}
+
+// impairedPacket is a packet waiting for delivery.
+type impairedPacket struct {
+	seq   uint64
+	delay time.Duration
+	write func() error
+}
+
+// impairment decides about the fate of packets and delivers them.
+type impairment struct {
+	cfg impairmentOptions
+
+	mutex      sync.Mutex
+	rnd        *rand.Rand
+	seq        uint64
+	delivered  uint64
+	pending    []impairedPacket
+	flushTimer *time.Timer
+	closed     bool
+	stats      ImpairmentStats
+}
+
+func newImpairment(opts []ImpairmentOption) *impairment {
+	cfg := defaultImpairmentOptions
+	for _, o := range opts {
+		o.applyImpairment(&cfg)
+	}
+	return &impairment{
+		cfg: cfg,
+		rnd: rand.New(rand.NewSource(cfg.seed)),
+	}
+}
+
+// write passes the packet through the impairments. The error is returned only when the packet
+// was written synchronously.
+func (i *impairment) write(write func() error) error {
+	i.mutex.Lock()
+	if i.closed {
+		i.mutex.Unlock()
+		return errMemoryConnClosed
+	}
+	i.stats.Written++
+	i.seq++
+	// each packet consumes the same random values, so the decisions depend only on the seed and order of writes
+	drop := i.rnd.Float64() < i.cfg.dropProbability
+	duplicate := i.rnd.Float64() < i.cfg.duplicateProbability
+	if drop {
+		i.stats.Dropped++
+		i.mutex.Unlock()
+		return nil
+	}
+	packets := []impairedPacket{{seq: i.seq, delay: i.nextDelay(), write: write}}
+	if duplicate {
+		i.stats.Duplicated++
+		packets = append(packets, impairedPacket{seq: i.seq, delay: i.nextDelay(), write: write})
+	}
+	if i.cfg.reorderWindow > 1 {
+		i.pending = append(i.pending, packets...)
+		packets = nil
+		if len(i.pending) >= i.cfg.reorderWindow {
+			packets = i.takePendingLocked()
+		} else if i.flushTimer == nil {
+			i.flushTimer = time.AfterFunc(i.cfg.reorderHold, i.flush)
+		}
+	}
+	i.mutex.Unlock()
+	return i.deliver(packets)
+}
+
+func (i *impairment) nextDelay() time.Duration {
+	if i.cfg.delay == nil {
+		return 0
+	}
+	return i.cfg.delay(i.rnd)
+}
+
+func (i *impairment) flush() {
+	i.mutex.Lock()
+	packets := i.takePendingLocked()
+	i.mutex.Unlock()
+	i.deliver(packets)
+}
+
+// takePendingLocked shuffles packets collected for reordering.
+func (i *impairment) takePendingLocked() []impairedPacket {
+	if i.flushTimer != nil {
+		i.flushTimer.Stop()
+		i.flushTimer = nil
+	}
+	packets := i.pending
+	i.pending = nil
+	i.rnd.Shuffle(len(packets), func(a, b int) {
+		packets[a], packets[b] = packets[b], packets[a]
+	})
+	return packets
+}
+
+func (i *impairment) deliver(packets []impairedPacket) error {
+	var err error
+	for _, p := range packets {
+		p := p
+		if p.delay > 0 {
+			time.AfterFunc(p.delay, func() {
+				i.send(p)
+			})
+			continue
+		}
+		if errSend := i.send(p); errSend != nil && err == nil {
+			err = errSend
+		}
+	}
+	return err
+}
+
+func (i *impairment) send(p impairedPacket) error {
+	i.mutex.Lock()
+	if i.closed {
+		i.mutex.Unlock()
+		return errMemoryConnClosed
+	}
+	if p.seq < i.delivered {
+		i.stats.Reordered++
+	} else {
+		i.delivered = p.seq
+	}
+	i.mutex.Unlock()
+	return p.write()
+}
+
+// Stats returns counters of the impairments.
+func (i *impairment) Stats() ImpairmentStats {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.stats
+}
+
+func (i *impairment) close() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.closed = true
+	i.pending = nil
+	if i.flushTimer != nil {
+		i.flushTimer.Stop()
+		i.flushTimer = nil
+	}
+}
+
+// ImpairedPacketConn drops, duplicates, reorders and delays written datagrams, so retransmissions,
+// deduplication and blockwise transfers can be tested. It wraps a *net.UDPConn or a MemoryPacketConn
+// and can be passed to NewUDPConn or udp.Client. Wrap both sides to impair both directions.
+type ImpairedPacketConn struct {
+	UDPPacketConn
+	*impairment
+}
+
+// NewImpairedPacketConn wraps the connection with the impairments.
+func NewImpairedPacketConn(c UDPPacketConn, opts ...ImpairmentOption) *ImpairedPacketConn {
+	return &ImpairedPacketConn{
+		UDPPacketConn: c,
+		impairment:    newImpairment(opts),
+	}
+}
+
+// Read reads a datagram.
+func (c *ImpairedPacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.UDPPacketConn.ReadFromUDP(b)
+	return n, err
+}
+
+// WriteToUDP sends the datagram to addr through the impairments.
+func (c *ImpairedPacketConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	data := append([]byte(nil), b...)
+	err := c.write(func() error {
+		_, err := c.UDPPacketConn.WriteToUDP(data, addr)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// WriteTo sends the datagram to addr through the impairments.
+func (c *ImpairedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	data := append([]byte(nil), b...)
+	err := c.write(func() error {
+		_, err := c.UDPPacketConn.WriteTo(data, addr)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Write sends the datagram to the connected address through the impairments.
+func (c *ImpairedPacketConn) Write(b []byte) (int, error) {
+	data := append([]byte(nil), b...)
+	err := c.write(func() error {
+		_, err := c.UDPPacketConn.Write(data)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close discards packets waiting for delivery and closes the connection.
+func (c *ImpairedPacketConn) Close() error {
+	c.close()
+	return c.UDPPacketConn.Close()
+}
+
+// ImpairedConn applies the impairments to writes of a connection which preserves boundaries of writes,
+// eg. DTLS connections of MemoryNetwork. It isn't suitable for stream transports as TCP.
+type ImpairedConn struct {
+	net.Conn
+	*impairment
+}
+
+// NewImpairedConn wraps the connection with the impairments.
+func NewImpairedConn(c net.Conn, opts ...ImpairmentOption) *ImpairedConn {
+	return &ImpairedConn{
+		Conn:       c,
+		impairment: newImpairment(opts),
+	}
+}
+
+// Write sends data through the impairments.
+func (c *ImpairedConn) Write(b []byte) (int, error) {
+	data := append([]byte(nil), b...)
+	err := c.write(func() error {
+		_, err := c.Conn.Write(data)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close discards data waiting for delivery and closes the connection.
+func (c *ImpairedConn) Close() error {
+	c.close()
+	return c.Conn.Close()
+}
@@ -0,0 +1,92 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newImpairedPair(t *testing.T, opts ...ImpairmentOption) (*ImpairedPacketConn, *MemoryPacketConn) {
+	network := NewMemoryNetwork()
+	server, err := network.ListenPacket("")
+	require.NoError(t, err)
+	client, err := network.DialPacket(server.LocalAddr().String())
+	require.NoError(t, err)
+	return NewImpairedPacketConn(client, opts...), server
+}
+
+func readPackets(t *testing.T, c *MemoryPacketConn, timeout time.Duration) []string {
+	var packets []string
+	buf := make([]byte, 64)
+	for {
+		require.NoError(t, c.SetReadDeadline(time.Now().Add(timeout)))
+		n, _, err := c.ReadFromUDP(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func writePackets(t *testing.T, c *ImpairedPacketConn, n int) []string {
+	var packets []string
+	for i := 0; i < n; i++ {
+		p := string(rune('a' + i))
+		_, err := c.Write([]byte(p))
+		require.NoError(t, err)
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+func TestImpairedPacketConn_Drop(t *testing.T) {
+	var delivered [][]string
+	for i := 0; i < 2; i++ {
+		client, server := newImpairedPair(t, WithDropProbability(0.5), WithSeed(42))
+		writePackets(t, client, 20)
+		delivered = append(delivered, readPackets(t, server, time.Millisecond*20))
+		stats := client.Stats()
+		require.Equal(t, uint64(20), stats.Written)
+		require.NotZero(t, stats.Dropped)
+		require.Len(t, delivered[i], int(stats.Written-stats.Dropped))
+		client.Close()
+		server.Close()
+	}
+	// the same seed drops the same packets
+	require.Equal(t, delivered[0], delivered[1])
+}
+
+func TestImpairedPacketConn_Duplicate(t *testing.T) {
+	client, server := newImpairedPair(t, WithDuplicateProbability(1))
+	defer server.Close()
+	defer client.Close()
+	writePackets(t, client, 2)
+	require.Equal(t, []string{"a", "a", "b", "b"}, readPackets(t, server, time.Millisecond*20))
+	require.Equal(t, uint64(2), client.Stats().Duplicated)
+}
+
+func TestImpairedPacketConn_Reorder(t *testing.T) {
+	client, server := newImpairedPair(t, WithReorder(4, time.Millisecond*10), WithSeed(3))
+	defer server.Close()
+	defer client.Close()
+	written := writePackets(t, client, 5)
+	delivered := readPackets(t, server, time.Millisecond*50)
+	require.ElementsMatch(t, written, delivered)
+	require.NotEqual(t, written, delivered)
+	require.NotZero(t, client.Stats().Reordered)
+}
+
+func TestImpairedPacketConn_Delay(t *testing.T) {
+	client, server := newImpairedPair(t, WithDelay(UniformDelay(time.Millisecond*40, time.Millisecond*60)))
+	defer server.Close()
+	defer client.Close()
+	start := time.Now()
+	writePackets(t, client, 1)
+	require.Equal(t, []string{"a"}, readPackets(t, server, time.Millisecond*200))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*40))
+
+	client.Close()
+	_, err := client.Write([]byte("b"))
+	require.Error(t, err)
+}
@@ -0,0 +1,564 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+const (
+	memoryQueueSize  = 1024
+	memoryFirstPort  = 10000
+	memoryDefaultIP  = "127.0.0.1"
+	memoryMaxPort    = 65535
+	memoryNetworkUDP = "udp"
+	memoryNetworkTCP = "tcp"
+)
+
+var errMemoryConnClosed = errors.New("use of closed network connection")
+
+// memoryTimeoutError is returned when a deadline of an in-memory connection is exceeded.
+type memoryTimeoutError struct{}
+
+func (memoryTimeoutError) Error() string   { return "i/o timeout" }
+func (memoryTimeoutError) Timeout() bool   { return true }
+func (memoryTimeoutError) Temporary() bool { return true }
+
+// memoryDeadline is a deadline of read or write operations.
+type memoryDeadline struct {
+	t atomic.Value
+}
+
+func (d *memoryDeadline) set(t time.Time) {
+	d.t.Store(t)
+}
+
+// wait returns channel which is closed when the deadline is exceeded and function which releases the timer.
+func (d *memoryDeadline) wait() (<-chan time.Time, func()) {
+	t, _ := d.t.Load().(time.Time)
+	if t.IsZero() {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(t))
+	return timer.C, func() { timer.Stop() }
+}
+
+// MemoryNetwork connects in-memory packet and stream connections, so servers and clients can communicate
+// without sockets, eg. in tests. Addresses have the form host:port, an empty host means 127.0.0.1 and
+// a zero port allocates a free port.
+type MemoryNetwork struct {
+	mutex     sync.Mutex
+	nextPort  int
+	packets   map[string]*MemoryPacketConn
+	listeners map[string]*MemoryListener
+}
+
+// NewMemoryNetwork creates an in-memory network.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		nextPort:  memoryFirstPort,
+		packets:   make(map[string]*MemoryPacketConn),
+		listeners: make(map[string]*MemoryListener),
+	}
+}
+
+func (n *MemoryNetwork) isUsed(network, addr string) bool {
+	if network == memoryNetworkUDP {
+		_, ok := n.packets[addr]
+		return ok
+	}
+	_, ok := n.listeners[addr]
+	return ok
+}
+
+// allocate resolves the address and allocates a free port when the port is zero.
+func (n *MemoryNetwork) allocate(network, addr string) (net.IP, int, error) {
+	ip := net.ParseIP(memoryDefaultIP)
+	port := 0
+	if addr != "" {
+		host, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, 0, err
+		}
+		if host != "" {
+			if ip = net.ParseIP(host); ip == nil {
+				return nil, 0, fmt.Errorf("invalid ip %v", host)
+			}
+		}
+		if port, err = strconv.Atoi(p); err != nil || port < 0 || port > memoryMaxPort {
+			return nil, 0, fmt.Errorf("invalid port %v", p)
+		}
+	}
+	if port != 0 {
+		if n.isUsed(network, net.JoinHostPort(ip.String(), strconv.Itoa(port))) {
+			return nil, 0, fmt.Errorf("address %v is already in use", addr)
+		}
+		return ip, port, nil
+	}
+	for i := memoryFirstPort; i <= memoryMaxPort; i++ {
+		port = n.nextPort
+		n.nextPort++
+		if n.nextPort > memoryMaxPort {
+			n.nextPort = memoryFirstPort
+		}
+		if !n.isUsed(network, net.JoinHostPort(ip.String(), strconv.Itoa(port))) {
+			return ip, port, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no free port")
+}
+
+// ListenPacket creates a packet connection which receives datagrams sent to addr.
+func (n *MemoryNetwork) ListenPacket(addr string) (*MemoryPacketConn, error) {
+	return n.newPacketConn(addr, nil)
+}
+
+// DialPacket creates a packet connection whose Write sends datagrams to addr.
+func (n *MemoryNetwork) DialPacket(addr string) (*MemoryPacketConn, error) {
+	raddr, err := net.ResolveUDPAddr(memoryNetworkUDP, addr)
+	if err != nil {
+		return nil, err
+	}
+	return n.newPacketConn("", raddr)
+}
+
+// ListenUDP creates a UDPConn over the in-memory packet connection, it can be served by udp.Server.
+func (n *MemoryNetwork) ListenUDP(addr string, opts ...UDPOption) (*UDPConn, error) {
+	c, err := n.ListenPacket(addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPConn(memoryNetworkUDP, c, opts...), nil
+}
+
+func (n *MemoryNetwork) newPacketConn(addr string, raddr *net.UDPAddr) (*MemoryPacketConn, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	ip, port, err := n.allocate(memoryNetworkUDP, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &MemoryPacketConn{
+		network: n,
+		laddr:   &net.UDPAddr{IP: ip, Port: port},
+		raddr:   raddr,
+		packets: make(chan memoryPacket, memoryQueueSize),
+		done:    make(chan struct{}),
+	}
+	n.packets[c.laddr.String()] = c
+	return c, nil
+}
+
+func (n *MemoryNetwork) packetConn(addr *net.UDPAddr) (*MemoryPacketConn, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	c, ok := n.packets[addr.String()]
+	return c, ok
+}
+
+// Listen creates a stream listener, it can be served by tcp.Server.
+func (n *MemoryNetwork) Listen(addr string) (*MemoryListener, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	ip, port, err := n.allocate(memoryNetworkTCP, addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &MemoryListener{
+		network: n,
+		addr:    &net.TCPAddr{IP: ip, Port: port},
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	n.listeners[l.addr.String()] = l
+	return l, nil
+}
+
+// ListenDTLS creates a listener which accepts DTLS connections over in-memory streams, it can be served by dtls.Server.
+func (n *MemoryNetwork) ListenDTLS(addr string, cfg *dtls.Config) (*MemoryDTLSListener, error) {
+	l, err := n.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryDTLSListener{MemoryListener: l, cfg: cfg}, nil
+}
+
+// Dial connects to the stream listener.
+func (n *MemoryNetwork) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	raddr, err := net.ResolveTCPAddr(memoryNetworkTCP, addr)
+	if err != nil {
+		return nil, err
+	}
+	n.mutex.Lock()
+	l, ok := n.listeners[raddr.String()]
+	if !ok {
+		n.mutex.Unlock()
+		return nil, fmt.Errorf("cannot dial %v: connection refused", addr)
+	}
+	ip, port, err := n.allocate(memoryNetworkTCP, "")
+	n.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	client, server := newMemoryConnPair(&net.TCPAddr{IP: ip, Port: port}, l.addr)
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, fmt.Errorf("cannot dial %v: connection refused", addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// DialDTLS connects to the DTLS listener and performs the handshake.
+func (n *MemoryNetwork) DialDTLS(ctx context.Context, addr string, cfg *dtls.Config) (*dtls.Conn, error) {
+	c, err := n.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dtls.ClientWithContext(ctx, c, cfg)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+type memoryPacket struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// MemoryPacketConn is an in-memory packet connection. Datagrams sent to unknown addresses
+// or to connections with the full queue are dropped as in UDP.
+type MemoryPacketConn struct {
+	network *MemoryNetwork
+	laddr   *net.UDPAddr
+	raddr   *net.UDPAddr
+	packets chan memoryPacket
+
+	readDeadline  memoryDeadline
+	writeDeadline memoryDeadline
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// ReadFromUDP reads a datagram and returns the address of the sender.
+func (c *MemoryPacketConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	deadline, release := c.readDeadline.wait()
+	defer release()
+	select {
+	case p := <-c.packets:
+		return copy(b, p.data), p.from, nil
+	case <-c.done:
+		return 0, nil, errMemoryConnClosed
+	case <-deadline:
+		return 0, nil, memoryTimeoutError{}
+	}
+}
+
+// ReadFrom reads a datagram and returns the address of the sender.
+func (c *MemoryPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.ReadFromUDP(b)
+	if err != nil {
+		return n, nil, err
+	}
+	return n, addr, nil
+}
+
+// Read reads a datagram.
+func (c *MemoryPacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFromUDP(b)
+	return n, err
+}
+
+// WriteToUDP sends the datagram to addr.
+func (c *MemoryPacketConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, errMemoryConnClosed
+	default:
+	}
+	deadline, release := c.writeDeadline.wait()
+	defer release()
+	select {
+	case <-deadline:
+		return 0, memoryTimeoutError{}
+	default:
+	}
+	dst, ok := c.network.packetConn(addr)
+	if !ok {
+		return len(b), nil
+	}
+	p := memoryPacket{
+		data: append([]byte(nil), b...),
+		from: &net.UDPAddr{IP: c.laddr.IP, Port: c.laddr.Port},
+	}
+	select {
+	case dst.packets <- p:
+	default:
+	}
+	return len(b), nil
+}
+
+// WriteTo sends the datagram to addr.
+func (c *MemoryPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("invalid address %v", addr)
+	}
+	return c.WriteToUDP(b, udpAddr)
+}
+
+// Write sends the datagram to the address of DialPacket.
+func (c *MemoryPacketConn) Write(b []byte) (int, error) {
+	if c.raddr == nil {
+		return 0, fmt.Errorf("connection is not connected")
+	}
+	return c.WriteToUDP(b, c.raddr)
+}
+
+// Close closes the connection.
+func (c *MemoryPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.network.mutex.Lock()
+		defer c.network.mutex.Unlock()
+		delete(c.network.packets, c.laddr.String())
+	})
+	return nil
+}
+
+// LocalAddr returns the local address.
+func (c *MemoryPacketConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr returns the address of DialPacket, nil is returned for the connection of ListenPacket.
+func (c *MemoryPacketConn) RemoteAddr() net.Addr {
+	if c.raddr == nil {
+		return nil
+	}
+	return c.raddr
+}
+
+func (c *MemoryPacketConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *MemoryPacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *MemoryPacketConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// MemoryListener accepts in-memory stream connections.
+type MemoryListener struct {
+	network   *MemoryNetwork
+	addr      *net.TCPAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// AcceptWithContext waits with context for a connection.
+func (l *MemoryListener) AcceptWithContext(ctx context.Context) (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, ErrListenerIsClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept waits for a connection.
+func (l *MemoryListener) Accept() (net.Conn, error) {
+	return l.AcceptWithContext(context.Background())
+}
+
+// Close closes the listener.
+func (l *MemoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.network.mutex.Lock()
+		defer l.network.mutex.Unlock()
+		delete(l.network.listeners, l.addr.String())
+	})
+	return nil
+}
+
+// Addr returns the address of the listener.
+func (l *MemoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// MemoryDTLSListener accepts DTLS connections over in-memory streams.
+type MemoryDTLSListener struct {
+	*MemoryListener
+	cfg *dtls.Config
+}
+
+// AcceptWithContext waits with context for a connection and performs the DTLS handshake.
+func (l *MemoryDTLSListener) AcceptWithContext(ctx context.Context) (net.Conn, error) {
+	c, err := l.MemoryListener.AcceptWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dtls.ServerWithContext(ctx, c, l.cfg)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cannot perform handshake: %w", err)
+	}
+	return conn, nil
+}
+
+// Accept waits for a connection and performs the DTLS handshake.
+func (l *MemoryDTLSListener) Accept() (net.Conn, error) {
+	return l.AcceptWithContext(context.Background())
+}
+
+// memoryPipe transfers chunks of data in one direction. Each chunk is read by one Read
+// when the buffer is large enough, so datagram protocols as DTLS can use it.
+type memoryPipe struct {
+	data         chan []byte
+	writerClosed chan struct{}
+	readerClosed chan struct{}
+	closeWriter  sync.Once
+	closeReader  sync.Once
+}
+
+func newMemoryPipe() *memoryPipe {
+	return &memoryPipe{
+		data:         make(chan []byte, memoryQueueSize),
+		writerClosed: make(chan struct{}),
+		readerClosed: make(chan struct{}),
+	}
+}
+
+// memoryConn is an in-memory stream connection.
+type memoryConn struct {
+	laddr net.Addr
+	raddr net.Addr
+	in    *memoryPipe
+	out   *memoryPipe
+
+	readMutex sync.Mutex
+	leftover  []byte
+
+	readDeadline  memoryDeadline
+	writeDeadline memoryDeadline
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+func newMemoryConnPair(clientAddr, serverAddr net.Addr) (net.Conn, net.Conn) {
+	a, b := newMemoryPipe(), newMemoryPipe()
+	client := &memoryConn{laddr: clientAddr, raddr: serverAddr, in: a, out: b, done: make(chan struct{})}
+	server := &memoryConn{laddr: serverAddr, raddr: clientAddr, in: b, out: a, done: make(chan struct{})}
+	return client, server
+}
+
+func (c *memoryConn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	if len(c.leftover) > 0 {
+		n := copy(b, c.leftover)
+		c.leftover = c.leftover[n:]
+		return n, nil
+	}
+	deadline, release := c.readDeadline.wait()
+	defer release()
+	select {
+	case data := <-c.in.data:
+		return c.consume(b, data), nil
+	case <-c.in.writerClosed:
+		select {
+		case data := <-c.in.data:
+			return c.consume(b, data), nil
+		default:
+			return 0, io.EOF
+		}
+	case <-c.done:
+		return 0, errMemoryConnClosed
+	case <-deadline:
+		return 0, memoryTimeoutError{}
+	}
+}
+
+func (c *memoryConn) consume(b []byte, data []byte) int {
+	n := copy(b, data)
+	c.leftover = data[n:]
+	return n
+}
+
+func (c *memoryConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, errMemoryConnClosed
+	case <-c.out.readerClosed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	deadline, release := c.writeDeadline.wait()
+	defer release()
+	select {
+	case c.out.data <- append([]byte(nil), b...):
+		return len(b), nil
+	case <-c.out.readerClosed:
+		return 0, io.ErrClosedPipe
+	case <-c.done:
+		return 0, errMemoryConnClosed
+	case <-deadline:
+		return 0, memoryTimeoutError{}
+	}
+}
+
+func (c *memoryConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.out.closeWriter.Do(func() { close(c.out.writerClosed) })
+		c.in.closeReader.Do(func() { close(c.in.readerClosed) })
+	})
+	return nil
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *memoryConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
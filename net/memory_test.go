@@ -0,0 +1,85 @@
+package net
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryNetwork_Packet(t *testing.T) {
+	network := NewMemoryNetwork()
+	server, err := network.ListenPacket("127.0.0.1:5683")
+	require.NoError(t, err)
+	defer server.Close()
+	_, err = network.ListenPacket("127.0.0.1:5683")
+	require.Error(t, err)
+
+	client, err := network.DialPacket(server.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, from, err := server.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf[:n]))
+	require.Equal(t, client.LocalAddr().String(), from.String())
+
+	_, err = server.WriteToUDP([]byte("pong"), from)
+	require.NoError(t, err)
+	n, _, err = client.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf[:n]))
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Millisecond*10)))
+	_, _, err = client.ReadFromUDP(buf)
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+}
+
+func TestMemoryNetwork_Stream(t *testing.T) {
+	network := NewMemoryNetwork()
+	l, err := network.Listen("")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.AcceptWithContext(ctx)
+		require.NoError(t, err)
+		accepted <- c
+	}()
+	client, err := network.Dial(ctx, l.Addr().String())
+	require.NoError(t, err)
+	server := <-accepted
+	require.Equal(t, client.LocalAddr(), server.RemoteAddr())
+
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 3)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hel", string(buf[:n]))
+	n, err = server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "lo", string(buf[:n]))
+
+	require.NoError(t, client.Close())
+	_, err = server.Read(buf)
+	require.Equal(t, io.EOF, err)
+	_, err = server.Write([]byte("a"))
+	require.Error(t, err)
+
+	require.NoError(t, l.Close())
+	_, err = l.AcceptWithContext(ctx)
+	require.Equal(t, ErrListenerIsClosed, err)
+	_, err = network.Dial(ctx, l.Addr().String())
+	require.Error(t, err)
+}
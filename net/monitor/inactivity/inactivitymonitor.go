@@ -4,6 +4,8 @@ import (
 	"context"
 	"sync/atomic"
 	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
 )
 
 type Monitor = interface {
@@ -21,12 +23,13 @@ type ClientConn = interface {
 type inactivityMonitor struct {
 	duration   time.Duration
 	onInactive OnInactiveFunc
+	clock      clock.Clock
 	// lastActivity stores time.Time
 	lastActivity atomic.Value
 }
 
 func (m *inactivityMonitor) Notify() {
-	m.lastActivity.Store(time.Now())
+	m.lastActivity.Store(m.clock.Now())
 }
 
 func (m *inactivityMonitor) LastActivity() time.Time {
@@ -41,9 +44,15 @@ func CloseClientConn(cc ClientConn) {
 }
 
 func NewInactivityMonitor(duration time.Duration, onInactive OnInactiveFunc) Monitor {
+	return NewInactivityMonitorWithClock(duration, onInactive, nil)
+}
+
+// NewInactivityMonitorWithClock creates monitor which measures inactivity by the clock, nil means the clock of the system.
+func NewInactivityMonitorWithClock(duration time.Duration, onInactive OnInactiveFunc, clk clock.Clock) Monitor {
 	m := &inactivityMonitor{
 		duration:   duration,
 		onInactive: onInactive,
+		clock:      clock.Get(clk),
 	}
 	m.Notify()
 	return m
@@ -53,7 +62,7 @@ func (m *inactivityMonitor) CheckInactivity(cc ClientConn) {
 	if m.onInactive == nil || m.duration == time.Duration(0) {
 		return
 	}
-	if time.Until(m.LastActivity().Add(m.duration)) <= 0 {
+	if m.clock.Until(m.LastActivity().Add(m.duration)) <= 0 {
 		m.onInactive(cc)
 	}
 }
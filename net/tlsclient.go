@@ -0,0 +1,42 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// TLSClient performs client TLS handshake over the connection, the handshake is aborted when ctx is done.
+// When the config doesn't contain ServerName, the serverName is used for verification of the server certificate.
+func TLSClient(ctx context.Context, conn net.Conn, cfg *tls.Config, serverName string) (*tls.Conn, error) {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		cfg = cfg.Clone()
+		cfg.ServerName = serverName
+	}
+	c := tls.Client(conn, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Handshake()
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -5,7 +5,7 @@ import (
 )
 
 // WriteToUDP acts just like net.UDPConn.WriteTo(), but uses a *SessionUDP instead of a net.Addr.
-func WriteToUDP(conn *net.UDPConn, raddr *net.UDPAddr, b []byte) (int, error) {
+func WriteToUDP(conn UDPPacketConn, raddr *net.UDPAddr, b []byte) (int, error) {
 	if conn.RemoteAddr() == nil {
 		// Connection remote address must be nil otherwise
 		// "WriteTo with pre-connected connection" will be thrown
@@ -0,0 +1,114 @@
+package coap
+
+import (
+	"crypto/tls"
+	"net"
+
+	piondtls "github.com/pion/dtls/v2"
+	"github.com/plgd-dev/go-coap/v2/dtls"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+)
+
+// A DialOption sets options of Dial.
+type DialOption interface {
+	applyDial(*dialOptions)
+}
+
+// DialerOpt dialer option.
+type DialerOpt struct {
+	dialer *net.Dialer
+}
+
+func (o DialerOpt) applyDial(opts *dialOptions) {
+	if o.dialer != nil {
+		opts.dialer = o.dialer
+	}
+}
+
+// WithDialer set dialer which resolves the host and connects to it.
+func WithDialer(dialer *net.Dialer) DialerOpt {
+	return DialerOpt{
+		dialer: dialer,
+	}
+}
+
+// TLSOpt tls configuration option.
+type TLSOpt struct {
+	tlsCfg *tls.Config
+}
+
+func (o TLSOpt) applyDial(opts *dialOptions) {
+	opts.tlsCfg = o.tlsCfg
+}
+
+// WithTLS set's tls configuration for coaps+tcp and coaps+ws schemes.
+func WithTLS(cfg *tls.Config) TLSOpt {
+	return TLSOpt{
+		tlsCfg: cfg,
+	}
+}
+
+// DTLSOpt dtls configuration option.
+type DTLSOpt struct {
+	dtlsCfg *piondtls.Config
+}
+
+func (o DTLSOpt) applyDial(opts *dialOptions) {
+	opts.dtlsCfg = o.dtlsCfg
+}
+
+// WithDTLS set's dtls configuration for coaps scheme.
+func WithDTLS(cfg *piondtls.Config) DTLSOpt {
+	return DTLSOpt{
+		dtlsCfg: cfg,
+	}
+}
+
+// UDPOpt options of udp client.
+type UDPOpt struct {
+	opts []udp.DialOption
+}
+
+func (o UDPOpt) applyDial(opts *dialOptions) {
+	opts.udpOpts = append(opts.udpOpts, o.opts...)
+}
+
+// WithUDPOptions set's options of the client for coap scheme.
+func WithUDPOptions(opts ...udp.DialOption) UDPOpt {
+	return UDPOpt{
+		opts: opts,
+	}
+}
+
+// DTLSClientOpt options of dtls client.
+type DTLSClientOpt struct {
+	opts []dtls.DialOption
+}
+
+func (o DTLSClientOpt) applyDial(opts *dialOptions) {
+	opts.dtlsOpts = append(opts.dtlsOpts, o.opts...)
+}
+
+// WithDTLSOptions set's options of the client for coaps scheme.
+func WithDTLSOptions(opts ...dtls.DialOption) DTLSClientOpt {
+	return DTLSClientOpt{
+		opts: opts,
+	}
+}
+
+// TCPOpt options of tcp client.
+type TCPOpt struct {
+	opts []tcp.DialOption
+}
+
+func (o TCPOpt) applyDial(opts *dialOptions) {
+	opts.tcpOpts = append(opts.tcpOpts, o.opts...)
+}
+
+// WithTCPOptions set's options of the client for coap+tcp, coaps+tcp, coap+ws and coaps+ws schemes.
+func WithTCPOptions(opts ...tcp.DialOption) TCPOpt {
+	return TCPOpt{
+		opts: opts,
+	}
+}
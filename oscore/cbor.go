@@ -0,0 +1,45 @@
+package oscore
+
+// Minimal CBOR (RFC 7049) encoder for the structures defined by RFC 8613.
+
+const (
+	cborMajorUint   = 0
+	cborMajorBytes  = 2
+	cborMajorString = 3
+	cborMajorArray  = 4
+	cborNull        = 0xf6
+)
+
+func appendCBORHead(buf []byte, major byte, v uint64) []byte {
+	major <<= 5
+	switch {
+	case v < 24:
+		return append(buf, major|byte(v))
+	case v <= 0xff:
+		return append(buf, major|24, byte(v))
+	case v <= 0xffff:
+		return append(buf, major|25, byte(v>>8), byte(v))
+	case v <= 0xffffffff:
+		return append(buf, major|26, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(buf, major|27, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+func appendCBORUint(buf []byte, v uint64) []byte {
+	return appendCBORHead(buf, cborMajorUint, v)
+}
+
+func appendCBORBytes(buf []byte, v []byte) []byte {
+	buf = appendCBORHead(buf, cborMajorBytes, uint64(len(v)))
+	return append(buf, v...)
+}
+
+func appendCBORString(buf []byte, v string) []byte {
+	buf = appendCBORHead(buf, cborMajorString, uint64(len(v)))
+	return append(buf, v...)
+}
+
+func appendCBORArray(buf []byte, length int) []byte {
+	return appendCBORHead(buf, cborMajorArray, uint64(length))
+}
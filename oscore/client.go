@@ -0,0 +1,171 @@
+package oscore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+// Client protects requests of the underlying client by OSCORE and verifies the responses.
+// It wraps clients of udp, dtls and tcp connections, eg. udp/client.ClientConn.Client().
+type Client struct {
+	cc  mux.Client
+	ctx *SecurityContext
+}
+
+// NewClient creates client which protects messages by the security context.
+func NewClient(cc mux.Client, ctx *SecurityContext) *Client {
+	return &Client{
+		cc:  cc,
+		ctx: ctx,
+	}
+}
+
+// SecurityContext returns security context of the client.
+func (c *Client) SecurityContext() *SecurityContext {
+	return c.ctx
+}
+
+func newRequest(ctx context.Context, code codes.Code, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	token, err := message.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token: %w", err)
+	}
+	options := make(message.Options, 0, len(opts)+4)
+	for _, o := range opts {
+		options = options.Add(o)
+	}
+	buf := make([]byte, 64)
+	options, n, err := options.SetPath(buf, path)
+	if err == message.ErrTooSmall {
+		buf = append(buf, make([]byte, n)...)
+		options, _, err = options.SetPath(buf, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot set path: %w", err)
+	}
+	if payload != nil {
+		options, _, err = options.SetContentFormat(make([]byte, 2), contentFormat)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set content format: %w", err)
+		}
+	}
+	return &message.Message{
+		Context: ctx,
+		Token:   token,
+		Code:    code,
+		Options: options,
+		Body:    payload,
+	}, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, code codes.Code, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	req, err := newRequest(ctx, code, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Get issues a GET to the specified path.
+func (c *Client) Get(ctx context.Context, path string, opts ...message.Option) (*message.Message, error) {
+	return c.doRequest(ctx, codes.GET, path, 0, nil, opts...)
+}
+
+// Delete deletes the resource identified by the request path.
+func (c *Client) Delete(ctx context.Context, path string, opts ...message.Option) (*message.Message, error) {
+	return c.doRequest(ctx, codes.DELETE, path, 0, nil, opts...)
+}
+
+// Post issues a POST to the specified path.
+func (c *Client) Post(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	return c.doRequest(ctx, codes.POST, path, contentFormat, payload, opts...)
+}
+
+// Put issues a PUT to the specified path.
+func (c *Client) Put(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	return c.doRequest(ctx, codes.PUT, path, contentFormat, payload, opts...)
+}
+
+// Fetch issues a FETCH to the specified path.
+func (c *Client) Fetch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	return c.doRequest(ctx, codes.FETCH, path, contentFormat, payload, opts...)
+}
+
+// Patch issues a PATCH to the specified path.
+func (c *Client) Patch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	return c.doRequest(ctx, codes.PATCH, path, contentFormat, payload, opts...)
+}
+
+// IPatch issues an iPATCH to the specified path.
+func (c *Client) IPatch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	return c.doRequest(ctx, codes.IPATCH, path, contentFormat, payload, opts...)
+}
+
+// Observe is not supported, it returns ErrObserveNotSupported.
+func (c *Client) Observe(ctx context.Context, path string, observeFunc func(notification *message.Message), opts ...message.Option) (mux.Observation, error) {
+	return nil, ErrObserveNotSupported
+}
+
+// Do sends the protected request and returns the verified response.
+// An unprotected response, eg. error response of the server which cannot verify the request, is returned as error.
+func (c *Client) Do(req *message.Message) (*message.Message, error) {
+	protected, binding, err := c.ctx.protectRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot protect request: %w", err)
+	}
+	resp, err := c.cc.Do(protected)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Options.HasOption(message.OSCORE) {
+		return nil, fmt.Errorf("%w: response code %v", ErrUnprotectedMessage, resp.Code)
+	}
+	r, err := c.ctx.unprotectResponse(resp, binding)
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify response: %w", err)
+	}
+	return r, nil
+}
+
+// WriteMessage sends the protected message without waiting for the response.
+func (c *Client) WriteMessage(req *message.Message) error {
+	protected, _, err := c.ctx.protectRequest(req)
+	if err != nil {
+		return fmt.Errorf("cannot protect request: %w", err)
+	}
+	return c.cc.WriteMessage(protected)
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.cc.Ping(ctx)
+}
+
+func (c *Client) ClientConn() interface{} {
+	return c.cc.ClientConn()
+}
+
+func (c *Client) RemoteAddr() net.Addr {
+	return c.cc.RemoteAddr()
+}
+
+func (c *Client) Context() context.Context {
+	return c.cc.Context()
+}
+
+func (c *Client) SetContextValue(key interface{}, val interface{}) {
+	c.cc.SetContextValue(key, val)
+}
+
+func (c *Client) Close() error {
+	return c.cc.Close()
+}
+
+func (c *Client) Sequence() uint64 {
+	return c.cc.Sequence()
+}
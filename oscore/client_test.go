@@ -0,0 +1,122 @@
+package oscore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/oscore"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/require"
+)
+
+func newSecurityContexts(t *testing.T) (client *oscore.SecurityContext, server *oscore.SecurityContext) {
+	secret := []byte("0123456789abcdef")
+	salt := []byte("salt")
+	client, err := oscore.NewSecurityContext(secret, salt, nil, []byte("c"), []byte("s"), oscore.NewMemoryStorage())
+	require.NoError(t, err)
+	server, err = oscore.NewSecurityContext(secret, salt, nil, []byte("s"), []byte("c"), oscore.NewMemoryStorage())
+	require.NoError(t, err)
+	return client, server
+}
+
+func newRouter(t *testing.T) *mux.Router {
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+		}
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(append([]byte(r.Code.String()+":"), body...)))
+		require.NoError(t, err)
+	}))
+	return m
+}
+
+func testClient(t *testing.T, c mux.Client, wrongClient mux.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	resp, err := c.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, []byte("GET:"), body)
+
+	resp, err = c.Post(ctx, "/a", message.TextPlain, bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, []byte("POST:data"), body)
+
+	resp, err = c.Get(ctx, "/b")
+	require.NoError(t, err)
+	require.Equal(t, codes.NotFound, resp.Code)
+
+	_, err = wrongClient.Get(ctx, "/a")
+	require.ErrorIs(t, err, oscore.ErrUnprotectedMessage)
+}
+
+func TestClient_UDP(t *testing.T) {
+	clientCtx, serverCtx := newSecurityContexts(t)
+	_, otherCtx := newSecurityContexts(t)
+
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	s := udp.NewServer(udp.WithMux(oscore.NewHandler(newRouter(t), serverCtx)))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	testClient(t, oscore.NewClient(cc.Client(), clientCtx), oscore.NewClient(cc.Client(), otherCtx))
+}
+
+func TestClient_TCP(t *testing.T) {
+	clientCtx, serverCtx := newSecurityContexts(t)
+	_, otherCtx := newSecurityContexts(t)
+
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	s := tcp.NewServer(tcp.WithMux(oscore.NewHandler(newRouter(t), serverCtx)))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := tcp.Dial(l.Addr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	testClient(t, oscore.NewClient(cc.Client(), clientCtx), oscore.NewClient(cc.Client(), otherCtx))
+}
@@ -0,0 +1,26 @@
+package oscore
+
+import "errors"
+
+var (
+	// ErrSecurityContextNotFound no security context matches the kid of the message.
+	ErrSecurityContextNotFound = errors.New("security context not found")
+
+	// ErrDecryptionFailed the message cannot be verified by the security context.
+	ErrDecryptionFailed = errors.New("decryption failed")
+
+	// ErrReplayDetected the Partial IV of the message was already received.
+	ErrReplayDetected = errors.New("replay detected")
+
+	// ErrInvalidOption the OSCORE option has invalid value.
+	ErrInvalidOption = errors.New("invalid OSCORE option")
+
+	// ErrUnprotectedMessage the message doesn't contain OSCORE option.
+	ErrUnprotectedMessage = errors.New("message is not protected by OSCORE")
+
+	// ErrSequenceNumberExhausted all sender sequence numbers were used, a new security context must be established.
+	ErrSequenceNumberExhausted = errors.New("sender sequence number is exhausted")
+
+	// ErrObserveNotSupported observation of resources is not supported over OSCORE.
+	ErrObserveNotSupported = errors.New("observe is not supported over OSCORE")
+)
@@ -0,0 +1,128 @@
+package oscore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+type contextKey struct {
+	kid        string
+	kidContext string
+}
+
+type handler struct {
+	next     mux.Handler
+	contexts map[string][]*SecurityContext
+}
+
+// NewHandler creates handler which verifies requests by the security context matching their kid,
+// serves them by next and protects the responses. It must wrap the router, because the request path
+// is encrypted.
+//
+// Requests without OSCORE option are rejected by 4.01 Unauthorized.
+func NewHandler(next mux.Handler, contexts ...*SecurityContext) mux.Handler {
+	h := &handler{
+		next:     next,
+		contexts: make(map[string][]*SecurityContext),
+	}
+	for _, c := range contexts {
+		kid := string(c.RecipientID())
+		h.contexts[kid] = append(h.contexts[kid], c)
+	}
+	return h
+}
+
+func (h *handler) findContext(opt optionValue) (*SecurityContext, error) {
+	for _, c := range h.contexts[string(opt.kid)] {
+		if opt.kidContext == nil || bytes.Equal(c.IDContext(), opt.kidContext) {
+			return c, nil
+		}
+	}
+	return nil, ErrSecurityContextNotFound
+}
+
+// setError sends unprotected error response: https://tools.ietf.org/html/rfc8613#section-8.2
+func setError(w mux.ResponseWriter, code codes.Code, err error) {
+	w.SetResponse(code, message.TextPlain, bytes.NewReader([]byte(err.Error())), message.Option{ID: message.MaxAge, Value: []byte{}})
+}
+
+func (h *handler) ServeCOAP(w mux.ResponseWriter, r *mux.Message) {
+	opt, err := getOptionValue(r.Message)
+	if err != nil {
+		if errors.Is(err, ErrUnprotectedMessage) {
+			setError(w, codes.Unauthorized, err)
+			return
+		}
+		setError(w, codes.BadOption, err)
+		return
+	}
+	ctx, err := h.findContext(opt)
+	if err != nil {
+		setError(w, codes.Unauthorized, err)
+		return
+	}
+	req, binding, err := ctx.unprotectRequest(r.Message, opt)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrReplayDetected):
+		setError(w, codes.Unauthorized, err)
+		return
+	case errors.Is(err, ErrInvalidOption):
+		setError(w, codes.BadOption, err)
+		return
+	default:
+		setError(w, codes.BadRequest, ErrDecryptionFailed)
+		return
+	}
+	h.next.ServeCOAP(&responseWriter{
+		w:       w,
+		ctx:     ctx,
+		binding: binding,
+		request: req,
+	}, &mux.Message{
+		Message:        req,
+		SequenceNumber: r.SequenceNumber,
+		IsConfirmable:  r.IsConfirmable,
+	})
+}
+
+type responseWriter struct {
+	w       mux.ResponseWriter
+	ctx     *SecurityContext
+	binding requestBinding
+	request *message.Message
+}
+
+func (w *responseWriter) SetResponse(code codes.Code, contentFormat message.MediaType, d io.ReadSeeker, opts ...message.Option) error {
+	options := make(message.Options, 0, len(opts)+1)
+	for _, o := range opts {
+		options = options.Add(o)
+	}
+	if d != nil {
+		var err error
+		options, _, err = options.SetContentFormat(make([]byte, 2), contentFormat)
+		if err != nil {
+			return err
+		}
+	}
+	protected, err := w.ctx.protectResponse(&message.Message{
+		Context: w.request.Context,
+		Token:   w.request.Token,
+		Code:    code,
+		Options: options,
+		Body:    d,
+	}, w.binding)
+	if err != nil {
+		return err
+	}
+	return w.w.SetResponse(protected.Code, message.AppOctets, protected.Body, protected.Options...)
+}
+
+func (w *responseWriter) Client() mux.Client {
+	return w.w.Client()
+}
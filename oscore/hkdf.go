@@ -0,0 +1,25 @@
+package oscore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// hkdfSHA256 derives key material by HKDF (RFC 5869) with SHA-256.
+func hkdfSHA256(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	out := make([]byte, 0, length+sha256.Size)
+	var prev []byte
+	for i := byte(1); len(out) < length; i++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(prev)
+		expand.Write(info)
+		expand.Write([]byte{i})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,102 @@
+package oscore
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	flagPartialIVLength = 0x07
+	flagKid             = 0x08
+	flagKidContext      = 0x10
+	flagReserved        = 0xe0
+
+	// maxPartialIVLength Partial IV is at most 5 bytes long.
+	maxPartialIVLength = 5
+	maxSequenceNumber  = 1<<(8*maxPartialIVLength) - 1
+)
+
+// optionValue is decoded value of OSCORE option: https://tools.ietf.org/html/rfc8613#section-6.1
+type optionValue struct {
+	partialIV  []byte
+	kid        []byte
+	hasKid     bool
+	kidContext []byte
+}
+
+func (v optionValue) marshal() []byte {
+	if len(v.partialIV) == 0 && !v.hasKid && len(v.kidContext) == 0 {
+		return []byte{}
+	}
+	flags := byte(len(v.partialIV))
+	if v.hasKid {
+		flags |= flagKid
+	}
+	if len(v.kidContext) > 0 {
+		flags |= flagKidContext
+	}
+	buf := make([]byte, 0, 2+len(v.partialIV)+len(v.kidContext)+len(v.kid))
+	buf = append(buf, flags)
+	buf = append(buf, v.partialIV...)
+	if len(v.kidContext) > 0 {
+		buf = append(buf, byte(len(v.kidContext)))
+		buf = append(buf, v.kidContext...)
+	}
+	if v.hasKid {
+		buf = append(buf, v.kid...)
+	}
+	return buf
+}
+
+func parseOptionValue(data []byte) (optionValue, error) {
+	var v optionValue
+	if len(data) == 0 {
+		return v, nil
+	}
+	flags := data[0]
+	data = data[1:]
+	if flags&flagReserved != 0 {
+		return v, fmt.Errorf("%w: reserved flags are set", ErrInvalidOption)
+	}
+	n := int(flags & flagPartialIVLength)
+	if n > maxPartialIVLength {
+		return v, fmt.Errorf("%w: invalid length of Partial IV %v", ErrInvalidOption, n)
+	}
+	if len(data) < n {
+		return v, fmt.Errorf("%w: Partial IV is truncated", ErrInvalidOption)
+	}
+	v.partialIV = data[:n]
+	data = data[n:]
+	if flags&flagKidContext != 0 {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return v, fmt.Errorf("%w: kid context is truncated", ErrInvalidOption)
+		}
+		v.kidContext = data[1 : 1+int(data[0])]
+		data = data[1+int(data[0]):]
+	}
+	if flags&flagKid != 0 {
+		v.hasKid = true
+		v.kid = data
+	} else if len(data) > 0 {
+		return v, fmt.Errorf("%w: unexpected data", ErrInvalidOption)
+	}
+	return v, nil
+}
+
+func encodePartialIV(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	i := 0
+	for i < len(buf)-1 && buf[i] == 0 {
+		i++
+	}
+	return buf[i:]
+}
+
+func decodePartialIV(partialIV []byte) uint64 {
+	var seq uint64
+	for _, b := range partialIV {
+		seq = seq<<8 | uint64(b)
+	}
+	return seq
+}
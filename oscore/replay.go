@@ -0,0 +1,43 @@
+package oscore
+
+const replayWindowSize = 32
+
+// replayWindow is sliding window of received Partial IVs: https://tools.ietf.org/html/rfc8613#section-7.4
+type replayWindow struct {
+	initialized bool
+	highest     uint64
+	// bit n is set when sequence number highest-n was received
+	received uint32
+}
+
+// check returns true when the sequence number was not received yet and it fits to the window.
+func (w *replayWindow) check(seq uint64) bool {
+	if !w.initialized || seq > w.highest {
+		return true
+	}
+	diff := w.highest - seq
+	if diff >= replayWindowSize {
+		return false
+	}
+	return w.received&(1<<diff) == 0
+}
+
+// update marks the sequence number as received.
+func (w *replayWindow) update(seq uint64) {
+	switch {
+	case !w.initialized:
+		w.initialized = true
+		w.highest = seq
+		w.received = 1
+	case seq > w.highest:
+		shift := seq - w.highest
+		if shift >= replayWindowSize {
+			w.received = 1
+		} else {
+			w.received = w.received<<shift | 1
+		}
+		w.highest = seq
+	default:
+		w.received |= 1 << (w.highest - seq)
+	}
+}
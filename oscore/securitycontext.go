@@ -0,0 +1,418 @@
+package oscore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pion/dtls/v2/pkg/crypto/ccm"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+)
+
+const (
+	// algAESCCM16_64_128 COSE algorithm AES-CCM-16-64-128 which is mandatory to implement by RFC 8613.
+	algAESCCM16_64_128 = 10
+	keyLength          = 16
+	nonceLength        = 13
+	tagLength          = 8
+	maxIDLength        = nonceLength - 6
+
+	// sequenceNumberReserve count of sequence numbers which are stored ahead of use.
+	sequenceNumberReserve = 32
+)
+
+// SecurityContext is OSCORE security context (RFC 8613) shared by two endpoints.
+// The sender part protects outgoing messages, the recipient part verifies incoming messages.
+type SecurityContext struct {
+	idContext   []byte
+	senderID    []byte
+	recipientID []byte
+	commonIV    []byte
+
+	senderAEAD    cipher.AEAD
+	recipientAEAD cipher.AEAD
+
+	storage SequenceNumberStorage
+
+	mutex          sync.Mutex
+	senderSequence uint64
+	storedSequence uint64
+	replay         replayWindow
+}
+
+// NewSecurityContext derives security context from master secret and master salt.
+// The algorithm is AES-CCM-16-64-128 with HKDF SHA-256.
+//
+// The storage persists the sender sequence number, nil means that the sequence number
+// starts from 0 and the security context must not be reused after a restart.
+func NewSecurityContext(masterSecret, masterSalt, idContext, senderID, recipientID []byte, storage SequenceNumberStorage) (*SecurityContext, error) {
+	if len(senderID) > maxIDLength {
+		return nil, fmt.Errorf("sender id is longer than %v bytes", maxIDLength)
+	}
+	if len(recipientID) > maxIDLength {
+		return nil, fmt.Errorf("recipient id is longer than %v bytes", maxIDLength)
+	}
+	senderAEAD, err := newAEAD(deriveSecret(masterSecret, masterSalt, senderID, idContext, "Key", keyLength))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create sender cipher: %w", err)
+	}
+	recipientAEAD, err := newAEAD(deriveSecret(masterSecret, masterSalt, recipientID, idContext, "Key", keyLength))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create recipient cipher: %w", err)
+	}
+	var senderSequence uint64
+	if storage != nil {
+		senderSequence, err = storage.Load(idContext, senderID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load sender sequence number: %w", err)
+		}
+	}
+	return &SecurityContext{
+		idContext:      append([]byte(nil), idContext...),
+		senderID:       append([]byte(nil), senderID...),
+		recipientID:    append([]byte(nil), recipientID...),
+		commonIV:       deriveSecret(masterSecret, masterSalt, nil, idContext, "IV", nonceLength),
+		senderAEAD:     senderAEAD,
+		recipientAEAD:  recipientAEAD,
+		storage:        storage,
+		senderSequence: senderSequence,
+		storedSequence: senderSequence,
+	}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return ccm.NewCCM(block, tagLength, nonceLength)
+}
+
+// deriveSecret derives key or common IV: https://tools.ietf.org/html/rfc8613#section-3.2.1
+func deriveSecret(masterSecret, masterSalt, id, idContext []byte, typ string, length int) []byte {
+	info := appendCBORArray(nil, 5)
+	info = appendCBORBytes(info, id)
+	if idContext == nil {
+		info = append(info, cborNull)
+	} else {
+		info = appendCBORBytes(info, idContext)
+	}
+	info = appendCBORUint(info, algAESCCM16_64_128)
+	info = appendCBORString(info, typ)
+	info = appendCBORUint(info, uint64(length))
+	return hkdfSHA256(masterSalt, masterSecret, info, length)
+}
+
+// SenderID returns id of the sender.
+func (c *SecurityContext) SenderID() []byte {
+	return c.senderID
+}
+
+// RecipientID returns id of the recipient.
+func (c *SecurityContext) RecipientID() []byte {
+	return c.recipientID
+}
+
+// IDContext returns id context of the security context.
+func (c *SecurityContext) IDContext() []byte {
+	return c.idContext
+}
+
+func (c *SecurityContext) nextSequenceNumber() (uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.senderSequence > maxSequenceNumber {
+		return 0, ErrSequenceNumberExhausted
+	}
+	if c.storage != nil && c.senderSequence >= c.storedSequence {
+		stored := c.senderSequence + sequenceNumberReserve
+		err := c.storage.Store(c.idContext, c.senderID, stored)
+		if err != nil {
+			return 0, fmt.Errorf("cannot store sender sequence number: %w", err)
+		}
+		c.storedSequence = stored
+	}
+	seq := c.senderSequence
+	c.senderSequence++
+	return seq, nil
+}
+
+func (c *SecurityContext) checkReplay(partialIV []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.replay.check(decodePartialIV(partialIV)) {
+		return ErrReplayDetected
+	}
+	return nil
+}
+
+// acceptReplay checks the Partial IV again and marks it as received in one critical section, so only
+// one of concurrently verified copies of the message is accepted.
+func (c *SecurityContext) acceptReplay(partialIV []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	seq := decodePartialIV(partialIV)
+	if !c.replay.check(seq) {
+		return ErrReplayDetected
+	}
+	c.replay.update(seq)
+	return nil
+}
+
+// nonce computes AEAD nonce: https://tools.ietf.org/html/rfc8613#section-5.2
+func (c *SecurityContext) nonce(id, partialIV []byte) []byte {
+	nonce := make([]byte, nonceLength)
+	nonce[0] = byte(len(id))
+	copy(nonce[1+maxIDLength-len(id):1+maxIDLength], id)
+	copy(nonce[nonceLength-len(partialIV):], partialIV)
+	for i := range nonce {
+		nonce[i] ^= c.commonIV[i]
+	}
+	return nonce
+}
+
+// requestBinding identifies the request for the verification of its responses.
+type requestBinding struct {
+	kid       []byte
+	partialIV []byte
+}
+
+// additionalData creates Enc_structure of COSE_Encrypt0: https://tools.ietf.org/html/rfc8613#section-5.4
+func additionalData(b requestBinding) []byte {
+	externalAAD := appendCBORArray(nil, 5)
+	externalAAD = appendCBORUint(externalAAD, 1)
+	externalAAD = appendCBORArray(externalAAD, 1)
+	externalAAD = appendCBORUint(externalAAD, algAESCCM16_64_128)
+	externalAAD = appendCBORBytes(externalAAD, b.kid)
+	externalAAD = appendCBORBytes(externalAAD, b.partialIV)
+	externalAAD = appendCBORBytes(externalAAD, nil)
+
+	aad := appendCBORArray(nil, 3)
+	aad = appendCBORString(aad, "Encrypt0")
+	aad = appendCBORBytes(aad, nil)
+	return appendCBORBytes(aad, externalAAD)
+}
+
+// isOuterOption returns true for options which are not encrypted (class U).
+func isOuterOption(id message.OptionID) bool {
+	switch id {
+	case message.URIHost, message.URIPort, message.ProxyScheme, message.ProxyURI, message.OSCORE:
+		return true
+	}
+	return false
+}
+
+func splitOptions(opts message.Options) (inner message.Options, outer message.Options) {
+	inner = make(message.Options, 0, len(opts))
+	outer = make(message.Options, 0, 4)
+	for _, o := range opts {
+		switch {
+		case o.ID == message.Observe:
+			// Observe is processed by both the endpoint and proxies.
+			inner = append(inner, o)
+			outer = append(outer, o)
+		case isOuterOption(o.ID):
+			outer = append(outer, o)
+		default:
+			inner = append(inner, o)
+		}
+	}
+	return inner, outer
+}
+
+func readBody(m *message.Message) ([]byte, error) {
+	if m.Body == nil {
+		return nil, nil
+	}
+	if _, err := m.Body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(m.Body)
+}
+
+// encodePlaintext encodes code, inner options and payload: https://tools.ietf.org/html/rfc8613#section-5.3
+func encodePlaintext(code codes.Code, opts message.Options, payload []byte) ([]byte, error) {
+	buf := make([]byte, 256)
+	n, err := opts.Marshal(buf[1:])
+	if err == message.ErrTooSmall {
+		buf = make([]byte, n+1)
+		n, err = opts.Marshal(buf[1:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal options: %w", err)
+	}
+	buf[0] = byte(code)
+	buf = buf[:n+1]
+	if len(payload) > 0 {
+		buf = append(buf, 0xff)
+		buf = append(buf, payload...)
+	}
+	return buf, nil
+}
+
+func decodePlaintext(data []byte) (codes.Code, message.Options, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil, fmt.Errorf("plaintext is empty")
+	}
+	code := codes.Code(data[0])
+	data = data[1:]
+	opts := make(message.Options, 0, len(data))
+	n, err := opts.Unmarshal(data, message.CoapOptionDefs)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("cannot unmarshal options: %w", err)
+	}
+	var payload []byte
+	if n < len(data) {
+		payload = data[n:]
+	}
+	return code, opts, payload, nil
+}
+
+func (c *SecurityContext) seal(aead cipher.AEAD, nonce []byte, b requestBinding, m *message.Message, outerCode codes.Code, opt optionValue) (*message.Message, error) {
+	payload, err := readBody(m)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read payload: %w", err)
+	}
+	inner, outer := splitOptions(m.Options)
+	plaintext, err := encodePlaintext(m.Code, inner, payload)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, additionalData(b))
+	outer = outer.Add(message.Option{ID: message.OSCORE, Value: opt.marshal()})
+	return &message.Message{
+		Context: m.Context,
+		Token:   m.Token,
+		Code:    outerCode,
+		Options: outer,
+		Body:    bytes.NewReader(ciphertext),
+	}, nil
+}
+
+func (c *SecurityContext) open(aead cipher.AEAD, nonce []byte, b requestBinding, m *message.Message) (*message.Message, error) {
+	ciphertext, err := readBody(m)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read payload: %w", err)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData(b))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	code, opts, payload, err := decodePlaintext(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	for _, o := range m.Options {
+		switch {
+		case o.ID == message.OSCORE:
+		case o.ID == message.Observe && !opts.HasOption(message.Observe):
+			opts = opts.Add(o)
+		case isOuterOption(o.ID):
+			opts = opts.Add(o)
+		}
+	}
+	r := &message.Message{
+		Context: m.Context,
+		Token:   m.Token,
+		Code:    code,
+		Options: opts,
+	}
+	if payload != nil {
+		r.Body = bytes.NewReader(payload)
+	}
+	return r, nil
+}
+
+func getOptionValue(m *message.Message) (optionValue, error) {
+	if !m.Options.HasOption(message.OSCORE) {
+		return optionValue{}, ErrUnprotectedMessage
+	}
+	data, err := m.Options.GetBytes(message.OSCORE)
+	if err != nil {
+		return optionValue{}, fmt.Errorf("%w: %v", ErrInvalidOption, err)
+	}
+	return parseOptionValue(data)
+}
+
+// protectRequest encrypts the request by the sender key.
+func (c *SecurityContext) protectRequest(req *message.Message) (*message.Message, requestBinding, error) {
+	seq, err := c.nextSequenceNumber()
+	if err != nil {
+		return nil, requestBinding{}, err
+	}
+	partialIV := encodePartialIV(seq)
+	b := requestBinding{
+		kid:       c.senderID,
+		partialIV: partialIV,
+	}
+	// Outer code of the request is POST: https://tools.ietf.org/html/rfc8613#section-4.2
+	r, err := c.seal(c.senderAEAD, c.nonce(c.senderID, partialIV), b, req, codes.POST, optionValue{
+		partialIV:  partialIV,
+		kid:        c.senderID,
+		hasKid:     true,
+		kidContext: c.idContext,
+	})
+	if err != nil {
+		return nil, requestBinding{}, err
+	}
+	return r, b, nil
+}
+
+// unprotectRequest verifies and decrypts the request by the recipient key.
+func (c *SecurityContext) unprotectRequest(req *message.Message, opt optionValue) (*message.Message, requestBinding, error) {
+	if len(opt.partialIV) == 0 || !opt.hasKid {
+		return nil, requestBinding{}, fmt.Errorf("%w: request must contain Partial IV and kid", ErrInvalidOption)
+	}
+	if err := c.checkReplay(opt.partialIV); err != nil {
+		return nil, requestBinding{}, err
+	}
+	b := requestBinding{
+		kid:       opt.kid,
+		partialIV: opt.partialIV,
+	}
+	r, err := c.open(c.recipientAEAD, c.nonce(c.recipientID, opt.partialIV), b, req)
+	if err != nil {
+		return nil, requestBinding{}, err
+	}
+	if err := c.acceptReplay(opt.partialIV); err != nil {
+		return nil, requestBinding{}, err
+	}
+	return r, b, nil
+}
+
+// protectResponse encrypts the response to the request by the sender key. The response reuses nonce of the request.
+func (c *SecurityContext) protectResponse(resp *message.Message, b requestBinding) (*message.Message, error) {
+	// Outer code of the response is 2.04 Changed, 2.05 Content for notifications: https://tools.ietf.org/html/rfc8613#section-4.2
+	outerCode := codes.Changed
+	if resp.Options.HasOption(message.Observe) {
+		outerCode = codes.Content
+	}
+	return c.seal(c.senderAEAD, c.nonce(b.kid, b.partialIV), b, resp, outerCode, optionValue{})
+}
+
+// unprotectResponse verifies and decrypts the response to the request by the recipient key.
+func (c *SecurityContext) unprotectResponse(resp *message.Message, b requestBinding) (*message.Message, error) {
+	opt, err := getOptionValue(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.partialIV) == 0 {
+		return c.open(c.recipientAEAD, c.nonce(b.kid, b.partialIV), b, resp)
+	}
+	if err := c.checkReplay(opt.partialIV); err != nil {
+		return nil, err
+	}
+	r, err := c.open(c.recipientAEAD, c.nonce(c.recipientID, opt.partialIV), b, resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.acceptReplay(opt.partialIV); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,220 @@
+package oscore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	v, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return v
+}
+
+// Test vectors from https://tools.ietf.org/html/rfc8613#appendix-C
+func TestSecurityContext_Derivation(t *testing.T) {
+	masterSecret := mustDecodeHex(t, "0102030405060708090a0b0c0d0e0f10")
+	masterSalt := mustDecodeHex(t, "9e7ca92223786340")
+
+	require.Equal(t, mustDecodeHex(t, "f0910ed7295e6ad4b54fc793154302ff"), deriveSecret(masterSecret, masterSalt, []byte{}, nil, "Key", keyLength))
+	require.Equal(t, mustDecodeHex(t, "ffb14e093c94c9cac9471648b4f98710"), deriveSecret(masterSecret, masterSalt, []byte{0x01}, nil, "Key", keyLength))
+	require.Equal(t, mustDecodeHex(t, "4622d4dd6d944168eefb54987c"), deriveSecret(masterSecret, masterSalt, nil, nil, "IV", nonceLength))
+}
+
+func TestSecurityContext_ProtectRequest(t *testing.T) {
+	storage := NewMemoryStorage()
+	err := storage.Store(nil, []byte{}, 20)
+	require.NoError(t, err)
+	client, err := NewSecurityContext(mustDecodeHex(t, "0102030405060708090a0b0c0d0e0f10"), mustDecodeHex(t, "9e7ca92223786340"), nil, []byte{}, []byte{0x01}, storage)
+	require.NoError(t, err)
+
+	req := &message.Message{
+		Context: context.Background(),
+		Token:   mustDecodeHex(t, "00003974"),
+		Code:    codes.GET,
+		Options: message.Options{
+			{ID: message.URIHost, Value: []byte("localhost")},
+			{ID: message.URIPath, Value: []byte("tv1")},
+		},
+	}
+	require.Equal(t, mustDecodeHex(t, "8368456e63727970743040488501810a40411440"), additionalData(requestBinding{kid: []byte{}, partialIV: []byte{0x14}}))
+	require.Equal(t, mustDecodeHex(t, "4622d4dd6d944168eefb549868"), client.nonce([]byte{}, []byte{0x14}))
+
+	protected, binding, err := client.protectRequest(req)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x14}, binding.partialIV)
+	require.Equal(t, codes.POST, protected.Code)
+	host, err := protected.Options.GetString(message.URIHost)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", host)
+	opt, err := protected.Options.GetBytes(message.OSCORE)
+	require.NoError(t, err)
+	require.Equal(t, mustDecodeHex(t, "0914"), opt)
+	ciphertext, err := ioutil.ReadAll(protected.Body)
+	require.NoError(t, err)
+	require.Equal(t, mustDecodeHex(t, "612f1092f1776f1c1668b3825e"), ciphertext)
+
+	stored, err := storage.Load(nil, []byte{})
+	require.NoError(t, err)
+	require.Greater(t, stored, uint64(20))
+}
+
+func TestSecurityContext_RequestResponse(t *testing.T) {
+	secret := []byte("secret")
+	salt := []byte("salt")
+	client, err := NewSecurityContext(secret, salt, []byte{0xaa}, []byte{0x01}, []byte{0x02}, nil)
+	require.NoError(t, err)
+	server, err := NewSecurityContext(secret, salt, []byte{0xaa}, []byte{0x02}, []byte{0x01}, nil)
+	require.NoError(t, err)
+
+	opts := make(message.Options, 0, 4)
+	opts, _, err = opts.SetPath(make([]byte, 32), "/a/b")
+	require.NoError(t, err)
+	req := &message.Message{
+		Context: context.Background(),
+		Token:   []byte{1, 2},
+		Code:    codes.PUT,
+		Options: opts,
+		Body:    bytes.NewReader([]byte("request")),
+	}
+	protected, clientBinding, err := client.protectRequest(req)
+	require.NoError(t, err)
+	require.False(t, protected.Options.HasOption(message.URIPath))
+
+	opt, err := getOptionValue(protected)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01}, opt.kid)
+	require.Equal(t, []byte{0xaa}, opt.kidContext)
+	unprotected, serverBinding, err := server.unprotectRequest(protected, opt)
+	require.NoError(t, err)
+	require.Equal(t, codes.PUT, unprotected.Code)
+	path, err := unprotected.Options.Path()
+	require.NoError(t, err)
+	require.Equal(t, "a/b", path)
+	body, err := ioutil.ReadAll(unprotected.Body)
+	require.NoError(t, err)
+	require.Equal(t, []byte("request"), body)
+
+	// replayed request is rejected
+	_, _, err = server.unprotectRequest(protected, opt)
+	require.ErrorIs(t, err, ErrReplayDetected)
+
+	resp, err := server.protectResponse(&message.Message{
+		Context: context.Background(),
+		Token:   []byte{1, 2},
+		Code:    codes.Changed,
+		Body:    bytes.NewReader([]byte("response")),
+	}, serverBinding)
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code)
+	unprotectedResp, err := client.unprotectResponse(resp, clientBinding)
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, unprotectedResp.Code)
+	body, err = ioutil.ReadAll(unprotectedResp.Body)
+	require.NoError(t, err)
+	require.Equal(t, []byte("response"), body)
+
+	// response cannot be used for another request
+	_, err = client.unprotectResponse(resp, requestBinding{kid: clientBinding.kid, partialIV: []byte{0x05}})
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestSecurityContext_ConcurrentReplay(t *testing.T) {
+	secret := []byte("secret")
+	salt := []byte("salt")
+	client, err := NewSecurityContext(secret, salt, nil, []byte{0x01}, []byte{0x02}, nil)
+	require.NoError(t, err)
+	server, err := NewSecurityContext(secret, salt, nil, []byte{0x02}, []byte{0x01}, nil)
+	require.NoError(t, err)
+
+	protected, _, err := client.protectRequest(&message.Message{
+		Context: context.Background(),
+		Token:   []byte{1, 2},
+		Code:    codes.POST,
+		Body:    bytes.NewReader([]byte("request")),
+	})
+	require.NoError(t, err)
+	ciphertext, err := ioutil.ReadAll(protected.Body)
+	require.NoError(t, err)
+	opt, err := getOptionValue(protected)
+	require.NoError(t, err)
+
+	// copies of the same message are verified concurrently, only one of them is accepted
+	const copies = 16
+	var accepted, replayed uint32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < copies; i++ {
+		opts, err := protected.Options.Clone()
+		require.NoError(t, err)
+		m := &message.Message{
+			Context: context.Background(),
+			Token:   protected.Token,
+			Code:    protected.Code,
+			Options: opts,
+			Body:    bytes.NewReader(ciphertext),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, _, err := server.unprotectRequest(m, opt)
+			switch {
+			case err == nil:
+				atomic.AddUint32(&accepted, 1)
+			case errors.Is(err, ErrReplayDetected):
+				atomic.AddUint32(&replayed, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	require.Equal(t, uint32(1), accepted)
+	require.Equal(t, uint32(copies-1), replayed)
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	require.True(t, w.check(5))
+	w.update(5)
+	require.False(t, w.check(5))
+	require.True(t, w.check(4))
+	w.update(40)
+	require.False(t, w.check(5))
+	require.True(t, w.check(39))
+	require.True(t, w.check(9))
+	require.False(t, w.check(8))
+	w.update(9)
+	require.False(t, w.check(9))
+	require.True(t, w.check(41))
+}
+
+func TestOptionValue(t *testing.T) {
+	v := optionValue{
+		partialIV:  []byte{0x01, 0x02},
+		kid:        []byte{0x03},
+		hasKid:     true,
+		kidContext: []byte{0x04, 0x05},
+	}
+	data := v.marshal()
+	require.Equal(t, []byte{0x1a, 0x01, 0x02, 0x02, 0x04, 0x05, 0x03}, data)
+	parsed, err := parseOptionValue(data)
+	require.NoError(t, err)
+	require.Equal(t, v, parsed)
+
+	_, err = parseOptionValue([]byte{0x07})
+	require.ErrorIs(t, err, ErrInvalidOption)
+	_, err = parseOptionValue([]byte{0x02, 0x01})
+	require.ErrorIs(t, err, ErrInvalidOption)
+	require.Equal(t, []byte{}, optionValue{}.marshal())
+}
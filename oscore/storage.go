@@ -0,0 +1,46 @@
+package oscore
+
+import "sync"
+
+// SequenceNumberStorage persists sender sequence numbers of security contexts,
+// so a sequence number is never reused with the same key after a restart.
+//
+// The security context stores the sequence number ahead of use: https://tools.ietf.org/html/rfc8613#appendix-B.1.1
+type SequenceNumberStorage interface {
+	// Load returns the sequence number from which the sender continues. It returns 0 when nothing was stored.
+	Load(idContext, senderID []byte) (uint64, error)
+	// Store persists the sequence number. Lower sequence numbers may have been already used.
+	Store(idContext, senderID []byte, sequenceNumber uint64) error
+}
+
+// MemoryStorage keeps sequence numbers in the memory.
+type MemoryStorage struct {
+	mutex     sync.Mutex
+	sequences map[string]uint64
+}
+
+// NewMemoryStorage creates storage which keeps sequence numbers in the memory.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		sequences: make(map[string]uint64),
+	}
+}
+
+func storageKey(idContext, senderID []byte) string {
+	return string(appendCBORBytes(appendCBORBytes(nil, idContext), senderID))
+}
+
+// Load returns stored sequence number.
+func (s *MemoryStorage) Load(idContext, senderID []byte) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sequences[storageKey(idContext, senderID)], nil
+}
+
+// Store stores sequence number.
+func (s *MemoryStorage) Store(idContext, senderID []byte, sequenceNumber uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sequences[storageKey(idContext, senderID)] = sequenceNumber
+	return nil
+}
@@ -0,0 +1,91 @@
+package coap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+// Pool keeps one client connection per endpoint, the connections are created by Dial on demand.
+// It is safe for concurrent use.
+type Pool struct {
+	opts []DialOption
+
+	mutex   sync.Mutex
+	clients map[string]mux.Client
+}
+
+// NewPool creates pool of client connections, the opts are used for dialing of the connections.
+func NewPool(opts ...DialOption) *Pool {
+	return &Pool{
+		opts:    opts,
+		clients: make(map[string]mux.Client),
+	}
+}
+
+func endpoint(u message.URI) string {
+	return message.URI{Scheme: u.Scheme, Host: u.Host, Port: u.Port}.String()
+}
+
+func (p *Pool) load(key string) mux.Client {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c, ok := p.clients[key]
+	if !ok {
+		return nil
+	}
+	select {
+	case <-c.Context().Done():
+		delete(p.clients, key)
+		return nil
+	default:
+		return c
+	}
+}
+
+// Get returns connection to the endpoint of the URI. A new connection is dialed when there is none or
+// the previous one was closed. Path and query of the URI are not used.
+func (p *Pool) Get(ctx context.Context, u message.URI) (mux.Client, error) {
+	key := endpoint(u)
+	if c := p.load(key); c != nil {
+		return c, nil
+	}
+	c, err := Dial(ctx, key, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if v, ok := p.clients[key]; ok {
+		select {
+		case <-v.Context().Done():
+		default:
+			// connection was dialed concurrently
+			c.Close()
+			return v, nil
+		}
+	}
+	p.clients[key] = c
+	return c, nil
+}
+
+// Close closes all connections of the pool.
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	clients := p.clients
+	p.clients = make(map[string]mux.Client)
+	p.mutex.Unlock()
+	var errors []error
+	for _, c := range clients {
+		if err := c.Close(); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("cannot close connections: %v", errors)
+	}
+	return nil
+}
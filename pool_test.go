@@ -0,0 +1,51 @@
+package coap
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithMux(newTestRouter(t)))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	p := NewPool()
+	defer p.Close()
+
+	u, err := message.ParseURI("coap://" + l.LocalAddr().String() + "/a")
+	require.NoError(t, err)
+	c1, err := p.Get(ctx, u)
+	require.NoError(t, err)
+	u.Path = []string{"b"}
+	c2, err := p.Get(ctx, u)
+	require.NoError(t, err)
+	require.Equal(t, c1, c2)
+
+	err = c1.Close()
+	require.NoError(t, err)
+	c3, err := p.Get(ctx, u)
+	require.NoError(t, err)
+	require.NotEqual(t, c1, c3)
+	_, err = c3.Get(ctx, "/a")
+	require.NoError(t, err)
+}
@@ -0,0 +1,323 @@
+// Package proxy provides forward and reverse CoAP proxy: https://tools.ietf.org/html/rfc7252#section-5.7
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2"
+	"github.com/plgd-dev/go-coap/v2/cache"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+type route struct {
+	prefix   []string
+	upstream message.URI
+}
+
+type handlerOptions struct {
+	forwardSchemes map[string]bool
+	routes         []route
+	next           mux.Handler
+	requestTimeout time.Duration
+	cache          cache.Storage
+	errors         func(error)
+}
+
+// Handler forwards requests to the origin servers identified by Proxy-Uri or Proxy-Scheme options (forward proxy)
+// or by the path prefix (reverse proxy). Connections to the origins are kept by the pool.
+//
+// Block-wise transfers are reassembled by the proxy and observations are relayed: the proxy observes the origin
+// and forwards the notifications to the client until the client cancels the observation.
+type Handler struct {
+	pool *coap.Pool
+	opts handlerOptions
+
+	mutex        sync.Mutex
+	observations map[observationKey]*observation
+}
+
+// NewHandler creates proxy which forwards requests through the connections of the pool.
+func NewHandler(pool *coap.Pool, opts ...HandlerOption) *Handler {
+	cfg := handlerOptions{
+		forwardSchemes: make(map[string]bool),
+		requestTimeout: time.Second * 10,
+		errors:         func(error) {},
+	}
+	for _, o := range opts {
+		o.apply(&cfg)
+	}
+	if cfg.errors == nil {
+		cfg.errors = func(error) {}
+	}
+	if cfg.next == nil {
+		cfg.next = mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+			w.SetResponse(codes.NotFound, message.TextPlain, nil)
+		})
+	}
+	return &Handler{
+		pool:         pool,
+		opts:         cfg,
+		observations: make(map[observationKey]*observation),
+	}
+}
+
+func splitPath(p string) []string {
+	var r []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			r = append(r, s)
+		}
+	}
+	return r
+}
+
+// proxyError carries response code of the request which cannot be forwarded.
+type proxyError struct {
+	code codes.Code
+	err  error
+}
+
+func (e proxyError) Error() string {
+	return e.err.Error()
+}
+
+func newProxyError(code codes.Code, format string, a ...interface{}) error {
+	return proxyError{code: code, err: fmt.Errorf(format, a...)}
+}
+
+func (h *Handler) checkForward(scheme string) error {
+	if !h.opts.forwardSchemes[scheme] {
+		return newProxyError(codes.ProxyingNotSupported, "proxying to scheme '%v' is not supported", scheme)
+	}
+	return nil
+}
+
+// target returns URI of the request target. It returns false when the request is not proxied.
+func (h *Handler) target(r *mux.Message) (message.URI, bool, error) {
+	if r.Options.HasOption(message.ProxyURI) {
+		// Proxy-Uri takes precedence over Uri-* options: https://tools.ietf.org/html/rfc7252#section-5.10.2
+		v, err := r.Options.GetString(message.ProxyURI)
+		if err != nil {
+			return message.URI{}, false, newProxyError(codes.BadOption, "invalid Proxy-Uri: %w", err)
+		}
+		u, err := message.ParseURI(v)
+		if err != nil {
+			if i := strings.Index(v, "://"); i > 0 {
+				if err := h.checkForward(strings.ToLower(v[:i])); err != nil {
+					return message.URI{}, false, err
+				}
+			}
+			return message.URI{}, false, newProxyError(codes.BadOption, "invalid Proxy-Uri: %w", err)
+		}
+		return u, true, h.checkForward(u.Scheme)
+	}
+	if r.Options.HasOption(message.ProxyScheme) {
+		scheme, err := r.Options.GetString(message.ProxyScheme)
+		if err != nil {
+			return message.URI{}, false, newProxyError(codes.BadOption, "invalid Proxy-Scheme: %w", err)
+		}
+		if err := h.checkForward(strings.ToLower(scheme)); err != nil {
+			return message.URI{}, false, err
+		}
+		if !r.Options.HasOption(message.URIHost) {
+			return message.URI{}, false, newProxyError(codes.BadRequest, "missing Uri-Host of Proxy-Scheme request")
+		}
+		u, err := message.URIFromOptions(scheme, "", 0, r.Options)
+		if err != nil {
+			return message.URI{}, false, newProxyError(codes.BadOption, "%w", err)
+		}
+		return u, true, nil
+	}
+
+	var path, queries []string
+	for _, o := range r.Options {
+		switch o.ID {
+		case message.URIPath:
+			path = append(path, string(o.Value))
+		case message.URIQuery:
+			queries = append(queries, string(o.Value))
+		}
+	}
+	var match *route
+	for i := range h.opts.routes {
+		rt := &h.opts.routes[i]
+		if !hasPrefix(path, rt.prefix) {
+			continue
+		}
+		if match == nil || len(rt.prefix) > len(match.prefix) {
+			match = rt
+		}
+	}
+	if match == nil {
+		return message.URI{}, false, nil
+	}
+	u := match.upstream
+	u.Path = append(append([]string{}, u.Path...), path[len(match.prefix):]...)
+	u.Queries = append(append([]string{}, u.Queries...), queries...)
+	return u, true, nil
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hopByHop contains options which are not forwarded, because they are set by the proxy or handled per hop.
+var hopByHop = map[message.OptionID]bool{
+	message.URIHost:     true,
+	message.URIPort:     true,
+	message.URIPath:     true,
+	message.URIQuery:    true,
+	message.ProxyURI:    true,
+	message.ProxyScheme: true,
+	message.Observe:     true,
+	message.Block1:      true,
+	message.Block2:      true,
+	message.Size1:       true,
+	message.Size2:       true,
+}
+
+func upstreamOptions(r *mux.Message, u message.URI) (message.Options, error) {
+	opts, err := u.Options()
+	if err != nil {
+		return nil, newProxyError(codes.BadOption, "invalid target uri: %w", err)
+	}
+	for _, o := range r.Options {
+		if !hopByHop[o.ID] {
+			opts = opts.Add(o)
+		}
+	}
+	return opts, nil
+}
+
+func downstreamOptions(resp *message.Message, skip ...message.OptionID) message.Options {
+	opts := make(message.Options, 0, len(resp.Options))
+	for _, o := range resp.Options {
+		if hopByHop[o.ID] {
+			continue
+		}
+		skipped := false
+		for _, id := range skip {
+			if o.ID == id {
+				skipped = true
+			}
+		}
+		if !skipped {
+			opts = append(opts, o)
+		}
+	}
+	return opts
+}
+
+func (h *Handler) setError(w mux.ResponseWriter, err error) {
+	code := codes.BadGateway
+	var proxyErr proxyError
+	switch {
+	case errors.As(err, &proxyErr):
+		code = proxyErr.code
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.GatewayTimeout
+	}
+	h.opts.errors(fmt.Errorf("proxy: %w", err))
+	if err := w.SetResponse(code, message.TextPlain, strings.NewReader(err.Error())); err != nil {
+		h.opts.errors(fmt.Errorf("proxy: cannot set response: %w", err))
+	}
+}
+
+func (h *Handler) setResponse(w mux.ResponseWriter, resp *message.Message, opts ...message.Option) {
+	cf, _ := resp.Options.ContentFormat()
+	options := downstreamOptions(resp, message.ContentFormat)
+	options = append(options, opts...)
+	var body io.ReadSeeker
+	if resp.Body != nil {
+		if _, err := resp.Body.Seek(0, io.SeekStart); err != nil {
+			h.setError(w, fmt.Errorf("cannot read response: %w", err))
+			return
+		}
+		body = resp.Body
+	}
+	if err := w.SetResponse(resp.Code, cf, body, options...); err != nil {
+		h.opts.errors(fmt.Errorf("proxy: cannot set response: %w", err))
+	}
+}
+
+// ServeCOAP forwards the request to the target.
+func (h *Handler) ServeCOAP(w mux.ResponseWriter, r *mux.Message) {
+	u, ok, err := h.target(r)
+	if err != nil {
+		h.setError(w, err)
+		return
+	}
+	if !ok {
+		h.opts.next.ServeCOAP(w, r)
+		return
+	}
+	if obs, err := r.Options.Observe(); err == nil && r.Code == codes.GET {
+		switch obs {
+		case 0:
+			h.observe(w, r, u)
+			return
+		case 1:
+			h.cancelObservation(observationKey{cc: w.Client().ClientConn(), token: r.Token.String()})
+		}
+	}
+	h.forward(w, r, u)
+}
+
+func (h *Handler) forward(w mux.ResponseWriter, r *mux.Message, u message.URI) {
+	opts, err := upstreamOptions(r, u)
+	if err != nil {
+		h.setError(w, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context, h.opts.requestTimeout)
+	defer cancel()
+	client, err := h.pool.Get(ctx, u)
+	if err != nil {
+		h.setError(w, fmt.Errorf("cannot connect to %v: %w", u.Address(), err))
+		return
+	}
+	if h.opts.cache != nil {
+		client = cache.NewClient(client, h.opts.cache)
+	}
+	token, err := message.GetToken()
+	if err != nil {
+		h.setError(w, newProxyError(codes.InternalServerError, "cannot create token: %w", err))
+		return
+	}
+	var body io.ReadSeeker
+	if r.Body != nil {
+		if _, err := r.Body.Seek(0, io.SeekStart); err != nil {
+			h.setError(w, newProxyError(codes.InternalServerError, "cannot read request: %w", err))
+			return
+		}
+		body = r.Body
+	}
+	resp, err := client.Do(&message.Message{
+		Context: ctx,
+		Token:   token,
+		Code:    r.Code,
+		Options: opts,
+		Body:    body,
+	})
+	if err != nil {
+		h.setError(w, fmt.Errorf("cannot forward request to %v: %w", u, err))
+		return
+	}
+	h.setResponse(w, resp)
+}
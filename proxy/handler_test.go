@@ -0,0 +1,225 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2"
+	"github.com/plgd-dev/go-coap/v2/cache"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/proxy"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, handler mux.Handler) (*coapNet.UDPConn, func()) {
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	s := udp.NewServer(udp.WithMux(handler))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+	return l, func() {
+		s.Stop()
+		l.Close()
+		wg.Wait()
+	}
+}
+
+func newOrigin(t *testing.T, observers *mux.Observers) *mux.Router {
+	m := mux.NewRouter()
+	m.Use(observers.Middleware)
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")), message.Option{ID: message.MaxAge, Value: []byte{10}})
+		require.NoError(t, err)
+	}))
+	m.Handle("/echo", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		err = w.SetResponse(codes.Changed, message.AppOctets, bytes.NewReader(body))
+		require.NoError(t, err)
+	}))
+	m.Handle("/obs", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("first")))
+		require.NoError(t, err)
+	}))
+	return m
+}
+
+func readBody(t *testing.T, m *message.Message) string {
+	body, err := ioutil.ReadAll(m.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	observers := mux.NewObservers(0, nil)
+	origin, stopOrigin := serve(t, newOrigin(t, observers))
+	defer stopOrigin()
+	originURI, err := message.ParseURI("coap://" + origin.LocalAddr().String())
+	require.NoError(t, err)
+
+	local := mux.NewRouter()
+	local.Handle("/local", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("local")))
+		require.NoError(t, err)
+	}))
+	pool := coap.NewPool()
+	defer pool.Close()
+	p := proxy.NewHandler(pool,
+		proxy.WithForward(message.SchemeCoap),
+		proxy.WithReverse("/api", originURI),
+		proxy.WithHandler(local),
+	)
+	l, stopProxy := serve(t, p)
+	defer stopProxy()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+	c := cc.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// forward proxy
+	resp, err := c.Get(ctx, "", message.Option{ID: message.ProxyURI, Value: []byte(originURI.String() + "a")})
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+	require.Equal(t, "hello", readBody(t, resp))
+	maxAge, err := resp.Options.GetUint32(message.MaxAge)
+	require.NoError(t, err)
+	require.Equal(t, uint32(10), maxAge)
+
+	buf := make([]byte, 4)
+	n, err := message.EncodeUint32(buf, uint32(originURI.Port))
+	require.NoError(t, err)
+	resp, err = c.Get(ctx, "/a",
+		message.Option{ID: message.ProxyScheme, Value: []byte(message.SchemeCoap)},
+		message.Option{ID: message.URIHost, Value: []byte(originURI.Host)},
+		message.Option{ID: message.URIPort, Value: buf[:n]},
+	)
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+	require.Equal(t, "hello", readBody(t, resp))
+
+	resp, err = c.Get(ctx, "", message.Option{ID: message.ProxyURI, Value: []byte("coaps+tcp://" + origin.LocalAddr().String() + "/a")})
+	require.NoError(t, err)
+	require.Equal(t, codes.ProxyingNotSupported, resp.Code)
+
+	// reverse proxy with blockwise transfers in both directions
+	resp, err = c.Get(ctx, "/api/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code)
+	require.Equal(t, "hello", readBody(t, resp))
+	payload := bytes.Repeat([]byte("0123456789"), 500)
+	resp, err = c.Post(ctx, "/api/echo", message.AppOctets, bytes.NewReader(payload))
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code)
+	require.Equal(t, string(payload), readBody(t, resp))
+	resp, err = c.Get(ctx, "/api/unknown")
+	require.NoError(t, err)
+	require.Equal(t, codes.NotFound, resp.Code)
+
+	// requests which aren't proxied
+	resp, err = c.Get(ctx, "/local")
+	require.NoError(t, err)
+	require.Equal(t, "local", readBody(t, resp))
+	resp, err = c.Get(ctx, "/other")
+	require.NoError(t, err)
+	require.Equal(t, codes.NotFound, resp.Code)
+
+	// observe
+	notifications := make(chan string, 8)
+	obs, err := c.Observe(ctx, "/api/obs", func(n *message.Message) {
+		notifications <- readBody(t, n)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "first", <-notifications)
+	require.True(t, observers.Observed("/obs"))
+	for i := 0; i < 3; i++ {
+		v := strconv.Itoa(i)
+		err = observers.Publish("/obs", message.TextPlain, bytes.NewReader([]byte(v)))
+		require.NoError(t, err)
+		select {
+		case n := <-notifications:
+			require.Equal(t, v, n)
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		}
+	}
+	err = obs.Cancel(ctx)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return !observers.Observed("/obs")
+	}, time.Second*3, time.Millisecond*10)
+}
+
+func TestHandler_ForwardDisabled(t *testing.T) {
+	pool := coap.NewPool()
+	defer pool.Close()
+	l, stop := serve(t, proxy.NewHandler(pool))
+	defer stop()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	resp, err := cc.Client().Get(ctx, "", message.Option{ID: message.ProxyURI, Value: []byte("coap://127.0.0.1/a")})
+	require.NoError(t, err)
+	require.Equal(t, codes.ProxyingNotSupported, resp.Code)
+	resp, err = cc.Client().Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.NotFound, resp.Code)
+}
+
+func TestHandler_Cache(t *testing.T) {
+	var requests int32
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddInt32(&requests, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")))
+		require.NoError(t, err)
+	}))
+	origin, stopOrigin := serve(t, m)
+	defer stopOrigin()
+	originURI, err := message.ParseURI("coap://" + origin.LocalAddr().String())
+	require.NoError(t, err)
+
+	pool := coap.NewPool()
+	defer pool.Close()
+	l, stop := serve(t, proxy.NewHandler(pool, proxy.WithReverse("/", originURI), proxy.WithCache(cache.NewLRU(8))))
+	defer stop()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		resp, err := cc.Client().Get(ctx, "/a")
+		require.NoError(t, err)
+		require.Equal(t, "hello", readBody(t, resp))
+		if i == 0 {
+			continue
+		}
+		// responses served from the cache carry the remaining freshness lifetime
+		maxAge, err := resp.Options.GetUint32(message.MaxAge)
+		require.NoError(t, err)
+		require.LessOrEqual(t, maxAge, uint32(cache.DefaultMaxAge/time.Second))
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+type observationKey struct {
+	cc    interface{}
+	token string
+}
+
+type observation struct {
+	cancel context.CancelFunc
+}
+
+func observeOption(seq uint32) message.Option {
+	buf := make([]byte, 4)
+	n, _ := message.EncodeUint32(buf, seq)
+	return message.Option{ID: message.Observe, Value: buf[:n]}
+}
+
+func (h *Handler) cancelObservation(key observationKey) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if o, ok := h.observations[key]; ok {
+		o.cancel()
+		delete(h.observations, key)
+	}
+}
+
+func (h *Handler) removeObservation(key observationKey, o *observation) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.observations[key] == o {
+		delete(h.observations, key)
+	}
+}
+
+// observe registers observation at the origin and relays its notifications: https://tools.ietf.org/html/rfc7641#section-5
+func (h *Handler) observe(w mux.ResponseWriter, r *mux.Message, u message.URI) {
+	opts, err := upstreamOptions(r, u)
+	if err != nil {
+		h.setError(w, err)
+		return
+	}
+	opts = opts.Remove(message.URIPath)
+	downstream := w.Client()
+	// ctx is used by the upstream observation, so it must not be cancelled when the registration succeeds
+	ctx, cancel := context.WithCancel(downstream.Context())
+	timeout := time.AfterFunc(h.opts.requestTimeout, cancel)
+	upstream, err := h.pool.Get(ctx, u)
+	if err != nil {
+		cancel()
+		h.setError(w, fmt.Errorf("cannot connect to %v: %w", u.Address(), err))
+		return
+	}
+	notifications := make(chan *message.Message, 8)
+	obs, err := upstream.Observe(ctx, u.PathString(), func(n *message.Message) {
+		select {
+		case notifications <- n:
+		case <-ctx.Done():
+		}
+	}, opts...)
+	if err != nil {
+		defer cancel()
+		select {
+		case resp := <-notifications:
+			// the origin rejected the observation
+			h.setResponse(w, resp)
+		default:
+			if !timeout.Stop() {
+				err = context.DeadlineExceeded
+			}
+			h.setError(w, fmt.Errorf("cannot observe %v: %w", u, err))
+		}
+		return
+	}
+	var first *message.Message
+	select {
+	case first = <-notifications:
+	case <-ctx.Done():
+		h.cancelUpstream(obs, u)
+		h.setError(w, fmt.Errorf("cannot observe %v: %w", u, context.DeadlineExceeded))
+		return
+	}
+	if !timeout.Stop() {
+		h.cancelUpstream(obs, u)
+		h.setError(w, fmt.Errorf("cannot observe %v: %w", u, context.DeadlineExceeded))
+		return
+	}
+	seq, err := first.Options.Observe()
+	if err != nil {
+		// the origin doesn't support observe of the resource
+		cancel()
+		h.cancelUpstream(obs, u)
+		h.setResponse(w, first)
+		return
+	}
+
+	key := observationKey{cc: downstream.ClientConn(), token: r.Token.String()}
+	o := &observation{cancel: cancel}
+	h.mutex.Lock()
+	if old, ok := h.observations[key]; ok {
+		old.cancel()
+	}
+	h.observations[key] = o
+	h.mutex.Unlock()
+
+	h.setResponse(w, first, observeOption(seq))
+	token := append(message.Token(nil), r.Token...)
+	go func() {
+		defer h.removeObservation(key, o)
+		defer h.cancelUpstream(obs, u)
+		defer cancel()
+		h.relay(ctx, upstream, downstream, token, notifications)
+	}()
+}
+
+func (h *Handler) cancelUpstream(obs mux.Observation, u message.URI) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.requestTimeout)
+	defer cancel()
+	if err := obs.Cancel(ctx); err != nil {
+		h.opts.errors(fmt.Errorf("proxy: cannot cancel observation of %v: %w", u, err))
+	}
+}
+
+// relay forwards notifications to the observer until the observation is cancelled or ends by non 2.xx notification.
+func (h *Handler) relay(ctx context.Context, upstream, downstream mux.Client, token message.Token, notifications <-chan *message.Message) {
+	for {
+		var n *message.Message
+		select {
+		case <-ctx.Done():
+			return
+		case <-upstream.Context().Done():
+			// connection to the origin was lost
+			n = &message.Message{Code: codes.BadGateway}
+		case n = <-notifications:
+		}
+		opts := downstreamOptions(n)
+		if n.Code>>5 == 2 {
+			if seq, err := n.Options.Observe(); err == nil {
+				opts = opts.Add(observeOption(seq))
+			}
+		}
+		err := downstream.WriteMessage(&message.Message{
+			Context: downstream.Context(),
+			Token:   token,
+			Code:    n.Code,
+			Options: opts,
+			Body:    n.Body,
+		})
+		if err != nil {
+			h.opts.errors(fmt.Errorf("proxy: cannot send notification to %v: %w", downstream.RemoteAddr(), err))
+			return
+		}
+		if n.Code>>5 != 2 {
+			return
+		}
+	}
+}
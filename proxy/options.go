@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/cache"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/mux"
+)
+
+// A HandlerOption sets options of the Handler.
+type HandlerOption interface {
+	apply(*handlerOptions)
+}
+
+// ForwardOpt forward proxy option.
+type ForwardOpt struct {
+	schemes []string
+}
+
+func (o ForwardOpt) apply(opts *handlerOptions) {
+	for _, s := range o.schemes {
+		opts.forwardSchemes[s] = true
+	}
+}
+
+// WithForward enables forward proxying of requests with Proxy-Uri or Proxy-Scheme option to the origins
+// of the schemes, eg. message.SchemeCoap. Requests to other schemes are rejected by 5.05 Proxying Not Supported.
+func WithForward(schemes ...string) ForwardOpt {
+	return ForwardOpt{
+		schemes: schemes,
+	}
+}
+
+// ReverseOpt reverse proxy option.
+type ReverseOpt struct {
+	prefix   string
+	upstream message.URI
+}
+
+func (o ReverseOpt) apply(opts *handlerOptions) {
+	opts.routes = append(opts.routes, route{
+		prefix:   splitPath(o.prefix),
+		upstream: o.upstream,
+	})
+}
+
+// WithReverse forwards requests with the path prefix to the upstream. The prefix is replaced by the path of
+// the upstream, eg. the prefix /api and the upstream coap://backend/v1 maps /api/a to coap://backend/v1/a.
+func WithReverse(prefix string, upstream message.URI) ReverseOpt {
+	return ReverseOpt{
+		prefix:   prefix,
+		upstream: upstream,
+	}
+}
+
+// HandlerOpt handler option.
+type HandlerOpt struct {
+	next mux.Handler
+}
+
+func (o HandlerOpt) apply(opts *handlerOptions) {
+	opts.next = o.next
+}
+
+// WithHandler serves requests which are not proxied by the next handler. By default they are rejected by 4.04 Not Found.
+func WithHandler(next mux.Handler) HandlerOpt {
+	return HandlerOpt{
+		next: next,
+	}
+}
+
+// RequestTimeoutOpt request timeout option.
+type RequestTimeoutOpt struct {
+	timeout time.Duration
+}
+
+func (o RequestTimeoutOpt) apply(opts *handlerOptions) {
+	opts.requestTimeout = o.timeout
+}
+
+// WithRequestTimeout limits time of waiting for the response of the upstream, the request is answered by 5.04 Gateway Timeout.
+func WithRequestTimeout(timeout time.Duration) RequestTimeoutOpt {
+	return RequestTimeoutOpt{
+		timeout: timeout,
+	}
+}
+
+// CacheOpt cache option.
+type CacheOpt struct {
+	storage cache.Storage
+}
+
+func (o CacheOpt) apply(opts *handlerOptions) {
+	opts.cache = o.storage
+}
+
+// WithCache caches responses of GET requests in the storage: https://tools.ietf.org/html/rfc7252#section-5.7.1
+func WithCache(storage cache.Storage) CacheOpt {
+	return CacheOpt{
+		storage: storage,
+	}
+}
+
+// ErrorsOpt errors option.
+type ErrorsOpt struct {
+	errors func(error)
+}
+
+func (o ErrorsOpt) apply(opts *handlerOptions) {
+	opts.errors = o.errors
+}
+
+// WithErrors set function for logging error.
+func WithErrors(errors func(error)) ErrorsOpt {
+	return ErrorsOpt{
+		errors: errors,
+	}
+}
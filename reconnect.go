@@ -0,0 +1,617 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+)
+
+// ErrClientClosed is returned by requests of the closed ReconnectingClient.
+var ErrClientClosed = errors.New("client was closed")
+
+// ConnectionState is a state of the connection of ReconnectingClient.
+type ConnectionState int
+
+const (
+	// Connected means that the connection is established.
+	Connected ConnectionState = iota
+	// Disconnected means that the connection was lost or the attempt to reconnect failed.
+	Disconnected
+	// Reconnecting means that the client dials the server.
+	Reconnecting
+	// Closed means that the client was closed.
+	Closed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	case Closed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// OnStateChangeFunc is called when the state of the connection changes. The err is set
+// when the attempt to reconnect failed.
+type OnStateChangeFunc = func(state ConnectionState, err error)
+
+var defaultReconnectOptions = reconnectOptions{
+	minBackoff:    time.Millisecond * 100,
+	maxBackoff:    time.Second * 30,
+	onStateChange: func(ConnectionState, error) {},
+	errors:        func(error) {},
+}
+
+type reconnectOptions struct {
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	onStateChange OnStateChangeFunc
+	errors        func(error)
+	dialOpts      []DialOption
+}
+
+// backoff returns the delay before the attempt to reconnect. The delay grows exponentially
+// from minBackoff to maxBackoff and the second half of it is randomized.
+func (o reconnectOptions) backoff(attempt int) time.Duration {
+	d := o.minBackoff
+	for i := 0; i < attempt && d < o.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.maxBackoff {
+		d = o.maxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// A ReconnectOption sets options of ReconnectingClient.
+type ReconnectOption interface {
+	applyReconnect(*reconnectOptions)
+}
+
+// BackoffOpt backoff option.
+type BackoffOpt struct {
+	min time.Duration
+	max time.Duration
+}
+
+func (o BackoffOpt) applyReconnect(opts *reconnectOptions) {
+	opts.minBackoff = o.min
+	opts.maxBackoff = o.max
+}
+
+// WithBackoff sets the delay before the first attempt to reconnect and the maximal delay between attempts.
+func WithBackoff(min, max time.Duration) BackoffOpt {
+	if max < min {
+		max = min
+	}
+	return BackoffOpt{min: min, max: max}
+}
+
+// OnStateChangeOpt state change option.
+type OnStateChangeOpt struct {
+	onStateChange OnStateChangeFunc
+}
+
+func (o OnStateChangeOpt) applyReconnect(opts *reconnectOptions) {
+	if o.onStateChange != nil {
+		opts.onStateChange = o.onStateChange
+	}
+}
+
+// WithOnStateChange sets function which is called when the state of the connection changes.
+func WithOnStateChange(onStateChange OnStateChangeFunc) OnStateChangeOpt {
+	return OnStateChangeOpt{onStateChange: onStateChange}
+}
+
+// ErrorsOpt errors option.
+type ErrorsOpt struct {
+	errors func(error)
+}
+
+func (o ErrorsOpt) applyReconnect(opts *reconnectOptions) {
+	if o.errors != nil {
+		opts.errors = o.errors
+	}
+}
+
+// WithErrors sets function which is called when an observation cannot be registered after reconnection.
+func WithErrors(errors func(error)) ErrorsOpt {
+	return ErrorsOpt{errors: errors}
+}
+
+// DialOptionsOpt dial options option.
+type DialOptionsOpt struct {
+	opts []DialOption
+}
+
+func (o DialOptionsOpt) applyReconnect(opts *reconnectOptions) {
+	opts.dialOpts = append(opts.dialOpts, o.opts...)
+}
+
+// WithDialOptions sets options of Dial used by DialReconnecting.
+func WithDialOptions(opts ...DialOption) DialOptionsOpt {
+	return DialOptionsOpt{opts: opts}
+}
+
+type contextValue struct {
+	key interface{}
+	val interface{}
+}
+
+// ReconnectingClient is a client which reconnects to the server when the connection is lost.
+// Each attempt dials a new connection, so TCP CSM messages are exchanged and DTLS handshake is performed again.
+// Observations are registered again with new tokens and the notifications are delivered to the original
+// observe functions. Requests wait for the connection until their context is done.
+//
+// When the server releases a TCP connection by the Release message, the client reconnects after Hold-Off.
+// The connection is dialed to Alternative-Address when it is set and the client was created by DialReconnecting.
+type ReconnectingClient struct {
+	// dial connects to the alternative address of the server, empty address means the original one
+	dial func(ctx context.Context, alternativeAddress string) (mux.Client, error)
+	opts reconnectOptions
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// alternativeAddress is the address of the last Release message, it is used only by run
+	alternativeAddress string
+
+	mutex        sync.Mutex
+	cc           mux.Client
+	connected    chan struct{}
+	remoteAddr   net.Addr
+	observations map[*ReconnectingObservation]struct{}
+	values       []contextValue
+}
+
+// DialReconnecting creates a reconnecting client to the server identified by CoAP URI, see Dial.
+func DialReconnecting(ctx context.Context, uri string, opts ...ReconnectOption) (*ReconnectingClient, error) {
+	u, err := message.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	cfg := defaultReconnectOptions
+	for _, o := range opts {
+		o.applyReconnect(&cfg)
+	}
+	return newReconnectingClient(ctx, func(ctx context.Context, alternativeAddress string) (mux.Client, error) {
+		if alternativeAddress == "" {
+			return Dial(ctx, uri, cfg.dialOpts...)
+		}
+		alternative, err := alternativeURI(u, alternativeAddress)
+		if err != nil {
+			return nil, err
+		}
+		return Dial(ctx, alternative, cfg.dialOpts...)
+	}, opts...)
+}
+
+// alternativeURI replaces host and port of the URI by the Alternative-Address of the Release message.
+func alternativeURI(u message.URI, alternativeAddress string) (string, error) {
+	host, port, err := net.SplitHostPort(alternativeAddress)
+	if err != nil {
+		return "", fmt.Errorf("invalid alternative address '%v': %w", alternativeAddress, err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid port of alternative address '%v': %w", alternativeAddress, err)
+	}
+	u.Host = host
+	u.Port = uint16(p)
+	return u.String(), nil
+}
+
+// NewReconnectingClient creates a reconnecting client which uses dial to establish connections.
+// The first connection is established before return and its failure is returned as an error.
+// The dial always connects to the same server, so Alternative-Address of the TCP Release message
+// isn't used - the dial is responsible for it, eg. by tcp.WithOnRelease.
+func NewReconnectingClient(ctx context.Context, dial func(ctx context.Context) (mux.Client, error), opts ...ReconnectOption) (*ReconnectingClient, error) {
+	return newReconnectingClient(ctx, func(ctx context.Context, _ string) (mux.Client, error) {
+		return dial(ctx)
+	}, opts...)
+}
+
+func newReconnectingClient(ctx context.Context, dial func(ctx context.Context, alternativeAddress string) (mux.Client, error), opts ...ReconnectOption) (*ReconnectingClient, error) {
+	cfg := defaultReconnectOptions
+	for _, o := range opts {
+		o.applyReconnect(&cfg)
+	}
+	cc, err := dial(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	clientCtx, cancel := context.WithCancel(context.Background())
+	c := &ReconnectingClient{
+		dial:         dial,
+		opts:         cfg,
+		ctx:          clientCtx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		cc:           cc,
+		connected:    make(chan struct{}),
+		remoteAddr:   cc.RemoteAddr(),
+		observations: make(map[*ReconnectingObservation]struct{}),
+	}
+	close(c.connected)
+	c.opts.onStateChange(Connected, nil)
+	go c.run(cc)
+	return c, nil
+}
+
+func (c *ReconnectingClient) run(cc mux.Client) {
+	defer close(c.done)
+	for {
+		select {
+		case <-cc.Context().Done():
+		case <-releaseReceived(cc):
+		case <-c.ctx.Done():
+			c.mutex.Lock()
+			c.cc = nil
+			c.mutex.Unlock()
+			cc.Close()
+			return
+		}
+		c.mutex.Lock()
+		c.cc = nil
+		c.connected = make(chan struct{})
+		c.mutex.Unlock()
+		c.opts.onStateChange(Disconnected, nil)
+		var holdOff time.Duration
+		if release, ok := released(cc); ok {
+			holdOff = release.HoldOff
+			if release.AlternativeAddress != "" {
+				c.alternativeAddress = release.AlternativeAddress
+			}
+		}
+		cc = c.reconnect(cc, holdOff)
+		if cc == nil {
+			return
+		}
+	}
+}
+
+// releaseReceived returns channel which is closed when the server releases the TCP connection,
+// nil channel is returned for other connections.
+func releaseReceived(cc mux.Client) <-chan struct{} {
+	if tcpConn, ok := cc.ClientConn().(*tcp.ClientConn); ok {
+		return tcpConn.ReleaseReceived()
+	}
+	return nil
+}
+
+// released returns the Release message of the server of the TCP connection.
+func released(cc mux.Client) (tcp.ReleaseSignal, bool) {
+	if tcpConn, ok := cc.ClientConn().(*tcp.ClientConn); ok {
+		return tcpConn.Released()
+	}
+	return tcp.ReleaseSignal{}, false
+}
+
+// reconnect closes the lost connection and dials the server until it succeeds or the client is closed.
+// Requests of the released connection are served until the first attempt after holdOff.
+func (c *ReconnectingClient) reconnect(lost mux.Client, holdOff time.Duration) mux.Client {
+	for attempt := 0; ; attempt++ {
+		delay := c.opts.backoff(attempt)
+		if delay < holdOff {
+			delay = holdOff
+		}
+		if !c.sleep(delay) {
+			lost.Close()
+			return nil
+		}
+		if attempt == 0 {
+			lost.Close()
+		}
+		c.opts.onStateChange(Reconnecting, nil)
+		cc, err := c.dial(c.ctx, c.alternativeAddress)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return nil
+			}
+			c.opts.onStateChange(Disconnected, err)
+			continue
+		}
+		c.connect(cc)
+		return cc
+	}
+}
+
+func (c *ReconnectingClient) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// connect sets the connection, restores context values and registers observations.
+func (c *ReconnectingClient) connect(cc mux.Client) {
+	c.mutex.Lock()
+	for _, v := range c.values {
+		cc.SetContextValue(v.key, v.val)
+	}
+	observations := make([]*ReconnectingObservation, 0, len(c.observations))
+	for o := range c.observations {
+		observations = append(observations, o)
+	}
+	c.cc = cc
+	c.remoteAddr = cc.RemoteAddr()
+	close(c.connected)
+	c.mutex.Unlock()
+
+	for _, o := range observations {
+		if err := o.register(cc.Context(), cc); err != nil {
+			c.opts.errors(fmt.Errorf("cannot register observation of %v: %w", o.path, err))
+		}
+	}
+	c.opts.onStateChange(Connected, nil)
+}
+
+// conn returns the connection, it waits for the connection when the client is disconnected.
+func (c *ReconnectingClient) conn(ctx context.Context) (mux.Client, error) {
+	for {
+		if c.ctx.Err() != nil {
+			return nil, ErrClientClosed
+		}
+		c.mutex.Lock()
+		cc, connected := c.cc, c.connected
+		c.mutex.Unlock()
+		if cc != nil {
+			return cc, nil
+		}
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, ErrClientClosed
+		}
+	}
+}
+
+func (c *ReconnectingClient) Ping(ctx context.Context) error {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	return cc.Ping(ctx)
+}
+
+func (c *ReconnectingClient) Get(ctx context.Context, path string, opts ...message.Option) (*message.Message, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Get(ctx, path, opts...)
+}
+
+func (c *ReconnectingClient) Delete(ctx context.Context, path string, opts ...message.Option) (*message.Message, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Delete(ctx, path, opts...)
+}
+
+func (c *ReconnectingClient) Post(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Post(ctx, path, contentFormat, payload, opts...)
+}
+
+func (c *ReconnectingClient) Put(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Put(ctx, path, contentFormat, payload, opts...)
+}
+
+func (c *ReconnectingClient) Fetch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Fetch(ctx, path, contentFormat, payload, opts...)
+}
+
+func (c *ReconnectingClient) Patch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Patch(ctx, path, contentFormat, payload, opts...)
+}
+
+func (c *ReconnectingClient) IPatch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cc.IPatch(ctx, path, contentFormat, payload, opts...)
+}
+
+// Observe observes the resource. The observation is registered again after each reconnection.
+func (c *ReconnectingClient) Observe(ctx context.Context, path string, observeFunc func(notification *message.Message), opts ...message.Option) (mux.Observation, error) {
+	cc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o := &ReconnectingObservation{
+		client:      c,
+		path:        path,
+		observeFunc: observeFunc,
+		opts:        append([]message.Option(nil), opts...),
+	}
+	c.mutex.Lock()
+	c.observations[o] = struct{}{}
+	c.mutex.Unlock()
+	if err := o.register(ctx, cc); err != nil {
+		if !o.abandon() {
+			// the connection was lost and the observation was registered over the new one
+			return o, nil
+		}
+		c.removeObservation(o)
+		return nil, err
+	}
+	return o, nil
+}
+
+func (c *ReconnectingClient) removeObservation(o *ReconnectingObservation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.observations, o)
+}
+
+// ClientConn returns the underlying connection, nil is returned when the client is disconnected.
+func (c *ReconnectingClient) ClientConn() interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cc == nil {
+		return nil
+	}
+	return c.cc.ClientConn()
+}
+
+// RemoteAddr returns address of the last established connection.
+func (c *ReconnectingClient) RemoteAddr() net.Addr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.remoteAddr
+}
+
+// Context returns context of the client which is canceled by Close.
+func (c *ReconnectingClient) Context() context.Context {
+	return c.ctx
+}
+
+// SetContextValue stores the value to context of the connection and of the future connections.
+func (c *ReconnectingClient) SetContextValue(key interface{}, val interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values = append(c.values, contextValue{key: key, val: val})
+	if c.cc != nil {
+		c.cc.SetContextValue(key, val)
+	}
+}
+
+func (c *ReconnectingClient) WriteMessage(req *message.Message) error {
+	cc, err := c.conn(req.Context)
+	if err != nil {
+		return err
+	}
+	return cc.WriteMessage(req)
+}
+
+func (c *ReconnectingClient) Do(req *message.Message) (*message.Message, error) {
+	cc, err := c.conn(req.Context)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Do(req)
+}
+
+// Sequence acquires sequence number of the connection, zero is returned when the client is disconnected.
+func (c *ReconnectingClient) Sequence() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cc == nil {
+		return 0
+	}
+	return c.cc.Sequence()
+}
+
+// Close closes the connection and stops reconnecting.
+func (c *ReconnectingClient) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		<-c.done
+		c.opts.onStateChange(Closed, nil)
+	})
+	return nil
+}
+
+// ReconnectingObservation is an observation of ReconnectingClient.
+type ReconnectingObservation struct {
+	client      *ReconnectingClient
+	path        string
+	observeFunc func(notification *message.Message)
+	opts        []message.Option
+
+	mutex    sync.Mutex
+	cc       mux.Client
+	obs      mux.Observation
+	canceled bool
+}
+
+// register registers the observation over the connection when it isn't registered yet.
+func (o *ReconnectingObservation) register(ctx context.Context, cc mux.Client) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.canceled || o.cc == cc {
+		return nil
+	}
+	if o.cc != nil && o.cc.Context().Err() == nil {
+		// the observation is registered over the newer connection
+		return nil
+	}
+	obs, err := cc.Observe(ctx, o.path, o.observeFunc, o.opts...)
+	if err != nil {
+		return err
+	}
+	o.cc = cc
+	o.obs = obs
+	return nil
+}
+
+// abandon stops the observation whose first registration failed. It returns false when
+// the observation was registered over a new connection meanwhile.
+func (o *ReconnectingObservation) abandon() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.cc != nil {
+		return false
+	}
+	o.canceled = true
+	return true
+}
+
+// Cancel removes the observation from the server and stops registering it after reconnection.
+func (o *ReconnectingObservation) Cancel(ctx context.Context) error {
+	o.client.removeObservation(o)
+	o.mutex.Lock()
+	o.canceled = true
+	cc, obs := o.cc, o.obs
+	o.cc, o.obs = nil, nil
+	o.mutex.Unlock()
+	if obs == nil || cc.Context().Err() != nil {
+		return nil
+	}
+	return obs.Cancel(ctx)
+}
@@ -51,6 +51,33 @@ func (c *ClientTCP) Post(ctx context.Context, path string, contentFormat message
 	return pool.ConvertTo(resp)
 }
 
+func (c *ClientTCP) Fetch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	resp, err := c.cc.Fetch(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseMessage(resp)
+	return pool.ConvertTo(resp)
+}
+
+func (c *ClientTCP) Patch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	resp, err := c.cc.Patch(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseMessage(resp)
+	return pool.ConvertTo(resp)
+}
+
+func (c *ClientTCP) IPatch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	resp, err := c.cc.IPatch(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseMessage(resp)
+	return pool.ConvertTo(resp)
+}
+
 func (c *ClientTCP) Get(ctx context.Context, path string, opts ...message.Option) (*message.Message, error) {
 	resp, err := c.cc.Get(ctx, path, opts...)
 	if err != nil {
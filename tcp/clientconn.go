@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -9,9 +10,11 @@ import (
 	"net"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/net/blockwise"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
+	coapTCP "github.com/plgd-dev/go-coap/v2/tcp/message"
 	"github.com/plgd-dev/go-coap/v2/tcp/message/pool"
 
 	"github.com/plgd-dev/go-coap/v2/message/codes"
@@ -25,7 +28,7 @@ var defaultDialOptions = dialOptions{
 	heartBeat:      time.Millisecond * 100,
 	handler: func(w *ResponseWriter, r *pool.Message) {
 		switch r.Code() {
-		case codes.POST, codes.PUT, codes.GET, codes.DELETE:
+		case codes.POST, codes.PUT, codes.GET, codes.DELETE, codes.FETCH, codes.PATCH, codes.IPATCH:
 			w.SetResponse(codes.NotFound, message.TextPlain, nil)
 		}
 	},
@@ -43,7 +46,8 @@ var defaultDialOptions = dialOptions{
 	blockwiseSZX:             blockwise.SZX1024,
 	blockwiseEnable:          true,
 	blockwiseTransferTimeout: time.Second * 3,
-	createInactivityMonitor: func() inactivity.Monitor {
+	peerCSMTimeout:           defaultPeerCSMTimeout,
+	createInactivityMonitor: func(clock.Clock) inactivity.Monitor {
 		return inactivity.NewNilMonitor()
 	},
 }
@@ -64,7 +68,12 @@ type dialOptions struct {
 	disableTCPSignalMessageCSM      bool
 	tlsCfg                          *tls.Config
 	closeSocket                     bool
-	createInactivityMonitor         func() inactivity.Monitor
+	createInactivityMonitor         func(clock.Clock) inactivity.Monitor
+	onRelease                       OnReleaseFunc
+	csmOptions                      message.Options
+	acceptedCSMOptions              []message.OptionID
+	peerCSMTimeout                  time.Duration
+	clock                           clock.Clock
 }
 
 // A DialOption sets options such as credentials, keepalive parameters, etc.
@@ -142,7 +151,7 @@ func Client(conn net.Conn, opts ...DialOption) *ClientConn {
 		cfg.errors = func(error) {}
 	}
 	if cfg.createInactivityMonitor == nil {
-		cfg.createInactivityMonitor = func() inactivity.Monitor {
+		cfg.createInactivityMonitor = func(clock.Clock) inactivity.Monitor {
 			return inactivity.NewNilMonitor()
 		}
 	}
@@ -158,24 +167,25 @@ func Client(conn net.Conn, opts ...DialOption) *ClientConn {
 	observationRequests := kitSync.NewMap()
 	var blockWise *blockwise.BlockWise
 	if cfg.blockwiseEnable {
-		blockWise = blockwise.NewBlockWise(
+		blockWise = blockwise.NewBlockWiseWithClock(
 			bwAcquireMessage,
 			bwReleaseMessage,
 			cfg.blockwiseTransferTimeout,
 			cfg.errors,
 			false,
 			bwCreateHandlerFunc(observationRequests),
+			cfg.clock,
 		)
 	}
 
 	observationTokenHandler := NewHandlerContainer()
-	monitor := cfg.createInactivityMonitor()
+	monitor := cfg.createInactivityMonitor(cfg.clock)
 	var cc *ClientConn
 	l := coapNet.NewConn(conn, coapNet.WithHeartBeat(cfg.heartBeat), coapNet.WithOnReadTimeout(func() error {
 		monitor.CheckInactivity(cc)
 		return nil
 	}))
-	session := NewSession(cfg.ctx,
+	session := newSession(cfg.ctx,
 		l,
 		NewObservationHandler(observationTokenHandler, cfg.handler),
 		cfg.maxMessageSize,
@@ -187,7 +197,9 @@ func Client(conn net.Conn, opts ...DialOption) *ClientConn {
 		cfg.disableTCPSignalMessageCSM,
 		cfg.closeSocket,
 		monitor,
+		csmConfig{options: cfg.csmOptions, accepted: cfg.acceptedCSMOptions, timeout: cfg.peerCSMTimeout, clock: cfg.clock},
 	)
+	session.onRelease = cfg.onRelease
 	cc = NewClientConn(session, observationTokenHandler, observationRequests)
 
 	go func() {
@@ -218,7 +230,7 @@ func (cc *ClientConn) Close() error {
 	return cc.session.Close()
 }
 
-func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
+func (cc *ClientConn) exchange(req *pool.Message) (*pool.Message, error) {
 	token := req.Token()
 	if token == nil {
 		return nil, fmt.Errorf("invalid token")
@@ -237,6 +249,9 @@ func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
 	defer cc.session.TokenHandler().Pop(token)
 	err = cc.session.WriteMessage(req)
 	if err != nil {
+		if abortErr := cc.session.abortError(); abortErr != nil {
+			err = abortErr
+		}
 		return nil, fmt.Errorf("cannot write request: %w", err)
 	}
 
@@ -244,12 +259,46 @@ func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
 	case <-req.Context().Done():
 		return nil, req.Context().Err()
 	case <-cc.session.Context().Done():
+		if err := cc.session.abortError(); err != nil {
+			return nil, fmt.Errorf("connection was closed: %w", err)
+		}
 		return nil, fmt.Errorf("connection was closed: %w", cc.Context().Err())
 	case resp := <-respChan:
 		return resp, nil
 	}
 }
 
+func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
+	resp, err := cc.exchange(req)
+	if err != nil {
+		return nil, err
+	}
+	echo, ok := echoChallenge(req, resp)
+	if !ok {
+		return resp, nil
+	}
+	// the server verifies the client so the request is repeated with the echoed value
+	req.SetOptionBytes(message.Echo, echo)
+	pool.ReleaseMessage(resp)
+	return cc.exchange(req)
+}
+
+// echoChallenge returns the Echo value of 4.01 Unauthorized response (RFC 9175) which was not sent by the request.
+func echoChallenge(req, resp *pool.Message) ([]byte, bool) {
+	if resp.Code() != codes.Unauthorized {
+		return nil, false
+	}
+	echo, err := resp.GetOptionBytes(message.Echo)
+	if err != nil {
+		return nil, false
+	}
+	reqEcho, err := req.GetOptionBytes(message.Echo)
+	if err == nil && bytes.Equal(reqEcho, echo) {
+		return nil, false
+	}
+	return echo, true
+}
+
 // Do sends an coap message and returns an coap response.
 //
 // An error is returned if by failure to speak COAP (such as a network connectivity problem).
@@ -257,10 +306,17 @@ func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
 //
 // Caller is responsible to release request and response.
 func (cc *ClientConn) Do(req *pool.Message) (*pool.Message, error) {
+	if _, released := cc.session.Released(); released && startsExchange(req) {
+		return nil, ErrConnectionReleased
+	}
+	if err := cc.session.WaitForPeerCSM(req.Context()); err != nil {
+		return nil, err
+	}
 	if !cc.session.PeerBlockWiseTransferEnabled() || cc.session.blockWise == nil {
 		return cc.do(req)
 	}
-	bwresp, err := cc.session.blockWise.Do(req, cc.session.blockwiseSZX, cc.session.maxMessageSize, func(bwreq blockwise.Message) (blockwise.Message, error) {
+	szx, maxMessageSize := cc.session.blockwiseParams()
+	bwresp, err := cc.session.blockWise.Do(req, szx, maxMessageSize, func(bwreq blockwise.Message) (blockwise.Message, error) {
 		return cc.do(bwreq.(*pool.Message))
 	})
 	if err != nil {
@@ -275,10 +331,14 @@ func (cc *ClientConn) writeMessage(req *pool.Message) error {
 
 // WriteMessage sends an coap message.
 func (cc *ClientConn) WriteMessage(req *pool.Message) error {
+	if err := cc.session.WaitForPeerCSM(req.Context()); err != nil {
+		return err
+	}
 	if !cc.session.PeerBlockWiseTransferEnabled() || cc.session.blockWise == nil {
 		return cc.writeMessage(req)
 	}
-	return cc.session.blockWise.WriteMessage(cc.RemoteAddr(), req, cc.session.blockwiseSZX, cc.session.maxMessageSize, func(bwreq blockwise.Message) error {
+	szx, maxMessageSize := cc.session.blockwiseParams()
+	return cc.session.blockWise.WriteMessage(cc.RemoteAddr(), req, szx, maxMessageSize, func(bwreq blockwise.Message) error {
 		return cc.writeMessage(bwreq.(*pool.Message))
 	})
 }
@@ -408,6 +468,96 @@ func (cc *ClientConn) Delete(ctx context.Context, path string, opts ...message.O
 	return cc.Do(req)
 }
 
+func newRequestWithPayload(ctx context.Context, code codes.Code, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := newCommonRequest(ctx, code, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.SetContentFormat(contentFormat)
+		req.SetBody(payload)
+	}
+	return req, nil
+}
+
+// NewFetchRequest creates fetch request (RFC 8132).
+//
+// Use ctx to set timeout.
+//
+// If payload is nil then content format is not used.
+func NewFetchRequest(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	return newRequestWithPayload(ctx, codes.FETCH, path, contentFormat, payload, opts...)
+}
+
+// Fetch issues a FETCH to the specified path. The payload describes which parts of the resource are requested.
+//
+// Use ctx to set timeout.
+//
+// An error is returned if by failure to speak COAP (such as a network connectivity problem).
+// Any status code doesn't cause an error.
+//
+// If payload is nil then content format is not used.
+func (cc *ClientConn) Fetch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := NewFetchRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create fetch request: %w", err)
+	}
+	defer pool.ReleaseMessage(req)
+	return cc.Do(req)
+}
+
+// NewPatchRequest creates patch request (RFC 8132).
+//
+// Use ctx to set timeout.
+//
+// If payload is nil then content format is not used.
+func NewPatchRequest(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	return newRequestWithPayload(ctx, codes.PATCH, path, contentFormat, payload, opts...)
+}
+
+// Patch issues a PATCH to the specified path. The payload describes changes of the resource.
+//
+// Use ctx to set timeout.
+//
+// An error is returned if by failure to speak COAP (such as a network connectivity problem).
+// Any status code doesn't cause an error.
+//
+// If payload is nil then content format is not used.
+func (cc *ClientConn) Patch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := NewPatchRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create patch request: %w", err)
+	}
+	defer pool.ReleaseMessage(req)
+	return cc.Do(req)
+}
+
+// NewIPatchRequest creates idempotent patch request (RFC 8132).
+//
+// Use ctx to set timeout.
+//
+// If payload is nil then content format is not used.
+func NewIPatchRequest(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	return newRequestWithPayload(ctx, codes.IPATCH, path, contentFormat, payload, opts...)
+}
+
+// IPatch issues an iPATCH to the specified path. Unlike PATCH, the changes can be applied repeatedly with the same result.
+//
+// Use ctx to set timeout.
+//
+// An error is returned if by failure to speak COAP (such as a network connectivity problem).
+// Any status code doesn't cause an error.
+//
+// If payload is nil then content format is not used.
+func (cc *ClientConn) IPatch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := NewIPatchRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create ipatch request: %w", err)
+	}
+	defer pool.ReleaseMessage(req)
+	return cc.Do(req)
+}
+
 // Context returns the client's context.
 //
 // If connections was closed context is cancelled.
@@ -419,6 +569,18 @@ func (cc *ClientConn) Context() context.Context {
 //
 // Use ctx to set timeout.
 func (cc *ClientConn) Ping(ctx context.Context) error {
+	return cc.ping(ctx, false)
+}
+
+// CustodyPing issues a PING with the Custody option, the peer responds by PONG after it sent
+// responses to all pending requests (RFC 8323 section 5.4).
+//
+// Use ctx to set timeout.
+func (cc *ClientConn) CustodyPing(ctx context.Context) error {
+	return cc.ping(ctx, true)
+}
+
+func (cc *ClientConn) ping(ctx context.Context, custody bool) error {
 	resp := make(chan bool, 1)
 	receivedPong := func() {
 		select {
@@ -426,7 +588,7 @@ func (cc *ClientConn) Ping(ctx context.Context) error {
 		default:
 		}
 	}
-	cancel, err := cc.AsyncPing(receivedPong)
+	cancel, err := cc.asyncPing(receivedPong, custody)
 	if err != nil {
 		return err
 	}
@@ -441,6 +603,10 @@ func (cc *ClientConn) Ping(ctx context.Context) error {
 
 // AsyncPing sends ping and receivedPong will be called when pong arrives. It returns cancellation of ping operation.
 func (cc *ClientConn) AsyncPing(receivedPong func()) (func(), error) {
+	return cc.asyncPing(receivedPong, false)
+}
+
+func (cc *ClientConn) asyncPing(receivedPong func(), custody bool) (func(), error) {
 	token, err := message.GetToken()
 	if err != nil {
 		return nil, fmt.Errorf("cannot get token: %w", err)
@@ -448,6 +614,9 @@ func (cc *ClientConn) AsyncPing(receivedPong func()) (func(), error) {
 	req := pool.AcquireMessage(cc.Context())
 	req.SetToken(token)
 	req.SetCode(codes.Ping)
+	if custody {
+		req.SetOptionBytes(coapTCP.Custody, nil)
+	}
 	defer pool.ReleaseMessage(req)
 
 	err = cc.session.TokenHandler().Insert(token, func(w *ResponseWriter, r *pool.Message) {
@@ -473,6 +642,57 @@ func (cc *ClientConn) Run() (err error) {
 	return cc.session.Run(cc)
 }
 
+// Release informs the peer that the connection will be closed so it shouldn't send new requests,
+// eg. when the connection is drained by a load balancer. The peer can reconnect to alternativeAddress
+// after holdOff, empty alternativeAddress and zero holdOff are omitted. Requests which start new
+// exchanges are answered by 5.03 Service Unavailable afterwards.
+func (cc *ClientConn) Release(ctx context.Context, alternativeAddress string, holdOff time.Duration) error {
+	cc.Drain()
+	err := cc.session.sendRelease(ctx, alternativeAddress, holdOff)
+	if err != nil {
+		return fmt.Errorf("cannot send release: %w", err)
+	}
+	return nil
+}
+
+// Abort sends the Abort message with the diagnostic payload to the peer and closes the connection.
+func (cc *ClientConn) Abort(ctx context.Context, diagnostic string) error {
+	defer cc.Close()
+	err := cc.session.sendAbort(ctx, 0, diagnostic)
+	if err != nil {
+		return fmt.Errorf("cannot send abort: %w", err)
+	}
+	return nil
+}
+
+// Released returns the Release message of the peer. New requests aren't sent after the peer
+// released the connection.
+func (cc *ClientConn) Released() (ReleaseSignal, bool) {
+	return cc.session.Released()
+}
+
+// ReleaseReceived returns channel which is closed when the peer releases the connection by the Release message.
+func (cc *ClientConn) ReleaseReceived() <-chan struct{} {
+	return cc.session.ReleaseReceived()
+}
+
+// PeerCSM returns capabilities which the peer advertised by CSM message, false is returned until it is received.
+// When the peer doesn't send CSM in time (WithPeerCSMTimeout), defaults of RFC 8323 are returned.
+func (cc *ClientConn) PeerCSM() (CSM, bool) {
+	return cc.session.PeerCSM()
+}
+
+// BERTEnabled returns true when blockwise transfers of the connection use BERT.
+func (cc *ClientConn) BERTEnabled() bool {
+	return cc.session.BERTEnabled()
+}
+
+// Drain rejects requests which start new exchanges by 5.03 Service Unavailable. Requests
+// of blockwise transfers in progress are still served. It is used by graceful shutdown of the server.
+func (cc *ClientConn) Drain() {
+	cc.session.drain()
+}
+
 // AddOnClose calls function on close connection event.
 func (cc *ClientConn) AddOnClose(f EventFunc) {
 	cc.session.AddOnClose(f)
@@ -17,6 +17,7 @@ import (
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/tcp/message/pool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -601,3 +602,305 @@ func TestClient_KeepAliveMonitor(t *testing.T) {
 	checkCloseWg.Wait()
 	require.True(t, inactivityDetected)
 }
+
+func TestClientConn_FetchPatchIPatch(t *testing.T) {
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		buf := bytes.NewBuffer(nil)
+		_, err := buf.ReadFrom(r.Body)
+		require.NoError(t, err)
+		code := codes.Changed
+		if r.Code == codes.FETCH {
+			code = codes.Content
+		}
+		err = w.SetResponse(code, message.AppOctets, bytes.NewReader(append([]byte(r.Code.String()), buf.Bytes()...)))
+		require.NoError(t, err)
+	}))
+
+	s := NewServer(WithMux(m))
+	defer s.Stop()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := Dial(l.Addr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	payload := make([]byte, 5330)
+	tests := []struct {
+		code     codes.Code
+		wantCode codes.Code
+		do       func(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error)
+	}{
+		{code: codes.FETCH, wantCode: codes.Content, do: cc.Fetch},
+		{code: codes.PATCH, wantCode: codes.Changed, do: cc.Patch},
+		{code: codes.IPATCH, wantCode: codes.Changed, do: cc.IPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			got, err := tt.do(ctx, "/a", message.AppOctets, bytes.NewReader(payload))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCode, got.Code())
+			buf := bytes.NewBuffer(nil)
+			_, err = buf.ReadFrom(got.Body())
+			require.NoError(t, err)
+			require.Equal(t, append([]byte(tt.code.String()), payload...), buf.Bytes())
+		})
+	}
+}
+
+func newSignalTestServer(t *testing.T, handler HandlerFunc) (*ClientConn, *ClientConn, <-chan ReleaseSignal, func()) {
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	serverConns := make(chan *ClientConn, 1)
+	s := NewServer(WithHandlerFunc(handler), WithOnNewClientConn(func(cc *ClientConn, tlscon *tls.Conn) {
+		serverConns <- cc
+	}))
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	releases := make(chan ReleaseSignal, 1)
+	cc, err := Dial(l.Addr().String(), WithOnRelease(func(cc *ClientConn, release ReleaseSignal) {
+		releases <- release
+	}))
+	require.NoError(t, err)
+	return cc, <-serverConns, releases, func() {
+		cc.Close()
+		s.Stop()
+		wg.Wait()
+		l.Close()
+	}
+}
+
+func TestClientConn_Release(t *testing.T) {
+	cc, serverCC, releases, shutdown := newSignalTestServer(t, func(w *ResponseWriter, r *pool.Message) {
+		w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+	})
+	defer shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	_, released := cc.Released()
+	require.False(t, released)
+	select {
+	case <-cc.ReleaseReceived():
+		require.Fail(t, "release was received")
+	default:
+	}
+
+	err := serverCC.Release(ctx, "127.0.0.1:5683", time.Millisecond*1500)
+	require.NoError(t, err)
+	release := <-releases
+	require.Equal(t, ReleaseSignal{AlternativeAddress: "127.0.0.1:5683", HoldOff: time.Second * 2}, release)
+	got, released := cc.Released()
+	require.True(t, released)
+	require.Equal(t, release, got)
+	select {
+	case <-cc.ReleaseReceived():
+	default:
+		require.Fail(t, "release wasn't received")
+	}
+
+	// new requests aren't sent to the released connection
+	_, err = cc.Get(ctx, "/a")
+	require.ErrorIs(t, err, ErrConnectionReleased)
+	err = cc.Ping(ctx)
+	require.NoError(t, err)
+
+	// hold-off is respected before reconnecting
+	dialCtx, dialCancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer dialCancel()
+	_, err = release.Dial(dialCtx, "")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClientConn_Abort(t *testing.T) {
+	entered := make(chan struct{})
+	done := make(chan struct{})
+	cc, serverCC, _, shutdown := newSignalTestServer(t, func(w *ResponseWriter, r *pool.Message) {
+		close(entered)
+		<-done
+	})
+	defer shutdown()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cc.Get(ctx, "/a")
+		errs <- err
+	}()
+	<-entered
+	err := serverCC.Abort(ctx, "maintenance")
+	require.NoError(t, err)
+
+	err = <-errs
+	var abortErr *AbortError
+	require.ErrorAs(t, err, &abortErr)
+	require.Equal(t, "maintenance", abortErr.Diagnostic)
+	require.Equal(t, uint32(0), abortErr.BadCSMOption)
+	<-cc.Context().Done()
+}
+
+func TestClientConn_CustodyPing(t *testing.T) {
+	entered := make(chan struct{})
+	done := make(chan struct{})
+	cc, _, _, shutdown := newSignalTestServer(t, func(w *ResponseWriter, r *pool.Message) {
+		close(entered)
+		<-done
+		w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+	})
+	defer shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	events := make(chan string, 2)
+	go func() {
+		resp, err := cc.Get(ctx, "/a")
+		require.NoError(t, err)
+		require.Equal(t, codes.Content, resp.Code())
+		events <- "response"
+	}()
+	<-entered
+	go func() {
+		err := cc.CustodyPing(ctx)
+		require.NoError(t, err)
+		events <- "pong"
+	}()
+
+	select {
+	case e := <-events:
+		require.FailNow(t, "unexpected event before the response is sent", e)
+	case <-time.After(time.Millisecond * 100):
+	}
+	close(done)
+	// the pong is sent after the response, but the client processes the pong in the reading goroutine
+	require.ElementsMatch(t, []string{"response", "pong"}, []string{<-events, <-events})
+}
+
+func TestClientConn_CSM(t *testing.T) {
+	payload := make([]byte, 100000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	const extension = message.OptionID(6)
+	const criticalExtension = message.OptionID(7)
+	peerCSMs := make(chan CSM, 1)
+	s := NewServer(
+		WithHandlerFunc(func(w *ResponseWriter, r *pool.Message) {
+			csm, ok := w.ClientConn().PeerCSM()
+			require.True(t, ok)
+			select {
+			case peerCSMs <- csm:
+			default:
+			}
+			w.SetResponse(codes.Content, message.AppOctets, bytes.NewReader(payload))
+		}),
+		WithCSMOptions(message.Option{ID: extension, Value: []byte("ext")}),
+		WithAcceptedCSMOptions(criticalExtension),
+	)
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	cc, err := Dial(l.Addr().String(), WithMaxMessageSize(32*1024), WithCSMOptions(message.Option{ID: criticalExtension, Value: []byte{1}}))
+	require.NoError(t, err)
+	defer cc.Close()
+	resp, err := cc.Get(ctx, "/a")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Equal(t, payload, body)
+
+	csm, ok := cc.PeerCSM()
+	require.True(t, ok)
+	require.Equal(t, uint32(64*1024), csm.MaxMessageSize)
+	require.True(t, csm.BlockWiseTransfer)
+	require.Equal(t, message.Options{{ID: extension, Value: []byte("ext")}}, csm.Options)
+	require.True(t, cc.BERTEnabled())
+
+	csm = <-peerCSMs
+	require.Equal(t, uint32(32*1024), csm.MaxMessageSize)
+	require.True(t, csm.BlockWiseTransfer)
+	require.Equal(t, message.Options{{ID: criticalExtension, Value: []byte{1}}}, csm.Options)
+
+	// the server doesn't understand the critical option
+	cc1, err := Dial(l.Addr().String(), WithCSMOptions(message.Option{ID: 9}))
+	require.NoError(t, err)
+	defer cc1.Close()
+	<-cc1.Context().Done()
+	_, err = cc1.Get(ctx, "/a")
+	var abortErr *AbortError
+	require.ErrorAs(t, err, &abortErr)
+	require.Equal(t, uint32(9), abortErr.BadCSMOption)
+}
+
+func TestClientConn_PeerCSMTimeout(t *testing.T) {
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// the server doesn't send CSM
+	s := NewServer(WithHandlerFunc(func(w *ResponseWriter, r *pool.Message) {
+		w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("hello")))
+	}), WithDisableTCPSignalMessageCSM())
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	cc, err := Dial(l.Addr().String(), WithPeerCSMTimeout(time.Millisecond*50))
+	require.NoError(t, err)
+	defer cc.Close()
+	_, ok := cc.PeerCSM()
+	require.False(t, ok)
+	resp, err := cc.Get(ctx, "/a")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), body)
+
+	csm, ok := cc.PeerCSM()
+	require.True(t, ok)
+	require.Equal(t, uint32(1152), csm.MaxMessageSize)
+	require.False(t, csm.BlockWiseTransfer)
+	require.False(t, cc.BERTEnabled())
+}
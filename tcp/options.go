@@ -6,6 +6,8 @@ import (
 	"net"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/net/blockwise"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
 )
@@ -110,20 +112,20 @@ type KeepAliveOpt struct {
 }
 
 func (o KeepAliveOpt) apply(opts *serverOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
 		keepalive := inactivity.NewKeepAlive(o.maxRetries, o.onInactive, func(cc inactivity.ClientConn, receivePong func()) (func(), error) {
 			return cc.(*ClientConn).AsyncPing(receivePong)
 		})
-		return inactivity.NewInactivityMonitor(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive)
+		return inactivity.NewInactivityMonitorWithClock(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive, clk)
 	}
 }
 
 func (o KeepAliveOpt) applyDial(opts *dialOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
 		keepalive := inactivity.NewKeepAlive(o.maxRetries, o.onInactive, func(cc inactivity.ClientConn, receivePong func()) (func(), error) {
 			return cc.(*ClientConn).AsyncPing(receivePong)
 		})
-		return inactivity.NewInactivityMonitor(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive)
+		return inactivity.NewInactivityMonitorWithClock(o.timeout/time.Duration(o.maxRetries+1), keepalive.OnInactive, clk)
 	}
 }
 
@@ -143,14 +145,14 @@ type InactivityMonitorOpt struct {
 }
 
 func (o InactivityMonitorOpt) apply(opts *serverOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
-		return inactivity.NewInactivityMonitor(o.duration, o.onInactive)
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
+		return inactivity.NewInactivityMonitorWithClock(o.duration, o.onInactive, clk)
 	}
 }
 
 func (o InactivityMonitorOpt) applyDial(opts *dialOptions) {
-	opts.createInactivityMonitor = func() inactivity.Monitor {
-		return inactivity.NewInactivityMonitor(o.duration, o.onInactive)
+	opts.createInactivityMonitor = func(clk clock.Clock) inactivity.Monitor {
+		return inactivity.NewInactivityMonitorWithClock(o.duration, o.onInactive, clk)
 	}
 }
 
@@ -162,6 +164,25 @@ func WithInactivityMonitor(duration time.Duration, onInactive inactivity.OnInact
 	}
 }
 
+// ClockOpt clock option.
+type ClockOpt struct {
+	clock clock.Clock
+}
+
+func (o ClockOpt) apply(opts *serverOptions) {
+	opts.clock = o.clock
+}
+
+func (o ClockOpt) applyDial(opts *dialOptions) {
+	opts.clock = o.clock
+}
+
+// WithClock sets clock of inactivity monitors and expiration of blockwise transfers.
+// Eg. clock.NewFake lets tests advance the time manually.
+func WithClock(c clock.Clock) ClockOpt {
+	return ClockOpt{clock: c}
+}
+
 // NetOpt network option.
 type NetOpt struct {
 	net string
@@ -222,6 +243,44 @@ func WithBlockwise(enable bool, szx blockwise.SZX, transferTimeout time.Duration
 	}
 }
 
+// OnShutdownOpt server option.
+type OnShutdownOpt struct {
+	onShutdown OnShutdownFunc
+}
+
+func (o OnShutdownOpt) apply(opts *serverOptions) {
+	opts.onShutdown = o.onShutdown
+}
+
+// WithOnShutdown sets function which is called by Shutdown before the server is drained,
+// eg. mux.Observers.Shutdown to notify observers.
+func WithOnShutdown(onShutdown OnShutdownFunc) OnShutdownOpt {
+	return OnShutdownOpt{
+		onShutdown: onShutdown,
+	}
+}
+
+// OnReleaseOpt network option.
+type OnReleaseOpt struct {
+	onRelease OnReleaseFunc
+}
+
+func (o OnReleaseOpt) apply(opts *serverOptions) {
+	opts.onRelease = o.onRelease
+}
+
+func (o OnReleaseOpt) applyDial(opts *dialOptions) {
+	opts.onRelease = o.onRelease
+}
+
+// WithOnRelease sets function which is called when the peer releases the connection by the Release
+// message. ReleaseSignal.Dial can be used to reconnect to the alternative address after hold-off.
+func WithOnRelease(onRelease OnReleaseFunc) OnReleaseOpt {
+	return OnReleaseOpt{
+		onRelease: onRelease,
+	}
+}
+
 // OnNewClientConnOpt network option.
 type OnNewClientConnOpt struct {
 	onNewClientConn OnNewClientConnFunc
@@ -275,6 +334,62 @@ func WithDisableTCPSignalMessageCSM() DisableTCPSignalMessageCSMOpt {
 	return DisableTCPSignalMessageCSMOpt{}
 }
 
+// CSMOptionsOpt coap-tcp csm option.
+type CSMOptionsOpt struct {
+	opts message.Options
+}
+
+func (o CSMOptionsOpt) apply(opts *serverOptions) {
+	opts.csmOptions = o.opts
+}
+
+func (o CSMOptionsOpt) applyDial(opts *dialOptions) {
+	opts.csmOptions = o.opts
+}
+
+// WithCSMOptions sends extension options in CSM message.
+func WithCSMOptions(opts ...message.Option) CSMOptionsOpt {
+	return CSMOptionsOpt{opts: opts}
+}
+
+// AcceptedCSMOptionsOpt coap-tcp csm option.
+type AcceptedCSMOptionsOpt struct {
+	ids []message.OptionID
+}
+
+func (o AcceptedCSMOptionsOpt) apply(opts *serverOptions) {
+	opts.acceptedCSMOptions = o.ids
+}
+
+func (o AcceptedCSMOptionsOpt) applyDial(opts *dialOptions) {
+	opts.acceptedCSMOptions = o.ids
+}
+
+// WithAcceptedCSMOptions accepts critical extension options in peer's CSM message. The connection
+// is aborted with Bad-CSM-Option when the peer sends other unknown critical option.
+func WithAcceptedCSMOptions(ids ...message.OptionID) AcceptedCSMOptionsOpt {
+	return AcceptedCSMOptionsOpt{ids: ids}
+}
+
+// PeerCSMTimeoutOpt coap-tcp csm option.
+type PeerCSMTimeoutOpt struct {
+	timeout time.Duration
+}
+
+func (o PeerCSMTimeoutOpt) apply(opts *serverOptions) {
+	opts.peerCSMTimeout = o.timeout
+}
+
+func (o PeerCSMTimeoutOpt) applyDial(opts *dialOptions) {
+	opts.peerCSMTimeout = o.timeout
+}
+
+// WithPeerCSMTimeout sets how long requests wait for peer's CSM message. When it isn't received in time,
+// defaults of RFC 8323 are used: max message size 1152 bytes without blockwise transfers. Default is 500ms.
+func WithPeerCSMTimeout(timeout time.Duration) PeerCSMTimeoutOpt {
+	return PeerCSMTimeoutOpt{timeout: timeout}
+}
+
 // TLSOpt tls configuration option.
 type TLSOpt struct {
 	tlsCfg *tls.Config
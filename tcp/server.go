@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/net/blockwise"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
@@ -41,6 +43,11 @@ type BlockwiseFactoryFunc = func(getSendedRequest func(token message.Token) (blo
 // "read-only" parameter, mainly used to get the peer certificate from the underlining connection
 type OnNewClientConnFunc = func(cc *ClientConn, tlscon *tls.Conn)
 
+type OnShutdownFunc = func(ctx context.Context)
+
+// OnReleaseFunc is called when the peer releases the connection by the Release message.
+type OnReleaseFunc = func(cc *ClientConn, release ReleaseSignal)
+
 var defaultServerOptions = serverOptions{
 	ctx:            context.Background(),
 	maxMessageSize: 64 * 1024,
@@ -61,7 +68,8 @@ var defaultServerOptions = serverOptions{
 	blockwiseTransferTimeout: time.Second * 3,
 	onNewClientConn:          func(cc *ClientConn, tlscon *tls.Conn) {},
 	heartBeat:                time.Millisecond * 100,
-	createInactivityMonitor: func() inactivity.Monitor {
+	peerCSMTimeout:           defaultPeerCSMTimeout,
+	createInactivityMonitor: func(clock.Clock) inactivity.Monitor {
 		return inactivity.NewNilMonitor()
 	},
 }
@@ -72,7 +80,7 @@ type serverOptions struct {
 	handler                         HandlerFunc
 	errors                          ErrorFunc
 	goPool                          GoPoolFunc
-	createInactivityMonitor         func() inactivity.Monitor
+	createInactivityMonitor         func(clock.Clock) inactivity.Monitor
 	blockwiseSZX                    blockwise.SZX
 	blockwiseEnable                 bool
 	blockwiseTransferTimeout        time.Duration
@@ -80,6 +88,12 @@ type serverOptions struct {
 	heartBeat                       time.Duration
 	disablePeerTCPSignalMessageCSMs bool
 	disableTCPSignalMessageCSM      bool
+	onShutdown                      OnShutdownFunc
+	clock                           clock.Clock
+	onRelease                       OnReleaseFunc
+	csmOptions                      message.Options
+	acceptedCSMOptions              []message.OptionID
+	peerCSMTimeout                  time.Duration
 }
 
 // Listener defined used by coap
@@ -89,11 +103,15 @@ type Listener interface {
 }
 
 type Server struct {
+	// This field needs to be the first in the struct to ensure proper word alignment on 32-bit platforms.
+	// See: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
+	activeTasks                     int64
+	shuttingDown                    uint32
 	maxMessageSize                  int
 	handler                         HandlerFunc
 	errors                          ErrorFunc
 	goPool                          GoPoolFunc
-	createInactivityMonitor         func() inactivity.Monitor
+	createInactivityMonitor         func(clock.Clock) inactivity.Monitor
 	blockwiseSZX                    blockwise.SZX
 	blockwiseEnable                 bool
 	blockwiseTransferTimeout        time.Duration
@@ -101,9 +119,21 @@ type Server struct {
 	heartBeat                       time.Duration
 	disablePeerTCPSignalMessageCSMs bool
 	disableTCPSignalMessageCSM      bool
+	onShutdown                      OnShutdownFunc
+	clock                           clock.Clock
+	onRelease                       OnReleaseFunc
+	csmOptions                      message.Options
+	acceptedCSMOptions              []message.OptionID
+	peerCSMTimeout                  time.Duration
 
 	ctx    context.Context
 	cancel context.CancelFunc
+	// acceptCtx is canceled when the server stops accepting new connections.
+	acceptCtx    context.Context
+	cancelAccept context.CancelFunc
+
+	conns      map[*ClientConn]struct{}
+	connsMutex sync.Mutex
 
 	listen      Listener
 	listenMutex sync.Mutex
@@ -116,16 +146,19 @@ func NewServer(opt ...ServerOption) *Server {
 	}
 
 	ctx, cancel := context.WithCancel(opts.ctx)
+	acceptCtx, cancelAccept := context.WithCancel(ctx)
 
 	if opts.createInactivityMonitor == nil {
-		opts.createInactivityMonitor = func() inactivity.Monitor {
+		opts.createInactivityMonitor = func(clock.Clock) inactivity.Monitor {
 			return inactivity.NewNilMonitor()
 		}
 	}
 
-	return &Server{
+	s := &Server{
 		ctx:            ctx,
 		cancel:         cancel,
+		acceptCtx:      acceptCtx,
+		cancelAccept:   cancelAccept,
 		handler:        opts.handler,
 		maxMessageSize: opts.maxMessageSize,
 		errors: func(err error) {
@@ -135,7 +168,6 @@ func NewServer(opt ...ServerOption) *Server {
 			}
 			opts.errors(fmt.Errorf("tcp: %w", err))
 		},
-		goPool:                          opts.goPool,
 		blockwiseSZX:                    opts.blockwiseSZX,
 		blockwiseEnable:                 opts.blockwiseEnable,
 		blockwiseTransferTimeout:        opts.blockwiseTransferTimeout,
@@ -144,7 +176,16 @@ func NewServer(opt ...ServerOption) *Server {
 		disableTCPSignalMessageCSM:      opts.disableTCPSignalMessageCSM,
 		onNewClientConn:                 opts.onNewClientConn,
 		createInactivityMonitor:         opts.createInactivityMonitor,
+		onShutdown:                      opts.onShutdown,
+		clock:                           clock.Get(opts.clock),
+		onRelease:                       opts.onRelease,
+		csmOptions:                      opts.csmOptions,
+		acceptedCSMOptions:              opts.acceptedCSMOptions,
+		peerCSMTimeout:                  opts.peerCSMTimeout,
+		conns:                           make(map[*ClientConn]struct{}),
 	}
+	s.goPool = s.trackTasks(opts.goPool)
+	return s
 }
 
 func (s *Server) checkAndSetListener(l Listener) error {
@@ -167,7 +208,7 @@ func (s *Server) checkAcceptError(err error) (bool, error) {
 		return false, nil
 	case context.DeadlineExceeded, context.Canceled:
 		select {
-		case <-s.ctx.Done():
+		case <-s.acceptCtx.Done():
 		default:
 			s.errors(fmt.Errorf("cannot accept connection: %w", err))
 			return true, nil
@@ -196,7 +237,7 @@ func (s *Server) Serve(l Listener) error {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 	for {
-		rw, err := l.AcceptWithContext(s.ctx)
+		rw, err := l.AcceptWithContext(s.acceptCtx)
 		ok, err := s.checkAcceptError(err)
 		if err != nil {
 			return err
@@ -209,7 +250,7 @@ func (s *Server) Serve(l Listener) error {
 			go func() {
 				defer wg.Done()
 				var cc *ClientConn
-				monitor := s.createInactivityMonitor()
+				monitor := s.createInactivityMonitor(s.clock)
 				opts := []coapNet.ConnOption{
 					coapNet.WithHeartBeat(s.heartBeat),
 					coapNet.WithOnReadTimeout(func() error {
@@ -239,10 +280,88 @@ func (s *Server) Stop() {
 	s.cancel()
 }
 
+// Shutdown gracefully stops the server. New connections aren't accepted, the peers are informed by
+// the Release signal with Hold-Off set to the time remaining until the deadline of ctx and requests which
+// start new exchanges are answered by 5.03 Service Unavailable, while active handlers and blockwise
+// transfers are allowed to finish. The function set by WithOnShutdown is called before the server
+// is drained, eg. to notify observers. Shutdown stops the server when it is drained or when ctx expires,
+// in that case ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connsMutex.Lock()
+	atomic.StoreUint32(&s.shuttingDown, 1)
+	s.connsMutex.Unlock()
+	s.cancelAccept()
+	conns := s.getClientConns()
+	for _, cc := range conns {
+		cc.Drain()
+	}
+	if s.onShutdown != nil {
+		s.onShutdown(ctx)
+	}
+	var holdOff time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		holdOff = time.Until(deadline)
+	}
+	for _, cc := range conns {
+		err := cc.Release(ctx, "", holdOff)
+		if err != nil {
+			s.errors(fmt.Errorf("%v: cannot send release: %w", cc.RemoteAddr(), err))
+		}
+	}
+	err := s.waitForDrain(ctx)
+	s.Stop()
+	return err
+}
+
+// shutdownPollInterval is how often Shutdown checks whether the server is drained.
+const shutdownPollInterval = time.Millisecond * 10
+
+func (s *Server) waitForDrain(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *Server) drained() bool {
+	if atomic.LoadInt64(&s.activeTasks) > 0 {
+		return false
+	}
+	for _, cc := range s.getClientConns() {
+		if cc.session.transfers() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// trackTasks counts tasks of goPool which are in progress.
+func (s *Server) trackTasks(goPool GoPoolFunc) GoPoolFunc {
+	return func(f func()) error {
+		atomic.AddInt64(&s.activeTasks, 1)
+		err := goPool(func() {
+			defer atomic.AddInt64(&s.activeTasks, -1)
+			f()
+		})
+		if err != nil {
+			atomic.AddInt64(&s.activeTasks, -1)
+		}
+		return err
+	}
+}
+
 func (s *Server) createClientConn(connection *coapNet.Conn, monitor inactivity.Monitor) *ClientConn {
 	var blockWise *blockwise.BlockWise
 	if s.blockwiseEnable {
-		blockWise = blockwise.NewBlockWise(
+		blockWise = blockwise.NewBlockWiseWithClock(
 			bwAcquireMessage,
 			bwReleaseMessage,
 			s.blockwiseTransferTimeout,
@@ -251,11 +370,12 @@ func (s *Server) createClientConn(connection *coapNet.Conn, monitor inactivity.M
 			func(token message.Token) (blockwise.Message, bool) {
 				return nil, false
 			},
+			s.clock,
 		)
 	}
 	obsHandler := NewHandlerContainer()
 	cc := NewClientConn(
-		NewSession(
+		newSession(
 			s.ctx,
 			connection,
 			NewObservationHandler(obsHandler, s.handler),
@@ -267,9 +387,32 @@ func (s *Server) createClientConn(connection *coapNet.Conn, monitor inactivity.M
 			s.disablePeerTCPSignalMessageCSMs,
 			s.disableTCPSignalMessageCSM,
 			true,
-			monitor),
+			monitor,
+			csmConfig{options: s.csmOptions, accepted: s.acceptedCSMOptions, timeout: s.peerCSMTimeout, clock: s.clock}),
 		obsHandler, kitSync.NewMap(),
 	)
+	cc.session.onRelease = s.onRelease
+	s.connsMutex.Lock()
+	s.conns[cc] = struct{}{}
+	if atomic.LoadUint32(&s.shuttingDown) == 1 {
+		cc.Drain()
+	}
+	s.connsMutex.Unlock()
+	cc.AddOnClose(func() {
+		s.connsMutex.Lock()
+		defer s.connsMutex.Unlock()
+		delete(s.conns, cc)
+	})
 
 	return cc
 }
+
+func (s *Server) getClientConns() []*ClientConn {
+	s.connsMutex.Lock()
+	defer s.connsMutex.Unlock()
+	conns := make([]*ClientConn, 0, len(s.conns))
+	for cc := range s.conns {
+		conns = append(conns, cc)
+	}
+	return conns
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/plgd-dev/go-coap/v2/examples/dtls/pki"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
 	"github.com/plgd-dev/go-coap/v2/tcp"
@@ -264,3 +265,88 @@ func TestServer_KeepAliveMonitor(t *testing.T) {
 	checkCloseWg.Wait()
 	require.True(t, inactivityDetected)
 }
+
+func TestServer_Shutdown(t *testing.T) {
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	defer l.Close()
+
+	observers := mux.NewObservers(0, nil)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	m := mux.NewRouter()
+	m.Use(observers.Middleware)
+	m.Handle("/obs", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("obs")))
+		require.NoError(t, err)
+	}))
+	m.Handle("/slow", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		close(entered)
+		<-release
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("slow")))
+		require.NoError(t, err)
+	}))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := tcp.NewServer(tcp.WithMux(m), tcp.WithOnShutdown(observers.Shutdown))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	releases := make(chan tcp.ReleaseSignal, 1)
+	cc, err := tcp.Dial(l.Addr().String(), tcp.WithOnRelease(func(cc *tcp.ClientConn, release tcp.ReleaseSignal) {
+		releases <- release
+	}))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	notifications := make(chan codes.Code, 4)
+	_, err = cc.Observe(ctx, "/obs", func(n *pool.Message) {
+		notifications <- n.Code()
+	})
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, <-notifications)
+
+	slowResp := make(chan codes.Code, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := cc.Get(ctx, "/slow")
+		require.NoError(t, err)
+		slowResp <- resp.Code()
+	}()
+	<-entered
+
+	shutdownErr := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shutdownErr <- s.Shutdown(ctx)
+	}()
+
+	// observers are notified by 5.03 and the connection is released
+	require.Equal(t, codes.ServiceUnavailable, <-notifications)
+	sig := <-releases
+	require.Empty(t, sig.AlternativeAddress)
+	require.Equal(t, time.Second*5, sig.HoldOff)
+	_, err = cc.Get(ctx, "/obs")
+	require.ErrorIs(t, err, tcp.ErrConnectionReleased)
+	select {
+	case err := <-shutdownErr:
+		require.FailNow(t, "shutdown finished before active handler", err)
+	default:
+	}
+
+	// active handler finishes
+	close(release)
+	require.Equal(t, codes.Content, <-slowResp)
+	require.NoError(t, <-shutdownErr)
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
@@ -24,6 +27,7 @@ type Session struct {
 	// See: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
 	sequence   uint64
 	connection *coapNet.Conn
+	draining   uint32
 
 	maxMessageSize                  int
 	peerMaxMessageSize              uint32
@@ -48,7 +52,18 @@ type Session struct {
 	cancel context.CancelFunc
 	ctx    atomic.Value
 
-	errSendCSM error
+	errSendCSM      error
+	csm             csmConfig
+	peerCSMMutex    sync.Mutex
+	peerCSM         atomic.Value
+	peerCSMReceived chan struct{}
+
+	pendingResponses pendingResponses
+	onRelease        OnReleaseFunc
+	release          atomic.Value
+	releaseOnce      sync.Once
+	releaseReceived  chan struct{}
+	abortErr         atomic.Value
 }
 
 func NewSession(
@@ -64,6 +79,36 @@ func NewSession(
 	disableTCPSignalMessageCSM bool,
 	closeSocket bool,
 	inactivityMonitor Notifier,
+) *Session {
+	return newSession(ctx, connection, handler, maxMessageSize, goPool, errors, blockwiseSZX, blockWise,
+		disablePeerTCPSignalMessageCSMs, disableTCPSignalMessageCSM, closeSocket, inactivityMonitor, csmConfig{})
+}
+
+// csmConfig contains extension options of CSM messages.
+type csmConfig struct {
+	// options are extension options sent in CSM message.
+	options message.Options
+	// accepted are critical extension options of peer's CSM message which are understood.
+	accepted []message.OptionID
+	// timeout caps the wait for peer's CSM message, defaults of RFC 8323 are used after it.
+	timeout time.Duration
+	clock   clock.Clock
+}
+
+func newSession(
+	ctx context.Context,
+	connection *coapNet.Conn,
+	handler HandlerFunc,
+	maxMessageSize int,
+	goPool GoPoolFunc,
+	errors ErrorFunc,
+	blockwiseSZX blockwise.SZX,
+	blockWise *blockwise.BlockWise,
+	disablePeerTCPSignalMessageCSMs bool,
+	disableTCPSignalMessageCSM bool,
+	closeSocket bool,
+	inactivityMonitor Notifier,
+	csm csmConfig,
 ) *Session {
 	ctx, cancel := context.WithCancel(ctx)
 	if errors == nil {
@@ -88,6 +133,9 @@ func NewSession(
 		disableTCPSignalMessageCSM:      disableTCPSignalMessageCSM,
 		closeSocket:                     closeSocket,
 		inactivityMonitor:               inactivityMonitor,
+		csm:                             csm,
+		peerCSMReceived:                 make(chan struct{}),
+		releaseReceived:                 make(chan struct{}),
 	}
 	s.ctx.Store(&ctx)
 
@@ -158,12 +206,157 @@ func (s *Session) PeerBlockWiseTransferEnabled() bool {
 	return atomic.LoadUint32(&s.peerBlockWiseTranferEnabled) == 1
 }
 
+// PeerCSM returns capabilities of the peer, false is returned until the peer's CSM message is received
+// or the wait for it timed out.
+func (s *Session) PeerCSM() (CSM, bool) {
+	csm, ok := s.peerCSM.Load().(CSM)
+	if !ok {
+		return CSM{}, false
+	}
+	return csm.clone(), true
+}
+
+// BERTEnabled returns true when blockwise transfers use BERT (RFC 8323 section 6).
+func (s *Session) BERTEnabled() bool {
+	szx, _ := s.blockwiseParams()
+	return szx == blockwise.SZXBERT
+}
+
+// bertReserve is the part of the negotiated message size which is reserved for the header and options of BERT messages.
+const bertReserve = 1024
+
+// blockwiseParams returns the block size and the max message size used by blockwise transfers. When both sides
+// support blockwise transfers and the block size is SZX1024, BERT is used if the negotiated message size allows
+// to send more blocks in one message.
+func (s *Session) blockwiseParams() (blockwise.SZX, int) {
+	szx, size := s.blockwiseSZX, s.maxMessageSize
+	csm, ok := s.peerCSM.Load().(CSM)
+	if !ok || !csm.BlockWiseTransfer || s.blockWise == nil {
+		return szx, size
+	}
+	if size < 0 || int64(size) > int64(csm.MaxMessageSize) {
+		size = int(csm.MaxMessageSize)
+	}
+	if szx == blockwise.SZX1024 && size-bertReserve >= 2*int(blockwise.SZX1024.Size()) {
+		szx = blockwise.SZXBERT
+	}
+	if szx == blockwise.SZXBERT {
+		size -= bertReserve
+	}
+	return szx, size
+}
+
+// WaitForPeerCSM waits until the peer's CSM message is received. It returns immediately
+// when peer's CSM messages are ignored. When the peer doesn't send CSM in time, the defaults
+// of RFC 8323 are used: 1152 bytes max message size without blockwise transfers.
+func (s *Session) WaitForPeerCSM(ctx context.Context) error {
+	if s.disablePeerTCPSignalMessageCSMs {
+		return nil
+	}
+	timeout := s.csm.timeout
+	if timeout <= 0 {
+		timeout = defaultPeerCSMTimeout
+	}
+	timer := clock.Get(s.csm.clock).NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-s.peerCSMReceived:
+		return nil
+	case <-timer.C():
+		s.storePeerCSM(func(csm CSM, received bool) (CSM, bool) {
+			// CSM which arrives later updates the defaults
+			return csm, !received
+		})
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("cannot receive CSM of peer: %w", ctx.Err())
+	case <-s.Done():
+		if err := s.abortError(); err != nil {
+			return fmt.Errorf("connection was closed: %w", err)
+		}
+		return fmt.Errorf("connection was closed: %w", s.Context().Err())
+	}
+}
+
+// storePeerCSM updates capabilities of the peer by update, which gets the current capabilities or the defaults when
+// none were received yet. The capabilities are stored when update returns true.
+func (s *Session) storePeerCSM(update func(csm CSM, received bool) (CSM, bool)) {
+	s.peerCSMMutex.Lock()
+	defer s.peerCSMMutex.Unlock()
+	csm, received := s.peerCSM.Load().(CSM)
+	if !received {
+		csm = CSM{MaxMessageSize: defaultPeerMaxMessageSize}
+	} else {
+		csm = csm.clone()
+	}
+	csm, ok := update(csm, received)
+	if !ok {
+		return
+	}
+	atomic.StoreUint32(&s.peerMaxMessageSize, csm.MaxMessageSize)
+	if csm.BlockWiseTransfer {
+		atomic.StoreUint32(&s.peerBlockWiseTranferEnabled, 1)
+	}
+	s.peerCSM.Store(csm)
+	if !received {
+		close(s.peerCSMReceived)
+	}
+}
+
+// handleCSM updates capabilities of the peer. Unknown critical options abort the connection (RFC 8323 section 5.3).
+func (s *Session) handleCSM(r *pool.Message) {
+	s.storePeerCSM(func(csm CSM, _ bool) (CSM, bool) {
+		return s.updatePeerCSM(csm, r)
+	})
+}
+
+func (s *Session) updatePeerCSM(csm CSM, r *pool.Message) (CSM, bool) {
+	var extensions message.Options
+	for _, o := range r.Options() {
+		switch o.ID {
+		case coapTCP.MaxMessageSize:
+			if size, err := r.GetOptionUint32(coapTCP.MaxMessageSize); err == nil {
+				csm.MaxMessageSize = size
+			}
+		case coapTCP.BlockWiseTransfer:
+			csm.BlockWiseTransfer = true
+		default:
+			if isCriticalOption(o.ID) && !s.acceptCSMOption(o.ID) {
+				s.errors(fmt.Errorf("%v: unsupported critical CSM option %d", s.connection.RemoteAddr(), o.ID))
+				if err := s.sendAbort(s.Context(), o.ID, "unsupported critical CSM option"); err != nil {
+					s.errors(fmt.Errorf("cannot send abort to %v: %w", s.connection.RemoteAddr(), err))
+				}
+				s.Close()
+				return csm, false
+			}
+			extensions = append(extensions, message.Option{ID: o.ID, Value: append([]byte(nil), o.Value...)})
+		}
+	}
+	for _, o := range extensions {
+		csm.Options = csm.Options.Remove(o.ID)
+	}
+	for _, o := range extensions {
+		csm.Options = csm.Options.Add(o)
+	}
+	return csm, true
+}
+
+func (s *Session) acceptCSMOption(id message.OptionID) bool {
+	for _, a := range s.csm.accepted {
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Session) handleBlockwise(w *ResponseWriter, r *pool.Message) {
 	if s.blockWise != nil && s.PeerBlockWiseTransferEnabled() {
 		bwr := bwResponseWriter{
 			w: w,
 		}
-		s.blockWise.Handle(&bwr, r, s.blockwiseSZX, s.maxMessageSize, func(bw blockwise.ResponseWriter, br blockwise.Message) {
+		szx, maxMessageSize := s.blockwiseParams()
+		s.blockWise.Handle(&bwr, r, szx, maxMessageSize, func(bw blockwise.ResponseWriter, br blockwise.Message) {
 			h, err := s.tokenHandlerContainer.Pop(r.Token())
 			w := bw.(*bwResponseWriter).w
 			r := br.(*pool.Message)
@@ -189,28 +382,52 @@ func (s *Session) handleSignals(r *pool.Message, cc *ClientConn) bool {
 		if s.disablePeerTCPSignalMessageCSMs {
 			return true
 		}
-		if size, err := r.GetOptionUint32(coapTCP.MaxMessageSize); err == nil {
-			atomic.StoreUint32(&s.peerMaxMessageSize, size)
-		}
-		if r.HasOption(coapTCP.BlockWiseTransfer) {
-			atomic.StoreUint32(&s.peerBlockWiseTranferEnabled, 1)
-		}
+		s.handleCSM(r)
 		return true
 	case codes.Ping:
 		if r.HasOption(coapTCP.Custody) {
-			//TODO
+			// the pong is delayed until the pending responses are sent
+			token := append(message.Token(nil), r.Token()...)
+			go func() {
+				select {
+				case <-s.pendingResponses.wait():
+					s.sendPong(token, true)
+				case <-s.Done():
+				}
+			}()
+			return true
 		}
-		s.sendPong(r.Token())
+		s.sendPong(r.Token(), false)
 		return true
 	case codes.Release:
-		if r.HasOption(coapTCP.AlternativeAddress) {
-			//TODO
+		var release ReleaseSignal
+		if addr, err := r.GetOptionBytes(coapTCP.AlternativeAddress); err == nil {
+			release.AlternativeAddress = string(addr)
+		}
+		if holdOff, err := r.GetOptionUint32(coapTCP.HoldOff); err == nil {
+			release.HoldOff = time.Duration(holdOff) * time.Second
+		}
+		s.release.Store(release)
+		s.releaseOnce.Do(func() {
+			close(s.releaseReceived)
+		})
+		if s.onRelease != nil {
+			go s.onRelease(cc, release)
 		}
 		return true
 	case codes.Abort:
-		if r.HasOption(coapTCP.BadCSMOption) {
-			//TODO
+		abortErr := &AbortError{}
+		if opt, err := r.GetOptionUint32(coapTCP.BadCSMOption); err == nil {
+			abortErr.BadCSMOption = opt
+		}
+		if r.Body() != nil {
+			diagnostic, err := ioutil.ReadAll(r.Body())
+			if err == nil {
+				abortErr.Diagnostic = string(diagnostic)
+			}
 		}
+		s.abortErr.Store(abortErr)
+		s.Close()
 		return true
 	case codes.Pong:
 		h, err := s.tokenHandlerContainer.Pop(r.Token())
@@ -240,9 +457,61 @@ func (b *bwResponseWriter) RemoteAddr() net.Addr {
 }
 
 func (s *Session) Handle(w *ResponseWriter, r *pool.Message) {
+	if atomic.LoadUint32(&s.draining) == 1 && startsExchange(r) {
+		w.SetResponse(codes.ServiceUnavailable, message.TextPlain, nil)
+		return
+	}
 	s.handleBlockwise(w, r)
 }
 
+// startsExchange returns true when the request isn't a continuation of a blockwise transfer.
+func startsExchange(r *pool.Message) bool {
+	if r.Code() < codes.GET || r.Code() > codes.IPATCH {
+		return false
+	}
+	for _, id := range []message.OptionID{message.Block1, message.Block2} {
+		v, err := r.GetOptionUint32(id)
+		if err != nil {
+			continue
+		}
+		if _, num, _, err := blockwise.DecodeBlockOption(v); err == nil && num > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Released returns Release message of the peer.
+func (s *Session) Released() (ReleaseSignal, bool) {
+	release, ok := s.release.Load().(ReleaseSignal)
+	return release, ok
+}
+
+// ReleaseReceived returns channel which is closed when the peer releases the connection by the Release message.
+func (s *Session) ReleaseReceived() <-chan struct{} {
+	return s.releaseReceived
+}
+
+// abortError returns error of Abort message of the peer.
+func (s *Session) abortError() error {
+	if err, ok := s.abortErr.Load().(*AbortError); ok {
+		return err
+	}
+	return nil
+}
+
+func (s *Session) drain() {
+	atomic.StoreUint32(&s.draining, 1)
+}
+
+// transfers returns number of blockwise transfers in progress.
+func (s *Session) transfers() int {
+	if s.blockWise == nil {
+		return 0
+	}
+	return s.blockWise.Transfers()
+}
+
 func (s *Session) TokenHandler() *HandlerContainer {
 	return s.tokenHandlerContainer
 }
@@ -303,11 +572,19 @@ func (s *Session) processBuffer(buffer *bytes.Buffer, cc *ClientConn) error {
 		req.SetSequence(s.Sequence())
 		s.inactivityMonitor.Notify()
 		if s.handleSignals(req, cc) {
+			if err := s.abortError(); err != nil {
+				return err
+			}
 			continue
 		}
-		s.goPool(func() {
+		s.pendingResponses.add()
+		err = s.goPool(func() {
+			defer s.pendingResponses.done()
 			s.processReq(req, cc, s.Handle)
 		})
+		if err != nil {
+			s.pendingResponses.done()
+		}
 	}
 	return nil
 }
@@ -333,14 +610,66 @@ func (s *Session) sendCSM() error {
 	defer pool.ReleaseMessage(req)
 	req.SetCode(codes.CSM)
 	req.SetToken(token)
+	if s.maxMessageSize > 0 {
+		req.SetOptionUint32(coapTCP.MaxMessageSize, uint32(s.maxMessageSize))
+	}
+	if s.blockWise != nil {
+		req.SetOptionBytes(coapTCP.BlockWiseTransfer, nil)
+	}
+	for _, o := range s.csm.options {
+		req.AddOptionBytes(o.ID, o.Value)
+	}
+	return s.WriteMessage(req)
+}
+
+// sendRelease informs the peer that the connection will be closed, that it can reconnect
+// to alternativeAddress and that it shouldn't reconnect before holdOff (RFC 8323 section 5.5).
+func (s *Session) sendRelease(ctx context.Context, alternativeAddress string, holdOff time.Duration) error {
+	token, err := message.GetToken()
+	if err != nil {
+		return fmt.Errorf("cannot get token: %w", err)
+	}
+	req := pool.AcquireMessage(ctx)
+	defer pool.ReleaseMessage(req)
+	req.SetCode(codes.Release)
+	req.SetToken(token)
+	if alternativeAddress != "" {
+		req.SetOptionString(coapTCP.AlternativeAddress, alternativeAddress)
+	}
+	if holdOff > 0 {
+		req.SetOptionUint32(coapTCP.HoldOff, uint32((holdOff+time.Second-1)/time.Second))
+	}
 	return s.WriteMessage(req)
 }
 
-func (s *Session) sendPong(token message.Token) error {
+// sendAbort informs the peer that the connection is aborted (RFC 8323 section 5.6). The badCSMOption
+// identifies the option of CSM message which caused the abort, zero means that it isn't set.
+func (s *Session) sendAbort(ctx context.Context, badCSMOption message.OptionID, diagnostic string) error {
+	token, err := message.GetToken()
+	if err != nil {
+		return fmt.Errorf("cannot get token: %w", err)
+	}
+	req := pool.AcquireMessage(ctx)
+	defer pool.ReleaseMessage(req)
+	req.SetCode(codes.Abort)
+	req.SetToken(token)
+	if badCSMOption != 0 {
+		req.SetOptionUint32(coapTCP.BadCSMOption, uint32(badCSMOption))
+	}
+	if diagnostic != "" {
+		req.SetBody(bytes.NewReader([]byte(diagnostic)))
+	}
+	return s.WriteMessage(req)
+}
+
+func (s *Session) sendPong(token message.Token, custody bool) error {
 	req := pool.AcquireMessage(s.Context())
 	defer pool.ReleaseMessage(req)
 	req.SetCode(codes.Pong)
 	req.SetToken(token)
+	if custody {
+		req.SetOptionBytes(coapTCP.Custody, nil)
+	}
 	return s.WriteMessage(req)
 }
 
@@ -0,0 +1,123 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+)
+
+// ErrConnectionReleased is returned for new requests when the peer released the connection.
+var ErrConnectionReleased = errors.New("connection was released by peer")
+
+// ReleaseSignal describes Release message of the peer (RFC 8323 section 5.5).
+type ReleaseSignal struct {
+	// AlternativeAddress is address where the peer can be reached, empty when the peer didn't set it.
+	AlternativeAddress string
+	// HoldOff is time before the peer accepts a new connection.
+	HoldOff time.Duration
+}
+
+// Dial waits for HoldOff and connects to AlternativeAddress, or to target when the peer didn't set it.
+func (r ReleaseSignal) Dial(ctx context.Context, target string, opts ...DialOption) (*ClientConn, error) {
+	if r.HoldOff > 0 {
+		timer := time.NewTimer(r.HoldOff)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if r.AlternativeAddress != "" {
+		target = r.AlternativeAddress
+	}
+	return Dial(target, opts...)
+}
+
+// AbortError is returned when the peer aborted the connection (RFC 8323 section 5.6).
+type AbortError struct {
+	// BadCSMOption is the option of CSM message which caused the abort, zero when it is not set.
+	BadCSMOption uint32
+	// Diagnostic is the diagnostic payload of the Abort message.
+	Diagnostic string
+}
+
+func (e *AbortError) Error() string {
+	if e.BadCSMOption != 0 {
+		return fmt.Sprintf("connection was aborted by peer: bad CSM option %v: %v", e.BadCSMOption, e.Diagnostic)
+	}
+	return fmt.Sprintf("connection was aborted by peer: %v", e.Diagnostic)
+}
+
+// defaultPeerMaxMessageSize is the base value of Max-Message-Size (RFC 8323 section 5.3.1).
+const defaultPeerMaxMessageSize = 1152
+
+// defaultPeerCSMTimeout is how long requests wait for the peer's CSM message before the defaults are used.
+const defaultPeerCSMTimeout = time.Millisecond * 500
+
+// CSM describes capabilities and settings of the peer received by CSM message (RFC 8323 section 5.3).
+type CSM struct {
+	// MaxMessageSize is the maximum size of a message which the peer accepts.
+	MaxMessageSize uint32
+	// BlockWiseTransfer is true when the peer supports blockwise transfers including BERT.
+	BlockWiseTransfer bool
+	// Options are extension options of the CSM message.
+	Options message.Options
+}
+
+func (c CSM) clone() CSM {
+	opts := make(message.Options, 0, len(c.Options))
+	for _, o := range c.Options {
+		opts = append(opts, message.Option{ID: o.ID, Value: append([]byte(nil), o.Value...)})
+	}
+	c.Options = opts
+	return c
+}
+
+// isCriticalOption returns true for critical options, they have odd numbers (RFC 7252 section 5.4.6).
+func isCriticalOption(id message.OptionID) bool {
+	return id&1 == 1
+}
+
+// pendingResponses counts requests which are processed by the handler.
+type pendingResponses struct {
+	mutex   sync.Mutex
+	count   int
+	waiters []chan struct{}
+}
+
+func (p *pendingResponses) add() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.count++
+}
+
+func (p *pendingResponses) done() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.count--
+	if p.count > 0 {
+		return
+	}
+	for _, w := range p.waiters {
+		close(w)
+	}
+	p.waiters = nil
+}
+
+// wait returns channel which is closed when all pending responses are sent.
+func (p *pendingResponses) wait() <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	w := make(chan struct{})
+	if p.count == 0 {
+		close(w)
+		return w
+	}
+	p.waiters = append(p.waiters, w)
+	return w
+}
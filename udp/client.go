@@ -7,6 +7,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/net/blockwise"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
@@ -25,7 +26,7 @@ var defaultDialOptions = dialOptions{
 	heartBeat:      time.Millisecond * 100,
 	handler: func(w *client.ResponseWriter, r *pool.Message) {
 		switch r.Code() {
-		case codes.POST, codes.PUT, codes.GET, codes.DELETE:
+		case codes.POST, codes.PUT, codes.GET, codes.DELETE, codes.FETCH, codes.PATCH, codes.IPATCH:
 			w.SetResponse(codes.NotFound, message.TextPlain, nil)
 		}
 	},
@@ -44,10 +45,12 @@ var defaultDialOptions = dialOptions{
 	blockwiseEnable:                true,
 	blockwiseTransferTimeout:       time.Second * 3,
 	transmissionNStart:             time.Second,
+	nStart:                         1,
 	transmissionAcknowledgeTimeout: time.Second * 2,
 	transmissionMaxRetransmit:      4,
+	transmissionPiggybackTimeout:   time.Millisecond * 200,
 	getMID:                         udpMessage.GetMID,
-	createInactivityMonitor: func() inactivity.Monitor {
+	createInactivityMonitor: func(clock.Clock) inactivity.Monitor {
 		return inactivity.NewNilMonitor()
 	},
 }
@@ -64,12 +67,20 @@ type dialOptions struct {
 	blockwiseSZX                   blockwise.SZX
 	blockwiseEnable                bool
 	blockwiseTransferTimeout       time.Duration
+	qBlockEnable                   bool
+	qBlockMaxPayloads              int
+	qBlockNonReceiveTimeout        time.Duration
 	transmissionNStart             time.Duration
 	transmissionAcknowledgeTimeout time.Duration
 	transmissionMaxRetransmit      int
+	transmissionPiggybackTimeout   time.Duration
+	transmissionProbingRate        uint32
+	nStart                         uint32
+	congestionControl              client.CongestionControl
 	getMID                         GetMIDFunc
 	closeSocket                    bool
-	createInactivityMonitor        func() inactivity.Monitor
+	createInactivityMonitor        func(clock.Clock) inactivity.Monitor
+	clock                          clock.Clock
 }
 
 // A DialOption sets options such as credentials, keepalive parameters, etc.
@@ -123,8 +134,8 @@ func bwCreateHandlerFunc(observatioRequests *kitSync.Map) func(token message.Tok
 	}
 }
 
-// Client creates client over udp connection.
-func Client(conn *net.UDPConn, opts ...DialOption) *client.ClientConn {
+// Client creates client over udp connection, eg. *net.UDPConn or in-memory connection of coapNet.MemoryNetwork.
+func Client(conn coapNet.UDPPacketConn, opts ...DialOption) *client.ClientConn {
 	cfg := defaultDialOptions
 	for _, o := range opts {
 		o.applyDial(&cfg)
@@ -133,7 +144,7 @@ func Client(conn *net.UDPConn, opts ...DialOption) *client.ClientConn {
 		cfg.errors = func(error) {}
 	}
 	if cfg.createInactivityMonitor == nil {
-		cfg.createInactivityMonitor = func() inactivity.Monitor {
+		cfg.createInactivityMonitor = func(clock.Clock) inactivity.Monitor {
 			return inactivity.NewNilMonitor()
 		}
 	}
@@ -151,18 +162,19 @@ func Client(conn *net.UDPConn, opts ...DialOption) *client.ClientConn {
 	observatioRequests := kitSync.NewMap()
 	var blockWise *blockwise.BlockWise
 	if cfg.blockwiseEnable {
-		blockWise = blockwise.NewBlockWise(
+		blockWise = blockwise.NewBlockWiseWithClock(
 			bwAcquireMessage,
 			bwReleaseMessage,
 			cfg.blockwiseTransferTimeout,
 			cfg.errors,
 			false,
 			bwCreateHandlerFunc(observatioRequests),
+			cfg.clock,
 		)
 	}
 
 	observationTokenHandler := client.NewHandlerContainer()
-	monitor := cfg.createInactivityMonitor()
+	monitor := cfg.createInactivityMonitor(cfg.clock)
 	var cc *client.ClientConn
 	l := coapNet.NewUDPConn(cfg.net, conn, coapNet.WithHeartBeat(cfg.heartBeat), coapNet.WithErrors(cfg.errors), coapNet.WithOnReadTimeout(func() error {
 		monitor.CheckInactivity(cc)
@@ -174,7 +186,7 @@ func Client(conn *net.UDPConn, opts ...DialOption) *client.ClientConn {
 		cfg.maxMessageSize,
 		cfg.closeSocket,
 	)
-	cc = client.NewClientConn(session,
+	cc = client.NewClientConnWithClock(session,
 		observationTokenHandler, observatioRequests, cfg.transmissionNStart, cfg.transmissionAcknowledgeTimeout, cfg.transmissionMaxRetransmit,
 		client.NewObservationHandler(observationTokenHandler, cfg.handler),
 		cfg.blockwiseSZX,
@@ -183,7 +195,15 @@ func Client(conn *net.UDPConn, opts ...DialOption) *client.ClientConn {
 		cfg.errors,
 		cfg.getMID,
 		monitor,
+		cfg.clock,
 	)
+	cc.Transmission().SetTransmissionPiggybackTimeout(cfg.transmissionPiggybackTimeout)
+	cc.Transmission().SetCongestionControl(cfg.congestionControl)
+	cc.Transmission().SetTransmissionProbingRate(cfg.transmissionProbingRate)
+	cc.Transmission().SetNStart(cfg.nStart)
+	if cfg.qBlockEnable {
+		cc.EnableQBlock(cfg.qBlockMaxPayloads, cfg.qBlockNonReceiveTimeout)
+	}
 
 	go func() {
 		err := cc.Run()
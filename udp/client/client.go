@@ -7,6 +7,7 @@ import (
 
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/mux"
+	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
 	"github.com/plgd-dev/go-coap/v2/udp/message/pool"
 )
 
@@ -51,6 +52,33 @@ func (c *Client) Post(ctx context.Context, path string, contentFormat message.Me
 	return pool.ConvertTo(resp)
 }
 
+func (c *Client) Fetch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	resp, err := c.cc.Fetch(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseMessage(resp)
+	return pool.ConvertTo(resp)
+}
+
+func (c *Client) Patch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	resp, err := c.cc.Patch(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseMessage(resp)
+	return pool.ConvertTo(resp)
+}
+
+func (c *Client) IPatch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*message.Message, error) {
+	resp, err := c.cc.IPatch(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.ReleaseMessage(resp)
+	return pool.ConvertTo(resp)
+}
+
 func (c *Client) Get(ctx context.Context, path string, opts ...message.Option) (*message.Message, error) {
 	resp, err := c.cc.Get(ctx, path, opts...)
 	if err != nil {
@@ -85,6 +113,18 @@ func (c *Client) WriteMessage(req *message.Message) error {
 	return c.cc.WriteMessage(r)
 }
 
+// WriteConfirmableMessage sends the message as confirmable, so it is retransmitted until the peer acknowledges it.
+// ErrMessageReset is returned when the peer rejects the message by reset.
+func (c *Client) WriteConfirmableMessage(req *message.Message) error {
+	r, err := pool.ConvertFrom(req)
+	if err != nil {
+		return err
+	}
+	defer pool.ReleaseMessage(r)
+	r.SetType(udpMessage.Confirmable)
+	return c.cc.WriteMessage(r)
+}
+
 func (c *Client) Do(req *message.Message) (*message.Message, error) {
 	r, err := pool.ConvertFrom(req)
 	if err != nil {
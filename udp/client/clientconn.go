@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,9 +11,10 @@ import (
 
 	atomicTypes "go.uber.org/atomic"
 
-	"github.com/patrickmn/go-cache"
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/net/blockwise"
+	"github.com/plgd-dev/go-coap/v2/net/cache"
 
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
@@ -41,12 +43,20 @@ type Notifier interface {
 	Notify()
 }
 
+// states of the acknowledgement of a confirmable request.
+const (
+	ackPending uint32 = iota
+	ackSent
+	ackHandled
+)
+
 // ClientConn represents a virtual connection to a conceptual endpoint, to perform COAPs commands.
 type ClientConn struct {
 	// This field needs to be the first in the struct to ensure proper word alignment on 32-bit platforms.
 	// See: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
 	sequence                uint64
 	msgID                   uint32
+	draining                uint32
 	session                 Session
 	handler                 HandlerFunc
 	observationTokenHandler *HandlerContainer
@@ -59,9 +69,14 @@ type ClientConn struct {
 	responseMsgCache        *cache.Cache
 	msgIdMutex              *MutexMap
 	activityMonitor         Notifier
+	rttStats                *rttStats
+	interactions            interactionLimiter
+	probing                 probingLimiter
 
 	tokenHandlerContainer *HandlerContainer
 	midHandlerContainer   *HandlerContainer
+
+	clock clock.Clock
 }
 
 // Transmission is a threadsafe container for transmission related parameters
@@ -69,12 +84,29 @@ type Transmission struct {
 	nStart             *atomicTypes.Duration
 	acknowledgeTimeout *atomicTypes.Duration
 	maxRetransmit      *atomicTypes.Int32
+	piggybackTimeout   *atomicTypes.Duration
+	congestionControl  *atomicTypes.Int32
+	probingRate        *atomicTypes.Uint32
+	maxOutstanding     *atomicTypes.Uint32
 }
 
+// SetTransmissionNStart sets delay which is added to the acknowledge timeout before a confirmable message is retransmitted.
 func (t *Transmission) SetTransmissionNStart(d time.Duration) {
 	t.nStart.Store(d)
 }
 
+// SetNStart sets maximal number of outstanding confirmable messages to the peer (NSTART).
+// Further messages are queued until an outstanding one is acknowledged or times out. Zero disables the limit.
+func (t *Transmission) SetNStart(n uint32) {
+	t.maxOutstanding.Store(n)
+}
+
+// SetTransmissionProbingRate sets average data rate in bytes per second (PROBING_RATE) of non-confirmable messages
+// sent to the peer which doesn't respond. Zero disables the limit.
+func (t *Transmission) SetTransmissionProbingRate(bytesPerSecond uint32) {
+	t.probingRate.Store(bytesPerSecond)
+}
+
 func (t *Transmission) SetTransmissionAcknowledgeTimeout(d time.Duration) {
 	t.acknowledgeTimeout.Store(d)
 }
@@ -83,6 +115,22 @@ func (t *Transmission) SetTransmissionMaxRetransmit(d int32) {
 	t.maxRetransmit.Store(d)
 }
 
+// SetTransmissionPiggybackTimeout sets how long a handler can take to have its response
+// piggybacked in the acknowledgement of a confirmable request. Zero disables piggybacking.
+func (t *Transmission) SetTransmissionPiggybackTimeout(d time.Duration) {
+	t.piggybackTimeout.Store(d)
+}
+
+// SetCongestionControl selects the algorithm which computes retransmission timeouts of confirmable messages.
+func (t *Transmission) SetCongestionControl(c CongestionControl) {
+	t.congestionControl.Store(int32(c))
+}
+
+// CongestionControl returns the algorithm which computes retransmission timeouts of confirmable messages.
+func (t *Transmission) CongestionControl() CongestionControl {
+	return CongestionControl(t.congestionControl.Load())
+}
+
 func (cc *ClientConn) Transmission() *Transmission {
 	return cc.transmission
 }
@@ -103,6 +151,29 @@ func NewClientConn(
 	getMID GetMIDFunc,
 	activityMonitor Notifier,
 ) *ClientConn {
+	return NewClientConnWithClock(session, observationTokenHandler, observationRequests, transmissionNStart, transmissionAcknowledgeTimeout,
+		transmissionMaxRetransmit, handler, blockwiseSZX, blockWise, goPool, errors, getMID, activityMonitor, nil)
+}
+
+// NewClientConnWithClock creates connection whose caches, RTT measurements and probing rate are driven by the clock,
+// nil means the clock of the system.
+func NewClientConnWithClock(
+	session Session,
+	observationTokenHandler *HandlerContainer,
+	observationRequests *kitSync.Map,
+	transmissionNStart time.Duration,
+	transmissionAcknowledgeTimeout time.Duration,
+	transmissionMaxRetransmit int,
+	handler HandlerFunc,
+	blockwiseSZX blockwise.SZX,
+	blockWise *blockwise.BlockWise,
+	goPool GoPoolFunc,
+	errors ErrorFunc,
+	getMID GetMIDFunc,
+	activityMonitor Notifier,
+	clk clock.Clock,
+) *ClientConn {
+	clk = clock.Get(clk)
 	if errors == nil {
 		errors = func(error) {}
 	}
@@ -119,6 +190,10 @@ func NewClientConn(
 			atomicTypes.NewDuration(transmissionNStart),
 			atomicTypes.NewDuration(transmissionAcknowledgeTimeout),
 			atomicTypes.NewInt32(int32(transmissionMaxRetransmit)),
+			atomicTypes.NewDuration(0),
+			atomicTypes.NewInt32(int32(CongestionControlDefault)),
+			atomicTypes.NewUint32(0),
+			atomicTypes.NewUint32(0),
 		},
 		handler:      handler,
 		blockwiseSZX: blockwiseSZX,
@@ -129,10 +204,78 @@ func NewClientConn(
 		goPool:                goPool,
 		errors:                errors,
 		// EXCHANGE_LIFETIME = 247
-		responseMsgCache: cache.New(247*time.Second, 60*time.Second),
+		responseMsgCache: cache.New(247*time.Second, 60*time.Second, clk),
 		msgIdMutex:       NewMutexMap(),
 		activityMonitor:  activityMonitor,
+		rttStats:         newRTTStats(clk),
+		probing:          probingLimiter{clock: clk},
+		clock:            clk,
+	}
+}
+
+// EnableQBlock enables Q-Block1 and Q-Block2 transfers (RFC 9177) of the blockwise transfer. Blocks are sent
+// in bursts of maxPayloads non-confirmable messages and the receiver asks for missing blocks after nonReceiveTimeout.
+func (cc *ClientConn) EnableQBlock(maxPayloads int, nonReceiveTimeout time.Duration) {
+	if cc.blockWise == nil {
+		return
+	}
+	cc.blockWise.EnableQBlock(maxPayloads, nonReceiveTimeout, func(m blockwise.Message) error {
+		req := m.(*pool.Message)
+		req.SetType(udpMessage.NonConfirmable)
+		req.SetMessageID(cc.getMID())
+		return cc.writeMessage(req)
+	})
+}
+
+// BlockSize returns size of the block which is sent in one datagram by the blockwise transfer,
+// zero means the blockwise transfer is disabled.
+func (cc *ClientConn) BlockSize() int64 {
+	if cc.blockWise == nil {
+		return 0
+	}
+	return cc.blockwiseSZX.Size()
+}
+
+// InteractionStats returns number of outstanding and queued messages.
+func (cc *ClientConn) InteractionStats() InteractionStats {
+	outstanding, queued := cc.interactions.stats()
+	stats := InteractionStats{
+		Outstanding:   outstanding,
+		Queued:        queued,
+		ProbingQueued: cc.probing.queueLen(),
+	}
+	if cc.blockWise != nil {
+		stats.Transfers = cc.blockWise.Transfers()
+	}
+	return stats
+}
+
+// Drain rejects requests which start new exchanges by 5.03 Service Unavailable. Requests
+// of blockwise transfers in progress are still served. It is used by graceful shutdown of the server.
+func (cc *ClientConn) Drain() {
+	atomic.StoreUint32(&cc.draining, 1)
+}
+
+// startsExchange returns true when the request isn't a continuation of a blockwise transfer.
+func startsExchange(r *pool.Message) bool {
+	if r.Code() < codes.GET || r.Code() > codes.IPATCH {
+		return false
 	}
+	for _, id := range []message.OptionID{message.Block1, message.Block2, message.QBlock1, message.QBlock2} {
+		v, err := r.GetOptionUint32(id)
+		if err != nil {
+			continue
+		}
+		if _, num, _, err := blockwise.DecodeBlockOption(v); err == nil && num > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RTTStats returns round-trip time statistics measured from acknowledgements of confirmable messages.
+func (cc *ClientConn) RTTStats() RTTStats {
+	return cc.rttStats.get()
 }
 
 func (cc *ClientConn) Session() Session {
@@ -148,7 +291,7 @@ func (cc *ClientConn) Close() error {
 	return cc.session.Close()
 }
 
-func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
+func (cc *ClientConn) exchange(req *pool.Message) (*pool.Message, error) {
 	token := req.Token()
 	if token == nil {
 		return nil, fmt.Errorf("invalid token")
@@ -180,6 +323,38 @@ func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
 	}
 }
 
+func (cc *ClientConn) do(req *pool.Message) (*pool.Message, error) {
+	resp, err := cc.exchange(req)
+	if err != nil {
+		return nil, err
+	}
+	echo, ok := echoChallenge(req, resp)
+	if !ok {
+		return resp, nil
+	}
+	// the server verifies the client so the request is repeated with the echoed value
+	req.SetOptionBytes(message.Echo, echo)
+	pool.ReleaseMessage(resp)
+	req.SetMessageID(cc.getMID())
+	return cc.exchange(req)
+}
+
+// echoChallenge returns the Echo value of 4.01 Unauthorized response (RFC 9175) which was not sent by the request.
+func echoChallenge(req, resp *pool.Message) ([]byte, bool) {
+	if resp.Code() != codes.Unauthorized {
+		return nil, false
+	}
+	echo, err := resp.GetOptionBytes(message.Echo)
+	if err != nil {
+		return nil, false
+	}
+	reqEcho, err := req.GetOptionBytes(message.Echo)
+	if err == nil && bytes.Equal(reqEcho, echo) {
+		return nil, false
+	}
+	return echo, true
+}
+
 // Do sends an coap message and returns an coap response.
 //
 // An error is returned if by failure to speak COAP (such as a network connectivity problem).
@@ -208,10 +383,22 @@ func (cc *ClientConn) Do(req *pool.Message) (*pool.Message, error) {
 
 func (cc *ClientConn) writeMessage(req *pool.Message) error {
 	respChan := make(chan struct{})
+	var reset bool
 
 	// Only confirmable messages ever match an message ID
 	if req.Type() == udpMessage.Confirmable {
-		err := cc.midHandlerContainer.Insert(req.MessageID(), func(w *ResponseWriter, r *pool.Message) {
+		err := cc.interactions.acquire(req.Context(), cc.Context(), cc.transmission.maxOutstanding.Load)
+		if err != nil {
+			return err
+		}
+		defer cc.interactions.release()
+		err = cc.midHandlerContainer.Insert(req.MessageID(), func(w *ResponseWriter, r *pool.Message) {
+			if r.Type() == udpMessage.Reset {
+				// the peer rejected the message
+				reset = true
+				close(respChan)
+				return
+			}
 			close(respChan)
 			if r.IsSeparate() {
 				// separate message - just accept
@@ -223,6 +410,15 @@ func (cc *ClientConn) writeMessage(req *pool.Message) error {
 			return fmt.Errorf("cannot insert mid handler: %w", err)
 		}
 		defer cc.midHandlerContainer.Pop(req.MessageID())
+	} else if rate := cc.transmission.probingRate.Load(); rate > 0 {
+		data, err := req.Marshal()
+		if err != nil {
+			return fmt.Errorf("cannot write request: %w", err)
+		}
+		err = cc.probing.wait(req.Context(), cc.Context(), len(data), rate)
+		if err != nil {
+			return err
+		}
 	}
 
 	err := cc.session.WriteMessage(req)
@@ -235,30 +431,46 @@ func (cc *ClientConn) writeMessage(req *pool.Message) error {
 		close(respChan)
 	}
 
-	maxRetransmit := cc.transmission.maxRetransmit.Load()
-	for i := int32(0); i < maxRetransmit; i++ {
+	maxRetransmit := int(cc.transmission.maxRetransmit.Load())
+	cocoa := cc.transmission.CongestionControl() == CongestionControlCoCoA
+	timeout := cc.transmission.acknowledgeTimeout.Load()
+	if cocoa {
+		timeout = cc.rttStats.initialTimeout()
+	}
+	initialTimeout := timeout
+	delay := cc.transmission.nStart.Load()
+	start := cc.clock.Now()
+	timer := cc.clock.NewTimer(timeout + delay)
+	defer timer.Stop()
+	for i := 0; ; i++ {
 		select {
 		case <-respChan:
+			if reset {
+				return ErrMessageReset
+			}
+			if req.Type() == udpMessage.Confirmable {
+				cc.rttStats.update(cc.clock.Since(start), i)
+			}
 			return nil
 		case <-req.Context().Done():
 			return req.Context().Err()
 		case <-cc.Context().Done():
 			return fmt.Errorf("connection was closed: %w", cc.Context().Err())
-		case <-time.After(cc.transmission.acknowledgeTimeout.Load()):
-			select {
-			case <-req.Context().Done():
-				return req.Context().Err()
-			case <-cc.session.Context().Done():
-				return fmt.Errorf("connection was closed: %w", cc.Context().Err())
-			case <-time.After(cc.transmission.nStart.Load()):
-				err = cc.session.WriteMessage(req)
-				if err != nil {
-					return fmt.Errorf("cannot write request: %w", err)
-				}
+		case <-timer.C():
+			if i >= maxRetransmit {
+				cc.rttStats.timeout(i)
+				return fmt.Errorf("timeout: retransmission(%v) was exhausted", maxRetransmit)
+			}
+			err = cc.session.WriteMessage(req)
+			if err != nil {
+				return fmt.Errorf("cannot write request: %w", err)
+			}
+			if cocoa {
+				timeout = backoff(initialTimeout, timeout)
 			}
+			timer.Reset(timeout + delay)
 		}
 	}
-	return fmt.Errorf("timeout: retransmission(%v) was exhausted", cc.transmission.maxRetransmit.Load())
 }
 
 // WriteMessage sends an coap message.
@@ -404,6 +616,96 @@ func (cc *ClientConn) Delete(ctx context.Context, path string, opts ...message.O
 	return cc.Do(req)
 }
 
+func newRequestWithPayload(ctx context.Context, code codes.Code, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := newCommonRequest(ctx, code, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.SetContentFormat(contentFormat)
+		req.SetBody(payload)
+	}
+	return req, nil
+}
+
+// NewFetchRequest creates fetch request (RFC 8132).
+//
+// Use ctx to set timeout.
+//
+// If payload is nil then content format is not used.
+func NewFetchRequest(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	return newRequestWithPayload(ctx, codes.FETCH, path, contentFormat, payload, opts...)
+}
+
+// Fetch issues a FETCH to the specified path. The payload describes which parts of the resource are requested.
+//
+// Use ctx to set timeout.
+//
+// An error is returned if by failure to speak COAP (such as a network connectivity problem).
+// Any status code doesn't cause an error.
+//
+// If payload is nil then content format is not used.
+func (cc *ClientConn) Fetch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := NewFetchRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create fetch request: %w", err)
+	}
+	defer pool.ReleaseMessage(req)
+	return cc.Do(req)
+}
+
+// NewPatchRequest creates patch request (RFC 8132).
+//
+// Use ctx to set timeout.
+//
+// If payload is nil then content format is not used.
+func NewPatchRequest(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	return newRequestWithPayload(ctx, codes.PATCH, path, contentFormat, payload, opts...)
+}
+
+// Patch issues a PATCH to the specified path. The payload describes changes of the resource.
+//
+// Use ctx to set timeout.
+//
+// An error is returned if by failure to speak COAP (such as a network connectivity problem).
+// Any status code doesn't cause an error.
+//
+// If payload is nil then content format is not used.
+func (cc *ClientConn) Patch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := NewPatchRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create patch request: %w", err)
+	}
+	defer pool.ReleaseMessage(req)
+	return cc.Do(req)
+}
+
+// NewIPatchRequest creates idempotent patch request (RFC 8132).
+//
+// Use ctx to set timeout.
+//
+// If payload is nil then content format is not used.
+func NewIPatchRequest(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	return newRequestWithPayload(ctx, codes.IPATCH, path, contentFormat, payload, opts...)
+}
+
+// IPatch issues an iPATCH to the specified path. Unlike PATCH, the changes can be applied repeatedly with the same result.
+//
+// Use ctx to set timeout.
+//
+// An error is returned if by failure to speak COAP (such as a network connectivity problem).
+// Any status code doesn't cause an error.
+//
+// If payload is nil then content format is not used.
+func (cc *ClientConn) IPatch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error) {
+	req, err := NewIPatchRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create ipatch request: %w", err)
+	}
+	defer pool.ReleaseMessage(req)
+	return cc.Do(req)
+}
+
 // Context returns the client's context.
 //
 // If connections was closed context is cancelled.
@@ -535,6 +837,10 @@ func (cc *ClientConn) handle(w *ResponseWriter, r *pool.Message) {
 		// msg was processed by token handler - just drop it.
 		return
 	}
+	if atomic.LoadUint32(&cc.draining) == 1 && startsExchange(r) {
+		w.SetResponse(codes.ServiceUnavailable, message.TextPlain, nil)
+		return
+	}
 	cc.handleBW(w, r)
 }
 
@@ -587,6 +893,7 @@ func (cc *ClientConn) Process(datagram []byte) error {
 	req.SetSequence(cc.Sequence())
 	cc.CheckMyMessageID(req)
 	cc.activityMonitor.Notify()
+	cc.probing.received()
 	cc.goPool(func() {
 		defer cc.activityMonitor.Notify()
 		reqMid := req.MessageID()
@@ -630,14 +937,32 @@ func (cc *ClientConn) Process(datagram []byte) error {
 
 		reqType := req.Type()
 		origResp.SetModified(false)
+		var ackState uint32
+		if reqType == udpMessage.Confirmable {
+			if piggybackTimeout := cc.transmission.piggybackTimeout.Load(); piggybackTimeout > 0 {
+				// handler is too slow - confirm received message, response will be sent as separate message.
+				timer := cc.clock.AfterFunc(piggybackTimeout, func() {
+					if atomic.CompareAndSwapUint32(&ackState, ackPending, ackSent) {
+						cc.sendEmptyAck(reqMid)
+					}
+				})
+				defer timer.Stop()
+			}
+		}
 		cc.handle(w, req)
 
 		defer pool.ReleaseMessage(w.response)
-		if !req.IsHijacked() {
+		hijacked := req.IsHijacked()
+		if !hijacked {
 			pool.ReleaseMessage(req)
 		}
+		acked := !atomic.CompareAndSwapUint32(&ackState, ackPending, ackHandled)
 
 		if w.response.IsModified() && (w.response.Type() == udpMessage.Reset || w.response.Code() == codes.Empty) {
+			if acked {
+				// message was already confirmed by empty ack
+				return
+			}
 			// handle pong and reset message
 			if reqType == udpMessage.Confirmable {
 				w.response.SetType(udpMessage.Acknowledgement)
@@ -652,17 +977,28 @@ func (cc *ClientConn) Process(datagram []byte) error {
 				return
 			}
 			return
-		} else if reqType == udpMessage.Confirmable {
+		} else if reqType == udpMessage.Confirmable && !acked {
+			if w.response.IsModified() && !hijacked && cc.transmission.piggybackTimeout.Load() > 0 {
+				// send piggybacked response
+				w.response.SetType(udpMessage.Acknowledgement)
+				w.response.SetMessageID(reqMid)
+				err := cc.session.WriteMessage(w.response)
+				if err != nil {
+					cc.Close()
+					cc.errors(fmt.Errorf("cannot write response: %w", err))
+					return
+				}
+				// store message to cache
+				w.response.SetType(reqType)
+				err = cc.addResponseToCache(w.response)
+				if err != nil {
+					cc.Close()
+					cc.errors(fmt.Errorf("cannot cache response: %w", err))
+				}
+				return
+			}
 			// send separate message to confirm received message.
-			separateMessage := pool.AcquireMessage(cc.Context())
-			defer pool.ReleaseMessage(separateMessage)
-			separateMessage.SetCode(codes.Empty)
-			separateMessage.SetType(udpMessage.Acknowledgement)
-			separateMessage.SetMessageID(reqMid)
-			err := cc.session.WriteMessage(separateMessage)
-			if err != nil {
-				cc.Close()
-				cc.errors(fmt.Errorf("cannot write ack reponse: %w", err))
+			if !cc.sendEmptyAck(reqMid) {
 				return
 			}
 		}
@@ -696,6 +1032,22 @@ func (cc *ClientConn) Process(datagram []byte) error {
 	return nil
 }
 
+// sendEmptyAck confirms received message without response. It returns false when the connection was closed due to an error.
+func (cc *ClientConn) sendEmptyAck(mid uint16) bool {
+	separateMessage := pool.AcquireMessage(cc.Context())
+	defer pool.ReleaseMessage(separateMessage)
+	separateMessage.SetCode(codes.Empty)
+	separateMessage.SetType(udpMessage.Acknowledgement)
+	separateMessage.SetMessageID(mid)
+	err := cc.session.WriteMessage(separateMessage)
+	if err != nil {
+		cc.Close()
+		cc.errors(fmt.Errorf("cannot write ack reponse: %w", err))
+		return false
+	}
+	return true
+}
+
 func (cc *ClientConn) Client() *Client {
 	return NewClient(cc)
 }
@@ -8,6 +8,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -19,6 +20,7 @@ import (
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/net/blockwise"
 	"github.com/plgd-dev/go-coap/v2/udp/client"
 	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
 	"github.com/plgd-dev/go-coap/v2/udp/message/pool"
@@ -725,3 +727,309 @@ func TestClientConn_Ping(t *testing.T) {
 	err = cc.Ping(ctx)
 	require.NoError(t, err)
 }
+
+func TestClientConn_PiggybackedResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		handlerDelay time.Duration
+		wantSeparate bool
+	}{
+		{
+			name: "piggybacked",
+		},
+		{
+			name:         "separate",
+			handlerDelay: time.Millisecond * 300,
+			wantSeparate: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "")
+			require.NoError(t, err)
+			defer l.Close()
+			var wg sync.WaitGroup
+			defer wg.Wait()
+
+			m := mux.NewRouter()
+			m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				time.Sleep(tt.handlerDelay)
+				err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+				require.NoError(t, err)
+			}))
+
+			s := udp.NewServer(udp.WithMux(m), udp.WithPiggyback(time.Millisecond*100))
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := s.Serve(l)
+				require.NoError(t, err)
+			}()
+
+			c, err := net.Dial("udp", l.LocalAddr().String())
+			require.NoError(t, err)
+			defer c.Close()
+
+			opts := make(message.Options, 0, 4)
+			buf := make([]byte, 32)
+			opts, _, err = opts.SetPath(buf, "/a")
+			require.NoError(t, err)
+			req := udpMessage.Message{
+				Code:      codes.GET,
+				Token:     []byte{1, 2, 3},
+				MessageID: 1234,
+				Type:      udpMessage.Confirmable,
+				Options:   opts,
+			}
+			data, err := req.Marshal()
+			require.NoError(t, err)
+			_, err = c.Write(data)
+			require.NoError(t, err)
+
+			readMessage := func() udpMessage.Message {
+				err := c.SetReadDeadline(time.Now().Add(time.Second * 2))
+				require.NoError(t, err)
+				b := make([]byte, 1024)
+				n, err := c.Read(b)
+				require.NoError(t, err)
+				resp := udpMessage.Message{
+					Options: make(message.Options, 0, 16),
+				}
+				_, err = resp.Unmarshal(b[:n])
+				require.NoError(t, err)
+				return resp
+			}
+
+			resp := readMessage()
+			require.Equal(t, udpMessage.Acknowledgement, resp.Type)
+			require.Equal(t, req.MessageID, resp.MessageID)
+			if !tt.wantSeparate {
+				require.Equal(t, codes.Content, resp.Code)
+				require.Equal(t, req.Token, resp.Token)
+				require.Equal(t, []byte("a"), resp.Payload)
+				return
+			}
+			require.Equal(t, codes.Empty, resp.Code)
+			resp = readMessage()
+			require.Equal(t, udpMessage.Confirmable, resp.Type)
+			require.Equal(t, codes.Content, resp.Code)
+			require.Equal(t, req.Token, resp.Token)
+			require.Equal(t, []byte("a"), resp.Payload)
+		})
+	}
+}
+
+func TestClientConn_CongestionControlCoCoA(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	s := udp.NewServer()
+	defer s.Stop()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String(), udp.WithCongestionControl(client.CongestionControlCoCoA))
+	require.NoError(t, err)
+	defer cc.Close()
+	require.Equal(t, client.CongestionControlCoCoA, cc.Transmission().CongestionControl())
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err := cc.Get(ctx, "/a")
+		cancel()
+		require.NoError(t, err)
+		require.Equal(t, codes.NotFound, resp.Code())
+	}
+	stats := cc.RTTStats()
+	require.Equal(t, uint64(5), stats.Strong.Samples)
+	require.Equal(t, uint64(0), stats.Weak.Samples)
+	require.Less(t, int64(stats.RTO), int64(2*time.Second))
+	require.Less(t, int64(stats.Strong.SRTT), int64(time.Second))
+}
+
+func TestClientConn_NStart(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	m := mux.NewRouter()
+	h := mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		time.Sleep(time.Millisecond * 200)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, err)
+	})
+	m.Handle("/a", h)
+	m.Handle("/b", h)
+	s := udp.NewServer(udp.WithMux(m), udp.WithPiggyback(time.Second))
+	defer s.Stop()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String(), udp.WithNStart(1))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	var reqWg sync.WaitGroup
+	defer reqWg.Wait()
+	reqWg.Add(1)
+	go func() {
+		defer reqWg.Done()
+		resp, err := cc.Get(ctx, "/a")
+		require.NoError(t, err)
+		require.Equal(t, codes.Content, resp.Code())
+	}()
+	require.Eventually(t, func() bool {
+		return cc.InteractionStats().Outstanding == 1
+	}, time.Second, time.Millisecond)
+
+	// the second request waits for the response of the first one
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer timeoutCancel()
+	_, err = cc.Get(timeoutCtx, "/b")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	resp, err := cc.Get(ctx, "/b")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	require.Equal(t, client.InteractionStats{}, cc.InteractionStats())
+}
+
+func TestClientConn_QBlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverOpts []udp.ServerOption
+	}{
+		{
+			name:       "supported",
+			serverOpts: []udp.ServerOption{udp.WithQBlock(true, 4, time.Second)},
+		},
+		{
+			name: "unsupported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "")
+			require.NoError(t, err)
+			defer l.Close()
+			var wg sync.WaitGroup
+			defer wg.Wait()
+
+			payload := make([]byte, 4096+100)
+			for i := range payload {
+				payload[i] = byte(i)
+			}
+			m := mux.NewRouter()
+			err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				if r.Code == codes.POST {
+					body := bodyToBytes(t, r.Body)
+					require.Equal(t, payload, body)
+					err := w.SetResponse(codes.Changed, message.TextPlain, nil)
+					require.NoError(t, err)
+					return
+				}
+				err := w.SetResponse(codes.Content, message.AppOctets, bytes.NewReader(payload))
+				require.NoError(t, err)
+			}))
+			require.NoError(t, err)
+			s := udp.NewServer(append([]udp.ServerOption{udp.WithMux(m), udp.WithBlockwise(true, blockwise.SZX256, time.Second*5)}, tt.serverOpts...)...)
+			defer s.Stop()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := s.Serve(l)
+				require.NoError(t, err)
+			}()
+
+			cc, err := udp.Dial(l.LocalAddr().String(), udp.WithBlockwise(true, blockwise.SZX256, time.Second*5), udp.WithQBlock(true, 4, time.Second))
+			require.NoError(t, err)
+			defer cc.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			resp, err := cc.Post(ctx, "/a", message.AppOctets, bytes.NewReader(payload))
+			require.NoError(t, err)
+			require.Equal(t, codes.Changed, resp.Code())
+
+			resp, err = cc.Get(ctx, "/a")
+			require.NoError(t, err)
+			require.Equal(t, codes.Content, resp.Code())
+			require.Equal(t, payload, bodyToBytes(t, resp.Body()))
+		})
+	}
+}
+
+func TestClientConn_FetchPatchIPatch(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	m := mux.NewRouter()
+	err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		body := bodyToBytes(t, r.Body)
+		code := codes.Changed
+		if r.Code == codes.FETCH {
+			code = codes.Content
+		}
+		err := w.SetResponse(code, message.AppOctets, bytes.NewReader(append([]byte(r.Code.String()), body...)))
+		require.NoError(t, err)
+	}))
+	require.NoError(t, err)
+	s := udp.NewServer(udp.WithMux(m))
+	defer s.Stop()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	// payload and response are transferred blockwise
+	payload := make([]byte, 5330)
+	tests := []struct {
+		code     codes.Code
+		wantCode codes.Code
+		do       func(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, opts ...message.Option) (*pool.Message, error)
+	}{
+		{code: codes.FETCH, wantCode: codes.Content, do: cc.Fetch},
+		{code: codes.PATCH, wantCode: codes.Changed, do: cc.Patch},
+		{code: codes.IPATCH, wantCode: codes.Changed, do: cc.IPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			got, err := tt.do(ctx, "/a", message.AppOctets, bytes.NewReader(payload))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCode, got.Code())
+			require.Equal(t, append([]byte(tt.code.String()), payload...), bodyToBytes(t, got.Body()))
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,6 +40,11 @@ type Observation struct {
 	observeFunc  func(req *pool.Message)
 	respCodeChan chan codes.Code
 
+	// code, contentFormat and payload of the request which registered the observation
+	code          codes.Code
+	contentFormat message.MediaType
+	payload       []byte
+
 	obsSequence uint32
 	etag        []byte
 	lastEvent   time.Time
@@ -79,10 +87,22 @@ func (o *Observation) handler(w *ResponseWriter, r *pool.Message) {
 	}
 }
 
+// newRequest creates request which is equal to the request which registered the observation.
+func (o *Observation) newRequest(ctx context.Context) (*pool.Message, error) {
+	if o.code != codes.FETCH {
+		return NewGetRequest(ctx, o.path)
+	}
+	var payload io.ReadSeeker
+	if o.payload != nil {
+		payload = bytes.NewReader(o.payload)
+	}
+	return NewFetchRequest(ctx, o.path, o.contentFormat, payload)
+}
+
 // Cancel remove observation from server. For recreate observation use Observe.
 func (o *Observation) Cancel(ctx context.Context) error {
 	o.cleanUp()
-	req, err := NewGetRequest(ctx, o.path)
+	req, err := o.newRequest(ctx)
 	if err != nil {
 		return fmt.Errorf("cannot cancel observation request: %w", err)
 	}
@@ -125,13 +145,33 @@ func (cc *ClientConn) Observe(ctx context.Context, path string, observeFunc func
 	if err != nil {
 		return nil, fmt.Errorf("cannot create observe request: %w", err)
 	}
+	return cc.observe(req, path, observeFunc, 0, nil)
+}
+
+// ObserveFetch subscribes for every change of the part of resource on path which is described by payload (RFC 8132).
+func (cc *ClientConn) ObserveFetch(ctx context.Context, path string, contentFormat message.MediaType, payload io.ReadSeeker, observeFunc func(req *pool.Message), opts ...message.Option) (*Observation, error) {
+	data, err := readPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := NewFetchRequest(ctx, path, contentFormat, payload, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create observe request: %w", err)
+	}
+	return cc.observe(req, path, observeFunc, contentFormat, data)
+}
+
+func (cc *ClientConn) observe(req *pool.Message, path string, observeFunc func(req *pool.Message), contentFormat message.MediaType, payload []byte) (*Observation, error) {
 	token := req.Token()
 	req.SetObserve(0)
 	respCodeChan := make(chan codes.Code, 1)
 	o := newObservation(token, path, cc, observeFunc, respCodeChan)
+	o.code = req.Code()
+	o.contentFormat = contentFormat
+	o.payload = payload
 
 	cc.observationRequests.Store(token.String(), req)
-	err = o.cc.observationTokenHandler.Insert(token.String(), o.handler)
+	err := o.cc.observationTokenHandler.Insert(token.String(), o.handler)
 	defer func(err *error) {
 		if *err != nil {
 			o.cleanUp()
@@ -160,3 +200,19 @@ func (cc *ClientConn) Observe(ctx context.Context, path string, observeFunc func
 		return o, nil
 	}
 }
+
+// readPayload reads the payload and rewinds it, so it can be sent.
+func readPayload(payload io.ReadSeeker) ([]byte, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(payload)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read payload: %w", err)
+	}
+	_, err = payload.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("cannot seek to start of payload: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+)
+
+// CongestionControl selects the algorithm which computes retransmission timeouts of confirmable messages.
+type CongestionControl int32
+
+const (
+	// CongestionControlDefault retransmits after the fixed acknowledge timeout set by SetTransmissionAcknowledgeTimeout.
+	CongestionControlDefault CongestionControl = iota
+	// CongestionControlCoCoA computes retransmission timeouts from the measured round-trip times:
+	// https://tools.ietf.org/html/draft-ietf-core-cocoa
+	CongestionControlCoCoA
+)
+
+func (c CongestionControl) String() string {
+	switch c {
+	case CongestionControlDefault:
+		return "default"
+	case CongestionControlCoCoA:
+		return "CoCoA"
+	}
+	return "unknown"
+}
+
+// Parameters of CoCoA: https://tools.ietf.org/html/draft-ietf-core-cocoa-03
+const (
+	cocoaInitialRTO  = 2 * time.Second
+	cocoaMaxRTO      = 32 * time.Second
+	cocoaGranularity = time.Millisecond
+	// the weak estimator accepts exchanges with at most two retransmissions
+	cocoaMaxWeakRetransmissions = 2
+	strongK                     = 4
+	weakK                       = 1
+)
+
+// RTOEstimator contains state of the retransmission timeout estimator (RFC 6298).
+type RTOEstimator struct {
+	// SRTT is smoothed round-trip time.
+	SRTT time.Duration
+	// RTTVAR is round-trip time variation.
+	RTTVAR time.Duration
+	// RTO is retransmission timeout computed by the estimator.
+	RTO time.Duration
+	// Samples is number of measurements.
+	Samples uint64
+}
+
+func (e *RTOEstimator) update(rtt time.Duration, k time.Duration) {
+	if e.Samples == 0 {
+		e.SRTT = rtt
+		e.RTTVAR = rtt / 2
+	} else {
+		diff := e.SRTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		e.RTTVAR = (3*e.RTTVAR + diff) / 4
+		e.SRTT = (7*e.SRTT + rtt) / 8
+	}
+	variation := k * e.RTTVAR
+	if variation < cocoaGranularity {
+		variation = cocoaGranularity
+	}
+	e.RTO = e.SRTT + variation
+	e.Samples++
+}
+
+// RTTStats contains round-trip time statistics of the connection.
+type RTTStats struct {
+	// Strong estimator uses exchanges without retransmission.
+	Strong RTOEstimator
+	// Weak estimator uses exchanges with retransmissions, the round-trip time is measured from the first transmission.
+	Weak RTOEstimator
+	// RTO is the overall retransmission timeout, it is used as base of the initial timeout by CoCoA.
+	RTO time.Duration
+	// LastRTT is the last measured round-trip time.
+	LastRTT time.Duration
+	// LastUpdate is the time of the last update of RTO.
+	LastUpdate time.Time
+	// Retransmissions is number of retransmitted messages.
+	Retransmissions uint64
+	// Timeouts is number of messages which weren't acknowledged.
+	Timeouts uint64
+}
+
+// rttStats measures round-trip times of confirmable messages and computes timeouts of CoCoA.
+type rttStats struct {
+	mutex sync.Mutex
+	stats RTTStats
+	rand  *rand.Rand
+	clock clock.Clock
+}
+
+func newRTTStats(clk clock.Clock) *rttStats {
+	return &rttStats{
+		stats: RTTStats{
+			RTO:        cocoaInitialRTO,
+			LastUpdate: clk.Now(),
+		},
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		clock: clk,
+	}
+}
+
+// ageLocked applies aging of RTO: https://tools.ietf.org/html/draft-ietf-core-cocoa-03#section-4.2.2
+func (s *rttStats) ageLocked(now time.Time) {
+	for {
+		rto := s.stats.RTO
+		switch {
+		case rto < time.Second && now.Sub(s.stats.LastUpdate) > 16*rto:
+			s.stats.LastUpdate = s.stats.LastUpdate.Add(16 * rto)
+			s.stats.RTO = 2 * rto
+		case rto > 3*time.Second && now.Sub(s.stats.LastUpdate) > 4*rto:
+			s.stats.LastUpdate = s.stats.LastUpdate.Add(4 * rto)
+			s.stats.RTO = (cocoaInitialRTO + rto) / 2
+		default:
+			return
+		}
+	}
+}
+
+// initialTimeout returns randomized timeout of the first transmission: RTO * [1, ACK_RANDOM_FACTOR].
+func (s *rttStats) initialTimeout() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ageLocked(s.clock.Now())
+	rto := s.stats.RTO
+	return rto + time.Duration(s.rand.Int63n(int64(rto/2)+1))
+}
+
+// backoff returns timeout of the next retransmission by variable backoff factor:
+// https://tools.ietf.org/html/draft-ietf-core-cocoa-03#section-4.2.1
+func backoff(initial, timeout time.Duration) time.Duration {
+	switch {
+	case initial < time.Second:
+		timeout *= 3
+	case initial > 3*time.Second:
+		timeout = timeout * 3 / 2
+	default:
+		timeout *= 2
+	}
+	if timeout > cocoaMaxRTO {
+		return cocoaMaxRTO
+	}
+	return timeout
+}
+
+// update updates estimators by the round-trip time of acknowledged message.
+func (s *rttStats) update(rtt time.Duration, retransmissions int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.LastRTT = rtt
+	s.stats.Retransmissions += uint64(retransmissions)
+	switch {
+	case retransmissions == 0:
+		s.stats.Strong.update(rtt, strongK)
+		s.stats.RTO = (s.stats.Strong.RTO + s.stats.RTO) / 2
+	case retransmissions <= cocoaMaxWeakRetransmissions:
+		s.stats.Weak.update(rtt, weakK)
+		s.stats.RTO = (s.stats.Weak.RTO + 3*s.stats.RTO) / 4
+	default:
+		return
+	}
+	s.stats.LastUpdate = s.clock.Now()
+}
+
+func (s *rttStats) timeout(retransmissions int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Retransmissions += uint64(retransmissions)
+	s.stats.Timeouts++
+}
+
+func (s *rttStats) get() RTTStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ageLocked(s.clock.Now())
+	return s.stats
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTOEstimator(t *testing.T) {
+	var e RTOEstimator
+	e.update(100*time.Millisecond, strongK)
+	require.Equal(t, 100*time.Millisecond, e.SRTT)
+	require.Equal(t, 50*time.Millisecond, e.RTTVAR)
+	require.Equal(t, 300*time.Millisecond, e.RTO)
+
+	e.update(100*time.Millisecond, strongK)
+	require.Equal(t, 100*time.Millisecond, e.SRTT)
+	require.Equal(t, 37500*time.Microsecond, e.RTTVAR)
+	require.Equal(t, 250*time.Millisecond, e.RTO)
+	require.Equal(t, uint64(2), e.Samples)
+
+	var w RTOEstimator
+	w.update(0, weakK)
+	require.Equal(t, cocoaGranularity, w.RTO)
+}
+
+func TestRTTStats_Update(t *testing.T) {
+	s := newRTTStats(clock.New())
+	s.update(100*time.Millisecond, 0)
+	stats := s.get()
+	// (300ms + 2s) / 2
+	require.Equal(t, 1150*time.Millisecond, stats.RTO)
+	require.Equal(t, uint64(1), stats.Strong.Samples)
+
+	s.update(time.Second, 1)
+	stats = s.get()
+	// weak RTO = 1s + 1 * 500ms, (1.5s + 3 * 1.15s) / 4
+	require.Equal(t, 1237500*time.Microsecond, stats.RTO)
+	require.Equal(t, uint64(1), stats.Weak.Samples)
+	require.Equal(t, uint64(1), stats.Retransmissions)
+
+	// too many retransmissions are ignored
+	s.update(5*time.Second, 3)
+	stats = s.get()
+	require.Equal(t, 1237500*time.Microsecond, stats.RTO)
+	require.Equal(t, uint64(1), stats.Weak.Samples)
+
+	s.timeout(4)
+	stats = s.get()
+	require.Equal(t, uint64(1), stats.Timeouts)
+	require.Equal(t, uint64(8), stats.Retransmissions)
+}
+
+func TestRTTStats_InitialTimeout(t *testing.T) {
+	s := newRTTStats(clock.New())
+	for i := 0; i < 100; i++ {
+		timeout := s.initialTimeout()
+		require.GreaterOrEqual(t, int64(timeout), int64(cocoaInitialRTO))
+		require.LessOrEqual(t, int64(timeout), int64(cocoaInitialRTO*3/2))
+	}
+}
+
+func TestRTTStats_Aging(t *testing.T) {
+	s := newRTTStats(clock.New())
+	now := time.Now()
+
+	s.stats.RTO = 500 * time.Millisecond
+	s.stats.LastUpdate = now.Add(-9 * time.Second)
+	s.ageLocked(now)
+	// 500ms -> 1s after 8s, 1s is not aged
+	require.Equal(t, time.Second, s.stats.RTO)
+
+	s.stats.RTO = 10 * time.Second
+	s.stats.LastUpdate = now.Add(-41 * time.Second)
+	s.ageLocked(now)
+	require.Equal(t, 6*time.Second, s.stats.RTO)
+
+	s.stats.RTO = 2 * time.Second
+	s.stats.LastUpdate = now.Add(-time.Hour)
+	s.ageLocked(now)
+	require.Equal(t, 2*time.Second, s.stats.RTO)
+}
+
+func TestBackoff(t *testing.T) {
+	require.Equal(t, 1500*time.Millisecond, backoff(500*time.Millisecond, 500*time.Millisecond))
+	require.Equal(t, 4*time.Second, backoff(2*time.Second, 2*time.Second))
+	require.Equal(t, 6*time.Second, backoff(4*time.Second, 4*time.Second))
+	require.Equal(t, cocoaMaxRTO, backoff(4*time.Second, 30*time.Second))
+}
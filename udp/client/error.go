@@ -0,0 +1,8 @@
+package client
+
+import "errors"
+
+var (
+	// ErrMessageReset the confirmable message was rejected by the peer with a reset message.
+	ErrMessageReset = errors.New("message was reset by the peer")
+)
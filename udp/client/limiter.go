@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+)
+
+// InteractionStats contains number of messages waiting for the peer.
+type InteractionStats struct {
+	// Outstanding is number of confirmable messages waiting for acknowledgement.
+	Outstanding int
+	// Queued is number of confirmable messages waiting for NSTART.
+	Queued int
+	// ProbingQueued is number of non-confirmable messages delayed by PROBING_RATE.
+	ProbingQueued int
+	// Transfers is number of blockwise transfers in progress.
+	Transfers int
+}
+
+// interactionLimiter limits number of outstanding interactions with the peer to NSTART:
+// https://tools.ietf.org/html/rfc7252#section-4.7
+type interactionLimiter struct {
+	mutex       sync.Mutex
+	outstanding uint32
+	queue       []chan struct{}
+}
+
+func (l *interactionLimiter) removeLocked(w chan struct{}) bool {
+	for i, v := range l.queue {
+		if v == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits until number of outstanding interactions is less than nStart. Zero nStart disables the limit.
+// Waiters are served in FIFO order, because the released interaction is handed over to the head of the queue.
+func (l *interactionLimiter) acquire(ctx context.Context, connCtx context.Context, nStart func() uint32) error {
+	start := time.Now()
+	l.mutex.Lock()
+	limit := nStart()
+	if limit == 0 || (l.outstanding < limit && len(l.queue) == 0) {
+		l.outstanding++
+		l.mutex.Unlock()
+		return nil
+	}
+	w := make(chan struct{})
+	l.queue = append(l.queue, w)
+	depth := len(l.queue)
+	l.mutex.Unlock()
+	select {
+	case <-w:
+		return nil
+	case <-ctx.Done():
+		l.cancel(w)
+		return fmt.Errorf("cannot start interaction: %v outstanding, queued at position %v for %v: %w", limit, depth, time.Since(start), ctx.Err())
+	case <-connCtx.Done():
+		l.cancel(w)
+		return fmt.Errorf("connection was closed: %w", connCtx.Err())
+	}
+}
+
+// cancel removes waiter from the queue, when the interaction was already handed over to the waiter
+// it is released.
+func (l *interactionLimiter) cancel(w chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.removeLocked(w) {
+		l.releaseLocked()
+	}
+}
+
+// releaseLocked hands over the interaction to the head of the queue or decreases number of outstanding ones.
+func (l *interactionLimiter) releaseLocked() {
+	if len(l.queue) == 0 {
+		l.outstanding--
+		return
+	}
+	close(l.queue[0])
+	l.queue = l.queue[1:]
+}
+
+func (l *interactionLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.releaseLocked()
+}
+
+// stats returns number of outstanding and queued interactions.
+func (l *interactionLimiter) stats() (outstanding, queued int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.outstanding), len(l.queue)
+}
+
+// probingLimiter limits average data rate of non-confirmable messages sent to the peer
+// which doesn't respond to PROBING_RATE: https://tools.ietf.org/html/rfc7252#section-4.7
+type probingLimiter struct {
+	mutex sync.Mutex
+	clock clock.Clock
+	// next is the time when the next message can be sent.
+	next time.Time
+	// lastSent and lastReceived are used to detect unresponsive peer.
+	lastSent     time.Time
+	lastReceived time.Time
+	queued       int
+}
+
+func (l *probingLimiter) received() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lastReceived = l.clock.Now()
+}
+
+// wait waits until message of the size can be sent. Zero rate disables the limit.
+func (l *probingLimiter) wait(ctx context.Context, connCtx context.Context, size int, rate uint32) error {
+	start := l.clock.Now()
+	l.mutex.Lock()
+	if rate == 0 {
+		l.lastSent = start
+		l.mutex.Unlock()
+		return nil
+	}
+	next := start
+	// the peer is unresponsive when nothing was received since the previous message
+	if !l.lastSent.IsZero() && !l.lastReceived.After(l.lastSent) && l.next.After(next) {
+		next = l.next
+	}
+	l.next = next.Add(time.Duration(size) * time.Second / time.Duration(rate))
+	l.lastSent = next
+	l.queued++
+	depth := l.queued
+	l.mutex.Unlock()
+	defer func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.queued--
+	}()
+
+	delay := next.Sub(start)
+	if delay <= 0 {
+		return nil
+	}
+	t := l.clock.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("cannot send message to unresponsive peer: queued at position %v for %v: %w", depth, l.clock.Since(start), ctx.Err())
+	case <-connCtx.Done():
+		return fmt.Errorf("connection was closed: %w", connCtx.Err())
+	}
+}
+
+func (l *probingLimiter) queueLen() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.queued
+}
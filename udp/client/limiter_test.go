@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInteractionLimiter(t *testing.T) {
+	var l interactionLimiter
+	nStart := func() uint32 { return 2 }
+	ctx := context.Background()
+
+	require.NoError(t, l.acquire(ctx, ctx, nStart))
+	require.NoError(t, l.acquire(ctx, ctx, nStart))
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := l.acquire(timeoutCtx, ctx, nStart)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	outstanding, queued := l.stats()
+	require.Equal(t, 2, outstanding)
+	require.Equal(t, 0, queued)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := l.acquire(ctx, ctx, nStart)
+		require.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool {
+		_, queued := l.stats()
+		return queued == 1
+	}, time.Second, time.Millisecond)
+	l.release()
+	wg.Wait()
+	outstanding, queued = l.stats()
+	require.Equal(t, 2, outstanding)
+	require.Equal(t, 0, queued)
+
+	// zero disables the limit
+	require.NoError(t, l.acquire(ctx, ctx, func() uint32 { return 0 }))
+}
+
+func TestInteractionLimiter_FIFO(t *testing.T) {
+	var l interactionLimiter
+	nStart := func() uint32 { return 1 }
+	ctx := context.Background()
+	require.NoError(t, l.acquire(ctx, ctx, nStart))
+
+	const waiters = 3
+	acquired := make(chan int, waiters)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := l.acquire(ctx, ctx, nStart)
+			require.NoError(t, err)
+			acquired <- i
+		}(i)
+		require.Eventually(t, func() bool {
+			_, queued := l.stats()
+			return queued == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	for i := 0; i < waiters; i++ {
+		l.release()
+		// the released interaction belongs to the head of the queue, a new request cannot overtake it
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		err := l.acquire(timeoutCtx, ctx, nStart)
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Equal(t, i, <-acquired)
+	}
+	l.release()
+	outstanding, queued := l.stats()
+	require.Equal(t, 0, outstanding)
+	require.Equal(t, 0, queued)
+}
+
+func TestProbingLimiter(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	l := probingLimiter{clock: clk}
+	ctx := context.Background()
+
+	// the first message is sent immediately
+	require.NoError(t, l.wait(ctx, ctx, 10, 100))
+
+	// the peer didn't respond, so the next message waits 10 bytes / 100 bytes/s
+	done := make(chan error, 1)
+	go func() {
+		done <- l.wait(ctx, ctx, 10, 100)
+	}()
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, clk.WaitForTimers(waitCtx, 1))
+	clk.Advance(90 * time.Millisecond)
+	select {
+	case err := <-done:
+		require.FailNow(t, "message was sent before the delay", "%v", err)
+	default:
+	}
+	clk.Advance(10 * time.Millisecond)
+	require.NoError(t, <-done)
+
+	canceledCtx, cancelWait := context.WithCancel(ctx)
+	cancelWait()
+	err := l.wait(canceledCtx, ctx, 10, 100)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// the peer responded after the canceled message was scheduled
+	clk.Advance(110 * time.Millisecond)
+	l.received()
+	require.NoError(t, l.wait(ctx, ctx, 10, 100))
+	require.Equal(t, 0, clk.PendingTimers())
+	require.Equal(t, 0, l.queueLen())
+}
@@ -9,15 +9,18 @@ import (
 	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/mux"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
 
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/net/blockwise"
 	"github.com/plgd-dev/go-coap/v2/udp/client"
 	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
 	"github.com/plgd-dev/go-coap/v2/udp/message/pool"
@@ -726,3 +729,114 @@ func TestClient_KeepAliveMonitor(t *testing.T) {
 	checkCloseWg.Wait()
 	require.True(t, inactivityDetected)
 }
+
+func TestClientConn_LossyNetwork(t *testing.T) {
+	network := coapNet.NewMemoryNetwork()
+	serverConn, err := network.ListenPacket("")
+	require.NoError(t, err)
+	// responses and blocks of the server are lost too, so the client retransmits requests which were already handled
+	l := coapNet.NewUDPConn("udp", coapNet.NewImpairedPacketConn(serverConn, coapNet.WithDropProbability(0.2), coapNet.WithSeed(7)))
+	defer l.Close()
+
+	largePayload := bytes.Repeat([]byte("0123456789"), 300)
+	var handled uint32
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&handled, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, err)
+	}))
+	m.Handle("/large", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(largePayload))
+		require.NoError(t, err)
+	}))
+
+	transmission := WithTransmission(0, time.Millisecond*50, 20)
+	s := NewServer(WithMux(m), transmission, WithBlockwise(true, blockwise.SZX256, time.Second*10))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	clientConn, err := network.DialPacket(serverConn.LocalAddr().String())
+	require.NoError(t, err)
+	impaired := coapNet.NewImpairedPacketConn(clientConn,
+		coapNet.WithDropProbability(0.2),
+		coapNet.WithDuplicateProbability(0.2),
+		coapNet.WithReorder(2, time.Millisecond*5),
+		coapNet.WithSeed(11))
+	cc := Client(impaired, WithCloseSocket(), transmission, WithBlockwise(true, blockwise.SZX256, time.Second*10))
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancel()
+	const requests = 10
+	for i := 0; i < requests; i++ {
+		resp, err := cc.Get(ctx, "/a")
+		require.NoError(t, err)
+		require.Equal(t, codes.Content, resp.Code())
+	}
+	// retransmitted and duplicated requests are answered from the cache of responses
+	require.Equal(t, uint32(requests), atomic.LoadUint32(&handled))
+
+	resp, err := cc.Get(ctx, "/large")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err := ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Equal(t, largePayload, body)
+
+	stats := impaired.Stats()
+	require.NotZero(t, stats.Dropped)
+	require.NotZero(t, stats.Duplicated)
+}
+
+func TestClientConn_RetransmissionWithFakeClock(t *testing.T) {
+	network := coapNet.NewMemoryNetwork()
+	// the peer never responds
+	peer, err := network.ListenPacket("")
+	require.NoError(t, err)
+	defer peer.Close()
+	conn, err := network.DialPacket(peer.LocalAddr().String())
+	require.NoError(t, err)
+
+	clk := clock.NewFake(time.Now())
+	cc := Client(conn, WithCloseSocket(), WithClock(clk), WithTransmission(0, time.Second*2, 4))
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := cc.Get(ctx, "/a")
+		errCh <- err
+	}()
+
+	start := time.Now()
+	received := 0
+	buf := make([]byte, 1024)
+	for {
+		select {
+		case err := <-errCh:
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "retransmission(4) was exhausted")
+			// the request and 4 retransmissions, 10s of the acknowledge timeouts elapsed on the fake clock
+			require.Equal(t, 5, received)
+			require.Less(t, int64(time.Since(start)), int64(time.Second*2))
+			return
+		default:
+		}
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Millisecond*10)))
+		_, _, err := peer.ReadFromUDP(buf)
+		if err == nil {
+			received++
+			continue
+		}
+		clk.Advance(time.Second * 2)
+	}
+}
@@ -32,3 +32,11 @@ func Test_ConvertTo(t *testing.T) {
 	require.NoError(t, err)
 	wg.Wait()
 }
+
+func TestMessage_ResetClearsHijacked(t *testing.T) {
+	msg := pool.AcquireMessage(context.Background())
+	msg.Hijack()
+	require.True(t, msg.IsHijacked())
+	msg.Reset()
+	require.False(t, msg.IsHijacked())
+}
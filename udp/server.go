@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
@@ -38,6 +40,8 @@ type OnNewClientConnFunc = func(cc *client.ClientConn)
 
 type GetMIDFunc = func() uint16
 
+type OnShutdownFunc = func(ctx context.Context)
+
 var defaultServerOptions = serverOptions{
 	ctx:            context.Background(),
 	maxMessageSize: 64 * 1024,
@@ -53,7 +57,7 @@ var defaultServerOptions = serverOptions{
 		}()
 		return nil
 	},
-	createInactivityMonitor: func() inactivity.Monitor {
+	createInactivityMonitor: func(clock.Clock) inactivity.Monitor {
 		return inactivity.NewNilMonitor()
 	},
 	blockwiseEnable:                true,
@@ -61,8 +65,10 @@ var defaultServerOptions = serverOptions{
 	blockwiseTransferTimeout:       time.Second * 3,
 	onNewClientConn:                func(cc *client.ClientConn) {},
 	transmissionNStart:             time.Second,
+	nStart:                         1,
 	transmissionAcknowledgeTimeout: time.Second * 2,
 	transmissionMaxRetransmit:      4,
+	transmissionPiggybackTimeout:   time.Millisecond * 200,
 	getMID:                         udpMessage.GetMID,
 }
 
@@ -72,32 +78,54 @@ type serverOptions struct {
 	handler                        HandlerFunc
 	errors                         ErrorFunc
 	goPool                         GoPoolFunc
-	createInactivityMonitor        func() inactivity.Monitor
+	createInactivityMonitor        func(clock.Clock) inactivity.Monitor
 	net                            string
 	blockwiseSZX                   blockwise.SZX
 	blockwiseEnable                bool
 	blockwiseTransferTimeout       time.Duration
+	qBlockEnable                   bool
+	qBlockMaxPayloads              int
+	qBlockNonReceiveTimeout        time.Duration
 	onNewClientConn                OnNewClientConnFunc
 	transmissionNStart             time.Duration
 	transmissionAcknowledgeTimeout time.Duration
 	transmissionMaxRetransmit      int
+	transmissionPiggybackTimeout   time.Duration
+	transmissionProbingRate        uint32
+	nStart                         uint32
+	congestionControl              client.CongestionControl
 	getMID                         GetMIDFunc
+	onShutdown                     OnShutdownFunc
+	clock                          clock.Clock
 }
 
 type Server struct {
+	// This field needs to be the first in the struct to ensure proper word alignment on 32-bit platforms.
+	// See: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
+	activeTasks                    int64
+	shuttingDown                   uint32
 	maxMessageSize                 int
 	handler                        HandlerFunc
 	errors                         ErrorFunc
 	goPool                         GoPoolFunc
-	createInactivityMonitor        func() inactivity.Monitor
+	createInactivityMonitor        func(clock.Clock) inactivity.Monitor
 	blockwiseSZX                   blockwise.SZX
 	blockwiseEnable                bool
 	blockwiseTransferTimeout       time.Duration
+	qBlockEnable                   bool
+	qBlockMaxPayloads              int
+	qBlockNonReceiveTimeout        time.Duration
 	onNewClientConn                OnNewClientConnFunc
 	transmissionNStart             time.Duration
 	transmissionAcknowledgeTimeout time.Duration
 	transmissionMaxRetransmit      int
+	transmissionPiggybackTimeout   time.Duration
+	transmissionProbingRate        uint32
+	nStart                         uint32
+	congestionControl              client.CongestionControl
 	getMID                         GetMIDFunc
+	onShutdown                     OnShutdownFunc
+	clock                          clock.Clock
 
 	conns             map[string]*client.ClientConn
 	connsMutex        sync.Mutex
@@ -127,7 +155,7 @@ func NewServer(opt ...ServerOption) *Server {
 	}
 
 	if opts.createInactivityMonitor == nil {
-		opts.createInactivityMonitor = func() inactivity.Monitor {
+		opts.createInactivityMonitor = func(clock.Clock) inactivity.Monitor {
 			return inactivity.NewNilMonitor()
 		}
 	}
@@ -135,7 +163,7 @@ func NewServer(opt ...ServerOption) *Server {
 	ctx, cancel := context.WithCancel(opts.ctx)
 	serverStartedChan := make(chan struct{})
 
-	return &Server{
+	s := &Server{
 		ctx:            ctx,
 		cancel:         cancel,
 		handler:        opts.handler,
@@ -147,11 +175,13 @@ func NewServer(opt ...ServerOption) *Server {
 			}
 			opts.errors(fmt.Errorf("udp: %w", err))
 		},
-		goPool:                         opts.goPool,
 		createInactivityMonitor:        opts.createInactivityMonitor,
 		blockwiseSZX:                   opts.blockwiseSZX,
 		blockwiseEnable:                opts.blockwiseEnable,
 		blockwiseTransferTimeout:       opts.blockwiseTransferTimeout,
+		qBlockEnable:                   opts.qBlockEnable,
+		qBlockMaxPayloads:              opts.qBlockMaxPayloads,
+		qBlockNonReceiveTimeout:        opts.qBlockNonReceiveTimeout,
 		multicastHandler:               client.NewHandlerContainer(),
 		multicastRequests:              kitSync.NewMap(),
 		serverStartedChan:              serverStartedChan,
@@ -159,10 +189,18 @@ func NewServer(opt ...ServerOption) *Server {
 		transmissionNStart:             opts.transmissionNStart,
 		transmissionAcknowledgeTimeout: opts.transmissionAcknowledgeTimeout,
 		transmissionMaxRetransmit:      opts.transmissionMaxRetransmit,
+		transmissionPiggybackTimeout:   opts.transmissionPiggybackTimeout,
+		transmissionProbingRate:        opts.transmissionProbingRate,
+		nStart:                         opts.nStart,
+		congestionControl:              opts.congestionControl,
 		getMID:                         opts.getMID,
+		onShutdown:                     opts.onShutdown,
+		clock:                          clock.Get(opts.clock),
 
 		conns: make(map[string]*client.ClientConn),
 	}
+	s.goPool = s.trackTasks(opts.goPool)
+	return s
 }
 
 func (s *Server) checkAndSetListener(l *coapNet.UDPConn) error {
@@ -218,6 +256,10 @@ func (s *Server) Serve(l *coapNet.UDPConn) error {
 		}
 		buf = buf[:n]
 		cc, created := s.getOrCreateClientConn(l, raddr)
+		if cc == nil {
+			// server is shutting down - new peers aren't accepted
+			continue
+		}
 		if created {
 			if s.onNewClientConn != nil {
 				s.onNewClientConn(cc)
@@ -237,6 +279,70 @@ func (s *Server) Stop() {
 	s.closeSessions()
 }
 
+// Shutdown gracefully stops the server. Datagrams of new peers are dropped and requests which start
+// new exchanges are answered by 5.03 Service Unavailable, while active handlers, retransmissions and
+// blockwise transfers are allowed to finish. The function set by WithOnShutdown is called before
+// the server is drained, eg. to notify observers. Shutdown stops the server when it is drained
+// or when ctx expires, in that case ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreUint32(&s.shuttingDown, 1)
+	for _, cc := range s.getClientConns() {
+		cc.Drain()
+	}
+	if s.onShutdown != nil {
+		s.onShutdown(ctx)
+	}
+	err := s.waitForDrain(ctx)
+	s.Stop()
+	return err
+}
+
+// shutdownPollInterval is how often Shutdown checks whether the server is drained.
+const shutdownPollInterval = time.Millisecond * 10
+
+func (s *Server) waitForDrain(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *Server) drained() bool {
+	if atomic.LoadInt64(&s.activeTasks) > 0 {
+		return false
+	}
+	for _, cc := range s.getClientConns() {
+		stats := cc.InteractionStats()
+		if stats.Outstanding > 0 || stats.Queued > 0 || stats.Transfers > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// trackTasks counts tasks of goPool which are in progress.
+func (s *Server) trackTasks(goPool GoPoolFunc) GoPoolFunc {
+	return func(f func()) error {
+		atomic.AddInt64(&s.activeTasks, 1)
+		err := goPool(func() {
+			defer atomic.AddInt64(&s.activeTasks, -1)
+			f()
+		})
+		if err != nil {
+			atomic.AddInt64(&s.activeTasks, -1)
+		}
+		return err
+	}
+}
+
 func (s *Server) closeSessions() {
 	s.connsMutex.Lock()
 	conns := s.conns
@@ -278,12 +384,13 @@ func (s *Server) getClientConns() []*client.ClientConn {
 }
 
 func (s *Server) handleInactivityMonitors() {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+	timer := s.clock.NewTimer(time.Second)
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C():
+			timer.Reset(time.Second)
 			for _, cc := range s.getClientConns() {
 				select {
 				case <-cc.Context().Done():
@@ -324,17 +431,21 @@ func (s *Server) getOrCreateClientConn(UDPConn *coapNet.UDPConn, raddr *net.UDPA
 	defer s.connsMutex.Unlock()
 	key := raddr.String()
 	cc = s.conns[key]
+	if cc == nil && atomic.LoadUint32(&s.shuttingDown) == 1 {
+		return nil, false
+	}
 	if cc == nil {
 		created = true
 		var blockWise *blockwise.BlockWise
 		if s.blockwiseEnable {
-			blockWise = blockwise.NewBlockWise(
+			blockWise = blockwise.NewBlockWiseWithClock(
 				bwAcquireMessage,
 				bwReleaseMessage,
 				s.blockwiseTransferTimeout,
 				s.errors,
 				false,
 				bwCreateHandlerFunc(s.multicastRequests),
+				s.clock,
 			)
 		}
 		obsHandler := client.NewHandlerContainer()
@@ -345,8 +456,8 @@ func (s *Server) getOrCreateClientConn(UDPConn *coapNet.UDPConn, raddr *net.UDPA
 			s.maxMessageSize,
 			false,
 		)
-		monitor := s.createInactivityMonitor()
-		cc = client.NewClientConn(
+		monitor := s.createInactivityMonitor(s.clock)
+		cc = client.NewClientConnWithClock(
 			session,
 			obsHandler,
 			s.multicastRequests,
@@ -367,7 +478,15 @@ func (s *Server) getOrCreateClientConn(UDPConn *coapNet.UDPConn, raddr *net.UDPA
 			s.errors,
 			s.getMID,
 			monitor,
+			s.clock,
 		)
+		cc.Transmission().SetTransmissionPiggybackTimeout(s.transmissionPiggybackTimeout)
+		cc.Transmission().SetCongestionControl(s.congestionControl)
+		cc.Transmission().SetTransmissionProbingRate(s.transmissionProbingRate)
+		cc.Transmission().SetNStart(s.nStart)
+		if s.qBlockEnable {
+			cc.EnableQBlock(s.qBlockMaxPayloads, s.qBlockNonReceiveTimeout)
+		}
 		cc.SetContextValue(inactivityMonitorKey, monitor)
 		cc.SetContextValue(closeKey, func() {
 			session.close()
@@ -5,15 +5,19 @@ import (
 	"context"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/plgd-dev/go-coap/v2/clock"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
 	coapNet "github.com/plgd-dev/go-coap/v2/net"
 	"github.com/plgd-dev/go-coap/v2/net/monitor/inactivity"
 	"github.com/plgd-dev/go-coap/v2/udp"
 	"github.com/plgd-dev/go-coap/v2/udp/client"
+	udpMessage "github.com/plgd-dev/go-coap/v2/udp/message"
 	"github.com/plgd-dev/go-coap/v2/udp/message/pool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -293,3 +297,159 @@ func TestServer_KeepAliveMonitor(t *testing.T) {
 	checkCloseWg.Wait()
 	require.True(t, inactivityDetected)
 }
+
+func TestServer_Shutdown(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer l.Close()
+
+	observers := mux.NewObservers(0, nil)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	m := mux.NewRouter()
+	m.Use(observers.Middleware)
+	m.Handle("/obs", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("obs")))
+		require.NoError(t, err)
+	}))
+	m.Handle("/slow", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		close(entered)
+		<-release
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("slow")))
+		require.NoError(t, err)
+	}))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := udp.NewServer(udp.WithMux(m), udp.WithOnShutdown(observers.Shutdown))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	cc, err := udp.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	notifications := make(chan codes.Code, 4)
+	_, err = cc.Observe(ctx, "/obs", func(n *pool.Message) {
+		notifications <- n.Code()
+	})
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, <-notifications)
+
+	slowResp := make(chan codes.Code, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := cc.Get(ctx, "/slow")
+		require.NoError(t, err)
+		slowResp <- resp.Code()
+	}()
+	<-entered
+
+	shutdownErr := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shutdownErr <- s.Shutdown(ctx)
+	}()
+
+	// observers are notified by 5.03 and the server rejects new exchanges
+	require.Equal(t, codes.ServiceUnavailable, <-notifications)
+	resp, err := cc.Get(ctx, "/obs")
+	require.NoError(t, err)
+	require.Equal(t, codes.ServiceUnavailable, resp.Code())
+	select {
+	case err := <-shutdownErr:
+		require.FailNow(t, "shutdown finished before active handler", err)
+	default:
+	}
+
+	// active handler finishes
+	close(release)
+	require.Equal(t, codes.Content, <-slowResp)
+	require.NoError(t, <-shutdownErr)
+}
+
+func TestServer_FakeClock(t *testing.T) {
+	network := coapNet.NewMemoryNetwork()
+	l, err := network.ListenUDP("")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var handled uint32
+	m := mux.NewRouter()
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		atomic.AddUint32(&handled, 1)
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, err)
+	}))
+
+	clk := clock.NewFake(time.Now())
+	inactive := make(chan struct{})
+	var inactiveOnce sync.Once
+	s := udp.NewServer(udp.WithMux(m), udp.WithClock(clk), udp.WithInactivityMonitor(time.Hour, func(cc inactivity.ClientConn) {
+		inactiveOnce.Do(func() { close(inactive) })
+	}))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	conn, err := network.DialPacket(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	req := pool.AcquireMessage(ctx)
+	defer pool.ReleaseMessage(req)
+	req.SetCode(codes.GET)
+	req.SetType(udpMessage.Confirmable)
+	req.SetMessageID(1)
+	req.SetToken(message.Token("fake"))
+	req.SetPath("/a")
+	data, err := req.Marshal()
+	require.NoError(t, err)
+	exchange := func() []byte {
+		_, err := conn.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 1024)
+		n, _, err := conn.ReadFromUDP(buf)
+		require.NoError(t, err)
+		return buf[:n]
+	}
+
+	resp := exchange()
+	// the duplicate is answered from the cache of responses
+	require.Equal(t, resp, exchange())
+	require.Equal(t, uint32(1), atomic.LoadUint32(&handled))
+
+	// EXCHANGE_LIFETIME (247s) elapsed, the same message ID is handled as a new request
+	clk.Advance(time.Second * 248)
+	exchange()
+	require.Equal(t, uint32(2), atomic.LoadUint32(&handled))
+
+	for {
+		clk.Advance(time.Minute)
+		select {
+		case <-inactive:
+			return
+		case <-ctx.Done():
+			require.NoError(t, ctx.Err())
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"golang.org/x/net/websocket"
+)
+
+// Location returns URL of WebSocket endpoint of the coap+ws or coaps+ws URI: https://tools.ietf.org/html/rfc8323#section-8.4
+func Location(u message.URI) (string, error) {
+	var scheme string
+	switch u.Scheme {
+	case message.SchemeCoapWS:
+		scheme = "ws"
+	case message.SchemeCoapsWS:
+		scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported scheme '%v'", u.Scheme)
+	}
+	return scheme + "://" + u.Address() + Path, nil
+}
+
+// Client performs WebSocket handshake over the established connection and creates CoAP client connection.
+// The location is URL of the WebSocket endpoint, eg. ws://host/.well-known/coap. The ctx bounds the handshake.
+func Client(ctx context.Context, conn net.Conn, location string, opts ...tcp.DialOption) (*tcp.ClientConn, error) {
+	origin := "http://" + conn.LocalAddr().String()
+	if strings.HasPrefix(location, "wss:") {
+		origin = "https://" + conn.LocalAddr().String()
+	}
+	cfg, err := websocket.NewConfig(location, origin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid location: %w", err)
+	}
+	cfg.Protocol = []string{Protocol}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	type result struct {
+		ws  *websocket.Conn
+		err error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		ws, err := websocket.NewClient(cfg, conn)
+		resCh <- result{ws: ws, err: err}
+	}()
+	var res result
+	select {
+	case res = <-resCh:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, fmt.Errorf("websocket handshake failed: %w", res.err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	c := newConn(res.ws, conn.LocalAddr(), conn.RemoteAddr())
+	return tcp.Client(c, append(opts, tcp.WithCloseSocket())...), nil
+}
+
+// Dial connects to the server identified by coap+ws or coaps+ws URI. The tlsCfg is used for coaps+ws scheme.
+func Dial(ctx context.Context, uri string, tlsCfg *tls.Config, opts ...tcp.DialOption) (*tcp.ClientConn, error) {
+	u, err := message.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	location, err := Location(u)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", u.Address())
+	if err != nil {
+		return nil, err
+	}
+	conn := c
+	if u.Scheme == message.SchemeCoapsWS {
+		conn, err = coapNet.TLSClient(ctx, c, tlsCfg, u.Host)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	cc, err := Client(ctx, conn, location, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
@@ -0,0 +1,107 @@
+package ws_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	"github.com/plgd-dev/go-coap/v2/tcp"
+	"github.com/plgd-dev/go-coap/v2/ws"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestDial(t *testing.T) {
+	l := ws.NewListener()
+	defer l.Close()
+	httpServer := httptest.NewServer(l)
+	defer httpServer.Close()
+
+	observers := mux.NewObservers(0, nil)
+	m := mux.NewRouter()
+	m.Use(observers.Middleware)
+	m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		body := []byte("hello")
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+		}
+		err := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(body))
+		require.NoError(t, err)
+	}))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	s := tcp.NewServer(tcp.WithMux(m))
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.Serve(l)
+		require.NoError(t, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	cc, err := ws.Dial(ctx, fmt.Sprintf("coap+ws://%v", httpServer.Listener.Addr()), nil)
+	require.NoError(t, err)
+	defer cc.Close()
+
+	err = cc.Ping(ctx)
+	require.NoError(t, err)
+
+	resp, err := cc.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err := ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), body)
+
+	// blockwise transfer of the large payload
+	payload := bytes.Repeat([]byte{'x'}, 5000)
+	resp, err = cc.Post(ctx, "/a", message.TextPlain, bytes.NewReader(payload))
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err = ioutil.ReadAll(resp.Body())
+	require.NoError(t, err)
+	require.Equal(t, payload, body)
+
+	notifications := make(chan string, 4)
+	obs, err := cc.Client().Observe(ctx, "/a", func(n *message.Message) {
+		body, err := ioutil.ReadAll(n.Body)
+		require.NoError(t, err)
+		notifications <- string(body)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hello", <-notifications)
+	err = observers.Publish("/a", message.TextPlain, bytes.NewReader([]byte("changed")))
+	require.NoError(t, err)
+	select {
+	case n := <-notifications:
+		require.Equal(t, "changed", n)
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+	err = obs.Cancel(ctx)
+	require.NoError(t, err)
+}
+
+func TestListener_MissingProtocol(t *testing.T) {
+	l := ws.NewListener()
+	defer l.Close()
+	httpServer := httptest.NewServer(l)
+	defer httpServer.Close()
+
+	addr := httpServer.Listener.Addr().String()
+	_, err := websocket.Dial("ws://"+addr+ws.Path, "", "http://"+addr)
+	require.Error(t, err)
+}
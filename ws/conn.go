@@ -0,0 +1,250 @@
+package ws
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	tcpMessage "github.com/plgd-dev/go-coap/v2/tcp/message"
+	"golang.org/x/net/websocket"
+)
+
+var errClosed = errors.New("use of closed websocket connection")
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// toFrame converts CoAP over TCP message to the WebSocket message: https://tools.ietf.org/html/rfc8323#section-4.2
+// The length nibble and the extended length are removed.
+func toFrame(data []byte, hdr tcpMessage.MessageHeader) []byte {
+	tkl := int(data[0] & 0x0f)
+	lenOff := hdr.HeaderLen - 1 - tkl
+	frame := make([]byte, 1+hdr.TotalLen-lenOff)
+	frame[0] = data[0] & 0x0f
+	copy(frame[1:], data[lenOff:hdr.TotalLen])
+	return frame
+}
+
+// fromFrame converts WebSocket message to CoAP over TCP message by adding the length of options and payload.
+func fromFrame(frame []byte) ([]byte, error) {
+	if len(frame) < 2 {
+		return nil, fmt.Errorf("message is too short")
+	}
+	if frame[0]&0xf0 != 0 {
+		return nil, fmt.Errorf("invalid length nibble %v", frame[0]>>4)
+	}
+	tkl := int(frame[0] & 0x0f)
+	if tkl > message.MaxTokenSize {
+		return nil, message.ErrInvalidTokenLen
+	}
+	if len(frame) < 2+tkl {
+		return nil, message.ErrShortRead
+	}
+	optsLen := len(frame) - 2 - tkl
+
+	var hdr [5]byte
+	hdrLen := 1
+	switch {
+	case optsLen < tcpMessage.MESSAGE_LEN13_BASE:
+		hdr[0] = byte(optsLen) << 4
+	case optsLen < tcpMessage.MESSAGE_LEN14_BASE:
+		hdr[0] = 13 << 4
+		hdr[1] = byte(optsLen - tcpMessage.MESSAGE_LEN13_BASE)
+		hdrLen += 1
+	case optsLen < tcpMessage.MESSAGE_LEN15_BASE:
+		hdr[0] = 14 << 4
+		binary.BigEndian.PutUint16(hdr[1:], uint16(optsLen-tcpMessage.MESSAGE_LEN14_BASE))
+		hdrLen += 2
+	default:
+		hdr[0] = 15 << 4
+		binary.BigEndian.PutUint32(hdr[1:], uint32(optsLen-tcpMessage.MESSAGE_LEN15_BASE))
+		hdrLen += 4
+	}
+	hdr[0] |= byte(tkl)
+	data := make([]byte, hdrLen+len(frame)-1)
+	copy(data, hdr[:hdrLen])
+	copy(data[hdrLen:], frame[1:])
+	return data, nil
+}
+
+// conn adapts WebSocket connection to the stream of CoAP over TCP messages, so it can be used by tcp.Session.
+// Each CoAP message is carried by one binary WebSocket message.
+type conn struct {
+	ws         *websocket.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	frames  chan []byte
+	done    chan struct{}
+	readErr error
+
+	readLock sync.Mutex
+	readBuf  []byte
+
+	deadlineLock sync.Mutex
+	readDeadline time.Time
+
+	writeLock sync.Mutex
+	writeBuf  []byte
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(ws *websocket.Conn, localAddr, remoteAddr net.Addr) *conn {
+	ws.PayloadType = websocket.BinaryFrame
+	c := &conn{
+		ws:         ws,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		frames:     make(chan []byte),
+		done:       make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	go c.receive()
+	return c
+}
+
+// receive reads messages without deadline, because websocket.Conn cannot resume reading of a message
+// interrupted by timeout.
+func (c *conn) receive() {
+	defer close(c.done)
+	for {
+		var frame []byte
+		err := websocket.Message.Receive(c.ws, &frame)
+		if err == nil {
+			frame, err = fromFrame(frame)
+		}
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		select {
+		case c.frames <- frame:
+		case <-c.closed:
+			c.readErr = errClosed
+			return
+		}
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	if len(c.readBuf) == 0 {
+		c.deadlineLock.Lock()
+		deadline := c.readDeadline
+		c.deadlineLock.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, timeoutError{}
+			}
+			t := time.NewTimer(d)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case frame := <-c.frames:
+			c.readBuf = frame
+		case <-c.done:
+			if errors.Is(c.readErr, errClosed) {
+				return 0, c.readErr
+			}
+			return 0, io.EOF
+		case <-c.closed:
+			return 0, errClosed
+		case <-timeout:
+			return 0, timeoutError{}
+		}
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write buffers the data until whole CoAP message is written and then sends it as one WebSocket message.
+func (c *conn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	select {
+	case <-c.closed:
+		return 0, errClosed
+	default:
+	}
+	c.writeBuf = append(c.writeBuf, b...)
+	for len(c.writeBuf) > 0 {
+		var hdr tcpMessage.MessageHeader
+		err := hdr.Unmarshal(c.writeBuf)
+		if errors.Is(err, message.ErrShortRead) || len(c.writeBuf) < hdr.TotalLen {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := websocket.Message.Send(c.ws, toFrame(c.writeBuf, hdr)); err != nil {
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[hdr.TotalLen:]
+	}
+	if len(c.writeBuf) == 0 {
+		c.writeBuf = nil
+	}
+	return len(b), nil
+}
+
+func (c *conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.ws.Close()
+	})
+	return err
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets deadline for Read. It doesn't affect the underlying connection.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline is not supported, because interrupted WebSocket message cannot be resumed.
+// Write blocks until the message is sent or the connection is closed.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type addr string
+
+func (a addr) Network() string { return "websocket" }
+func (a addr) String() string  { return string(a) }
+
+func toAddr(a string) net.Addr {
+	if v, err := net.ResolveTCPAddr("tcp", a); err == nil && v.IP != nil {
+		return v
+	}
+	return addr(a)
+}
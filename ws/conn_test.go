@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	tcpMessage "github.com/plgd-dev/go-coap/v2/tcp/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		name       string
+		payloadLen int
+	}{
+		{name: "empty", payloadLen: 0},
+		{name: "len13", payloadLen: 20},
+		{name: "len14", payloadLen: 1000},
+		{name: "len15", payloadLen: 70000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tcpMessage.Message{
+				Token: []byte{1, 2, 3},
+				Code:  codes.GET,
+				Options: message.Options{
+					{ID: message.URIPath, Value: []byte("a")},
+				},
+				Payload: bytes.Repeat([]byte{0xaa}, tt.payloadLen),
+			}
+			data, err := m.Marshal()
+			require.NoError(t, err)
+			var hdr tcpMessage.MessageHeader
+			err = hdr.Unmarshal(data)
+			require.NoError(t, err)
+
+			frame := toFrame(data, hdr)
+			require.Equal(t, byte(3), frame[0])
+			require.Equal(t, byte(codes.GET), frame[1])
+			require.Equal(t, []byte{1, 2, 3}, frame[2:5])
+
+			converted, err := fromFrame(frame)
+			require.NoError(t, err)
+			require.Equal(t, data, converted)
+		})
+	}
+
+	_, err := fromFrame([]byte{0x10, byte(codes.GET)})
+	require.Error(t, err)
+	_, err = fromFrame([]byte{0x02, byte(codes.GET), 1})
+	require.Error(t, err)
+	_, err = fromFrame([]byte{0x00})
+	require.Error(t, err)
+}
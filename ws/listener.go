@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"golang.org/x/net/websocket"
+)
+
+// Path is the path of CoAP over WebSockets endpoint: https://tools.ietf.org/html/rfc8323#section-8.4
+const Path = "/.well-known/coap"
+
+// Protocol is the WebSocket subprotocol of CoAP: https://tools.ietf.org/html/rfc8323#section-4.1
+const Protocol = "coap"
+
+// Listener is a http.Handler which upgrades requests to CoAP over WebSockets connections.
+// The connections are accepted by tcp.Server, eg.:
+//
+//	l := ws.NewListener()
+//	http.Handle(ws.Path, l)
+//	go tcp.NewServer(tcp.WithMux(router)).Serve(l)
+type Listener struct {
+	server    websocket.Server
+	conns     chan *conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener creates listener of CoAP over WebSockets connections.
+func NewListener() *Listener {
+	l := &Listener{
+		conns:  make(chan *conn),
+		closed: make(chan struct{}),
+	}
+	l.server = websocket.Server{
+		Handshake: handshake,
+		Handler:   l.handle,
+	}
+	return l
+}
+
+func handshake(cfg *websocket.Config, r *http.Request) error {
+	for _, p := range cfg.Protocol {
+		if p == Protocol {
+			cfg.Protocol = []string{Protocol}
+			return nil
+		}
+	}
+	return fmt.Errorf("missing websocket subprotocol '%v'", Protocol)
+}
+
+func (l *Listener) handle(w *websocket.Conn) {
+	r := w.Request()
+	var localAddr net.Addr = addr("")
+	if a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		localAddr = a
+	}
+	c := newConn(w, localAddr, toAddr(r.RemoteAddr))
+	select {
+	case l.conns <- c:
+	case <-l.closed:
+		c.Close()
+		return
+	case <-r.Context().Done():
+		c.Close()
+		return
+	}
+	// the connection is closed by the server when the handler returns
+	select {
+	case <-c.closed:
+	case <-c.done:
+	}
+}
+
+// ServeHTTP upgrades the request to the WebSocket connection, which is returned by AcceptWithContext.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-l.closed:
+		http.Error(w, coapNet.ErrListenerIsClosed.Error(), http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	l.server.ServeHTTP(w, r)
+}
+
+// AcceptWithContext waits with context for a new connection.
+func (l *Listener) AcceptWithContext(ctx context.Context) (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, coapNet.ErrListenerIsClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops accepting of new connections. Already accepted connections are not closed.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}